@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"real-time-forum/backend/internal/audit"
+	"real-time-forum/backend/internal/models"
+)
+
+const usage = `Usage:
+  api                              start the server
+  api promote-admin <login>        give the admin role to a user (username or email)
+  api set-role <login> <role>      set a user's role (user, moderator, admin)
+  api render-markdown              re-render the HTML of all posts, comments and messages
+`
+
+// runCommand executes a one-off administrative command instead of starting the server
+func runCommand(db *sql.DB, args []string) error {
+	switch args[0] {
+	case "promote-admin":
+		if len(args) != 2 {
+			return fmt.Errorf("promote-admin takes exactly one argument\n%s", usage)
+		}
+		return setRole(db, args[1], models.RoleAdmin)
+	case "set-role":
+		if len(args) != 3 {
+			return fmt.Errorf("set-role takes exactly two arguments\n%s", usage)
+		}
+		return setRole(db, args[1], args[2])
+	case "render-markdown":
+		if len(args) != 1 {
+			return fmt.Errorf("render-markdown takes no arguments\n%s", usage)
+		}
+		n, err := models.RenderStoredContent(db, true)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rendered %d posts, comments and messages\n", n)
+		return nil
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+func setRole(db *sql.DB, login, role string) error {
+	user, err := models.GetUserByLogin(db, login)
+	if err != nil {
+		return fmt.Errorf("user %q not found", login)
+	}
+
+	previousRole := user.Role
+	if err := models.SetUserRole(db, user.ID, role); err != nil {
+		return err
+	}
+
+	audit.Record(db, nil, audit.Event{
+		Action:     audit.ActionRoleChanged,
+		TargetType: "user",
+		TargetID:   user.ID,
+		Details:    map[string]interface{}{"from": previousRole, "to": role, "source": "cli"},
+	})
+
+	fmt.Printf("User %s (id %d) is now %s\n", user.Username, user.ID, role)
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"real-time-forum/backend/internal/handlers"
+	"real-time-forum/backend/internal/mail"
+	"real-time-forum/backend/internal/models"
+	"real-time-forum/backend/internal/push"
+)
+
+// config holds the server settings read from the environment
+type config struct {
+	Port         string // PORT, the HTTP port to listen on
+	InstanceID   string // INSTANCE_ID, unique among instances sharing a database
+	Broker       string // BROKER, "local" for a single instance or "sql" to share events through the database
+	UploadDir    string // UPLOAD_DIR, where attachments are stored; instances sharing a database must share it too
+	Mailer       string // MAILER, "file" to write email to MailDir or "none" to send no email
+	MailDir      string // MAIL_DIR, where the file mailer writes .eml files
+	MailFrom     string // MAIL_FROM, the sender address of outgoing email
+	SiteURL      string // SITE_URL, the public address of the forum, used for links in email
+	VAPIDSubject string // VAPID_SUBJECT, a mailto: or https: contact sent to Web Push services
+}
+
+// loadConfig reads the server settings, falling back to single-instance defaults
+func loadConfig() config {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return config{
+		Port:         getEnv("PORT", "8080"),
+		InstanceID:   getEnv("INSTANCE_ID", fmt.Sprintf("%s-%d", hostname, os.Getpid())),
+		Broker:       getEnv("BROKER", "local"),
+		UploadDir:    getEnv("UPLOAD_DIR", "./uploads"),
+		Mailer:       getEnv("MAILER", "file"),
+		MailDir:      getEnv("MAIL_DIR", "./mail"),
+		MailFrom:     getEnv("MAIL_FROM", "Real-Time Forum <noreply@localhost>"),
+		SiteURL:      strings.TrimSuffix(getEnv("SITE_URL", "http://localhost:8080"), "/"),
+		VAPIDSubject: getEnv("VAPID_SUBJECT", "mailto:noreply@localhost"),
+	}
+}
+
+// getEnv returns an environment variable, or fallback when it is unset or empty
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// newBroker creates the broker named in the config
+func newBroker(db *sql.DB, cfg config) (handlers.Broker, error) {
+	switch cfg.Broker {
+	case "local":
+		return handlers.NewLocalBroker().Join(cfg.InstanceID), nil
+	case "sql":
+		return handlers.NewSQLBroker(db, cfg.InstanceID)
+	default:
+		return nil, fmt.Errorf("unknown broker %q, expected local or sql", cfg.Broker)
+	}
+}
+
+// newMailer creates the mailer named in the config, or nil when email is turned off
+func newMailer(cfg config) (mail.Mailer, error) {
+	switch cfg.Mailer {
+	case "file":
+		return mail.NewFileMailer(cfg.MailDir, cfg.MailFrom)
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q, expected file or none", cfg.Mailer)
+	}
+}
+
+// newPushClient creates the Web Push client with the VAPID key shared by every instance,
+// generating it on first start
+func newPushClient(db *sql.DB, cfg config) (*push.Client, error) {
+	privateKey, err := models.GetOrCreateVAPIDKey(db, func() (string, error) {
+		key, err := push.GenerateVAPIDKey()
+		if err != nil {
+			return "", err
+		}
+		return key.PrivateKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := push.ParseVAPIDKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return push.NewClient(key, cfg.VAPIDSubject), nil
+}
@@ -12,10 +12,15 @@ import (
 	"real-time-forum/backend/internal/auth"
 	"real-time-forum/backend/internal/database"
 	"real-time-forum/backend/internal/handlers"
+	"real-time-forum/backend/internal/models"
+	"real-time-forum/backend/internal/storage"
 )
 
 const dbPath = "./internal/database/forum.db"
 
+// Several server instances may share the database, so writers wait for each other's locks
+const dbOptions = "?_busy_timeout=5000"
+
 func main() {
 	// Ensure database directory exists
 	dbDir := filepath.Dir(dbPath)
@@ -24,7 +29,7 @@ func main() {
 	}
 
 	// Initialize database
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", dbPath+dbOptions)
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
@@ -39,15 +44,68 @@ func main() {
 	if err := database.InitializeSchema(db); err != nil {
 		log.Fatalf("Failed to initialize schema: %v", err)
 	}
+	if n, err := models.RenderStoredContent(db, false); err != nil {
+		log.Fatalf("Failed to render stored content: %v", err)
+	} else if n > 0 {
+		log.Printf("Rendered Markdown for %d existing posts, comments and messages", n)
+	}
+
+	// Run an administrative command instead of the server when one is given
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg := loadConfig()
+
+	// Initialize WebSocket hub first, with the broker that connects it to other instances
+	broker, err := newBroker(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to start %s broker: %v", cfg.Broker, err)
+	}
+	defer broker.Close()
 
-	// Initialize WebSocket hub first
-	hub := handlers.NewHub(db)
+	pushClient, err := newPushClient(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load VAPID key: %v", err)
+	}
+
+	hub := handlers.NewHub(db, broker)
+	hub.SetPusher(handlers.NewWebPusher(db, pushClient))
 	go hub.Run()
 
+	store, err := storage.NewBlobStore(cfg.UploadDir)
+	if err != nil {
+		log.Fatalf("Failed to open upload directory: %v", err)
+	}
+
 	// Initialize handlers
 	userHandler := handlers.NewUserHandler(db)
-	postHandler := handlers.NewPostHandler(db)
+	twoFactorHandler := handlers.NewTwoFactorHandler(db)
+	postHandler := handlers.NewPostHandler(db, hub)
+	notificationHandler := handlers.NewNotificationHandler(db)
+	followHandler := handlers.NewFollowHandler(db)
+	bookmarkHandler := handlers.NewBookmarkHandler(db)
+	categoryHandler := handlers.NewCategoryHandler(db)
 	messageHandler := handlers.NewMessageHandler(db, hub)
+	reportHandler := handlers.NewReportHandler(db, hub)
+	sanctionHandler := handlers.NewSanctionHandler(db, hub)
+	auditHandler := handlers.NewAuditHandler(db)
+	conversationHandler := handlers.NewConversationHandler(db, hub)
+	presenceHandler := handlers.NewPresenceHandler(db, hub)
+	attachmentHandler := handlers.NewAttachmentHandler(db, store)
+	pushHandler := handlers.NewPushHandler(db, pushClient)
+	go attachmentHandler.CollectGarbage()
+
+	mailer, err := newMailer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to start %s mailer: %v", cfg.Mailer, err)
+	}
+	if mailer != nil {
+		go handlers.NewDigestSender(db, hub, mailer, cfg.SiteURL).Run()
+	}
 
 	// Create router
 	mux := http.NewServeMux()
@@ -55,25 +113,62 @@ func main() {
 	// Register public API routes
 	mux.HandleFunc("/api/register", userHandler.Register)
 	mux.HandleFunc("/api/login", userHandler.Login)
+	mux.HandleFunc("/api/login/verify", twoFactorHandler.VerifyLogin)
 	mux.HandleFunc("/api/logout", userHandler.Logout)
 	mux.HandleFunc("/api/categories", postHandler.ListCategories)
 
 	// Register protected routes
 	mux.HandleFunc("/api/profile", auth.RequireAuth(userHandler.Profile, db))
+	mux.HandleFunc("/api/2fa/status", auth.RequireAuth(twoFactorHandler.Status, db))
+	mux.HandleFunc("/api/2fa/setup", auth.RequireAuth(twoFactorHandler.Setup, db))
+	mux.HandleFunc("/api/2fa/enable", auth.RequireAuth(twoFactorHandler.Enable, db))
+	mux.HandleFunc("/api/2fa/disable", auth.RequireAuth(twoFactorHandler.Disable, db))
+	mux.HandleFunc("/api/2fa/recovery-codes", auth.RequireAuth(twoFactorHandler.RegenerateRecoveryCodes, db))
 	mux.HandleFunc("/api/posts/create", auth.RequireAuth(postHandler.CreatePost, db))
 	mux.HandleFunc("/api/posts/get", auth.RequireAuth(postHandler.GetPost, db))
 	mux.HandleFunc("/api/posts", auth.RequireAuth(postHandler.ListPosts, db))
 	mux.HandleFunc("/api/posts/", auth.RequireAuth(postHandler.HandlePostRoutes, db))
 	mux.HandleFunc("/api/posts/like", auth.RequireAuth(postHandler.LikePost, db))
 	mux.HandleFunc("/api/comments/like", auth.RequireAuth(postHandler.LikeComment, db))
+	mux.HandleFunc("/api/comments/", auth.RequireAuth(postHandler.HandleCommentRoutes, db))
+	mux.HandleFunc("/api/mentions", auth.RequireAuth(postHandler.ListMentions, db))
+	mux.HandleFunc("/api/notifications", auth.RequireAuth(notificationHandler.ListNotifications, db))
+	mux.HandleFunc("/api/notifications/", auth.RequireAuth(notificationHandler.HandleNotificationRoutes, db))
+	mux.HandleFunc("/api/follows", auth.RequireAuth(followHandler.ListFollows, db))
+	mux.HandleFunc("/api/follows/", auth.RequireAuth(followHandler.HandleFollowRoutes, db))
+	mux.HandleFunc("/api/feed/following", auth.RequireAuth(followHandler.FollowingFeed, db))
+	mux.HandleFunc("/api/bookmarks/folders", auth.RequireAuth(bookmarkHandler.HandleFolders, db))
+	mux.HandleFunc("/api/bookmarks/folders/", auth.RequireAuth(bookmarkHandler.HandleFolderRoutes, db))
+	mux.HandleFunc("/api/push/key", auth.RequireAuth(pushHandler.PublicKey, db))
+	mux.HandleFunc("/api/push/subscriptions", auth.RequireAuth(pushHandler.HandleSubscriptions, db))
+
+	// Register moderation routes
+	mux.HandleFunc("/api/reports", auth.RequireAuth(reportHandler.CreateReport, db))
+	mux.HandleFunc("/api/moderation/reports", auth.RequireAuth(reportHandler.ListReports, db))
+	mux.HandleFunc("/api/moderation/reports/", auth.RequireAuth(reportHandler.HandleReportRoutes, db))
+	mux.HandleFunc("/api/moderation/sanctions", auth.RequireAuth(sanctionHandler.HandleSanctions, db))
+	mux.HandleFunc("/api/moderation/sanctions/", auth.RequireAuth(sanctionHandler.HandleSanctionRoutes, db))
+
+	// Register admin routes
+	mux.HandleFunc("/api/admin/users/role", auth.RequireRole(userHandler.SetRole, db, models.RoleAdmin))
+	mux.HandleFunc("/api/admin/categories", auth.RequireAuth(categoryHandler.HandleAdminCategories, db))
+	mux.HandleFunc("/api/admin/categories/", auth.RequireAuth(categoryHandler.HandleAdminCategoryRoutes, db))
+	mux.HandleFunc("/api/admin/audit", auth.RequireRole(auditHandler.ListEvents, db, models.RoleAdmin))
 
 	// Register WebSocket and message routes
 	mux.HandleFunc("/ws", hub.WebSocketHandler)
 	mux.HandleFunc("/api/messages/conversations", auth.RequireAuth(messageHandler.GetConversations, db))
 	mux.HandleFunc("/api/messages/history", auth.RequireAuth(messageHandler.GetConversationHistory, db))
 	mux.HandleFunc("/api/messages/mark-read", auth.RequireAuth(messageHandler.MarkAsRead, db))
+	mux.HandleFunc("/api/messages/unread", auth.RequireAuth(messageHandler.GetUnreadTotals, db))
 	mux.HandleFunc("/api/messages/users", auth.RequireAuth(messageHandler.GetAllUsers, db))
+	mux.HandleFunc("/api/presence", auth.RequireAuth(presenceHandler.HandlePresence, db))
 	mux.HandleFunc("/api/messages/send", auth.RequireAuth(messageHandler.SendMessage, db))
+	mux.HandleFunc("/api/messages/", auth.RequireAuth(messageHandler.HandleMessageRoutes, db))
+	mux.HandleFunc("/api/attachments", auth.RequireAuth(attachmentHandler.Upload, db))
+	mux.HandleFunc("/api/attachments/", auth.RequireAuth(attachmentHandler.HandleAttachmentRoutes, db))
+	mux.HandleFunc("/api/conversations", auth.RequireAuth(conversationHandler.CreateConversation, db))
+	mux.HandleFunc("/api/conversations/", auth.RequireAuth(conversationHandler.HandleConversationRoutes, db))
 
 	// Create a custom handler that wraps the file server for SPA support
 	fs := http.FileServer(http.Dir("../frontend"))
@@ -91,8 +186,8 @@ func main() {
 	}))
 
 	// Start HTTP server
-	log.Println("Starting server on :8080...")
-	if err := http.ListenAndServe(":8080", mux); err != nil {
+	log.Printf("Starting server on :%s...", cfg.Port)
+	if err := http.ListenAndServe(":"+cfg.Port, mux); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
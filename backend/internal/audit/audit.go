@@ -0,0 +1,169 @@
+// Package audit records security and moderation events in an append-only table.
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Audited actions
+const (
+	ActionLoginSucceeded           = "login.succeeded"
+	ActionLoginFailed              = "login.failed"
+	ActionLogout                   = "session.logout"
+	ActionSessionsRevoked          = "session.revoked"
+	ActionTwoFactorEnabled         = "2fa.enabled"
+	ActionTwoFactorDisabled        = "2fa.disabled"
+	ActionRecoveryCodesRegenerated = "2fa.recovery_codes_regenerated"
+	ActionRoleChanged              = "user.role_changed"
+	ActionPostDeleted              = "post.deleted"
+	ActionCommentDeleted           = "comment.deleted"
+	ActionReportResolved           = "report.resolved"
+	ActionSanctionIssued           = "sanction.issued"
+	ActionSanctionRevoked          = "sanction.revoked"
+	ActionCategoryCreated          = "category.created"
+	ActionCategoryUpdated          = "category.updated"
+	ActionCategoryDeleted          = "category.deleted"
+)
+
+// Event is a single audit log entry. ActorID and TargetID are 0 when not applicable.
+type Event struct {
+	ID         int64                  `json:"id"`
+	ActorID    int64                  `json:"actor_id,omitempty"`
+	ActorName  string                 `json:"actor_name,omitempty"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type,omitempty"`
+	TargetID   int64                  `json:"target_id,omitempty"`
+	IP         string                 `json:"ip"`
+	UserAgent  string                 `json:"user_agent"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// Filter narrows an audit query
+type Filter struct {
+	ActorID    int64
+	Action     string
+	TargetType string
+	TargetID   int64
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+// Record appends an event, taking the IP and user agent from r when it is set.
+// Failures are logged rather than returned so auditing never breaks the request.
+func Record(db *sql.DB, r *http.Request, e Event) {
+	if r != nil {
+		e.IP = ClientIP(r)
+		e.UserAgent = r.UserAgent()
+	}
+
+	details := "{}"
+	if len(e.Details) > 0 {
+		encoded, err := json.Marshal(e.Details)
+		if err != nil {
+			log.Printf("Error encoding audit details for %s: %v", e.Action, err)
+		} else {
+			details = string(encoded)
+		}
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, ip, user_agent, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullID(e.ActorID), e.Action, e.TargetType, nullID(e.TargetID), e.IP, e.UserAgent, details, time.Now())
+	if err != nil {
+		log.Printf("Error recording audit event %s: %v", e.Action, err)
+	}
+}
+
+// Query returns matching events, newest first
+func Query(db *sql.DB, filter Filter) ([]Event, error) {
+	query := `
+		SELECT a.id, a.actor_id, COALESCE(u.username, ''), a.action, a.target_type, a.target_id,
+		       a.ip, a.user_agent, a.details, a.created_at
+		FROM audit_events a
+		LEFT JOIN users u ON u.id = a.actor_id
+		WHERE 1 = 1`
+	var args []interface{}
+
+	if filter.ActorID != 0 {
+		query += " AND a.actor_id = ?"
+		args = append(args, filter.ActorID)
+	}
+	if filter.Action != "" {
+		query += " AND a.action = ?"
+		args = append(args, filter.Action)
+	}
+	if filter.TargetType != "" {
+		query += " AND a.target_type = ?"
+		args = append(args, filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		query += " AND a.target_id = ?"
+		args = append(args, filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND a.created_at >= ?"
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query += " AND a.created_at < ?"
+		args = append(args, filter.Until)
+	}
+
+	query += " ORDER BY a.id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		var actorID, targetID sql.NullInt64
+		var details string
+		if err := rows.Scan(&e.ID, &actorID, &e.ActorName, &e.Action, &e.TargetType, &targetID,
+			&e.IP, &e.UserAgent, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.ActorID = actorID.Int64
+		e.TargetID = targetID.Int64
+		if err := json.Unmarshal([]byte(details), &e.Details); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// ClientIP returns the address of the direct peer. Proxy headers are ignored
+// because clients can set them to anything.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func nullID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
@@ -5,11 +5,16 @@ import (
 	"database/sql"
 	"net/http"
 	"time"
+
+	"real-time-forum/backend/internal/models"
 )
 
 type contextKey string
 
-const UserIDContextKey contextKey = "userID"
+const (
+	UserIDContextKey   contextKey = "userID"
+	UserRoleContextKey contextKey = "userRole"
+)
 
 // AuthMiddleware creates a new middleware that checks for valid session
 func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
@@ -25,10 +30,12 @@ func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 			// Check if session exists and is valid
 			var userID int64
 			var expiresAt time.Time
+			var role string
 			err = db.QueryRow(`
-				SELECT user_id, expires_at 
-				FROM sessions 
-				WHERE token = ?`, cookie.Value).Scan(&userID, &expiresAt)
+				SELECT s.user_id, s.expires_at, u.role
+				FROM sessions s
+				JOIN users u ON u.id = s.user_id
+				WHERE s.token = ?`, cookie.Value).Scan(&userID, &expiresAt, &role)
 			if err != nil {
 				if err == sql.ErrNoRows {
 					http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -46,8 +53,20 @@ func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 				return
 			}
 
-			// Add user ID to request context
+			// Banned users keep no access, even with a live session
+			ban, err := models.GetActiveSanction(db, userID, models.SanctionBan)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if ban != nil {
+				http.Error(w, ban.Describe(), http.StatusForbidden)
+				return
+			}
+
+			// Add user ID and role to request context
 			ctx := context.WithValue(r.Context(), UserIDContextKey, userID)
+			ctx = context.WithValue(ctx, UserRoleContextKey, role)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -67,3 +86,33 @@ func GetUserID(r *http.Request) (int64, bool) {
 	userID, ok := r.Context().Value(UserIDContextKey).(int64)
 	return userID, ok
 }
+
+// RequireRole is a middleware that ensures a route is only accessible to users holding
+// at least one of the given roles
+func RequireRole(next http.HandlerFunc, db *sql.DB, roles ...string) http.HandlerFunc {
+	return RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		role, _ := GetUserRole(r)
+		for _, allowed := range roles {
+			if role == allowed {
+				next(w, r)
+				return
+			}
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}, db)
+}
+
+// GetUserRole retrieves the user role from the request context
+func GetUserRole(r *http.Request) (string, bool) {
+	role, ok := r.Context().Value(UserRoleContextKey).(string)
+	return role, ok
+}
+
+// Can reports whether the authenticated user's role grants a permission
+func Can(r *http.Request, perm models.Permission) bool {
+	role, ok := GetUserRole(r)
+	if !ok {
+		return false
+	}
+	return models.RoleHasPermission(role, perm)
+}
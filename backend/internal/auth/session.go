@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"time"
 
+	"real-time-forum/backend/internal/audit"
+
 	"github.com/google/uuid"
 )
 
@@ -58,12 +60,27 @@ func DeleteSession(db *sql.DB, r *http.Request, w http.ResponseWriter) error {
 		return nil // No session to delete
 	}
 
+	var userID int64
+	err = db.QueryRow("SELECT user_id FROM sessions WHERE token = ?", cookie.Value).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
 	// Delete session from database
 	_, err = db.Exec("DELETE FROM sessions WHERE token = ?", cookie.Value)
 	if err != nil {
 		return err
 	}
 
+	if userID != 0 {
+		audit.Record(db, r, audit.Event{
+			ActorID:    userID,
+			Action:     audit.ActionLogout,
+			TargetType: "user",
+			TargetID:   userID,
+		})
+	}
+
 	// Clear cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     CookieName,
@@ -77,3 +94,27 @@ func DeleteSession(db *sql.DB, r *http.Request, w http.ResponseWriter) error {
 
 	return nil
 }
+
+// DeleteUserSessions signs a user out everywhere. The signed-in caller of r, if any,
+// is recorded as the actor.
+func DeleteUserSessions(db *sql.DB, r *http.Request, userID int64) error {
+	result, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	actorID, _ := GetUserID(r)
+	audit.Record(db, r, audit.Event{
+		ActorID:    actorID,
+		Action:     audit.ActionSessionsRevoked,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    map[string]interface{}{"sessions": revoked},
+	})
+	return nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key used by the RFC 4226 and RFC 6238 test vectors
+var rfcSecret = []byte("12345678901234567890")
+
+// RFC 4226 Appendix D
+func TestHOTPVectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := totpCode(rfcSecret, int64(counter)); got != code {
+			t.Errorf("counter %d: got %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// RFC 6238 Appendix B, SHA-1, truncated to the last 6 of the 8 digits
+func TestTOTPVectors(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfcSecret)
+	tests := []struct {
+		unix int64
+		code string // 8 digit code from the RFC
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		wantStep := tt.unix / 30
+		code := tt.code[len(tt.code)-TOTPDigits:]
+
+		if got := totpCode(rfcSecret, wantStep); got != code {
+			t.Errorf("T=%d: totpCode = %s, want %s", tt.unix, got, code)
+		}
+		step, ok := ValidateTOTP(secret, code, 0, now)
+		if !ok || step != wantStep {
+			t.Errorf("T=%d: ValidateTOTP(%s) = %d, %v, want %d, true", tt.unix, code, step, ok, wantStep)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfcSecret)
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / 30
+
+	tests := []struct {
+		name         string
+		step         int64
+		lastUsedStep int64
+		ok           bool
+	}{
+		{"current step", current, 0, true},
+		{"one step behind", current - 1, 0, true},
+		{"one step ahead", current + 1, 0, true},
+		{"two steps behind", current - 2, 0, false},
+		{"two steps ahead", current + 2, 0, false},
+		{"replayed step", current, current, false},
+		{"step before the last used", current - 1, current - 1, false},
+		{"step after the last used", current + 1, current, true},
+		{"earlier step after a later one was used", current - 1, current, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(secret, totpCode(rfcSecret, tt.step), tt.lastUsedStep, now)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != tt.step {
+				t.Errorf("matched step %d, want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfcSecret)
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{"surrounding spaces", secret, " 287082 ", true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287082", true},
+		{"wrong code", secret, "287083", false},
+		{"too short", secret, "28708", false},
+		{"too long", secret, "2870820", false},
+		{"empty", secret, "", false},
+		{"invalid secret", "not base32!", "287082", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, 0, now); ok != tt.ok {
+				t.Errorf("ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"strings"
 )
 
 // InitializeSchema creates all the necessary database tables if they don't exist
@@ -17,6 +18,9 @@ func InitializeSchema(db *sql.DB) error {
 			last_name TEXT NOT NULL,
 			age INTEGER NOT NULL,
 			gender TEXT NOT NULL CHECK(gender IN ('male', 'female', 'other')),
+			role TEXT NOT NULL DEFAULT 'user' CHECK(role IN ('user', 'moderator', 'admin')),
+			status TEXT NOT NULL DEFAULT 'online' CHECK(status IN ('online', 'away', 'dnd', 'invisible')),
+			last_seen_at TIMESTAMP,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		);
 	`)
@@ -24,6 +28,34 @@ func InitializeSchema(db *sql.DB) error {
 		return err
 	}
 
+	// Add role column to databases created before roles existed
+	err = addColumnIfMissing(db, "users", "role",
+		"TEXT NOT NULL DEFAULT 'user' CHECK(role IN ('user', 'moderator', 'admin'))")
+	if err != nil {
+		return err
+	}
+
+	// Presence status chosen by the user, and when they were last connected
+	err = addColumnIfMissing(db, "users", "status",
+		"TEXT NOT NULL DEFAULT 'online' CHECK(status IN ('online', 'away', 'dnd', 'invisible'))")
+	if err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "last_seen_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+
+	// How often the user is emailed a digest of unread activity, and when the last one went out.
+	// Digests are opt-in, so existing accounts aren't emailed after an upgrade.
+	err = addColumnIfMissing(db, "users", "digest_frequency",
+		"TEXT NOT NULL DEFAULT 'off' CHECK(digest_frequency IN ('off', 'daily', 'weekly'))")
+	if err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "users", "last_digest_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+
 	// Create sessions table
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS sessions (
@@ -80,20 +112,60 @@ func InitializeSchema(db *sql.DB) error {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			name TEXT NOT NULL UNIQUE,
 			description TEXT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			slug TEXT,
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			color TEXT NOT NULL DEFAULT '',
+			icon TEXT NOT NULL DEFAULT '',
+			archived BOOLEAN NOT NULL DEFAULT FALSE,
+			post_role TEXT NOT NULL DEFAULT 'user' CHECK(post_role IN ('user', 'moderator', 'admin')),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP
 		);
 	`)
 	if err != nil {
 		return err
 	}
 
-	// Insert default categories if they don't exist
+	// Add category management columns to databases created before they existed
+	categoryColumns := []struct{ name, definition string }{
+		{"slug", "TEXT"},
+		{"sort_order", "INTEGER NOT NULL DEFAULT 0"},
+		{"color", "TEXT NOT NULL DEFAULT ''"},
+		{"icon", "TEXT NOT NULL DEFAULT ''"},
+		{"archived", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"post_role", "TEXT NOT NULL DEFAULT 'user' CHECK(post_role IN ('user', 'moderator', 'admin'))"},
+		{"updated_at", "TIMESTAMP"},
+	}
+	for _, col := range categoryColumns {
+		if err := addColumnIfMissing(db, "categories", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Backfill slugs for categories that predate them
+	_, err = db.Exec(`
+		UPDATE categories SET slug = lower(replace(trim(name), ' ', '-'))
+		WHERE slug IS NULL OR slug = ''
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_slug ON categories(slug)`)
+	if err != nil {
+		return err
+	}
+
+	// Seed default categories only into an empty table, so admin renames and deletions stick
 	_, err = db.Exec(`
-		INSERT OR IGNORE INTO categories (name, description) VALUES 
-		('Technology', 'Discussions about tech and programming'),
-		('Gaming', 'Video games and gaming culture'),
-		('Movies', 'Film discussions and reviews'),
-		('Music', 'Music-related discussions')
+		INSERT INTO categories (name, description, slug, sort_order)
+		SELECT name, description, slug, sort_order FROM (
+			SELECT 'Technology' AS name, 'Discussions about tech and programming' AS description, 'technology' AS slug, 1 AS sort_order
+			UNION ALL SELECT 'Gaming', 'Video games and gaming culture', 'gaming', 2
+			UNION ALL SELECT 'Movies', 'Film discussions and reviews', 'movies', 3
+			UNION ALL SELECT 'Music', 'Music-related discussions', 'music', 4
+		)
+		WHERE NOT EXISTS (SELECT 1 FROM categories)
 	`)
 	if err != nil {
 		return err
@@ -149,5 +221,736 @@ func InitializeSchema(db *sql.DB) error {
 		return err
 	}
 
+	// Add edit and unsend tracking to databases created before messages could change
+	if err := addColumnIfMissing(db, "messages", "edited_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "deleted_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+
+	// Create message_deletions table (messages a participant removed from their own view)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS message_deletions (
+			message_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (message_id, user_id),
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create two_factor table (one TOTP secret per user)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS two_factor (
+			user_id INTEGER PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled BOOLEAN DEFAULT FALSE,
+			last_used_step INTEGER DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			enabled_at TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Wrong second-factor codes in a row, and the lockout they triggered
+	if err := addColumnIfMissing(db, "two_factor", "failed_attempts", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "two_factor", "locked_until", "TIMESTAMP"); err != nil {
+		return err
+	}
+
+	// Create recovery_codes table (hashed one-time codes)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create login_challenges table (pending second-factor logins)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_challenges (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			token TEXT NOT NULL UNIQUE,
+			attempts INTEGER DEFAULT 0,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add moderation visibility flags to reportable content
+	for _, table := range []string{"posts", "comments", "messages"} {
+		if err := addColumnIfMissing(db, table, "is_hidden", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+			return err
+		}
+	}
+
+	// Create reports table (user reports feeding the moderation queue)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reporter_id INTEGER NOT NULL,
+			target_type TEXT NOT NULL CHECK(target_type IN ('post', 'comment', 'message')),
+			target_id INTEGER NOT NULL,
+			target_user_id INTEGER NOT NULL,
+			target_content TEXT NOT NULL DEFAULT '',
+			reason_code TEXT NOT NULL CHECK(reason_code IN ('spam', 'harassment', 'hate', 'sexual', 'violence', 'self_harm', 'misinformation', 'other')),
+			details TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'open' CHECK(status IN ('open', 'claimed', 'resolved', 'dismissed')),
+			claimed_by INTEGER,
+			claimed_at TIMESTAMP,
+			resolved_by INTEGER,
+			resolved_at TIMESTAMP,
+			action TEXT,
+			resolution_note TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (reporter_id, target_type, target_id),
+			FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (target_user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (claimed_by) REFERENCES users(id) ON DELETE SET NULL,
+			FOREIGN KEY (resolved_by) REFERENCES users(id) ON DELETE SET NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, created_at)`)
+	if err != nil {
+		return err
+	}
+
+	// Create user_sanctions table (warnings, bans and mutes issued by moderators)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_sanctions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			moderator_id INTEGER,
+			type TEXT NOT NULL CHECK(type IN ('warning', 'ban', 'mute')),
+			reason TEXT NOT NULL DEFAULT '',
+			report_id INTEGER,
+			expires_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (moderator_id) REFERENCES users(id) ON DELETE SET NULL,
+			FOREIGN KEY (report_id) REFERENCES reports(id) ON DELETE SET NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create audit_events table (no foreign keys, so entries outlive the users they mention)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor_id INTEGER,
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL DEFAULT '',
+			target_id INTEGER,
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			details TEXT NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id, id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action, id)`)
+	if err != nil {
+		return err
+	}
+
+	// Make the audit log append-only
+	_, err = db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS audit_events_no_update
+		BEFORE UPDATE ON audit_events
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_events is append-only');
+		END;
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS audit_events_no_delete
+		BEFORE DELETE ON audit_events
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_events is append-only');
+		END;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create conversations table (direct_key is "lowID:highID" for one-to-one chats)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL CHECK(type IN ('direct', 'group')),
+			name TEXT NOT NULL DEFAULT '',
+			direct_key TEXT UNIQUE,
+			created_by INTEGER,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create conversation_members table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversation_members (
+			conversation_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL DEFAULT 'member' CHECK(role IN ('owner', 'admin', 'member')),
+			joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_read_at TIMESTAMP,
+			PRIMARY KEY (conversation_id, user_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_conversation_members_user ON conversation_members(user_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Let messages belong to a conversation instead of a single receiver
+	if err := rebuildMessagesForConversations(db); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, created_at)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_pair ON messages(sender_id, receiver_id, created_at)`)
+	if err != nil {
+		return err
+	}
+
+	// Move one-to-one messages that predate conversations into direct conversations
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO conversations (type, direct_key, created_at, updated_at)
+		SELECT 'direct', MIN(sender_id, receiver_id) || ':' || MAX(sender_id, receiver_id),
+		       MIN(created_at), MAX(created_at)
+		FROM messages
+		WHERE conversation_id IS NULL AND receiver_id IS NOT NULL
+		GROUP BY MIN(sender_id, receiver_id), MAX(sender_id, receiver_id)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO conversation_members (conversation_id, user_id, role, joined_at)
+		SELECT id, CAST(substr(direct_key, 1, instr(direct_key, ':') - 1) AS INTEGER), 'member', created_at
+		FROM conversations WHERE type = 'direct'
+		UNION ALL
+		SELECT id, CAST(substr(direct_key, instr(direct_key, ':') + 1) AS INTEGER), 'member', created_at
+		FROM conversations WHERE type = 'direct'
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		UPDATE messages
+		SET conversation_id = (
+			SELECT c.id FROM conversations c
+			WHERE c.direct_key = MIN(messages.sender_id, messages.receiver_id) || ':' || MAX(messages.sender_id, messages.receiver_id)
+		)
+		WHERE conversation_id IS NULL AND receiver_id IS NOT NULL
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create message_receipts table (per-recipient delivery and read times)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS message_receipts (
+			message_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			delivered_at TIMESTAMP,
+			read_at TIMESTAMP,
+			PRIMARY KEY (message_id, user_id),
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Direct messages read before receipts existed only have is_read; their
+	// send time is the best estimate we have
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO message_receipts (message_id, user_id, delivered_at, read_at)
+		SELECT id, receiver_id, created_at, created_at
+		FROM messages
+		WHERE is_read = TRUE AND receiver_id IS NOT NULL
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Idempotency keys let clients retry a send without creating a second message
+	if err := addColumnIfMissing(db, "messages", "client_msg_id", "TEXT"); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_msg
+		ON messages(sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create broker_events table (WebSocket events relayed between server instances)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS broker_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			origin TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create broker_instances table (heartbeats of running server instances)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS broker_instances (
+			instance_id TEXT PRIMARY KEY,
+			seen_at TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create broker_presence table (users connected to each server instance)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS broker_presence (
+			instance_id TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			username TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'online',
+			idle BOOLEAN NOT NULL DEFAULT FALSE,
+			PRIMARY KEY (instance_id, user_id)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "broker_presence", "status", "TEXT NOT NULL DEFAULT 'online'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "broker_presence", "idle", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+
+	// Create attachments table (uploaded files, linked to a message, post or comment once used)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			uploader_id INTEGER NOT NULL,
+			message_id INTEGER,
+			post_id INTEGER,
+			comment_id INTEGER,
+			blob_hash TEXT NOT NULL,
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			width INTEGER,
+			height INTEGER,
+			thumbnail_hash TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+			FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "attachments", "post_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "attachments", "comment_id", "INTEGER"); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments(message_id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_uploader ON attachments(uploader_id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_post ON attachments(post_id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_comment ON attachments(comment_id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_blob ON attachments(blob_hash)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_thumbnail ON attachments(thumbnail_hash)`)
+	if err != nil {
+		return err
+	}
+
+	// Cache the HTML rendered from Markdown content; NULL until rendered
+	for _, table := range []string{"posts", "comments", "messages"} {
+		if err := addColumnIfMissing(db, table, "content_html", "TEXT"); err != nil {
+			return err
+		}
+	}
+
+	// Create mentions table (@username in a post, or in a comment when comment_id is set)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS mentions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			author_id INTEGER NOT NULL,
+			post_id INTEGER NOT NULL,
+			comment_id INTEGER,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+			FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_mentions_user ON mentions(user_id, id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_mentions_post ON mentions(post_id, comment_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create notifications table (activity concerning a user, shown in their notification center)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS notifications ` + notificationsColumns)
+	if err != nil {
+		return err
+	}
+
+	// Allow the notification types added since the table was created
+	if err := rebuildNotificationsForNewTypes(db); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(user_id, is_read)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_notifications_post ON notifications(post_id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_notifications_comment ON notifications(comment_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create notification_preferences table (optional notification types a user turned on or off)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_preferences (
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			PRIMARY KEY (user_id, type),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create push_subscriptions table (Web Push endpoints, one per browser)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS push_subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			endpoint TEXT NOT NULL UNIQUE,
+			p256dh TEXT NOT NULL,
+			auth TEXT NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user ON push_subscriptions(user_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create vapid_keys table (the key pair every instance signs Web Push requests with)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS vapid_keys (
+			id INTEGER PRIMARY KEY CHECK(id = 1),
+			private_key TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create follows table (posts, categories and users a user follows for their feed)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS follows (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			target_type TEXT NOT NULL CHECK(target_type IN ('post', 'category', 'user')),
+			target_id INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, target_type, target_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_follows_target ON follows(target_type, target_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create bookmark folders table (named groups a user files bookmarks into)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bookmark_folders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, name),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create bookmarks table (posts a user saved, optionally in a folder)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bookmarks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			post_id INTEGER NOT NULL,
+			folder_id INTEGER,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, post_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+			FOREIGN KEY (folder_id) REFERENCES bookmark_folders(id) ON DELETE SET NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_bookmarks_post ON bookmarks(post_id)`)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// notificationsColumns defines the notifications table. The type list is a CHECK
+// constraint, so adding a type means rebuilding the table.
+const notificationsColumns = `(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	type TEXT NOT NULL CHECK(type IN ('post_reply', 'comment_reply', 'reaction', 'mention', 'moderation', 'followed_post')),
+	actor_id INTEGER,
+	post_id INTEGER,
+	comment_id INTEGER,
+	text TEXT NOT NULL DEFAULT '',
+	is_read BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+)`
+
+// rebuildNotificationsForNewTypes recreates the notifications table when its CHECK
+// constraint predates the newest notification type, since SQLite can't alter a
+// constraint in place. Indexes are created again by the caller.
+func rebuildNotificationsForNewTypes(db *sql.DB) error {
+	var definition string
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'notifications'`).Scan(&definition)
+	if err != nil || strings.Contains(definition, "'followed_post'") {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE notifications_new ` + notificationsColumns,
+		`INSERT INTO notifications_new (id, user_id, type, actor_id, post_id, comment_id, text, is_read, created_at)
+		 SELECT id, user_id, type, actor_id, post_id, comment_id, text, is_read, created_at FROM notifications`,
+		`DROP TABLE notifications`,
+		`ALTER TABLE notifications_new RENAME TO notifications`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rebuildMessagesForConversations recreates the messages table with a conversation_id
+// column and a nullable receiver_id, since SQLite can't drop a NOT NULL constraint in place
+func rebuildMessagesForConversations(db *sql.DB) error {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM pragma_table_info('messages') WHERE name = 'conversation_id')`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE messages_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id INTEGER,
+			sender_id INTEGER NOT NULL,
+			receiver_id INTEGER,
+			content TEXT NOT NULL,
+			is_read BOOLEAN DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			is_hidden BOOLEAN NOT NULL DEFAULT FALSE,
+			edited_at TIMESTAMP,
+			deleted_at TIMESTAMP,
+			FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`INSERT INTO messages_new (id, sender_id, receiver_id, content, is_read, created_at, is_hidden, edited_at, deleted_at)
+		 SELECT id, sender_id, receiver_id, content, is_read, created_at, is_hidden, edited_at, deleted_at FROM messages`,
+		`DROP TABLE messages`,
+		`ALTER TABLE messages_new RENAME TO messages`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already there
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+	"real-time-forum/backend/internal/storage"
+)
+
+const (
+	// thumbnailSize is the longest side of generated image thumbnails, in pixels
+	thumbnailSize = 320
+
+	// uploadGracePeriod is how long an upload may wait to be attached before it is collected
+	uploadGracePeriod = 24 * time.Hour
+
+	// blobGracePeriod keeps files stored this recently from being collected before the
+	// upload that stored them is recorded
+	blobGracePeriod = time.Hour
+
+	// collectInterval is how often unused uploads and files are collected
+	collectInterval = time.Hour
+)
+
+// inlineContentTypes are shown in the browser; everything else is downloaded
+var inlineContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+type AttachmentHandler struct {
+	db    *sql.DB
+	store *storage.BlobStore
+}
+
+func NewAttachmentHandler(db *sql.DB, store *storage.BlobStore) *AttachmentHandler {
+	return &AttachmentHandler{db: db, store: store}
+}
+
+// Upload stores the file in the "file" field of a multipart form, without the metadata
+// of JPEG and PNG images. The file stays private to the uploader until it is attached
+// to a message, post or comment.
+func (h *AttachmentHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := models.CheckCanPost(h.db, userID); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	used, err := models.GetAttachmentUsage(h.db, userID)
+	if err != nil {
+		log.Printf("Error getting attachment usage: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	limit := min(int64(models.MaxAttachmentSize), models.AttachmentQuota-used)
+	if limit <= 0 {
+		http.Error(w, models.ErrQuotaExceeded.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, models.MaxAttachmentSize+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	var part io.Reader
+	var filename string
+	for {
+		next, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+			return
+		}
+		if next.FormName() == "file" {
+			part, filename = next, next.FileName()
+			break
+		}
+	}
+	if part == nil {
+		http.Error(w, "Missing file field", http.StatusBadRequest)
+		return
+	}
+
+	// Sniff the type up front so image metadata never reaches the disk
+	head := bufio.NewReaderSize(part, 512)
+	sniffed, _ := head.Peek(512)
+	content := storage.StripMetadata(head, http.DetectContentType(sniffed))
+	defer content.Close()
+
+	blob, err := h.store.Put(content, limit)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, storage.ErrInvalidImage):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, storage.ErrTooLarge) && limit < models.MaxAttachmentSize:
+		http.Error(w, models.ErrQuotaExceeded.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, storage.ErrTooLarge), errors.As(err, &tooLarge):
+		http.Error(w, models.ErrAttachmentTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		log.Printf("Error storing upload: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	attachment := &models.Attachment{
+		UploaderID:  userID,
+		Filename:    filename,
+		ContentType: blob.ContentType,
+		Size:        blob.Size,
+		BlobHash:    blob.Hash,
+	}
+	h.addThumbnail(attachment)
+
+	// Parallel uploads all pass the check above, so the quota is checked again as the
+	// upload is recorded. A refused file is left for the garbage collector.
+	attachment, err = models.CreateAttachment(h.db, attachment)
+	if err == models.ErrQuotaExceeded {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating attachment: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// addThumbnail stores a thumbnail for an image attachment and records its dimensions.
+// Files that can't be decoded are kept without one.
+func (h *AttachmentHandler) addThumbnail(attachment *models.Attachment) {
+	file, err := h.store.Open(attachment.BlobHash)
+	if err != nil {
+		log.Printf("Error opening upload %s: %v", attachment.BlobHash, err)
+		return
+	}
+	defer file.Close()
+
+	thumbnail, err := storage.MakeThumbnail(file, attachment.ContentType, thumbnailSize)
+	if errors.Is(err, storage.ErrUnsupportedImage) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating thumbnail for %s: %v", attachment.BlobHash, err)
+		return
+	}
+
+	blob, err := h.store.Put(bytes.NewReader(thumbnail.Data), models.MaxAttachmentSize)
+	if err != nil {
+		log.Printf("Error storing thumbnail for %s: %v", attachment.BlobHash, err)
+		return
+	}
+	attachment.ThumbnailHash = blob.Hash
+	attachment.Width, attachment.Height = thumbnail.Width, thumbnail.Height
+}
+
+// HandleAttachmentRoutes serves /api/attachments/{id} and /api/attachments/{id}/thumbnail
+// to the uploader and to everyone who can see what the file is attached to
+func (h *AttachmentHandler) HandleAttachmentRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/attachments/")
+	idStr, isThumbnail := strings.CutSuffix(idStr, "/thumbnail")
+	attachmentID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := models.GetAttachment(h.db, attachmentID)
+	if err == models.ErrAttachmentNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting attachment: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Don't reveal attachments the user can't see
+	allowed, err := models.CanViewAttachment(h.db, attachment, userID)
+	if err != nil {
+		log.Printf("Error checking attachment access: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed || (isThumbnail && attachment.ThumbnailHash == "") {
+		http.Error(w, models.ErrAttachmentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	hash, contentType := attachment.BlobHash, attachment.ContentType
+	if isThumbnail {
+		hash, contentType = attachment.ThumbnailHash, storage.ThumbnailContentType(attachment.ContentType)
+	}
+	file, err := h.store.Open(hash)
+	if err != nil {
+		log.Printf("Error opening attachment %d: %v", attachment.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	disposition := "attachment"
+	if inlineContentTypes[contentType] {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", attachment.CreatedAt, file)
+}
+
+// CollectGarbage deletes uploads that were never attached and removes stored files that
+// no attachment uses any more, once at startup and then every collectInterval
+func (h *AttachmentHandler) CollectGarbage() {
+	ticker := time.NewTicker(collectInterval)
+	defer ticker.Stop()
+
+	for {
+		h.collect()
+		<-ticker.C
+	}
+}
+
+// collect runs one garbage collection pass
+func (h *AttachmentHandler) collect() {
+	uploads, err := models.DeleteStaleUploads(h.db, time.Now().Add(-uploadGracePeriod))
+	if err != nil {
+		log.Printf("Error deleting stale uploads: %v", err)
+		return
+	}
+
+	files, err := h.store.Sweep(time.Now().Add(-blobGracePeriod), func(hash string) (bool, error) {
+		return models.BlobInUse(h.db, hash)
+	})
+	if err != nil {
+		log.Printf("Error sweeping stored files: %v", err)
+	}
+
+	if uploads > 0 || files > 0 {
+		log.Printf("Collected %d stale uploads and %d unused files", uploads, files)
+	}
+}
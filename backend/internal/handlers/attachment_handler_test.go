@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+	"real-time-forum/backend/internal/storage"
+)
+
+// newTestStore opens an empty blob store
+func newTestStore(t *testing.T) (*storage.BlobStore, string) {
+	t.Helper()
+	root := t.TempDir()
+	store, err := storage.NewBlobStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, root
+}
+
+func TestCollectKeepsAttachedBlobs(t *testing.T) {
+	quietLogs(t)
+	db := newTestDB(t)
+	store, root := newTestStore(t)
+	user := newTestUser(t, db, "alice")
+
+	put := func(content string) string {
+		t.Helper()
+		blob, err := store.Put(strings.NewReader(content), 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob.Hash
+	}
+	file, thumbnail, orphan, stale := put("file"), put("thumbnail"), put("orphan"), put("stale upload")
+
+	if _, err := models.CreateAttachment(db, &models.Attachment{
+		UploaderID: user.ID, Filename: "photo.jpg", ContentType: "image/jpeg", Size: 4,
+		BlobHash: file, ThumbnailHash: thumbnail,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	staleUpload, err := models.CreateAttachment(db, &models.Attachment{
+		UploaderID: user.ID, Filename: "old.txt", ContentType: "text/plain", Size: 12, BlobHash: stale,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE attachments SET created_at = ? WHERE id = ?", time.Now().Add(-uploadGracePeriod-time.Hour), staleUpload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every blob is past the grace period that protects uploads still being recorded
+	old := time.Now().Add(-blobGracePeriod - time.Minute)
+	for _, hash := range []string{file, thumbnail, orphan, stale} {
+		if err := os.Chtimes(filepath.Join(root, hash[:2], hash), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	NewAttachmentHandler(db, store).collect()
+
+	for hash, kept := range map[string]bool{file: true, thumbnail: true, orphan: false, stale: false} {
+		f, err := store.Open(hash)
+		if err == nil {
+			f.Close()
+		}
+		if exists := !errors.Is(err, storage.ErrBlobNotFound); exists != kept {
+			t.Errorf("blob %s exists = %v after collection, want %v (%v)", hash[:8], exists, kept, err)
+		}
+	}
+	if _, err := models.GetAttachment(db, staleUpload.ID); err != models.ErrAttachmentNotFound {
+		t.Errorf("stale upload: %v, want ErrAttachmentNotFound", err)
+	}
+}
+
+// uploadRequest builds a multipart upload of content as userID
+func uploadRequest(t *testing.T, userID int64, body io.Reader, contentType string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/attachments", body)
+	req.Header.Set("Content-Type", contentType)
+	return req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, userID))
+}
+
+// gatedBody is a request body that reports when the handler starts reading it, which
+// is after the quota check, and then waits for the gate to open
+type gatedBody struct {
+	body    io.Reader
+	reading chan<- struct{}
+	gate    <-chan struct{}
+	once    sync.Once
+}
+
+func (b *gatedBody) Read(p []byte) (int, error) {
+	b.once.Do(func() {
+		b.reading <- struct{}{}
+		<-b.gate
+	})
+	return b.body.Read(p)
+}
+
+// Parallel uploads each pass the quota check made before streaming, so the quota must
+// hold when they are recorded
+func TestParallelUploadsStayWithinQuota(t *testing.T) {
+	quietLogs(t)
+	db := newTestDB(t)
+	store, _ := newTestStore(t)
+	handler := NewAttachmentHandler(db, store)
+	user := newTestUser(t, db, "alice")
+
+	const fileSize = 1 << 20
+	if _, err := models.CreateAttachment(db, &models.Attachment{
+		UploaderID: user.ID, Filename: "big.bin", ContentType: "application/octet-stream",
+		Size: models.AttachmentQuota - 2*fileSize - fileSize/2, BlobHash: strings.Repeat("0", 64),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold every upload after its quota check until all of them have passed it
+	const uploads = 8
+	reading := make(chan struct{})
+	gate := make(chan struct{})
+	codes := make([]int, uploads)
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", "data.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(bytes.Repeat([]byte{byte(i)}, fileSize))
+		form.Close()
+		req := uploadRequest(t, user.ID, &gatedBody{body: &body, reading: reading, gate: gate}, form.FormDataContentType())
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.Upload(rec, req)
+			codes[i] = rec.Code
+		}(i)
+	}
+	for i := 0; i < uploads; i++ {
+		<-reading
+	}
+	close(gate)
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusRequestEntityTooLarge:
+		default:
+			t.Errorf("upload answered %d", code)
+		}
+	}
+	if created != 2 {
+		t.Errorf("%d uploads were accepted (%v), want the 2 that fit", created, codes)
+	}
+	used, err := models.GetAttachmentUsage(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used > models.AttachmentQuota {
+		t.Errorf("usage %d is over the quota of %d", used, models.AttachmentQuota)
+	}
+}
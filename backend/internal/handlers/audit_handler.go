@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"real-time-forum/backend/internal/audit"
+)
+
+// maxAuditExport caps how many rows a single CSV export may contain
+const maxAuditExport = 10000
+
+type AuditHandler struct {
+	db *sql.DB
+}
+
+func NewAuditHandler(db *sql.DB) *AuditHandler {
+	return &AuditHandler{db: db}
+}
+
+// ListEvents returns audit events filtered by actor, action, target and time range,
+// as JSON or, with format=csv, as a CSV download
+func (h *AuditHandler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	asCSV := query.Get("format") == "csv"
+	filter := audit.Filter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		Limit:      100,
+	}
+	if asCSV {
+		filter.Limit = maxAuditExport
+	}
+
+	for param, dest := range map[string]*int64{"actor_id": &filter.ActorID, "target_id": &filter.TargetID} {
+		if value := query.Get(param); value != "" {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid "+param+" parameter", http.StatusBadRequest)
+				return
+			}
+			*dest = id
+		}
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid "+param+" parameter, expected RFC 3339", http.StatusBadRequest)
+				return
+			}
+			*dest = t
+		}
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= maxAuditExport {
+			filter.Limit = parsedLimit
+		}
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			filter.Offset = parsedOffset
+		}
+	}
+
+	events, err := audit.Query(h.db, filter)
+	if err != nil {
+		log.Printf("Error querying audit events: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !asCSV {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-events.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created_at", "actor_id", "actor_name", "action", "target_type", "target_id", "ip", "user_agent", "details"})
+	for _, e := range events {
+		details, _ := json.Marshal(e.Details)
+		cw.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			formatOptionalID(e.ActorID),
+			e.ActorName,
+			e.Action,
+			e.TargetType,
+			formatOptionalID(e.TargetID),
+			e.IP,
+			e.UserAgent,
+			string(details),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("Error writing audit CSV: %v", err)
+	}
+}
+
+func formatOptionalID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
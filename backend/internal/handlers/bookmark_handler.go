@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type BookmarkHandler struct {
+	db *sql.DB
+}
+
+func NewBookmarkHandler(db *sql.DB) *BookmarkHandler {
+	return &BookmarkHandler{db: db}
+}
+
+type bookmarkFolderRequest struct {
+	Name string `json:"name"`
+}
+
+// HandleFolders lists the caller's bookmark folders on GET and creates one on POST
+func (h *BookmarkHandler) HandleFolders(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		folders, err := models.ListBookmarkFolders(h.db, userID)
+		if err != nil {
+			log.Printf("Error listing bookmark folders: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(folders)
+	case http.MethodPost:
+		var req bookmarkFolderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		folder, err := models.CreateBookmarkFolder(h.db, userID, req.Name)
+		if err != nil {
+			writeBookmarkFolderError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(folder)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleFolderRoutes renames a bookmark folder on PUT /api/bookmarks/folders/{id} and
+// deletes it on DELETE
+func (h *BookmarkHandler) HandleFolderRoutes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	folderID, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/bookmarks/folders/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req bookmarkFolderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		folder, err := models.RenameBookmarkFolder(h.db, userID, folderID, req.Name)
+		if err != nil {
+			writeBookmarkFolderError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(folder)
+	case http.MethodDelete:
+		if err := models.DeleteBookmarkFolder(h.db, userID, folderID); err != nil {
+			writeBookmarkFolderError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeBookmarkFolderError maps bookmark folder errors to HTTP responses
+func writeBookmarkFolderError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrInvalidFolderName:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case models.ErrBookmarkFolderNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrBookmarkFolderExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Error updating bookmark folder: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
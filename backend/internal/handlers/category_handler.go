@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real-time-forum/backend/internal/audit"
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type CategoryHandler struct {
+	db *sql.DB
+}
+
+func NewCategoryHandler(db *sql.DB) *CategoryHandler {
+	return &CategoryHandler{db: db}
+}
+
+// HandleAdminCategories lists every category (GET) or creates one (POST)
+func (h *CategoryHandler) HandleAdminCategories(w http.ResponseWriter, r *http.Request) {
+	if !auth.Can(r, models.PermManageCategories) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		categories, err := models.ListAllCategories(h.db)
+		if err != nil {
+			log.Printf("Error listing categories: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(categories)
+
+	case http.MethodPost:
+		var req models.CategoryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		category, err := models.CreateCategory(h.db, req)
+		if err != nil {
+			writeCategoryError(w, err)
+			return
+		}
+
+		recordCategoryEvent(h.db, r, audit.ActionCategoryCreated, category.ID, category.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(category)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAdminCategoryRoutes reads (GET), updates (PUT) or deletes (DELETE) a single category
+func (h *CategoryHandler) HandleAdminCategoryRoutes(w http.ResponseWriter, r *http.Request) {
+	if !auth.Can(r, models.PermManageCategories) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/admin/categories/")
+	categoryID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		category, err := models.GetCategoryByID(h.db, categoryID)
+		if err != nil {
+			writeCategoryError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(category)
+
+	case http.MethodPut:
+		var req models.CategoryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		category, err := models.UpdateCategory(h.db, categoryID, req)
+		if err != nil {
+			writeCategoryError(w, err)
+			return
+		}
+
+		recordCategoryEvent(h.db, r, audit.ActionCategoryUpdated, category.ID, category.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(category)
+
+	case http.MethodDelete:
+		if err := models.DeleteCategory(h.db, categoryID); err != nil {
+			writeCategoryError(w, err)
+			return
+		}
+
+		recordCategoryEvent(h.db, r, audit.ActionCategoryDeleted, categoryID, "")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// recordCategoryEvent audits an admin change to a category
+func recordCategoryEvent(db *sql.DB, r *http.Request, action string, categoryID int64, name string) {
+	adminID, _ := auth.GetUserID(r)
+	event := audit.Event{
+		ActorID:    adminID,
+		Action:     action,
+		TargetType: "category",
+		TargetID:   categoryID,
+	}
+	if name != "" {
+		event.Details = map[string]interface{}{"name": name}
+	}
+	audit.Record(db, r, event)
+}
+
+// writeCategoryError maps category model errors to HTTP responses
+func writeCategoryError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrEmptyCategoryName, models.ErrInvalidSlug, models.ErrInvalidColor, models.ErrInvalidRole:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case models.ErrCategoryNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrCategoryExists, models.ErrCategoryInUse:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Error managing category: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type ConversationHandler struct {
+	db  *sql.DB
+	hub *Hub
+}
+
+func NewConversationHandler(db *sql.DB, hub *Hub) *ConversationHandler {
+	return &ConversationHandler{db: db, hub: hub}
+}
+
+// CreateConversation starts a named group conversation with the caller as owner
+func (h *ConversationHandler) CreateConversation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name      string  `json:"name"`
+		MemberIDs []int64 `json:"member_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	conversation, err := models.CreateGroupConversation(h.db, userID, req.Name, req.MemberIDs)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	h.broadcastUpdate(conversation.ID, ConversationUpdatedData{
+		ConversationID: conversation.ID,
+		Action:         "created",
+		ActorID:        userID,
+		Name:           conversation.Name,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// HandleConversationRoutes handles /api/conversations/{id}[/messages|/members[/{userID}]|/leave|/read]
+func (h *ConversationHandler) HandleConversationRoutes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/conversations/")
+	parts := strings.Split(path, "/")
+
+	conversationID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			h.getConversation(w, conversationID, userID)
+		case http.MethodPut:
+			h.renameConversation(w, r, conversationID, userID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case len(parts) == 2 && parts[1] == "messages":
+		switch r.Method {
+		case http.MethodGet:
+			h.listMessages(w, r, conversationID, userID)
+		case http.MethodPost:
+			h.sendMessage(w, r, conversationID, userID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case len(parts) == 2 && parts[1] == "members":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.addMembers(w, r, conversationID, userID)
+
+	case len(parts) == 3 && parts[1] == "members":
+		memberID, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			h.setMemberRole(w, r, conversationID, userID, memberID)
+		case http.MethodDelete:
+			h.removeMember(w, conversationID, userID, memberID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case len(parts) == 2 && parts[1] == "leave":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.removeMember(w, conversationID, userID, userID)
+
+	case len(parts) == 2 && parts[1] == "read":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.markRead(w, r, conversationID, userID)
+
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+	}
+}
+
+func (h *ConversationHandler) getConversation(w http.ResponseWriter, conversationID, userID int64) {
+	conversation, err := models.GetConversation(h.db, conversationID, userID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+func (h *ConversationHandler) renameConversation(w http.ResponseWriter, r *http.Request, conversationID, userID int64) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.RenameConversation(h.db, conversationID, userID, req.Name); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	conversation, err := models.GetConversation(h.db, conversationID, userID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	h.broadcastUpdate(conversationID, ConversationUpdatedData{
+		ConversationID: conversationID,
+		Action:         "renamed",
+		ActorID:        userID,
+		Name:           conversation.Name,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+func (h *ConversationHandler) listMessages(w http.ResponseWriter, r *http.Request, conversationID, userID int64) {
+	limit := 10
+	offset := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	messages, err := models.GetConversationMessages(h.db, conversationID, userID, limit, offset)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+func (h *ConversationHandler) sendMessage(w http.ResponseWriter, r *http.Request, conversationID, userID int64) {
+	var req struct {
+		Content     string `json:"content"`
+		ClientMsgID string `json:"client_msg_id,omitempty"` // optional idempotency key
+	}
+	if !decodeContentRequest(w, r, &req) {
+		return
+	}
+
+	message, created, err := models.CreateConversationMessage(h.db, conversationID, userID, req.Content, req.ClientMsgID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !created {
+		// A retried send returns the original message without delivering it again
+		json.NewEncoder(w).Encode(message)
+		return
+	}
+
+	if h.hub != nil {
+		h.hub.broadcastMessage(message, message.Sender)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+func (h *ConversationHandler) addMembers(w http.ResponseWriter, r *http.Request, conversationID, userID int64) {
+	var req struct {
+		UserIDs []int64 `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	added, err := models.AddConversationMembers(h.db, conversationID, userID, req.UserIDs)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	if len(added) > 0 {
+		h.broadcastUpdate(conversationID, ConversationUpdatedData{
+			ConversationID: conversationID,
+			Action:         "members_added",
+			ActorID:        userID,
+			UserIDs:        added,
+		})
+	}
+
+	h.getConversation(w, conversationID, userID)
+}
+
+func (h *ConversationHandler) setMemberRole(w http.ResponseWriter, r *http.Request, conversationID, userID, memberID int64) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.SetConversationMemberRole(h.db, conversationID, userID, memberID, req.Role); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	h.broadcastUpdate(conversationID, ConversationUpdatedData{
+		ConversationID: conversationID,
+		Action:         "role_changed",
+		ActorID:        userID,
+		UserIDs:        []int64{memberID},
+	})
+
+	h.getConversation(w, conversationID, userID)
+}
+
+// markRead marks the conversation read up to message_id, or entirely when the body is
+// empty, and sends read receipts to the senders
+func (h *ConversationHandler) markRead(w http.ResponseWriter, r *http.Request, conversationID, userID int64) {
+	var req struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	read, err := models.MarkConversationRead(h.db, conversationID, userID, req.MessageID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	if h.hub != nil {
+		h.hub.sendReadReceipts(userID, read)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "read": len(read)})
+}
+
+// removeMember kicks memberID, or lets the caller leave when memberID is themselves
+func (h *ConversationHandler) removeMember(w http.ResponseWriter, conversationID, userID, memberID int64) {
+	if err := models.RemoveConversationMember(h.db, conversationID, userID, memberID); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	action := "member_removed"
+	if memberID == userID {
+		action = "member_left"
+	}
+	update := ConversationUpdatedData{
+		ConversationID: conversationID,
+		Action:         action,
+		ActorID:        userID,
+		UserIDs:        []int64{memberID},
+	}
+	h.broadcastUpdate(conversationID, update)
+
+	// The removed user is no longer a member, so tell their clients directly
+	if h.hub != nil {
+		h.hub.sendToUser(memberID, WSMessage{
+			Type:      MessageTypeConversationUpdated,
+			Data:      update,
+			Timestamp: time.Now(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// broadcastUpdate tells every current member that the conversation changed
+func (h *ConversationHandler) broadcastUpdate(conversationID int64, update ConversationUpdatedData) {
+	if h.hub == nil {
+		return
+	}
+	h.hub.sendToConversation(conversationID, WSMessage{
+		Type:      MessageTypeConversationUpdated,
+		Data:      update,
+		Timestamp: time.Now(),
+	})
+}
+
+// writeConversationError maps conversation model errors to HTTP responses
+func writeConversationError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrInvalidConversationName, models.ErrInvalidMemberRole, models.ErrEmptyMessage,
+		models.ErrNotGroupConversation, models.ErrGroupTooLarge, models.ErrInvalidClientID:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case models.ErrConversationNotFound, models.ErrNotConversationMember, models.ErrUserNotFound,
+		models.ErrMessageNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrForbidden, models.ErrCannotRemoveOwner, models.ErrUserMuted, models.ErrUserBanned:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("Error handling conversation: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"real-time-forum/backend/internal/mail"
+	"real-time-forum/backend/internal/models"
+)
+
+const (
+	// digestInterval is how often the sender looks for users whose digest is due
+	digestInterval = 15 * time.Minute
+
+	// digestListLimit is how many messages and notifications a digest lists; the rest
+	// are only counted
+	digestListLimit = 10
+)
+
+// DigestSender emails users a digest of the messages and notifications they missed
+type DigestSender struct {
+	db      *sql.DB
+	hub     *Hub
+	mailer  mail.Mailer
+	siteURL string
+}
+
+func NewDigestSender(db *sql.DB, hub *Hub, mailer mail.Mailer, siteURL string) *DigestSender {
+	return &DigestSender{db: db, hub: hub, mailer: mailer, siteURL: siteURL}
+}
+
+// Run sends the digests that are due, once at startup and then every digestInterval
+func (s *DigestSender) Run() {
+	ticker := time.NewTicker(digestInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sendDue()
+		<-ticker.C
+	}
+}
+
+// sendDue runs one pass over the users whose digest is due
+func (s *DigestSender) sendDue() {
+	now := time.Now()
+	recipients, err := models.DueDigests(s.db, now)
+	if err != nil {
+		log.Printf("Error finding due digests: %v", err)
+		return
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	userIDs := make([]int64, len(recipients))
+	for i, recipient := range recipients {
+		userIDs[i] = recipient.UserID
+	}
+	connected := s.hub.Connected(userIDs)
+
+	sent := 0
+	for _, recipient := range recipients {
+		// Users who are here right now see their activity live
+		if connected[recipient.UserID] {
+			continue
+		}
+		if s.send(recipient, now) {
+			sent++
+		}
+	}
+	if sent > 0 {
+		log.Printf("Sent %d email digests", sent)
+	}
+}
+
+// send emails one user their digest. Users with nothing new get no email, and their
+// digest stays due so the next one covers everything since their last visit.
+func (s *DigestSender) send(recipient models.DigestRecipient, now time.Time) bool {
+	activity, err := models.GetDigestActivity(s.db, recipient.UserID, recipient.Since, digestListLimit)
+	if err != nil {
+		log.Printf("Error collecting digest for user %d: %v", recipient.UserID, err)
+		return false
+	}
+	if activity.Empty() {
+		return false
+	}
+
+	claimed, err := models.ClaimDigest(s.db, recipient, now)
+	if err != nil {
+		log.Printf("Error claiming digest for user %d: %v", recipient.UserID, err)
+		return false
+	}
+	if !claimed {
+		return false // another instance is sending it
+	}
+
+	digest := mail.Digest{
+		To:                recipient.Email,
+		Name:              recipient.FirstName,
+		Frequency:         recipient.Frequency,
+		SiteURL:           s.siteURL,
+		MessageCount:      activity.MessageCount,
+		NotificationCount: activity.NotificationCount,
+	}
+	if digest.Name == "" {
+		digest.Name = recipient.Username
+	}
+	for _, message := range activity.Messages {
+		excerpt := models.MentionExcerpt(message.Content)
+		if excerpt == "" {
+			excerpt = "(attachment)"
+		}
+		digest.Messages = append(digest.Messages, mail.DigestMessage{
+			From:         message.Sender.Username,
+			Conversation: message.ConversationName,
+			Excerpt:      excerpt,
+			SentAt:       message.CreatedAt,
+		})
+	}
+	for _, notification := range activity.Notifications {
+		digest.Notifications = append(digest.Notifications, mail.DigestNotification{
+			Summary: notification.Summary(),
+			Text:    notification.Text,
+		})
+	}
+
+	msg, err := digest.Message()
+	if err != nil {
+		log.Printf("Error rendering digest for user %d: %v", recipient.UserID, err)
+		return false
+	}
+	if err := s.mailer.Send(msg); err != nil {
+		log.Printf("Error sending digest to user %d: %v", recipient.UserID, err)
+		return false
+	}
+	return true
+}
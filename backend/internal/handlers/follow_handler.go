@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type FollowHandler struct {
+	db *sql.DB
+}
+
+func NewFollowHandler(db *sql.DB) *FollowHandler {
+	return &FollowHandler{db: db}
+}
+
+// ListFollows returns the posts, categories and users the caller follows
+func (h *FollowHandler) ListFollows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	follows, err := models.ListFollows(h.db, userID)
+	if err != nil {
+		log.Printf("Error listing follows: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(follows)
+}
+
+// HandleFollowRoutes follows a post, category or user on POST /api/follows/{type}/{id}
+// and unfollows it on DELETE
+func (h *FollowHandler) HandleFollowRoutes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/follows/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	targetType := parts[0]
+	targetID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		follow, err := models.FollowTarget(h.db, userID, targetType, targetID)
+		if err != nil {
+			switch err {
+			case models.ErrInvalidFollowType, models.ErrFollowSelf:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case models.ErrPostNotFound, models.ErrCategoryNotFound, models.ErrUserNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			default:
+				log.Printf("Error following %s %d: %v", targetType, targetID, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(follow)
+	case http.MethodDelete:
+		if err := models.UnfollowTarget(h.db, userID, targetType, targetID); err != nil {
+			if err == models.ErrFollowNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			log.Printf("Error unfollowing %s %d: %v", targetType, targetID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// FollowingFeed returns a page of activity from what the caller follows, newest first
+func (h *FollowHandler) FollowingFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	page, err := models.ListFollowingFeed(h.db, userID, limit, r.URL.Query().Get("cursor"))
+	if err != nil {
+		if err == models.ErrInvalidFeedCursor {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error loading following feed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"real-time-forum/backend/internal/database"
+	"real-time-forum/backend/internal/models"
+)
+
+// newTestDB opens a fresh database file with the full schema
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	return openTestDB(t, filepath.Join(t.TempDir(), "forum.db"))
+}
+
+// openTestDB opens a database file, creating the schema if it isn't there yet. Opening
+// the same file twice stands in for two server instances sharing a database.
+func openTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.InitializeSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// quietLogs silences logging for the rest of a test
+func quietLogs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// newTestClient makes a client with no connection behind it, so tests can read what
+// the hub sends it straight off its send channel
+func newTestClient(hub *Hub, userID int64, buffer int) *Client {
+	return &Client{
+		send:   make(chan WSMessage, buffer),
+		hub:    hub,
+		userID: userID,
+		user: &models.User{
+			ID:       userID,
+			Username: "user" + strconv.FormatInt(userID, 10),
+			Role:     models.RoleUser,
+			Status:   models.StatusOnline,
+		},
+		protocol: ProtocolV2,
+	}
+}
+
+// newTestUser registers a user with the given username
+func newTestUser(t *testing.T, db *sql.DB, username string) *models.User {
+	t.Helper()
+	user, err := models.CreateUser(db, models.RegisterRequest{
+		Username:  username,
+		Email:     username + "@example.com",
+		Password:  "password",
+		FirstName: username,
+		LastName:  "Test",
+		Age:       30,
+		Gender:    "other",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
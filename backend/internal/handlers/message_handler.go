@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"real-time-forum/backend/internal/auth"
@@ -21,7 +22,8 @@ func NewMessageHandler(db *sql.DB, hub *Hub) *MessageHandler {
 	return &MessageHandler{db: db, hub: hub}
 }
 
-// GetConversations retrieves all conversations for the authenticated user
+// GetConversations retrieves a page of the authenticated user's conversations, most
+// recently active first
 func (h *MessageHandler) GetConversations(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -35,17 +37,73 @@ func (h *MessageHandler) GetConversations(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	conversations, err := models.GetUserConversations(h.db, userID)
+	// Get pagination parameters
+	limit := 50 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	offset := 0 // default
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	conversations, err := models.GetUserConversations(h.db, userID, limit, offset)
 	if err != nil {
 		log.Printf("Error getting conversations: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	// Fill in the live status of the other user in direct conversations
+	if h.hub != nil {
+		var userIDs []int64
+		for _, conv := range conversations {
+			if conv.UserID != 0 {
+				userIDs = append(userIDs, conv.UserID)
+			}
+		}
+		statuses := h.hub.Statuses(userIDs)
+		for i := range conversations {
+			if status, ok := statuses[conversations[i].UserID]; ok {
+				conversations[i].Status = status
+				conversations[i].IsOnline = status != models.StatusOffline
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(conversations)
 }
 
+// GetUnreadTotals returns the authenticated user's unread message and conversation counts
+func (h *MessageHandler) GetUnreadTotals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	totals, err := models.GetUnreadTotals(h.db, userID)
+	if err != nil {
+		log.Printf("Error getting unread totals: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totals)
+}
+
 // GetConversationHistory retrieves message history between the authenticated user and another user
 func (h *MessageHandler) GetConversationHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -75,23 +133,37 @@ func (h *MessageHandler) GetConversationHistory(w http.ResponseWriter, r *http.R
 
 	// Get pagination parameters
 	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
 
 	limit := 10 // default
 	if limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
 			limit = parsedLimit
 		}
 	}
 
-	offset := 0 // default
-	if offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
+	// Pages are addressed by message ID cursors rather than offsets, so messages
+	// arriving while the user scrolls don't shift them
+	var cursors [2]int64
+	for i, name := range []string{"before_id", "after_id"} {
+		if value := r.URL.Query().Get(name); value != "" {
+			cursors[i], err = strconv.ParseInt(value, 10, 64)
+			if err != nil || cursors[i] <= 0 {
+				http.Error(w, "Invalid "+name+" parameter", http.StatusBadRequest)
+				return
+			}
 		}
 	}
+	beforeID, afterID := cursors[0], cursors[1]
+	if beforeID != 0 && afterID != 0 {
+		http.Error(w, "Use either before_id or after_id, not both", http.StatusBadRequest)
+		return
+	}
 
-	messages, err := models.GetConversationHistory(h.db, userID, otherUserID, limit, offset)
+	page, err := models.GetConversationHistory(h.db, userID, otherUserID, limit, beforeID, afterID)
+	if err == models.ErrMessageNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting conversation history: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -99,7 +171,7 @@ func (h *MessageHandler) GetConversationHistory(w http.ResponseWriter, r *http.R
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(page)
 }
 
 // MarkAsRead marks messages from a specific user as read
@@ -125,13 +197,17 @@ func (h *MessageHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := models.MarkMessagesAsRead(h.db, userID, req.SenderID)
+	read, err := models.MarkMessagesAsRead(h.db, userID, req.SenderID)
 	if err != nil {
 		log.Printf("Error marking messages as read: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	if h.hub != nil {
+		h.hub.sendReadReceipts(userID, read)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
@@ -157,6 +233,18 @@ func (h *MessageHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Fill in the status each user appears with right now
+	if h.hub != nil {
+		userIDs := make([]int64, len(users))
+		for i, user := range users {
+			userIDs[i] = user.ID
+		}
+		statuses := h.hub.Statuses(userIDs)
+		for i := range users {
+			users[i].Status = statuses[users[i].ID]
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
 }
@@ -177,23 +265,25 @@ func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request body
 	var req struct {
-		ReceiverID int64  `json:"receiver_id"`
-		Content    string `json:"content"`
+		ReceiverID    int64   `json:"receiver_id"`
+		Content       string  `json:"content"`
+		ClientMsgID   string  `json:"client_msg_id,omitempty"`  // optional idempotency key
+		AttachmentIDs []int64 `json:"attachment_ids,omitempty"` // uploads from POST /api/attachments
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeContentRequest(w, r, &req) {
 		return
 	}
 
-	message, err := models.CreatePrivateMessage(h.db, userID, req.ReceiverID, req.Content)
+	message, created, err := models.CreatePrivateMessage(h.db, userID, req.ReceiverID, req.Content, req.ClientMsgID, req.AttachmentIDs)
 	if err != nil {
 		log.Printf("Error creating private message: %v", err)
-		switch err.Error() {
-		case "message content cannot be empty":
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case "cannot send message to yourself":
+		switch err {
+		case models.ErrUserMuted, models.ErrUserBanned:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case models.ErrEmptyMessage, models.ErrMessageToSelf, models.ErrInvalidClientID,
+			models.ErrTooManyAttachments, models.ErrAttachmentUnusable, models.ErrInvalidAttachmentID:
 			http.Error(w, err.Error(), http.StatusBadRequest)
-		case "receiver not found":
+		case models.ErrReceiverNotFound:
 			http.Error(w, err.Error(), http.StatusNotFound)
 		default:
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -201,37 +291,167 @@ func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A retried send returns the original message without delivering it again
+	if !created {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(message)
+		return
+	}
+
 	// Broadcast message via WebSocket if hub is available
 	if h.hub != nil {
 		// Get sender info for WebSocket message
 		sender, err := models.GetUserByID(h.db, userID)
 		if err == nil {
-			// Create WebSocket message data
-			messageData := PrivateMessageData{
-				ID:         message.ID,
-				SenderID:   message.SenderID,
-				ReceiverID: message.ReceiverID,
-				Content:    message.Content,
-				IsRead:     message.IsRead,
-				CreatedAt:  message.CreatedAt,
-				Sender:     sender,
-			}
+			// Send to the receiver and to the sender's other tabs
+			h.hub.broadcastMessage(message, sender)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+// HandleMessageRoutes handles /api/messages/{id}: PUT edits, DELETE unsends for both
+// participants, and DELETE with ?scope=me removes the message from the caller's view only.
+// GET /api/messages/{id}/context returns the message with the messages around it.
+func (h *MessageHandler) HandleMessageRoutes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/messages/")
+	idStr, isContext := strings.CutSuffix(idStr, "/context")
+	messageID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
 
-			// Create WebSocket message
+	if isContext {
+		h.getMessageContext(w, r, userID, messageID)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Content string `json:"content"`
+		}
+		if !decodeContentRequest(w, r, &req) {
+			return
+		}
+
+		message, err := models.EditPrivateMessage(h.db, messageID, userID, req.Content)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		if h.hub != nil {
+			h.hub.sendToConversation(message.ConversationID, WSMessage{
+				Type:      MessageTypeMessageEdited,
+				Data:      newPrivateMessageData(message, message.Sender),
+				Timestamp: time.Now(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(message)
+
+	case http.MethodDelete:
+		scope := r.URL.Query().Get("scope")
+		if scope == "" {
+			scope = DeleteScopeEveryone
+		}
+
+		var message *models.PrivateMessage
+		switch scope {
+		case DeleteScopeEveryone:
+			message, err = models.UnsendPrivateMessage(h.db, messageID, userID)
+		case DeleteScopeMe:
+			message, err = models.DeleteMessageForUser(h.db, messageID, userID)
+		default:
+			http.Error(w, "scope must be 'everyone' or 'me'", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		if h.hub != nil {
 			wsMessage := WSMessage{
-				Type:      MessageTypePrivateMessage,
-				Data:      messageData,
+				Type: MessageTypeMessageDeleted,
+				Data: MessageDeletedData{
+					MessageID:      message.ID,
+					ConversationID: message.ConversationID,
+					SenderID:       message.SenderID,
+					ReceiverID:     message.ReceiverID,
+					Scope:          scope,
+				},
 				Timestamp: time.Now(),
 			}
+			// A message deleted "for me" only disappears from the caller's own tabs
+			if scope == DeleteScopeEveryone {
+				h.hub.sendToConversation(message.ConversationID, wsMessage)
+			} else {
+				h.hub.sendToUser(userID, wsMessage)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getMessageContext returns a message and up to limit messages on either side of it,
+// so a client can jump to it in history
+func (h *MessageHandler) getMessageContext(w http.ResponseWriter, r *http.Request, userID, messageID int64) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-			// Send to receiver
-			h.hub.sendToUser(req.ReceiverID, wsMessage)
-			// Send to sender (for multi-tab support)
-			h.hub.sendToUser(userID, wsMessage)
+	limit := 10 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 50 {
+			limit = parsedLimit
 		}
 	}
 
+	surrounding, err := models.GetMessageContext(h.db, messageID, userID, limit)
+	if err == models.ErrMessageNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting message context: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(message)
+	json.NewEncoder(w).Encode(surrounding)
+}
+
+// writeMessageError maps message model errors to HTTP responses
+func writeMessageError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrEmptyMessage:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case models.ErrMessageNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrNotMessageSender, models.ErrEditWindowExpired, models.ErrUserMuted, models.ErrUserBanned:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("Error updating message: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
 }
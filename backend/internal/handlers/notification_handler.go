@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type NotificationHandler struct {
+	db *sql.DB
+}
+
+func NewNotificationHandler(db *sql.DB) *NotificationHandler {
+	return &NotificationHandler{db: db}
+}
+
+// ListNotifications returns the caller's notifications, newest first
+func (h *NotificationHandler) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	var beforeID int64
+	if value := r.URL.Query().Get("before_id"); value != "" {
+		var err error
+		beforeID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || beforeID <= 0 {
+			http.Error(w, "Invalid before_id parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	page, err := models.ListNotifications(h.db, userID, limit, beforeID, unreadOnly)
+	if err != nil {
+		log.Printf("Error listing notifications: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// HandleNotificationRoutes handles marking notifications read, notification preferences
+// and the email digest frequency
+func (h *NotificationHandler) HandleNotificationRoutes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/notifications/")
+	switch path {
+	case "read-all":
+		h.markAllRead(w, r, userID)
+		return
+	case "preferences":
+		h.handlePreferences(w, r, userID)
+		return
+	case "digest":
+		h.handleDigest(w, r, userID)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || parts[1] != "read" {
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	notificationID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := models.MarkNotificationRead(h.db, userID, notificationID); err != nil {
+		if err == models.ErrNotificationNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error marking notification %d read: %v", notificationID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.writeUnreadCount(w, userID)
+}
+
+func (h *NotificationHandler) markAllRead(w http.ResponseWriter, r *http.Request, userID int64) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := models.MarkAllNotificationsRead(h.db, userID); err != nil {
+		log.Printf("Error marking notifications read: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.writeUnreadCount(w, userID)
+}
+
+// writeUnreadCount answers a mark-read request with the unread count left
+func (h *NotificationHandler) writeUnreadCount(w http.ResponseWriter, userID int64) {
+	count, err := models.CountUnreadNotifications(h.db, userID)
+	if err != nil {
+		log.Printf("Error counting unread notifications: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unread_count": count})
+}
+
+// handlePreferences reports which notification types the user receives on GET and
+// turns them on or off on PUT
+func (h *NotificationHandler) handlePreferences(w http.ResponseWriter, r *http.Request, userID int64) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var changes map[string]bool
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := models.SetNotificationPreferences(h.db, userID, changes); err != nil {
+			if err == models.ErrInvalidNotificationType {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Error saving notification preferences: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	preferences, err := models.GetNotificationPreferences(h.db, userID)
+	if err != nil {
+		log.Printf("Error loading notification preferences: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// handleDigest reports how often the user is emailed a digest on GET and changes it on PUT
+func (h *NotificationHandler) handleDigest(w http.ResponseWriter, r *http.Request, userID int64) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Frequency string `json:"frequency"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := models.SetDigestFrequency(h.db, userID, req.Frequency); err != nil {
+			if err == models.ErrInvalidDigestFrequency {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Error saving digest frequency: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	frequency, err := models.GetDigestFrequency(h.db, userID)
+	if err != nil {
+		log.Printf("Error loading digest frequency: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"frequency": frequency})
+}
+
+// notify stores a notification and pushes it to the recipient's open connections. It
+// reports whether the notification was stored; recipients who turned its type off get
+// nothing. Failures are logged rather than failing the action that caused them.
+func notify(db *sql.DB, hub *Hub, n *models.Notification) bool {
+	stored, err := models.CreateNotification(db, n)
+	if err != nil {
+		log.Printf("Error creating %s notification for user %d: %v", n.Type, n.UserID, err)
+		return false
+	}
+	if stored && hub != nil {
+		hub.sendToUser(n.UserID, WSMessage{
+			Type:      MessageTypeNotification,
+			Data:      n,
+			Timestamp: time.Now(),
+		})
+	}
+	return stored
+}
+
+// notifyModeration tells a user about a moderator's action on them or their content.
+// Moderators stay anonymous.
+func notifyModeration(db *sql.DB, hub *Hub, userID int64, postID, commentID *int64, text string) {
+	notify(db, hub, &models.Notification{
+		UserID:    userID,
+		Type:      models.NotificationModeration,
+		PostID:    postID,
+		CommentID: commentID,
+		Text:      text,
+	})
+}
+
+// optionalID turns a zero ID into nil
+func optionalID(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
@@ -3,22 +3,25 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"real-time-forum/backend/internal/audit"
 	"real-time-forum/backend/internal/auth"
 	"real-time-forum/backend/internal/models"
 )
 
 type PostHandler struct {
-	db *sql.DB
+	db  *sql.DB
+	hub *Hub
 }
 
-func NewPostHandler(db *sql.DB) *PostHandler {
-	return &PostHandler{db: db}
+func NewPostHandler(db *sql.DB, hub *Hub) *PostHandler {
+	return &PostHandler{db: db, hub: hub}
 }
 
 // CreatePost handles post creation
@@ -37,10 +40,13 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Creating post for user ID: %d", userID)
 
+	if !auth.Can(r, models.PermCreatePost) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
 	var req models.CreatePostRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeContentRequest(w, r, &req) {
 		return
 	}
 	log.Printf("Received post request: %+v", req)
@@ -48,14 +54,21 @@ func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
 	post, err := models.CreatePost(h.db, userID, req)
 	if err != nil {
 		switch err {
-		case models.ErrEmptyTitle, models.ErrEmptyContent, models.ErrNoCategories, models.ErrInvalidCategory:
+		case models.ErrUserMuted, models.ErrUserBanned:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case models.ErrEmptyTitle, models.ErrEmptyContent, models.ErrNoCategories, models.ErrInvalidCategory,
+			models.ErrCategoryArchived, models.ErrTooManyAttachments, models.ErrAttachmentUnusable,
+			models.ErrInvalidAttachmentID:
 			http.Error(w, err.Error(), http.StatusBadRequest)
+		case models.ErrCategoryRestricted:
+			http.Error(w, err.Error(), http.StatusForbidden)
 		default:
 			log.Printf("Error creating post: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 		return
 	}
+	h.notifyMentions(post.Mentioned, post.Author, post.ID, 0, post.Content, post.IsHidden, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -93,6 +106,22 @@ func (h *PostHandler) GetPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Hidden posts stay visible to their author and to moderators only
+	userID, _ := auth.GetUserID(r)
+	if post.IsHidden && post.UserID != userID && !auth.Can(r, models.PermModerate) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	if post.HasLiked, err = models.HasUserLikedPost(h.db, post.ID, userID); err == nil {
+		post.HasBookmarked, err = models.HasUserBookmarkedPost(h.db, post.ID, userID)
+	}
+	if err != nil {
+		log.Printf("Error checking like and bookmark status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(post)
 }
@@ -115,58 +144,81 @@ func (h *PostHandler) ListCategories(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(categories)
 }
 
-// ListPosts handles retrieving all posts, optionally filtered by category or creator
+// ListPosts handles retrieving posts. The my_posts, category_id, liked, bookmarked and
+// folder_id filters combine, and sort picks the order.
 func (h *PostHandler) ListPosts(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var posts []models.Post
-	var err error
+	// Get user ID from context (set by auth middleware)
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.PostFilter{
+		Liked:      query.Get("liked") == "true",
+		Bookmarked: query.Get("bookmarked") == "true",
+		Sort:       query.Get("sort"),
+	}
 
 	// Check for my_posts filter (user's own posts)
-	myPosts := r.URL.Query().Get("my_posts")
-	if myPosts == "true" {
-		// Get user ID from context (set by auth middleware)
-		userID, ok := auth.GetUserID(r)
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if query.Get("my_posts") == "true" {
+		filter.AuthorID = userID
+	}
+
+	// Check for category filter
+	if categoryIDStr := query.Get("category_id"); categoryIDStr != "" {
+		categoryID, err := strconv.ParseInt(categoryIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid category ID", http.StatusBadRequest)
 			return
 		}
-		posts, err = models.ListPostsByUserID(h.db, userID)
-	} else {
-		// Check for category filter
-		categoryIDStr := r.URL.Query().Get("category_id")
-		if categoryIDStr != "" {
-			// If category_id is provided, filter by category
-			categoryID, err := strconv.ParseInt(categoryIDStr, 10, 64)
-			if err != nil {
-				http.Error(w, "Invalid category ID", http.StatusBadRequest)
-				return
-			}
 
-			// Verify category exists
-			var exists bool
-			err = h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM categories WHERE id = ?)", categoryID).Scan(&exists)
-			if err != nil {
-				log.Printf("Error checking category existence: %v", err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-			if !exists {
-				http.Error(w, "Category not found", http.StatusNotFound)
+		// Verify category exists
+		var exists bool
+		err = h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM categories WHERE id = ?)", categoryID).Scan(&exists)
+		if err != nil {
+			log.Printf("Error checking category existence: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Category not found", http.StatusNotFound)
+			return
+		}
+		filter.CategoryID = categoryID
+	}
+
+	// Check for bookmark folder filter
+	if folderIDStr := query.Get("folder_id"); folderIDStr != "" {
+		folderID, err := strconv.ParseInt(folderIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+			return
+		}
+		if _, err := models.GetBookmarkFolder(h.db, userID, folderID); err != nil {
+			if err == models.ErrBookmarkFolderNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
 				return
 			}
-
-			posts, err = models.ListPostsByCategory(h.db, categoryID)
-		} else {
-			// If no filters, get all posts
-			posts, err = models.ListPosts(h.db)
+			log.Printf("Error getting bookmark folder: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
 		}
+		filter.FolderID = folderID
 	}
 
+	posts, err := models.ListPosts(h.db, userID, filter)
 	if err != nil {
+		if err == models.ErrInvalidPostSort {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		log.Printf("Error listing posts: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -203,16 +255,23 @@ func (h *PostHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !auth.Can(r, models.PermCreateComment) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
 	var req models.CreateCommentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeContentRequest(w, r, &req) {
 		return
 	}
 
 	comment, err := models.CreateComment(h.db, postID, userID, req)
 	if err != nil {
 		switch err {
-		case models.ErrEmptyComment:
+		case models.ErrUserMuted, models.ErrUserBanned:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case models.ErrEmptyComment, models.ErrTooManyAttachments, models.ErrAttachmentUnusable,
+			models.ErrInvalidAttachmentID:
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		case models.ErrPostNotFound:
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -222,6 +281,7 @@ func (h *PostHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	h.notifyComment(comment)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -277,6 +337,17 @@ func (h *PostHandler) LikePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if hasLiked {
+		if ownerID, err := models.GetPostOwnerID(h.db, postID); err == nil {
+			notify(h.db, h.hub, &models.Notification{
+				UserID:  ownerID,
+				Type:    models.NotificationReaction,
+				ActorID: &userID,
+				PostID:  &postID,
+			})
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"like_count": likeCount,
@@ -333,6 +404,23 @@ func (h *PostHandler) LikeComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if hasLiked {
+		ownerID, err := models.GetCommentOwnerID(h.db, commentID)
+		if err == nil {
+			var postID int64
+			postID, err = models.GetCommentPostID(h.db, commentID)
+			if err == nil {
+				notify(h.db, h.hub, &models.Notification{
+					UserID:    ownerID,
+					Type:      models.NotificationReaction,
+					ActorID:   &userID,
+					PostID:    &postID,
+					CommentID: &commentID,
+				})
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"like_count": likeCount,
@@ -359,6 +447,19 @@ func (h *PostHandler) HandlePostRoutes(w http.ResponseWriter, r *http.Request) {
 			h.LikePost(w, r)
 			return
 		}
+
+		// Handle edit and delete of a single post
+		if postID, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+			switch r.Method {
+			case http.MethodPut:
+				h.updatePost(w, r, postID)
+			case http.MethodDelete:
+				h.deletePost(w, r, postID)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
 	}
 
 	// Handle comments and other post-specific endpoints
@@ -374,12 +475,64 @@ func (h *PostHandler) HandlePostRoutes(w http.ResponseWriter, r *http.Request) {
 		case "comments":
 			h.handleComments(w, r, postID)
 			return
+		case "bookmark":
+			h.handleBookmark(w, r, postID)
+			return
 		}
 	}
 
 	http.Error(w, "Unknown action", http.StatusNotFound)
 }
 
+// handleBookmark saves a post to the caller's bookmarks on POST, optionally into the
+// folder given as {"folder_id": 3}, and removes it on DELETE
+func (h *PostHandler) handleBookmark(w http.ResponseWriter, r *http.Request, postID int64) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			FolderID *int64 `json:"folder_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		bookmark, err := models.BookmarkPost(h.db, userID, postID, req.FolderID)
+		if err != nil {
+			switch err {
+			case models.ErrPostNotFound, models.ErrBookmarkFolderNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			default:
+				log.Printf("Error bookmarking post %d: %v", postID, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bookmark)
+	case http.MethodDelete:
+		if err := models.UnbookmarkPost(h.db, userID, postID); err != nil {
+			if err == models.ErrBookmarkNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			log.Printf("Error removing bookmark of post %d: %v", postID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (h *PostHandler) handleComments(w http.ResponseWriter, r *http.Request, postID int64) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -393,77 +546,399 @@ func (h *PostHandler) handleComments(w http.ResponseWriter, r *http.Request, pos
 		return
 	}
 
-	// Verify post exists
-	var exists bool
-	err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID).Scan(&exists)
+	if !auth.Can(r, models.PermCreateComment) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	var req models.CreateCommentRequest
+	if !decodeContentRequest(w, r, &req) {
+		return
+	}
+
+	comment, err := models.CreateComment(h.db, postID, userID, req)
+	if err != nil {
+		switch err {
+		case models.ErrUserMuted, models.ErrUserBanned:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case models.ErrEmptyComment, models.ErrTooManyAttachments, models.ErrAttachmentUnusable,
+			models.ErrInvalidAttachmentID:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case models.ErrPostNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			log.Printf("Error creating comment: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	h.notifyComment(comment)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
+
+// HandleCommentRoutes handles edit and delete of a single comment
+func (h *PostHandler) HandleCommentRoutes(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/comments/")
+	commentID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		log.Printf("Error checking post existence: %v", err)
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		h.updateComment(w, r, commentID)
+	case http.MethodDelete:
+		h.deleteComment(w, r, commentID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *PostHandler) updatePost(w http.ResponseWriter, r *http.Request, postID int64) {
+	ownerID, err := models.GetPostOwnerID(h.db, postID)
+	if err != nil {
+		if err == models.ErrPostNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting post owner: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	if !exists {
-		http.Error(w, "Post not found", http.StatusNotFound)
+
+	if !authorizeContentAction(r, ownerID, models.PermEditOwnContent, models.PermEditAnyContent) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
 		return
 	}
 
-	// Parse comment content
-	var req struct {
-		Content string `json:"content"`
+	var req models.UpdatePostRequest
+	if !decodeContentRequest(w, r, &req) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+
+	userID, _ := auth.GetUserID(r)
+	post, err := models.UpdatePost(h.db, postID, userID, req)
+	if err != nil {
+		switch err {
+		case models.ErrUserMuted, models.ErrUserBanned:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case models.ErrEmptyTitle, models.ErrEmptyContent, models.ErrNoCategories, models.ErrInvalidCategory,
+			models.ErrCategoryArchived:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case models.ErrCategoryRestricted:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case models.ErrPostNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			log.Printf("Error updating post: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
+	h.notifyMentions(post.Mentioned, post.Author, post.ID, 0, post.Content, post.IsHidden, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(post)
+}
 
-	if req.Content == "" {
-		http.Error(w, "Comment content cannot be empty", http.StatusBadRequest)
+func (h *PostHandler) deletePost(w http.ResponseWriter, r *http.Request, postID int64) {
+	ownerID, err := models.GetPostOwnerID(h.db, postID)
+	if err != nil {
+		if err == models.ErrPostNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting post owner: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Create comment
-	comment := &models.Comment{
-		PostID:    postID,
-		UserID:    userID,
-		Content:   req.Content,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if !authorizeContentAction(r, ownerID, models.PermDeleteOwnContent, models.PermDeleteAnyContent) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
 	}
 
-	// Save comment to database
-	result, err := h.db.Exec(`
-		INSERT INTO comments (post_id, user_id, content, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)`,
-		comment.PostID, comment.UserID, comment.Content, comment.CreatedAt, comment.UpdatedAt)
+	if err := models.DeletePost(h.db, postID); err != nil {
+		if err == models.ErrPostNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting post: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := auth.GetUserID(r)
+	if userID != ownerID {
+		notifyModeration(h.db, h.hub, ownerID, nil, nil, "Your post was removed by a moderator")
+	}
+	audit.Record(h.db, r, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionPostDeleted,
+		TargetType: "post",
+		TargetID:   postID,
+		Details:    map[string]interface{}{"owner_id": ownerID},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+func (h *PostHandler) updateComment(w http.ResponseWriter, r *http.Request, commentID int64) {
+	ownerID, err := models.GetCommentOwnerID(h.db, commentID)
 	if err != nil {
-		log.Printf("Error creating comment: %v", err)
+		if err == models.ErrCommentNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting comment owner: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Get comment ID
-	commentID, err := result.LastInsertId()
+	if !authorizeContentAction(r, ownerID, models.PermEditOwnContent, models.PermEditAnyContent) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if !decodeContentRequest(w, r, &req) {
+		return
+	}
+
+	userID, _ := auth.GetUserID(r)
+	comment, err := models.UpdateComment(h.db, commentID, userID, req.Content)
 	if err != nil {
-		log.Printf("Error getting comment ID: %v", err)
+		switch err {
+		case models.ErrUserMuted, models.ErrUserBanned:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case models.ErrEmptyComment:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case models.ErrCommentNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			log.Printf("Error updating comment: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	h.notifyMentions(comment.Mentioned, comment.Author, comment.PostID, comment.ID, comment.Content, comment.IsHidden, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comment)
+}
+
+func (h *PostHandler) deleteComment(w http.ResponseWriter, r *http.Request, commentID int64) {
+	ownerID, err := models.GetCommentOwnerID(h.db, commentID)
+	if err != nil {
+		if err == models.ErrCommentNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting comment owner: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	comment.ID = commentID
 
-	// Get author details
-	var author models.User
-	err = h.db.QueryRow(`
-		SELECT id, username, email, first_name, last_name, age, gender, created_at
-		FROM users WHERE id = ?`, userID).Scan(
-		&author.ID, &author.Username, &author.Email, &author.FirstName,
-		&author.LastName, &author.Age, &author.Gender, &author.CreatedAt)
+	if !authorizeContentAction(r, ownerID, models.PermDeleteOwnContent, models.PermDeleteAnyContent) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := models.DeleteComment(h.db, commentID); err != nil {
+		if err == models.ErrCommentNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting comment: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := auth.GetUserID(r)
+	if userID != ownerID {
+		notifyModeration(h.db, h.hub, ownerID, nil, nil, "Your comment was removed by a moderator")
+	}
+	audit.Record(h.db, r, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionCommentDeleted,
+		TargetType: "comment",
+		TargetID:   commentID,
+		Details:    map[string]interface{}{"owner_id": ownerID},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// ListMentions returns the posts and comments that mentioned the caller, newest first
+func (h *PostHandler) ListMentions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	var beforeID int64
+	if value := r.URL.Query().Get("before_id"); value != "" {
+		var err error
+		beforeID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || beforeID <= 0 {
+			http.Error(w, "Invalid before_id parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := models.ListMentions(h.db, userID, limit, beforeID)
 	if err != nil {
-		log.Printf("Error getting author: %v", err)
+		log.Printf("Error listing mentions: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	comment.Author = &author
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(comment)
+	json.NewEncoder(w).Encode(page)
+}
+
+// notifyComment tells the authors of the post and of the parent comment about a new
+// comment, notifies the users it mentions, then the post's followers. Each user gets
+// one notification.
+func (h *PostHandler) notifyComment(comment *models.Comment) {
+	if comment.IsHidden || comment.Author == nil {
+		return
+	}
+
+	considered := make(map[int64]bool)
+	notified := make(map[int64]bool)
+	replyTo := func(ownerID int64, notificationType string) {
+		if considered[ownerID] {
+			return
+		}
+		considered[ownerID] = true
+		notified[ownerID] = notify(h.db, h.hub, &models.Notification{
+			UserID:    ownerID,
+			Type:      notificationType,
+			ActorID:   &comment.UserID,
+			PostID:    &comment.PostID,
+			CommentID: &comment.ID,
+			Text:      models.MentionExcerpt(comment.Content),
+		})
+	}
+
+	if comment.ParentID != nil {
+		if ownerID, err := models.GetCommentOwnerID(h.db, *comment.ParentID); err == nil {
+			replyTo(ownerID, models.NotificationCommentReply)
+		}
+	}
+	if ownerID, err := models.GetPostOwnerID(h.db, comment.PostID); err == nil {
+		replyTo(ownerID, models.NotificationPostReply)
+	}
+
+	h.notifyMentions(comment.Mentioned, comment.Author, comment.PostID, comment.ID, comment.Content, false, notified)
+	for _, userID := range comment.Mentioned {
+		considered[userID] = true
+	}
+
+	followerIDs, err := models.GetFollowerIDs(h.db, models.FollowPost, comment.PostID)
+	if err != nil {
+		log.Printf("Error loading followers of post %d: %v", comment.PostID, err)
+		return
+	}
+	for _, userID := range followerIDs {
+		if considered[userID] {
+			continue
+		}
+		notify(h.db, h.hub, &models.Notification{
+			UserID:    userID,
+			Type:      models.NotificationFollowedPost,
+			ActorID:   &comment.UserID,
+			PostID:    &comment.PostID,
+			CommentID: &comment.ID,
+			Text:      models.MentionExcerpt(comment.Content),
+		})
+	}
+}
+
+// notifyMentions tells users newly mentioned in a post or comment (commentID 0) about
+// it, with a mention event and a notification unless skip shows they already got one.
+// Those notified who aren't connected get a push notification. Nobody is notified
+// about hidden content.
+func (h *PostHandler) notifyMentions(userIDs []int64, author *models.User, postID, commentID int64, content string, hidden bool, skip map[int64]bool) {
+	if hidden || len(userIDs) == 0 || author == nil {
+		return
+	}
+
+	var notified []int64
+	for _, userID := range userIDs {
+		if skip[userID] {
+			continue
+		}
+		stored := notify(h.db, h.hub, &models.Notification{
+			UserID:    userID,
+			Type:      models.NotificationMention,
+			ActorID:   &author.ID,
+			PostID:    &postID,
+			CommentID: optionalID(commentID),
+			Text:      models.MentionExcerpt(content),
+		})
+		if stored {
+			notified = append(notified, userID)
+		}
+	}
+
+	if h.hub == nil {
+		return
+	}
+	h.hub.pushToOffline(notified, PushMessage{
+		Title: author.Username + " mentioned you",
+		Body:  models.MentionExcerpt(content),
+		URL:   "/notifications",
+		Tag:   "mention-" + strconv.FormatInt(postID, 10),
+	})
+	h.hub.sendToUsers(userIDs, WSMessage{
+		Type: MessageTypeMention,
+		Data: MentionData{
+			PostID:    postID,
+			CommentID: commentID,
+			Excerpt:   models.MentionExcerpt(content),
+			Author: &models.User{
+				ID:        author.ID,
+				Username:  author.Username,
+				FirstName: author.FirstName,
+				LastName:  author.LastName,
+			},
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// authorizeContentAction checks whether the caller may act on content owned by ownerID.
+// Authors need ownPerm; everyone else needs anyPerm.
+func authorizeContentAction(r *http.Request, ownerID int64, ownPerm, anyPerm models.Permission) bool {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		return false
+	}
+	if userID == ownerID && auth.Can(r, ownPerm) {
+		return true
+	}
+	return auth.Can(r, anyPerm)
 }
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type PresenceHandler struct {
+	db  *sql.DB
+	hub *Hub
+}
+
+func NewPresenceHandler(db *sql.DB, hub *Hub) *PresenceHandler {
+	return &PresenceHandler{db: db, hub: hub}
+}
+
+// HandlePresence reports the presence status the user chose on GET and changes it on PUT
+func (h *PresenceHandler) HandlePresence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.getStatus(w, userID)
+	case http.MethodPut:
+		h.setStatus(w, r, userID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *PresenceHandler) getStatus(w http.ResponseWriter, userID int64) {
+	user, err := models.GetUserByID(h.db, userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	status := models.StatusOffline
+	if h.hub != nil {
+		status = h.hub.Statuses([]int64{userID})[userID]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":       user.Status,
+		"shown_status": status,
+	})
+}
+
+func (h *PresenceHandler) setStatus(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req SetStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.SetUserStatus(h.db, userID, req.Status); err != nil {
+		if err == models.ErrInvalidStatus {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error setting presence status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if h.hub != nil {
+		h.hub.SetStatus(userID, req.Status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": req.Status})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+	"real-time-forum/backend/internal/push"
+)
+
+type PushHandler struct {
+	db     *sql.DB
+	client *push.Client
+}
+
+func NewPushHandler(db *sql.DB, client *push.Client) *PushHandler {
+	return &PushHandler{db: db, client: client}
+}
+
+// PublicKey returns the VAPID key browsers subscribe with
+func (h *PushHandler) PublicKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"public_key": h.client.PublicKey()})
+}
+
+// HandleSubscriptions lists the caller's push subscriptions on GET, stores the
+// subscription of the caller's browser on POST and removes it on DELETE
+func (h *PushHandler) HandleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subscriptions, err := models.GetPushSubscriptions(h.db, userID)
+		if err != nil {
+			log.Printf("Error listing push subscriptions: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subscriptions)
+	case http.MethodPost:
+		h.subscribe(w, r, userID)
+	case http.MethodDelete:
+		h.unsubscribe(w, r, userID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// subscribe stores a subscription in the form PushSubscription.toJSON() produces
+func (h *PushHandler) subscribe(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sub := push.Subscription{Endpoint: req.Endpoint, P256dh: req.Keys.P256dh, Auth: req.Keys.Auth}
+	if err := sub.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subscription := &models.PushSubscription{
+		UserID:    userID,
+		Endpoint:  sub.Endpoint,
+		P256dh:    sub.P256dh,
+		Auth:      sub.Auth,
+		UserAgent: r.UserAgent(),
+	}
+	if err := models.SavePushSubscription(h.db, subscription); err != nil {
+		log.Printf("Error saving push subscription: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+// unsubscribe removes the subscription of the caller's browser
+func (h *PushHandler) unsubscribe(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Endpoint == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.DeletePushSubscription(h.db, userID, req.Endpoint); err != nil {
+		if err == models.ErrPushSubscriptionNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting push subscription: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"real-time-forum/backend/internal/models"
+	"real-time-forum/backend/internal/push"
+)
+
+// pushTTL is how long push services hold a message for a browser that is offline
+const pushTTL = 24 * time.Hour
+
+// PushMessage is what the service worker shows as a system notification. Messages
+// with the same Tag replace each other instead of piling up.
+type PushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// WebPusher sends Web Push messages to every browser a user subscribed
+type WebPusher struct {
+	db     *sql.DB
+	client *push.Client
+}
+
+func NewWebPusher(db *sql.DB, client *push.Client) *WebPusher {
+	return &WebPusher{db: db, client: client}
+}
+
+// Push sends a message to each of a user's subscriptions, deleting those the push
+// service reports gone. Failures are logged.
+func (p *WebPusher) Push(userID int64, message PushMessage) {
+	subscriptions, err := models.GetPushSubscriptions(p.db, userID)
+	if err != nil {
+		log.Printf("Error loading push subscriptions of user %d: %v", userID, err)
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error encoding push message: %v", err)
+		return
+	}
+
+	for _, sub := range subscriptions {
+		err := p.client.Send(push.Subscription{Endpoint: sub.Endpoint, P256dh: sub.P256dh, Auth: sub.Auth}, payload, pushTTL)
+		switch {
+		case errors.Is(err, push.ErrSubscriptionGone):
+			log.Printf("Pruning push subscription %d of user %d: %v", sub.ID, userID, err)
+			if err := models.PrunePushSubscription(p.db, sub.ID); err != nil {
+				log.Printf("Error pruning push subscription %d: %v", sub.ID, err)
+			}
+		case err != nil:
+			log.Printf("Error pushing to subscription %d of user %d: %v", sub.ID, userID, err)
+		default:
+			if err := models.TouchPushSubscription(p.db, sub.ID, time.Now()); err != nil {
+				log.Printf("Error recording push to subscription %d: %v", sub.ID, err)
+			}
+		}
+	}
+}
+
+// SetPusher makes the hub reach users who have no open connection through Web Push.
+// Call it before Run.
+func (h *Hub) SetPusher(pusher *WebPusher) {
+	h.pusher = pusher
+}
+
+// pushToOffline sends a push message to each of userIDs not connected to any instance.
+// It works in the background, so callers never wait on push services.
+func (h *Hub) pushToOffline(userIDs []int64, message PushMessage) {
+	if h.pusher == nil || len(userIDs) == 0 {
+		return
+	}
+
+	go func() {
+		connected := h.Connected(userIDs)
+		for _, userID := range userIDs {
+			if !connected[userID] {
+				h.pusher.Push(userID, message)
+			}
+		}
+	}()
+}
+
+// newMessagePush describes a new direct message for a push notification
+func newMessagePush(message *models.PrivateMessage, sender *models.User) PushMessage {
+	body := models.MentionExcerpt(message.Content)
+	if body == "" {
+		body = "Sent an attachment"
+	}
+	return PushMessage{
+		Title: "New message from " + sender.Username,
+		Body:  body,
+		URL:   "/chat",
+		Tag:   "conversation-" + strconv.FormatInt(message.ConversationID, 10),
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"real-time-forum/backend/internal/models"
+	"real-time-forum/backend/internal/push"
+)
+
+func TestWebPusherPrunesGoneSubscriptions(t *testing.T) {
+	var mutex sync.Mutex
+	received := map[string]int{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || len(body) < 16+4+1+65+16 {
+			t.Errorf("%s: not an aes128gcm body (%d bytes)", r.URL.Path, len(body))
+		}
+		mutex.Lock()
+		received[r.URL.Path]++
+		mutex.Unlock()
+
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+	user := newTestUser(t, db, "alice")
+	for _, path := range []string{"/gone", "/live"} {
+		key, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth := make([]byte, 16)
+		rand.Read(auth)
+		err = models.SavePushSubscription(db, &models.PushSubscription{
+			UserID:   user.ID,
+			Endpoint: server.URL + path,
+			P256dh:   base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			Auth:     base64.RawURLEncoding.EncodeToString(auth),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := push.GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := push.NewClient(key, "mailto:admin@example.com")
+	client.SetHTTPClient(server.Client())
+	NewWebPusher(db, client).Push(user.ID, PushMessage{Title: "New message from bob", Body: "hi", URL: "/chat"})
+
+	if received["/gone"] != 1 || received["/live"] != 1 {
+		t.Fatalf("push service received %v, want one push to each subscription", received)
+	}
+
+	subscriptions, err := models.GetPushSubscriptions(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 1 || subscriptions[0].Endpoint != server.URL+"/live" {
+		t.Fatalf("subscriptions after push: %+v, want only the live one", subscriptions)
+	}
+	if subscriptions[0].LastUsedAt == nil {
+		t.Error("delivered subscription was not marked used")
+	}
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/internal/audit"
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type ReportHandler struct {
+	db  *sql.DB
+	hub *Hub
+}
+
+func NewReportHandler(db *sql.DB, hub *Hub) *ReportHandler {
+	return &ReportHandler{db: db, hub: hub}
+}
+
+// CreateReport lets any user flag a post, comment or private message
+func (h *ReportHandler) CreateReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	report, err := models.CreateReport(h.db, userID, req)
+	if err != nil {
+		switch err {
+		case models.ErrInvalidReportTarget, models.ErrInvalidReportReason, models.ErrCannotReportSelf:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case models.ErrReportTargetMissing:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case models.ErrDuplicateReport:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Error creating report: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Push the new report into every online moderator's queue
+	h.broadcastReport(MessageTypeReportCreated, report)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     report.ID,
+		"status": report.Status,
+	})
+}
+
+// ListReports returns the moderation queue, filtered by status, target type, reason or claimant
+func (h *ReportHandler) ListReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !auth.Can(r, models.PermModerate) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.ReportFilter{
+		Status:     query.Get("status"),
+		TargetType: query.Get("target_type"),
+		ReasonCode: query.Get("reason_code"),
+		Limit:      50,
+	}
+
+	// Default to the active queue
+	if filter.Status == "" {
+		filter.Status = models.ReportStatusOpen
+	} else if filter.Status == "all" {
+		filter.Status = ""
+	}
+
+	switch claimedBy := query.Get("claimed_by"); claimedBy {
+	case "":
+	case "me":
+		filter.ClaimedBy = userID
+	default:
+		id, err := strconv.ParseInt(claimedBy, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid claimed_by parameter", http.StatusBadRequest)
+			return
+		}
+		filter.ClaimedBy = id
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 200 {
+			filter.Limit = parsedLimit
+		}
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			filter.Offset = parsedOffset
+		}
+	}
+
+	reports, err := models.ListReports(h.db, filter)
+	if err != nil {
+		log.Printf("Error listing reports: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// HandleReportRoutes handles /api/moderation/reports/{id}[/claim|/release|/resolve]
+func (h *ReportHandler) HandleReportRoutes(w http.ResponseWriter, r *http.Request) {
+	if !auth.Can(r, models.PermModerate) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/moderation/reports/")
+	parts := strings.Split(path, "/")
+
+	reportID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid report ID", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := models.GetReport(h.db, reportID)
+		if err != nil {
+			writeReportError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	if len(parts) == 2 {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch parts[1] {
+		case "claim":
+			h.claimReport(w, r, reportID)
+			return
+		case "release":
+			h.releaseReport(w, r, reportID)
+			return
+		case "resolve":
+			h.resolveReport(w, r, reportID)
+			return
+		}
+	}
+
+	http.Error(w, "Unknown action", http.StatusNotFound)
+}
+
+func (h *ReportHandler) claimReport(w http.ResponseWriter, r *http.Request, reportID int64) {
+	moderatorID, _ := auth.GetUserID(r)
+
+	report, err := models.ClaimReport(h.db, reportID, moderatorID)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	h.broadcastReport(MessageTypeReportUpdated, report)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *ReportHandler) releaseReport(w http.ResponseWriter, r *http.Request, reportID int64) {
+	moderatorID, _ := auth.GetUserID(r)
+
+	report, err := models.ReleaseReport(h.db, reportID, moderatorID)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	h.broadcastReport(MessageTypeReportUpdated, report)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *ReportHandler) resolveReport(w http.ResponseWriter, r *http.Request, reportID int64) {
+	moderatorID, _ := auth.GetUserID(r)
+
+	var req models.ResolveReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !models.IsValidModerationAction(req.Action) {
+		http.Error(w, models.ErrInvalidAction.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.DurationHours < 0 {
+		http.Error(w, "duration_hours cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	report, err := models.GetReport(h.db, reportID)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	if !report.IsOpen() {
+		writeReportError(w, models.ErrReportClosed)
+		return
+	}
+	if report.ClaimedBy != nil && *report.ClaimedBy != moderatorID {
+		writeReportError(w, models.ErrReportClaimed)
+		return
+	}
+
+	if err := h.applyModerationAction(r, report, moderatorID, req); err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	closedIDs, err := models.CloseReport(h.db, report, moderatorID, req.Action, req.Note)
+	if err != nil {
+		log.Printf("Error closing report %d: %v", reportID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(h.db, r, audit.Event{
+		ActorID:    moderatorID,
+		Action:     audit.ActionReportResolved,
+		TargetType: report.TargetType,
+		TargetID:   report.TargetID,
+		Details: map[string]interface{}{
+			"report_ids":     closedIDs,
+			"action":         req.Action,
+			"note":           req.Note,
+			"target_user_id": report.TargetUserID,
+		},
+	})
+
+	var resolved *models.Report
+	for _, id := range closedIDs {
+		closed, err := models.GetReport(h.db, id)
+		if err != nil {
+			log.Printf("Error reloading report %d: %v", id, err)
+			continue
+		}
+		h.broadcastReport(MessageTypeReportUpdated, closed)
+		if id == reportID {
+			resolved = closed
+		}
+	}
+	if resolved == nil {
+		resolved = report
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resolved)
+}
+
+// applyModerationAction carries out the decision on the reported content or its author
+func (h *ReportHandler) applyModerationAction(r *http.Request, report *models.Report, moderatorID int64, req models.ResolveReportRequest) error {
+	switch req.Action {
+	case models.ModerationActionHide:
+		// Look up the comment's post while the comment still exists
+		postID, commentID := h.reportedContent(report)
+
+		var err error
+		switch report.TargetType {
+		case models.ReportTargetPost:
+			err = models.SetPostHidden(h.db, report.TargetID, true)
+		case models.ReportTargetComment:
+			err = models.SetCommentHidden(h.db, report.TargetID, true)
+		case models.ReportTargetMessage:
+			err = models.SetMessageHidden(h.db, report.TargetID, true)
+		}
+		if err != nil {
+			return ignoreMissing(err)
+		}
+		notifyModeration(h.db, h.hub, report.TargetUserID, postID, commentID,
+			"Your "+report.TargetType+" was hidden by a moderator for "+report.ReasonCode)
+
+	case models.ModerationActionDelete:
+		var err error
+		switch report.TargetType {
+		case models.ReportTargetPost:
+			err = models.DeletePost(h.db, report.TargetID)
+		case models.ReportTargetComment:
+			err = models.DeleteComment(h.db, report.TargetID)
+		case models.ReportTargetMessage:
+			err = models.DeletePrivateMessage(h.db, report.TargetID)
+		}
+		if err != nil {
+			return ignoreMissing(err)
+		}
+		notifyModeration(h.db, h.hub, report.TargetUserID, nil, nil,
+			"Your "+report.TargetType+" was removed by a moderator for "+report.ReasonCode)
+
+	case models.ModerationActionWarn, models.ModerationActionMute, models.ModerationActionBan:
+		reason := req.Note
+		if reason == "" {
+			reason = report.ReasonCode
+		}
+
+		sanctionType := models.SanctionWarning
+		switch req.Action {
+		case models.ModerationActionMute:
+			sanctionType = models.SanctionMute
+		case models.ModerationActionBan:
+			sanctionType = models.SanctionBan
+		}
+
+		_, err := issueSanction(h.db, h.hub, r, report.TargetUserID, moderatorID, &report.ID,
+			sanctionType, reason, req.DurationHours)
+		return err
+	}
+
+	return nil
+}
+
+// reportedContent returns the post and comment a notification about reported content
+// should link to. Messages aren't linked.
+func (h *ReportHandler) reportedContent(report *models.Report) (postID, commentID *int64) {
+	switch report.TargetType {
+	case models.ReportTargetPost:
+		return &report.TargetID, nil
+	case models.ReportTargetComment:
+		if id, err := models.GetCommentPostID(h.db, report.TargetID); err == nil {
+			return &id, &report.TargetID
+		}
+	}
+	return nil, nil
+}
+
+// broadcastReport pushes a report change to every online moderator
+func (h *ReportHandler) broadcastReport(messageType string, report *models.Report) {
+	if h.hub == nil {
+		return
+	}
+	h.hub.sendToRole(models.RoleModerator, WSMessage{
+		Type:      messageType,
+		Data:      report,
+		Timestamp: time.Now(),
+	})
+}
+
+// ignoreMissing treats content that is already gone as handled
+func ignoreMissing(err error) error {
+	if err == models.ErrPostNotFound || err == models.ErrCommentNotFound {
+		return nil
+	}
+	return err
+}
+
+// writeReportError maps report model errors to HTTP responses
+func writeReportError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrReportNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrReportClosed, models.ErrReportClaimed:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case models.ErrForbidden:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case models.ErrUserNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("Error handling report: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// maxContentRequestSize bounds the body of requests that write posts, comments and
+// messages, whose content is rendered as Markdown while the request waits
+const maxContentRequestSize = 64 << 10
+
+// decodeContentRequest decodes the JSON body of a request that writes content into v.
+// It answers 413 for bodies over maxContentRequestSize and 400 for malformed ones,
+// and reports whether v was decoded.
+func decodeContentRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxContentRequestSize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/backend/internal/audit"
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type SanctionHandler struct {
+	db  *sql.DB
+	hub *Hub
+}
+
+func NewSanctionHandler(db *sql.DB, hub *Hub) *SanctionHandler {
+	return &SanctionHandler{db: db, hub: hub}
+}
+
+// HandleSanctions lists a user's sanctions (GET ?user_id=) or issues a new one (POST)
+func (h *SanctionHandler) HandleSanctions(w http.ResponseWriter, r *http.Request) {
+	if !auth.Can(r, models.PermModerate) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user_id parameter", http.StatusBadRequest)
+			return
+		}
+
+		sanctions, err := models.ListSanctions(h.db, userID)
+		if err != nil {
+			log.Printf("Error listing sanctions: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sanctions)
+
+	case http.MethodPost:
+		moderatorID, _ := auth.GetUserID(r)
+
+		var req struct {
+			UserID        int64  `json:"user_id"`
+			Type          string `json:"type"`
+			Reason        string `json:"reason"`
+			DurationHours int    `json:"duration_hours,omitempty"` // 0 means permanent
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.DurationHours < 0 {
+			http.Error(w, "duration_hours cannot be negative", http.StatusBadRequest)
+			return
+		}
+
+		sanction, err := issueSanction(h.db, h.hub, r, req.UserID, moderatorID, nil, req.Type, req.Reason, req.DurationHours)
+		if err != nil {
+			writeSanctionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sanction)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSanctionRoutes handles /api/moderation/sanctions/{id}/revoke
+func (h *SanctionHandler) HandleSanctionRoutes(w http.ResponseWriter, r *http.Request) {
+	if !auth.Can(r, models.PermModerate) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/moderation/sanctions/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || parts[1] != "revoke" {
+		http.Error(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sanctionID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sanction ID", http.StatusBadRequest)
+		return
+	}
+
+	sanction, err := models.RevokeSanction(h.db, sanctionID)
+	if err != nil {
+		writeSanctionError(w, err)
+		return
+	}
+
+	moderatorID, _ := auth.GetUserID(r)
+	audit.Record(h.db, r, audit.Event{
+		ActorID:    moderatorID,
+		Action:     audit.ActionSanctionRevoked,
+		TargetType: "user",
+		TargetID:   sanction.UserID,
+		Details:    map[string]interface{}{"sanction_id": sanction.ID, "type": sanction.Type},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sanction)
+}
+
+// issueSanction records a warning, mute or ban and applies its immediate effects.
+// Bans end every session and close the user's live connections.
+func issueSanction(db *sql.DB, hub *Hub, r *http.Request, userID, moderatorID int64, reportID *int64, sanctionType, reason string, durationHours int) (*models.Sanction, error) {
+	// Moderators can only act on users below their own role
+	targetRole, err := models.GetUserRole(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	role, _ := auth.GetUserRole(r)
+	if models.RoleAtLeast(targetRole, role) {
+		return nil, models.ErrForbidden
+	}
+
+	var expiresAt *time.Time
+	if durationHours > 0 && sanctionType != models.SanctionWarning {
+		until := time.Now().Add(time.Duration(durationHours) * time.Hour)
+		expiresAt = &until
+	}
+
+	sanction, err := models.CreateSanction(db, userID, &moderatorID, reportID, sanctionType, reason, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]interface{}{
+		"sanction_id": sanction.ID,
+		"type":        sanction.Type,
+		"reason":      sanction.Reason,
+		"expires_at":  sanction.ExpiresAt,
+	}
+	if reportID != nil {
+		details["report_id"] = *reportID
+	}
+	audit.Record(db, r, audit.Event{
+		ActorID:    moderatorID,
+		Action:     audit.ActionSanctionIssued,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    details,
+	})
+
+	text := "You received a warning"
+	if sanction.Type != models.SanctionWarning {
+		text = sanction.Describe()
+	} else if sanction.Reason != "" {
+		text += ": " + sanction.Reason
+	}
+	notifyModeration(db, hub, userID, nil, nil, text)
+
+	if sanction.Type == models.SanctionBan {
+		if err := auth.DeleteUserSessions(db, r, userID); err != nil {
+			return nil, err
+		}
+		if hub != nil {
+			hub.DisconnectUser(userID, sanction.Describe())
+		}
+	}
+
+	return sanction, nil
+}
+
+// writeSanctionError maps sanction errors to HTTP responses
+func writeSanctionError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrInvalidSanctionType:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case models.ErrUserNotFound, models.ErrSanctionNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrForbidden:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("Error handling sanction: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
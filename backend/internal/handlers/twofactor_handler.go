@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"real-time-forum/backend/internal/audit"
+	"real-time-forum/backend/internal/auth"
+	"real-time-forum/backend/internal/models"
+)
+
+type TwoFactorHandler struct {
+	db *sql.DB
+}
+
+func NewTwoFactorHandler(db *sql.DB) *TwoFactorHandler {
+	return &TwoFactorHandler{db: db}
+}
+
+// twoFactorCodeRequest carries either a TOTP code or a recovery code
+type twoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// Status reports whether 2FA is enabled and how many recovery codes remain
+func (h *TwoFactorHandler) Status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	enabled, err := models.IsTwoFactorEnabled(h.db, userID)
+	if err != nil {
+		log.Printf("Error checking 2FA status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	remaining, err := models.CountRecoveryCodes(h.db, userID)
+	if err != nil {
+		log.Printf("Error counting recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// Setup starts enrollment by generating a new secret and its provisioning URI
+func (h *TwoFactorHandler) Setup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := models.GetUserByID(h.db, userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := models.SaveTwoFactorSecret(h.db, userID, secret); err != nil {
+		if err == models.ErrTwoFactorAlreadyEnabled {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Error saving TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": auth.TOTPProvisioningURI(secret, user.Username),
+	})
+}
+
+// Enable verifies the first code from the authenticator app and turns 2FA on
+func (h *TwoFactorHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tf, err := models.GetTwoFactor(h.db, userID)
+	if err != nil {
+		if err == models.ErrTwoFactorNotConfigured {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error getting 2FA config: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if tf.Enabled {
+		http.Error(w, models.ErrTwoFactorAlreadyEnabled.Error(), http.StatusConflict)
+		return
+	}
+
+	step, valid := auth.ValidateTOTP(tf.Secret, req.Code, tf.LastUsedStep, time.Now())
+	if !valid {
+		http.Error(w, models.ErrInvalidTwoFactorCode.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := models.EnableTwoFactor(h.db, userID, step); err != nil {
+		log.Printf("Error enabling 2FA: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := models.GenerateRecoveryCodes(h.db, userID)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(h.db, r, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionTwoFactorEnabled,
+		TargetType: "user",
+		TargetID:   userID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns 2FA off after re-checking the password and a second factor
+func (h *TwoFactorHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		twoFactorCodeRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := models.GetUserByID(h.db, userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !user.ValidatePassword(req.Password) {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	valid, err := verifySecondFactor(h.db, userID, req.twoFactorCodeRequest)
+	if err == models.ErrSecondFactorLocked {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, models.ErrInvalidTwoFactorCode.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := models.DisableTwoFactor(h.db, userID); err != nil {
+		log.Printf("Error disabling 2FA: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(h.db, r, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionTwoFactorDisabled,
+		TargetType: "user",
+		TargetID:   userID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a TOTP code
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// Recovery codes can't be used to mint new recovery codes
+	req.RecoveryCode = ""
+
+	valid, err := verifySecondFactor(h.db, userID, req)
+	if err == models.ErrSecondFactorLocked {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, models.ErrInvalidTwoFactorCode.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := models.GenerateRecoveryCodes(h.db, userID)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(h.db, r, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionRecoveryCodesRegenerated,
+		TargetType: "user",
+		TargetID:   userID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// VerifyLogin completes a two-step login and only then creates the session
+func (h *TwoFactorHandler) VerifyLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		twoFactorCodeRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := models.UseLoginChallenge(h.db, req.ChallengeToken)
+	if err != nil {
+		if err == models.ErrInvalidChallenge {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Error loading login challenge: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	valid, err := verifySecondFactor(h.db, userID, req.twoFactorCodeRequest)
+	if err == models.ErrSecondFactorLocked {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		recordFailedLogin(h.db, r, userID, "", "bad_second_factor")
+		http.Error(w, models.ErrInvalidTwoFactorCode.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := models.DeleteLoginChallenge(h.db, req.ChallengeToken); err != nil {
+		log.Printf("Error deleting login challenge: %v", err)
+	}
+
+	user, err := models.GetUserByID(h.db, userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Create session and set cookie
+	if err := auth.CreateSession(h.db, user.ID, w); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	recordLogin(h.db, r, user.ID, true)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code.
+// Wrong codes count towards a lockout, during which it returns ErrSecondFactorLocked.
+func verifySecondFactor(db *sql.DB, userID int64, req twoFactorCodeRequest) (bool, error) {
+	if err := models.CheckSecondFactorLock(db, userID); err != nil {
+		return false, err
+	}
+
+	valid, err := checkSecondFactor(db, userID, req)
+	if err != nil {
+		return false, err
+	}
+	if !valid {
+		return false, models.RecordSecondFactorFailure(db, userID)
+	}
+	return true, models.ResetSecondFactorFailures(db, userID)
+}
+
+// checkSecondFactor checks a TOTP or recovery code, consuming it if it is valid
+func checkSecondFactor(db *sql.DB, userID int64, req twoFactorCodeRequest) (bool, error) {
+	if req.RecoveryCode != "" {
+		return models.UseRecoveryCode(db, userID, req.RecoveryCode)
+	}
+
+	tf, err := models.GetTwoFactor(db, userID)
+	if err != nil {
+		if err == models.ErrTwoFactorNotConfigured {
+			return false, nil
+		}
+		return false, err
+	}
+	if !tf.Enabled {
+		return false, nil
+	}
+
+	step, valid := auth.ValidateTOTP(tf.Secret, req.Code, tf.LastUsedStep, time.Now())
+	if !valid {
+		return false, nil
+	}
+	if err := models.UpdateTwoFactorStep(db, userID, step); err != nil {
+		if err == models.ErrInvalidTwoFactorCode {
+			return false, nil // Another request used this code first
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 
+	"real-time-forum/backend/internal/audit"
 	"real-time-forum/backend/internal/auth"
 	"real-time-forum/backend/internal/models"
 )
@@ -66,22 +67,73 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	// Get user by email or username
 	user, err := models.GetUserByLogin(h.db, req.Login)
 	if err != nil {
+		recordFailedLogin(h.db, r, 0, req.Login, "unknown_user")
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
 	// Validate password
 	if !user.ValidatePassword(req.Password) {
+		recordFailedLogin(h.db, r, user.ID, req.Login, "bad_password")
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	// Banned users can't sign in
+	ban, err := models.GetActiveSanction(h.db, user.ID, models.SanctionBan)
+	if err != nil {
+		log.Printf("Error checking ban status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if ban != nil {
+		recordFailedLogin(h.db, r, user.ID, req.Login, "banned")
+		http.Error(w, ban.Describe(), http.StatusForbidden)
+		return
+	}
+
+	// Users with 2FA get a short-lived challenge instead of a session
+	twoFactorEnabled, err := models.IsTwoFactorEnabled(h.db, user.ID)
+	if err != nil {
+		log.Printf("Error checking 2FA status: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if twoFactorEnabled {
+		if err := models.CheckSecondFactorLock(h.db, user.ID); err != nil {
+			if err == models.ErrSecondFactorLocked {
+				recordFailedLogin(h.db, r, user.ID, req.Login, "second_factor_locked")
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			log.Printf("Error checking 2FA lockout: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		token, err := models.CreateLoginChallenge(h.db, user.ID)
+		if err != nil {
+			log.Printf("Error creating login challenge: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"two_factor_required": true,
+			"challenge_token":     token,
+		})
+		return
+	}
+
 	// Create session and set cookie
 	if err := auth.CreateSession(h.db, user.ID, w); err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	recordLogin(h.db, r, user.ID, false)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
@@ -125,3 +177,92 @@ func (h *UserHandler) Profile(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
+
+// SetRole changes another user's role (admin only)
+func (h *UserHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !auth.Can(r, models.PermManageRoles) {
+		http.Error(w, models.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		UserID int64  `json:"user_id"`
+		Role   string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	currentRole, err := models.GetUserRole(h.db, req.UserID)
+	if err != nil {
+		if err == models.ErrUserNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting user role: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := models.SetUserRole(h.db, req.UserID, req.Role); err != nil {
+		switch err {
+		case models.ErrInvalidRole:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case models.ErrUserNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case models.ErrLastAdmin:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Error setting user role: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	adminID, _ := auth.GetUserID(r)
+	audit.Record(h.db, r, audit.Event{
+		ActorID:    adminID,
+		Action:     audit.ActionRoleChanged,
+		TargetType: "user",
+		TargetID:   req.UserID,
+		Details:    map[string]interface{}{"from": currentRole, "to": req.Role},
+	})
+
+	user, err := models.GetUserByID(h.db, req.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// recordLogin audits a completed sign-in
+func recordLogin(db *sql.DB, r *http.Request, userID int64, twoFactor bool) {
+	audit.Record(db, r, audit.Event{
+		ActorID:    userID,
+		Action:     audit.ActionLoginSucceeded,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    map[string]interface{}{"two_factor": twoFactor},
+	})
+}
+
+// recordFailedLogin audits a rejected sign-in; userID is 0 when the login matched no account
+func recordFailedLogin(db *sql.DB, r *http.Request, userID int64, login, reason string) {
+	event := audit.Event{
+		Action:  audit.ActionLoginFailed,
+		Details: map[string]interface{}{"login": login, "reason": reason},
+	}
+	if userID != 0 {
+		event.TargetType = "user"
+		event.TargetID = userID
+	}
+	audit.Record(db, r, event)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"real-time-forum/backend/internal/models"
+)
+
+// Broker relays WebSocket events and presence between server instances, so a user
+// connected to one instance can reach users connected to another. Every method is
+// safe for concurrent use, and Publish and SetPresence never block: the hub calls
+// them from its own goroutine.
+type Broker interface {
+	// InstanceID identifies this server instance
+	InstanceID() string
+	// Publish sends an event to every other instance
+	Publish(event BrokerEvent)
+	// Events delivers the events other instances publish, in the order they published them
+	Events() <-chan BrokerEvent
+	// SetPresence records a user's presence on this instance, clearing it when they
+	// have no connections left
+	SetPresence(presence PresenceEvent)
+	// Presence lists the users connected to other live instances
+	Presence() ([]models.InstancePresence, error)
+	// Close withdraws this instance and its presence
+	Close() error
+}
+
+// BrokerEvent is relayed between instances. Exactly one of Message, Disconnect,
+// Presence, Status, Joined and InstanceDown is set.
+type BrokerEvent struct {
+	Origin       string           `json:"origin"`
+	UserIDs      []int64          `json:"user_ids,omitempty"`
+	MinRole      string           `json:"min_role,omitempty"`
+	All          bool             `json:"all,omitempty"`
+	Message      *WSMessage       `json:"message,omitempty"`
+	Disconnect   *DisconnectEvent `json:"disconnect,omitempty"`
+	Presence     *PresenceEvent   `json:"presence,omitempty"`
+	Status       *StatusEvent     `json:"status,omitempty"`
+	Joined       bool             `json:"joined,omitempty"`        // the origin (re)started with no connections
+	InstanceDown string           `json:"instance_down,omitempty"` // set by the broker when an instance stops heartbeating
+}
+
+// DisconnectEvent closes a user's connections on every instance
+type DisconnectEvent struct {
+	UserID int64  `json:"user_id"`
+	Reason string `json:"reason"`
+}
+
+// PresenceEvent reports a user's presence on the origin: whether they have connections
+// there, the status they chose, and whether all those connections are idle
+type PresenceEvent struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Online   bool   `json:"online"`
+	Status   string `json:"status,omitempty"`
+	Idle     bool   `json:"idle,omitempty"`
+}
+
+// StatusEvent reports that a user chose a new presence status
+type StatusEvent struct {
+	UserID int64  `json:"user_id"`
+	Status string `json:"status"`
+}
+
+// publish delivers a message on this instance and relays it to the others
+func (h *Hub) publish(d delivery) {
+	h.deliveries <- d
+	message := d.message
+	h.broker.Publish(BrokerEvent{UserIDs: d.userIDs, MinRole: d.minRole, All: d.all, Message: &message})
+}
+
+// handleBrokerEvent applies an event from another instance. Runs on the hub goroutine.
+func (h *Hub) handleBrokerEvent(event BrokerEvent) {
+	switch {
+	case event.Message != nil:
+		h.deliver(delivery{userIDs: event.UserIDs, minRole: event.MinRole, all: event.All, message: *event.Message})
+	case event.Disconnect != nil:
+		h.disconnectUser(event.Disconnect.UserID, event.Disconnect.Reason)
+	case event.Presence != nil:
+		h.setRemotePresence(event.Origin, *event.Presence)
+	case event.Status != nil:
+		h.applyStatus(event.Status.UserID, event.Status.Status)
+	case event.Joined:
+		h.dropInstance(event.Origin)
+	case event.InstanceDown != "":
+		log.Printf("[Hub] Instance %s went away", event.InstanceDown)
+		h.dropInstance(event.InstanceDown)
+	}
+}
+
+// loadPresence fills in the users already connected to other instances. Runs on the
+// hub goroutine before it starts serving.
+func (h *Hub) loadPresence() {
+	presence, err := h.broker.Presence()
+	if err != nil {
+		log.Printf("[Hub] Error loading presence from other instances: %v", err)
+		return
+	}
+	for _, entry := range presence {
+		h.setRemotePresence(entry.InstanceID, PresenceEvent{
+			UserID:   entry.UserID,
+			Username: entry.Username,
+			Online:   true,
+			Status:   entry.Status,
+			Idle:     entry.Idle,
+		})
+	}
+}
+
+// messageData returns the message a private_message or conversation_message event
+// carries. Events relayed through an SQL broker arrive with their data decoded as a map.
+func messageData(message WSMessage) (PrivateMessageData, bool) {
+	switch data := message.Data.(type) {
+	case PrivateMessageData:
+		return data, true
+	case map[string]interface{}:
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return PrivateMessageData{}, false
+		}
+		var decoded PrivateMessageData
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			return PrivateMessageData{}, false
+		}
+		return decoded, true
+	}
+	return PrivateMessageData{}, false
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+
+	"real-time-forum/backend/internal/models"
+)
+
+// localEventBuffer bounds how many events an instance may fall behind before the
+// local broker starts dropping them
+const localEventBuffer = 1024
+
+// LocalBroker relays events between hubs running in the same process. A single
+// server uses it with one instance; several instances can join it to share a bus.
+type LocalBroker struct {
+	mutex     sync.Mutex
+	instances map[string]*localInstance
+}
+
+// localInstance is one hub's connection to a LocalBroker
+type localInstance struct {
+	broker   *LocalBroker
+	id       string
+	events   chan BrokerEvent
+	presence map[int64]PresenceEvent // guarded by broker.mutex
+}
+
+// NewLocalBroker creates an in-process broker
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{instances: make(map[string]*localInstance)}
+}
+
+// Join adds an instance to the broker and returns its end of it
+func (b *LocalBroker) Join(instanceID string) Broker {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	instance := &localInstance{
+		broker:   b,
+		id:       instanceID,
+		events:   make(chan BrokerEvent, localEventBuffer),
+		presence: make(map[int64]PresenceEvent),
+	}
+	b.instances[instanceID] = instance
+	return instance
+}
+
+// fanOut queues an event for every instance but the origin. Callers must hold b.mutex.
+func (b *LocalBroker) fanOut(event BrokerEvent) {
+	for id, instance := range b.instances {
+		if id == event.Origin {
+			continue
+		}
+		select {
+		case instance.events <- event:
+		default:
+			log.Printf("[Broker] Instance %s is too far behind, dropping event from %s", id, event.Origin)
+		}
+	}
+}
+
+func (i *localInstance) InstanceID() string {
+	return i.id
+}
+
+func (i *localInstance) Publish(event BrokerEvent) {
+	event.Origin = i.id
+	i.broker.mutex.Lock()
+	defer i.broker.mutex.Unlock()
+	i.broker.fanOut(event)
+}
+
+func (i *localInstance) Events() <-chan BrokerEvent {
+	return i.events
+}
+
+func (i *localInstance) SetPresence(presence PresenceEvent) {
+	i.broker.mutex.Lock()
+	defer i.broker.mutex.Unlock()
+	if presence.Online {
+		i.presence[presence.UserID] = presence
+	} else {
+		delete(i.presence, presence.UserID)
+	}
+}
+
+func (i *localInstance) Presence() ([]models.InstancePresence, error) {
+	i.broker.mutex.Lock()
+	defer i.broker.mutex.Unlock()
+
+	var presence []models.InstancePresence
+	for id, instance := range i.broker.instances {
+		if id == i.id {
+			continue
+		}
+		for _, entry := range instance.presence {
+			presence = append(presence, models.InstancePresence{
+				InstanceID: id,
+				UserID:     entry.UserID,
+				Username:   entry.Username,
+				Status:     entry.Status,
+				Idle:       entry.Idle,
+			})
+		}
+	}
+	return presence, nil
+}
+
+func (i *localInstance) Close() error {
+	i.broker.mutex.Lock()
+	defer i.broker.mutex.Unlock()
+
+	if i.broker.instances[i.id] == i {
+		delete(i.broker.instances, i.id)
+		i.broker.fanOut(BrokerEvent{Origin: i.id, InstanceDown: i.id})
+	}
+	return nil
+}
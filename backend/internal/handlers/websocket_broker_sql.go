@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"real-time-forum/backend/internal/models"
+)
+
+const (
+	// sqlBrokerPollInterval is how often the broker_events table is checked for new events
+	sqlBrokerPollInterval = 100 * time.Millisecond
+
+	// sqlBrokerHeartbeatInterval is how often an instance reports that it is alive
+	sqlBrokerHeartbeatInterval = 5 * time.Second
+
+	// sqlBrokerInstanceTimeout is how long an instance may miss heartbeats before its
+	// users are considered offline
+	sqlBrokerInstanceTimeout = 3 * sqlBrokerHeartbeatInterval
+
+	// sqlBrokerEventRetention is how long relayed events stay in the table
+	sqlBrokerEventRetention = time.Minute
+
+	// sqlBrokerWriteBuffer bounds how many writes may be waiting before new ones are dropped
+	sqlBrokerWriteBuffer = 1024
+)
+
+// SQLBroker relays events between instances that share a database. Events are
+// written to the broker_events table and every instance polls it for new rows;
+// presence lives in broker_presence, and broker_instances holds heartbeats so
+// instances that die without closing are noticed.
+type SQLBroker struct {
+	db     *sql.DB
+	id     string
+	events chan BrokerEvent
+	writes chan func() error
+	done   chan struct{}
+
+	heartbeatInterval time.Duration
+	instanceTimeout   time.Duration
+
+	// Owned by the poll goroutine
+	lastID int64
+	live   map[string]bool
+}
+
+// NewSQLBroker registers an instance with the database and starts relaying events.
+// Events published before it starts are not delivered.
+func NewSQLBroker(db *sql.DB, instanceID string) (*SQLBroker, error) {
+	return newSQLBroker(db, instanceID, sqlBrokerHeartbeatInterval, sqlBrokerInstanceTimeout)
+}
+
+// newSQLBroker is NewSQLBroker with its heartbeat timing spelled out
+func newSQLBroker(db *sql.DB, instanceID string, heartbeatInterval, instanceTimeout time.Duration) (*SQLBroker, error) {
+	lastID, err := models.GetLatestBrokerEventID(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// A restarted instance starts with no connections
+	if err := models.RemoveBrokerInstance(db, instanceID); err != nil {
+		return nil, err
+	}
+	if err := models.TouchBrokerInstance(db, instanceID); err != nil {
+		return nil, err
+	}
+
+	b := &SQLBroker{
+		db:     db,
+		id:     instanceID,
+		events: make(chan BrokerEvent),
+		writes: make(chan func() error, sqlBrokerWriteBuffer),
+		done:   make(chan struct{}),
+		lastID: lastID,
+		live:   make(map[string]bool),
+
+		heartbeatInterval: heartbeatInterval,
+		instanceTimeout:   instanceTimeout,
+	}
+	if err := b.checkInstances(); err != nil {
+		return nil, err
+	}
+
+	go b.write()
+	go b.poll()
+	return b, nil
+}
+
+// InstanceID identifies this server instance
+func (b *SQLBroker) InstanceID() string {
+	return b.id
+}
+
+// Publish queues an event to be written for the other instances
+func (b *SQLBroker) Publish(event BrokerEvent) {
+	event.Origin = b.id
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[Broker] Error encoding event: %v", err)
+		return
+	}
+	b.queue(func() error {
+		return models.PublishBrokerEvent(b.db, b.id, payload)
+	})
+}
+
+// Events delivers the events other instances publish
+func (b *SQLBroker) Events() <-chan BrokerEvent {
+	return b.events
+}
+
+// SetPresence queues a presence change for this instance
+func (b *SQLBroker) SetPresence(presence PresenceEvent) {
+	b.queue(func() error {
+		if !presence.Online {
+			return models.ClearInstancePresence(b.db, b.id, presence.UserID)
+		}
+		return models.SetInstancePresence(b.db, models.InstancePresence{
+			InstanceID: b.id,
+			UserID:     presence.UserID,
+			Username:   presence.Username,
+			Status:     presence.Status,
+			Idle:       presence.Idle,
+		})
+	})
+}
+
+// Presence lists the users connected to other live instances
+func (b *SQLBroker) Presence() ([]models.InstancePresence, error) {
+	entries, err := models.GetInstancePresence(b.db, time.Now().Add(-b.instanceTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	var presence []models.InstancePresence
+	for _, entry := range entries {
+		if entry.InstanceID != b.id {
+			presence = append(presence, entry)
+		}
+	}
+	return presence, nil
+}
+
+// Close stops relaying and removes this instance and its presence
+func (b *SQLBroker) Close() error {
+	close(b.done)
+	return models.RemoveBrokerInstance(b.db, b.id)
+}
+
+// queue hands a write to the write goroutine without blocking. Writes run in the
+// order they were queued, so presence is recorded before the event announcing it.
+func (b *SQLBroker) queue(write func() error) {
+	select {
+	case b.writes <- write:
+	default:
+		log.Printf("[Broker] Write queue full, dropping a write from instance %s", b.id)
+	}
+}
+
+// write runs queued writes one at a time
+func (b *SQLBroker) write() {
+	for {
+		select {
+		case write := <-b.writes:
+			if err := write(); err != nil {
+				log.Printf("[Broker] Write error: %v", err)
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// poll relays new events to the hub and keeps this instance's heartbeat fresh
+func (b *SQLBroker) poll() {
+	pollTicker := time.NewTicker(sqlBrokerPollInterval)
+	defer pollTicker.Stop()
+	heartbeatTicker := time.NewTicker(b.heartbeatInterval)
+	defer heartbeatTicker.Stop()
+
+	for {
+		select {
+		case <-pollTicker.C:
+			if err := b.relay(); err != nil {
+				log.Printf("[Broker] Error reading events: %v", err)
+			}
+		case <-heartbeatTicker.C:
+			if err := b.heartbeat(); err != nil {
+				log.Printf("[Broker] Heartbeat error: %v", err)
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// relay delivers the events published since the last poll
+func (b *SQLBroker) relay() error {
+	records, err := models.GetBrokerEvents(b.db, b.id, b.lastID)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		b.lastID = record.ID
+
+		var event BrokerEvent
+		if err := json.Unmarshal(record.Payload, &event); err != nil {
+			log.Printf("[Broker] Skipping malformed event %d from %s: %v", record.ID, record.Origin, err)
+			continue
+		}
+		event.Origin = record.Origin
+		if !b.emit(event) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// heartbeat records that this instance is alive, notices instances that stopped,
+// and prunes old events
+func (b *SQLBroker) heartbeat() error {
+	if err := models.TouchBrokerInstance(b.db, b.id); err != nil {
+		return err
+	}
+	if err := b.checkInstances(); err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-b.instanceTimeout)
+	if err := models.RemoveStaleBrokerInstances(b.db, cutoff); err != nil {
+		return err
+	}
+	return models.PruneBrokerEvents(b.db, time.Now().Add(-sqlBrokerEventRetention))
+}
+
+// checkInstances refreshes the set of live instances and reports the ones that
+// stopped heartbeating since the last check
+func (b *SQLBroker) checkInstances() error {
+	instances, err := models.GetLiveBrokerInstances(b.db, time.Now().Add(-b.instanceTimeout))
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string]bool, len(instances))
+	for _, instanceID := range instances {
+		live[instanceID] = true
+	}
+	for instanceID := range b.live {
+		if !live[instanceID] && !b.emit(BrokerEvent{Origin: b.id, InstanceDown: instanceID}) {
+			return nil
+		}
+	}
+	b.live = live
+	return nil
+}
+
+// emit hands an event to the hub, giving up if the broker is closed
+func (b *SQLBroker) emit(event BrokerEvent) bool {
+	select {
+	case b.events <- event:
+		return true
+	case <-b.done:
+		return false
+	}
+}
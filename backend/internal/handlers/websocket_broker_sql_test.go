@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"real-time-forum/backend/internal/models"
+)
+
+// eventually polls check until it holds, failing the test after a few seconds
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// startSQLInstance runs a hub with a SQL broker on its own connection to the database
+// file, as a separate server process would
+func startSQLInstance(t *testing.T, path, instanceID string) (*Hub, *SQLBroker) {
+	t.Helper()
+	db := openTestDB(t, path)
+	broker, err := newSQLBroker(db, instanceID, 50*time.Millisecond, 250*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub(db, broker)
+	go hub.Run()
+	return hub, broker
+}
+
+func TestSQLBrokerAcrossInstances(t *testing.T) {
+	quietLogs(t)
+	path := filepath.Join(t.TempDir(), "forum.db")
+	hubA, brokerA := startSQLInstance(t, path, "a")
+	defer brokerA.Close()
+	hubB, brokerB := startSQLInstance(t, path, "b")
+
+	client := newTestClient(hubB, 1, 256)
+	hubB.register <- client
+
+	eventually(t, "instance a sees the user connected to b", func() bool {
+		return hubA.Connected([]int64{1})[1]
+	})
+	if status := hubA.Statuses([]int64{1})[1]; status != models.StatusOnline {
+		t.Errorf("instance a shows the user as %q, want online", status)
+	}
+
+	// A delivery published on a reaches the client connected to b
+	hubA.sendToUser(1, WSMessage{Type: MessageTypeNotification, Data: "hello", Timestamp: time.Now()})
+	timeout := time.After(5 * time.Second)
+	for delivered := false; !delivered; {
+		select {
+		case message := <-client.send:
+			delivered = message.Type == MessageTypeNotification && message.Data == "hello"
+		case <-timeout:
+			t.Fatal("delivery published on instance a never reached the client on b")
+		}
+	}
+
+	// Stop b as if it crashed: its heartbeat ends but its rows stay behind
+	close(brokerB.done)
+
+	eventually(t, "instance a notices b stopped heartbeating", func() bool {
+		return !hubA.Connected([]int64{1})[1]
+	})
+	if status := hubA.Statuses([]int64{1})[1]; status != models.StatusOffline {
+		t.Errorf("instance a shows the user as %q after b stopped, want offline", status)
+	}
+	eventually(t, "b's presence rows are removed", func() bool {
+		var rows int
+		err := hubA.db.QueryRow("SELECT COUNT(*) FROM broker_presence WHERE instance_id = 'b'").Scan(&rows)
+		return err == nil && rows == 0
+	})
+}
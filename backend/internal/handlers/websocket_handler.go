@@ -2,11 +2,12 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"sync"
 	"time"
+	"unicode/utf8"
 
 	"real-time-forum/backend/internal/models"
 
@@ -18,6 +19,7 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow connections from any origin in development
 	},
+	Subprotocols: supportedProtocols,
 }
 
 // Message types
@@ -27,206 +29,526 @@ const (
 	MessageTypeTyping         = "typing"
 	MessageTypeOnlineUsers    = "online_users"
 	MessageTypeError          = "error"
+	MessageTypeAck            = "ack"
+	MessageTypeReportCreated  = "report_created"
+	MessageTypeReportUpdated  = "report_updated"
+	MessageTypeMessageEdited  = "message_edited"
+	MessageTypeMessageDeleted = "message_deleted"
+
+	MessageTypeConversationMessage = "conversation_message"
+	MessageTypeConversationUpdated = "conversation_updated"
+
+	MessageTypeReceipt  = "receipt"
+	MessageTypeMarkRead = "mark_read"
+
+	MessageTypeSession = "session"
+	MessageTypeResume  = "resume"
+	MessageTypeResumed = "resumed"
+	MessageTypeResync  = "resync"
+
+	MessageTypeHeartbeat = "heartbeat"
+	MessageTypeSetStatus = "set_status"
+
+	MessageTypeMention      = "mention"
+	MessageTypeNotification = "notification"
 )
 
-// WebSocket message structure
+// WSMessage is a frame sent to clients. Seq numbers the events sent to one user so a
+// reconnecting client can resume; frames meant for a single connection have none.
+// RequestID is set on the ack or error answering a client request.
 type WSMessage struct {
 	Type      string      `json:"type"`
 	Data      interface{} `json:"data"`
 	Timestamp time.Time   `json:"timestamp"`
+	Seq       uint64      `json:"seq,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
 }
 
 // Private message data structure
 type PrivateMessageData struct {
-	ID         int64        `json:"id"`
-	SenderID   int64        `json:"sender_id"`
-	ReceiverID int64        `json:"receiver_id"`
-	Content    string       `json:"content"`
-	IsRead     bool         `json:"is_read"`
-	CreatedAt  time.Time    `json:"created_at"`
-	Sender     *models.User `json:"sender,omitempty"`
+	ID             int64               `json:"id"`
+	ConversationID int64               `json:"conversation_id,omitempty"`
+	SenderID       int64               `json:"sender_id"`
+	ReceiverID     int64               `json:"receiver_id"`
+	Content        string              `json:"content"`
+	ContentHTML    string              `json:"content_html"`
+	IsRead         bool                `json:"is_read"`
+	CreatedAt      time.Time           `json:"created_at"`
+	EditedAt       *time.Time          `json:"edited_at,omitempty"`
+	ClientMsgID    string              `json:"client_msg_id,omitempty"`
+	Attachments    []models.Attachment `json:"attachments,omitempty"`
+	Sender         *models.User        `json:"sender,omitempty"`
+}
+
+// newPrivateMessageData converts a stored message into its WebSocket payload
+func newPrivateMessageData(message *models.PrivateMessage, sender *models.User) PrivateMessageData {
+	if sender != nil {
+		// The chosen status would reveal invisible senders
+		public := *sender
+		public.Status, public.LastSeenAt = "", nil
+		sender = &public
+	}
+	return PrivateMessageData{
+		ID:             message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		ReceiverID:     message.ReceiverID,
+		Content:        message.Content,
+		ContentHTML:    message.ContentHTML,
+		IsRead:         message.IsRead,
+		CreatedAt:      message.CreatedAt,
+		EditedAt:       message.EditedAt,
+		ClientMsgID:    message.ClientMsgID,
+		Attachments:    message.Attachments,
+		Sender:         sender,
+	}
+}
+
+// MentionData tells a user that a post or comment mentioned them
+type MentionData struct {
+	PostID    int64        `json:"post_id"`
+	CommentID int64        `json:"comment_id,omitempty"`
+	Excerpt   string       `json:"excerpt"`
+	Author    *models.User `json:"author"`
+}
+
+// ConversationUpdatedData tells members that a room's name or membership changed
+type ConversationUpdatedData struct {
+	ConversationID int64   `json:"conversation_id"`
+	Action         string  `json:"action"` // "created", "renamed", "members_added", "member_removed", "member_left" or "role_changed"
+	ActorID        int64   `json:"actor_id"`
+	UserIDs        []int64 `json:"user_ids,omitempty"`
+	Name           string  `json:"name,omitempty"`
+}
+
+// ReceiptData tells a sender that a recipient received or read their messages
+type ReceiptData struct {
+	ConversationID int64     `json:"conversation_id"`
+	MessageIDs     []int64   `json:"message_ids"`
+	UserID         int64     `json:"user_id"` // the recipient
+	Status         string    `json:"status"`  // "delivered" or "read"
+	At             time.Time `json:"at"`
+}
+
+// Message deletion scopes
+const (
+	DeleteScopeEveryone = "everyone"
+	DeleteScopeMe       = "me"
+)
+
+// MessageDeletedData tells clients to drop a message from an open conversation
+type MessageDeletedData struct {
+	MessageID      int64  `json:"message_id"`
+	ConversationID int64  `json:"conversation_id"`
+	SenderID       int64  `json:"sender_id"`
+	ReceiverID     int64  `json:"receiver_id"`
+	Scope          string `json:"scope"` // "everyone" or "me"
 }
 
 // User status data structure
 type UserStatusData struct {
 	UserID   int64  `json:"user_id"`
 	Username string `json:"username"`
-	Status   string `json:"status"` // "online" or "offline"
+	Status   string `json:"status"` // "online", "away", "dnd" or "offline"
 }
 
 // Client represents a WebSocket connection
 type Client struct {
-	conn   *websocket.Conn
-	send   chan WSMessage
-	hub    *Hub
-	userID int64
-	user   *models.User
+	conn        *websocket.Conn
+	send        chan WSMessage
+	hub         *Hub
+	userID      int64
+	user        *models.User
+	closeReason string    // sent in the close frame when the server drops the client
+	protocol    string    // negotiated protocol version
+	lastActive  time.Time // when the user was last active on this connection, owned by the hub goroutine
 }
 
-// Hub maintains the set of active clients and broadcasts messages to the clients
+// Hub maintains the set of active clients and broadcasts messages to the clients.
+// All client and stream state is owned by the Run goroutine: other goroutines only
+// talk to it over channels, and only Run ever closes a client's send channel.
 type Hub struct {
 	clients     map[*Client]bool
 	userClients map[int64]map[*Client]bool // Map user ID to client
-	broadcast   chan WSMessage
-	register    chan *Client
-	unregister  chan *Client
-	db          *sql.DB
-	mutex       sync.RWMutex
+	streams     map[int64]*userStream      // per-user sequence numbers and replay buffers
+	epoch       int64                      // distinguishes this server run's sequence numbers from earlier ones
+	slow        []*Client                  // clients whose send buffer filled up during the current event
+	remote      map[int64]*remoteUser      // users connected to other instances
+	chosen      map[int64]string           // status chosen by each user connected here
+	announced   map[int64]PresenceEvent    // presence last reported to other instances
+	shown       map[int64]UserStatusData   // status clients last saw, for users who aren't offline
+	broker      Broker
+
+	register      chan *Client
+	unregister    chan *Client
+	deliveries    chan delivery
+	disconnects   chan disconnect
+	resumes       chan resumeRequest
+	heartbeats    chan heartbeat
+	statusChanges chan statusChange
+	statusQueries chan statusQuery
+	db            *sql.DB
+
+	connectionQueries chan connectionQuery
+	pusher            *WebPusher // reaches users with no connection, if set
+}
+
+// delivery asks Run to send a message. Exactly one target is set.
+type delivery struct {
+	message WSMessage
+	userIDs []int64 // each user, sequenced and buffered while they have a stream
+	minRole string  // every connected user with at least this role, sequenced
+	all     bool    // every user with a stream, sequenced
+	client  *Client // a single connection, unsequenced
+}
+
+// disconnect asks Run to drop every connection of a user
+type disconnect struct {
+	userID int64
+	reason string
 }
 
-// NewHub creates a new WebSocket hub
-func NewHub(db *sql.DB) *Hub {
+// resumeRequest asks Run to replay the events a client missed
+type resumeRequest struct {
+	client  *Client
+	epoch   int64
+	lastSeq uint64
+}
+
+// NewHub creates a new WebSocket hub that reaches other server instances through broker
+func NewHub(db *sql.DB, broker Broker) *Hub {
 	return &Hub{
 		clients:     make(map[*Client]bool),
 		userClients: make(map[int64]map[*Client]bool),
-		broadcast:   make(chan WSMessage),
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		db:          db,
+		streams:     make(map[int64]*userStream),
+		epoch:       time.Now().UnixMilli(),
+		remote:      make(map[int64]*remoteUser),
+		chosen:      make(map[int64]string),
+		announced:   make(map[int64]PresenceEvent),
+		shown:       make(map[int64]UserStatusData),
+		broker:      broker,
+
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		deliveries:    make(chan delivery),
+		disconnects:   make(chan disconnect),
+		resumes:       make(chan resumeRequest),
+		heartbeats:    make(chan heartbeat),
+		statusChanges: make(chan statusChange),
+		statusQueries: make(chan statusQuery),
+		db:            db,
+
+		connectionQueries: make(chan connectionQuery),
 	}
 }
 
-// Run starts the hub
+// Run starts the hub. It must be the only goroutine touching client and stream state,
+// and must never block, since every other goroutine waits on it to hand over work.
 func (h *Hub) Run() {
-	log.Println("Hub started")
+	log.Printf("Hub started as instance %s", h.broker.InstanceID())
+	h.broker.Publish(BrokerEvent{Joined: true})
+	h.loadPresence()
+
+	events := h.broker.Events()
+	pruneTicker := time.NewTicker(time.Minute)
+	defer pruneTicker.Stop()
+	idleTicker := time.NewTicker(idleCheckInterval)
+	defer idleTicker.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
-			log.Printf("[Hub] Registering client for user %d", client.userID)
-			h.mutex.Lock()
-			h.clients[client] = true
-			if h.userClients[client.userID] == nil {
-				h.userClients[client.userID] = make(map[*Client]bool)
-			}
-			h.userClients[client.userID][client] = true
-			var userSummary string
-			for uid, conns := range h.userClients {
-				userSummary += fmt.Sprintf("[User %d: %d connections] ", uid, len(conns))
-			}
-			log.Printf("[Hub] User %d connected. Current users: %s", client.userID, userSummary)
-			h.mutex.Unlock()
-			h.broadcastUserStatus(client.userID, client.user.Username, "online")
-			h.sendOnlineUsers(client)
-
+			h.addClient(client)
 		case client := <-h.unregister:
-			log.Printf("[Hub] Unregistering client for user %d", client.userID)
-			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				if userSet, exists := h.userClients[client.userID]; exists {
-					delete(userSet, client)
-					if len(userSet) == 0 {
-						delete(h.userClients, client.userID)
-						h.broadcastUserStatus(client.userID, client.user.Username, "offline")
-					}
-				}
-				close(client.send)
-				log.Printf("[Hub] User %d disconnected", client.userID)
+			h.removeClient(client)
+		case d := <-h.deliveries:
+			h.deliver(d)
+		case d := <-h.disconnects:
+			h.disconnectUser(d.userID, d.reason)
+		case r := <-h.resumes:
+			h.replay(r.client, r.epoch, r.lastSeq)
+		case event := <-events:
+			h.handleBrokerEvent(event)
+		case beat := <-h.heartbeats:
+			h.recordHeartbeat(beat.client, beat.active)
+		case change := <-h.statusChanges:
+			h.applyStatus(change.userID, change.status)
+		case query := <-h.statusQueries:
+			h.answerStatusQuery(query)
+		case query := <-h.connectionQueries:
+			h.answerConnectionQuery(query)
+		case <-pruneTicker.C:
+			h.pruneStreams()
+		case <-idleTicker.C:
+			h.checkIdle()
+		}
+		h.dropSlowClients()
+	}
+}
+
+// addClient registers a connection and greets it. Runs on the hub goroutine.
+func (h *Hub) addClient(client *Client) {
+	log.Printf("[Hub] Registering client for user %d", client.userID)
+	h.clients[client] = true
+	client.lastActive = time.Now()
+	if h.userClients[client.userID] == nil {
+		h.userClients[client.userID] = make(map[*Client]bool)
+		h.chosen[client.userID] = client.user.Status
+	}
+	h.userClients[client.userID][client] = true
+
+	var userSummary string
+	for uid, conns := range h.userClients {
+		userSummary += fmt.Sprintf("[User %d: %d connections] ", uid, len(conns))
+	}
+	log.Printf("[Hub] User %d connected. Current users: %s", client.userID, userSummary)
+
+	h.sendSession(client)
+	h.updateLocalPresence(client.userID, client.user.Username)
+	h.sendOnlineUsers(client)
+}
+
+// removeClient unregisters a connection and closes its send channel. It is safe to
+// call more than once for the same client. Runs on the hub goroutine.
+func (h *Hub) removeClient(client *Client) {
+	if !h.clients[client] {
+		return
+	}
+
+	delete(h.clients, client)
+	close(client.send)
+	if userSet, exists := h.userClients[client.userID]; exists {
+		delete(userSet, client)
+		if len(userSet) == 0 {
+			delete(h.userClients, client.userID)
+			h.recordLastSeen(client.userID)
+			if stream, ok := h.streams[client.userID]; ok {
+				stream.touched = time.Now() // keep it for replay until replayWindow after they left
 			}
-			h.mutex.Unlock()
-
-		case message := <-h.broadcast:
-			log.Printf("[Hub] Broadcasting message of type %s", message.Type)
-			h.mutex.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					log.Printf("[Hub] Closing send channel for client user %d", client.userID)
-					close(client.send)
-					delete(h.clients, client)
-					if userClients, exists := h.userClients[client.userID]; exists {
-						delete(userClients, client)
-						if len(userClients) == 0 {
-							delete(h.userClients, client.userID)
-						}
-					}
+		}
+		h.updateLocalPresence(client.userID, client.user.Username)
+	}
+	log.Printf("[Hub] User %d disconnected", client.userID)
+}
+
+// push queues a message on a client's send buffer. A client whose buffer is full
+// can't keep up and is dropped once the current event has been handled.
+func (h *Hub) push(client *Client, message WSMessage) {
+	select {
+	case client.send <- message:
+	default:
+		h.slow = append(h.slow, client)
+	}
+}
+
+// dropSlowClients disconnects the clients push found to be full. Dropping one can
+// broadcast an offline status that finds more, so it loops until none are left.
+func (h *Hub) dropSlowClients() {
+	for len(h.slow) > 0 {
+		client := h.slow[0]
+		h.slow = h.slow[1:]
+		if h.clients[client] {
+			log.Printf("[Hub] Closing send channel for slow client of user %d", client.userID)
+			h.removeClient(client)
+		}
+	}
+	h.slow = nil
+}
+
+// deliver sends a message to the targets of a delivery. Runs on the hub goroutine.
+func (h *Hub) deliver(d delivery) {
+	switch {
+	case d.client != nil:
+		if h.clients[d.client] {
+			h.push(d.client, d.message)
+		}
+	case d.all:
+		log.Printf("[Hub] Broadcasting message of type %s", d.message.Type)
+		for userID := range h.streams {
+			h.sendSequenced(userID, d.message)
+		}
+	case d.minRole != "":
+		for userID, clients := range h.userClients {
+			for client := range clients {
+				if models.RoleAtLeast(client.user.Role, d.minRole) {
+					h.sendSequenced(userID, d.message)
 				}
+				break // All clients of a user share the same role
 			}
-			h.mutex.RUnlock()
+		}
+	default:
+		for _, userID := range d.userIDs {
+			h.sendSequenced(userID, d.message)
 		}
 	}
 }
 
-// broadcastUserStatus sends user status update to all connected clients
+// sendSequenced numbers a message for one user and sends it to each of their
+// connections. Users without a stream have not connected recently enough to resume,
+// so nothing is kept for them. Runs on the hub goroutine.
+func (h *Hub) sendSequenced(userID int64, message WSMessage) {
+	stream, ok := h.streams[userID]
+	if !ok {
+		return
+	}
+	message = h.sequence(stream, message)
+	clients := h.userClients[userID]
+	if len(clients) == 0 {
+		log.Printf("[Hub] No clients found for user %d, buffered %s", userID, message.Type)
+		return
+	}
+	for client := range clients {
+		h.push(client, message)
+	}
+}
+
+// broadcastUserStatus sends user status update to all connected clients. Runs on
+// the hub goroutine.
 func (h *Hub) broadcastUserStatus(userID int64, username, status string) {
-	message := WSMessage{
-		Type: MessageTypeUserStatus,
-		Data: UserStatusData{
-			UserID:   userID,
-			Username: username,
-			Status:   status,
+	h.deliver(delivery{
+		all: true,
+		message: WSMessage{
+			Type: MessageTypeUserStatus,
+			Data: UserStatusData{
+				UserID:   userID,
+				Username: username,
+				Status:   status,
+			},
+			Timestamp: time.Now(),
 		},
-		Timestamp: time.Now(),
-	}
-	h.broadcast <- message
+	})
 }
 
-// sendOnlineUsers sends the list of online users to a specific client
+// sendOnlineUsers sends the list of online users to a specific client. Runs on the
+// hub goroutine.
 func (h *Hub) sendOnlineUsers(client *Client) {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
-
 	var onlineUsers []UserStatusData
-	for userID, userSet := range h.userClients {
-		for c := range userSet {
-			if c.user != nil {
-				onlineUsers = append(onlineUsers, UserStatusData{
-					UserID:   userID,
-					Username: c.user.Username,
-					Status:   "online",
-				})
-				break // Only need one entry per user
-			}
-		}
+	for _, status := range h.shown {
+		onlineUsers = append(onlineUsers, status)
 	}
 
-	message := WSMessage{
+	h.push(client, WSMessage{
 		Type:      MessageTypeOnlineUsers,
 		Data:      onlineUsers,
 		Timestamp: time.Now(),
-	}
+	})
+}
 
-	select {
-	case client.send <- message:
-	default:
-		close(client.send)
-		h.mutex.RUnlock()
-		h.mutex.Lock()
-		delete(h.clients, client)
-		if userSet, exists := h.userClients[client.userID]; exists {
-			delete(userSet, client)
-			if len(userSet) == 0 {
-				delete(h.userClients, client.userID)
-			}
-		}
-		h.mutex.Unlock()
-		h.mutex.RLock()
+// disconnectUser closes every connection of a user. Runs on the hub goroutine.
+func (h *Hub) disconnectUser(userID int64, reason string) {
+	clients := h.userClients[userID]
+	if len(clients) > 0 {
+		log.Printf("[Hub] Disconnected user %d: %s", userID, reason)
+	}
+	for client := range clients {
+		client.closeReason = reason
+		h.removeClient(client)
 	}
 }
 
+// sendToUser sends a message to every connection of a user, on every instance. It is
+// numbered and buffered for replay while the user is connected or recently was.
 func (h *Hub) sendToUser(userID int64, message WSMessage) {
 	log.Printf("[Hub] sendToUser: Sending message of type %s to user %d", message.Type, userID)
-	h.mutex.RLock()
-	clients, exists := h.userClients[userID]
-	h.mutex.RUnlock()
-	if exists {
-		for client := range clients {
-			select {
-			case client.send <- message:
-				log.Printf("[Hub] sendToUser: Message sent to client for user %d", userID)
-			default:
-				log.Printf("[Hub] sendToUser: Closing send channel for client user %d", userID)
-				h.mutex.Lock()
-				close(client.send)
-				delete(h.clients, client)
-				delete(h.userClients[userID], client)
-				h.mutex.Unlock()
+	h.publish(delivery{userIDs: []int64{userID}, message: message})
+}
+
+// sendToUsers sends the same message to several users
+func (h *Hub) sendToUsers(userIDs []int64, message WSMessage) {
+	if len(userIDs) == 0 {
+		return
+	}
+	h.publish(delivery{userIDs: userIDs, message: message})
+}
+
+// sendToConversation sends a message to every member of a conversation
+func (h *Hub) sendToConversation(conversationID int64, message WSMessage) {
+	memberIDs, err := models.GetConversationMemberIDs(h.db, conversationID)
+	if err != nil {
+		log.Printf("[Hub] sendToConversation: Error loading members of conversation %d: %v", conversationID, err)
+		return
+	}
+	h.sendToUsers(memberIDs, message)
+}
+
+// broadcastMessage delivers a new message to everyone in its conversation. Direct
+// messages keep the private_message type; group messages use conversation_message.
+func (h *Hub) broadcastMessage(message *models.PrivateMessage, sender *models.User) {
+	messageType := MessageTypePrivateMessage
+	if message.ReceiverID == 0 {
+		messageType = MessageTypeConversationMessage
+	}
+
+	h.sendToConversation(message.ConversationID, WSMessage{
+		Type:      messageType,
+		Data:      newPrivateMessageData(message, sender),
+		Timestamp: time.Now(),
+	})
+
+	if message.ReceiverID != 0 && sender != nil {
+		h.pushToOffline([]int64{message.ReceiverID}, newMessagePush(message, sender))
+	}
+}
+
+// sendReadReceipts tells the senders of newly read messages that readerID read them
+func (h *Hub) sendReadReceipts(readerID int64, read []models.ReadMessage) {
+	if len(read) == 0 {
+		return
+	}
+
+	now := time.Now()
+	bySender := make(map[int64]*ReceiptData)
+	var senders []int64
+	for _, message := range read {
+		receipt, ok := bySender[message.SenderID]
+		if !ok {
+			receipt = &ReceiptData{
+				ConversationID: message.ConversationID,
+				UserID:         readerID,
+				Status:         models.ReceiptRead,
+				At:             now,
 			}
+			bySender[message.SenderID] = receipt
+			senders = append(senders, message.SenderID)
 		}
-	} else {
-		log.Printf("[Hub] sendToUser: No clients found for user %d", userID)
+		receipt.MessageIDs = append(receipt.MessageIDs, message.MessageID)
+	}
+
+	for _, senderID := range senders {
+		h.sendToUser(senderID, WSMessage{
+			Type:      MessageTypeReceipt,
+			Data:      *bySender[senderID],
+			Timestamp: now,
+		})
+	}
+}
+
+// sendToRole sends a message to every connected user whose role is at least minRole
+func (h *Hub) sendToRole(minRole string, message WSMessage) {
+	h.publish(delivery{minRole: minRole, message: message})
+}
+
+// DisconnectUser closes every connection of a user with a close frame carrying reason
+func (h *Hub) DisconnectUser(userID int64, reason string) {
+	reason = truncateCloseReason(reason)
+	h.disconnects <- disconnect{userID: userID, reason: reason}
+	h.broker.Publish(BrokerEvent{Disconnect: &DisconnectEvent{UserID: userID, Reason: reason}})
+}
+
+// maxCloseReason is how many bytes of reason text fit in a close frame
+const maxCloseReason = 123
+
+// truncateCloseReason shortens a reason to fit in a close frame. It cuts on a rune
+// boundary, since browsers fail a connection whose close reason isn't valid UTF-8.
+func truncateCloseReason(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	cut := maxCloseReason - len("...")
+	for cut > 0 && !utf8.RuneStart(reason[cut]) {
+		cut--
 	}
+	return reason[:cut] + "..."
 }
 
 // WebSocketHandler handles WebSocket connections
@@ -244,6 +566,22 @@ func (h *Hub) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ban, err := models.GetActiveSanction(h.db, user.ID, models.SanctionBan)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if ban != nil {
+		http.Error(w, ban.Describe(), http.StatusForbidden)
+		return
+	}
+
+	// Clients that ask for protocol versions must share one with the server
+	if offered := websocket.Subprotocols(r); len(offered) > 0 && !supportsAnyProtocol(offered) {
+		http.Error(w, "Unsupported protocol version", http.StatusBadRequest)
+		return
+	}
+
 	// Upgrade HTTP connection to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -251,13 +589,19 @@ func (h *Hub) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	protocol := conn.Subprotocol()
+	if protocol == "" {
+		protocol = ProtocolV1
+	}
+
 	// Create new client
 	client := &Client{
-		conn:   conn,
-		send:   make(chan WSMessage, 256),
-		hub:    h,
-		userID: user.ID,
-		user:   user,
+		conn:     conn,
+		send:     make(chan WSMessage, 256),
+		hub:      h,
+		userID:   user.ID,
+		user:     user,
+		protocol: protocol,
 	}
 
 	// Register client with hub
@@ -268,6 +612,12 @@ func (h *Hub) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	go client.readPump()
 }
 
+// maxFrameSize bounds a client frame. It leaves room for message content up to
+// maxContentRequestSize, as accepted over HTTP, once JSON escaped and wrapped in the
+// frame, so an oversized message is answered with an error frame rather than a
+// dropped connection.
+const maxFrameSize = 2 * maxContentRequestSize
+
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
@@ -276,7 +626,7 @@ func (c *Client) readPump() {
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512)
+	c.conn.SetReadLimit(maxFrameSize)
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -284,8 +634,7 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		var message WSMessage
-		err := c.conn.ReadJSON(&message)
+		_, payload, err := c.conn.ReadMessage()
 		if err != nil {
 			log.Printf("[Client] WebSocket read error for user %d: %v", c.userID, err)
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
@@ -293,8 +642,14 @@ func (c *Client) readPump() {
 			}
 			break
 		}
-		log.Printf("[Client] Received message of type %s from user %d", message.Type, c.userID)
-		c.handleMessage(message)
+
+		var frame ClientFrame
+		if err := json.Unmarshal(payload, &frame); err != nil || frame.Type == "" {
+			c.sendError(frame.RequestID, ErrCodeInvalidFrame, "Frames must be JSON objects with a type")
+			continue
+		}
+		log.Printf("[Client] Received message of type %s from user %d", frame.Type, c.userID)
+		c.handleMessage(frame)
 	}
 }
 
@@ -313,7 +668,12 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
 				log.Printf("[Client] writePump: send channel closed for user %d", c.userID)
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				if c.closeReason != "" {
+					c.conn.WriteMessage(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.ClosePolicyViolation, c.closeReason))
+				} else {
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				}
 				return
 			}
 			log.Printf("[Client] writePump: Sending message of type %s to user %d", message.Type, c.userID)
@@ -321,6 +681,7 @@ func (c *Client) writePump() {
 				log.Printf("[Client] WebSocket write error for user %d: %v", c.userID, err)
 				return
 			}
+			c.recordDelivery(message)
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -331,110 +692,232 @@ func (c *Client) writePump() {
 	}
 }
 
-// handleMessage processes incoming WebSocket messages
-func (c *Client) handleMessage(message WSMessage) {
-	switch message.Type {
+// recordDelivery stores a delivery receipt once a new message has been written to one
+// of its recipient's connections, and tells the sender the first time it happens
+func (c *Client) recordDelivery(message WSMessage) {
+	if message.Type != MessageTypePrivateMessage && message.Type != MessageTypeConversationMessage {
+		return
+	}
+	data, ok := messageData(message)
+	if !ok || data.SenderID == c.userID {
+		return
+	}
+
+	delivered, err := models.MarkMessageDelivered(c.hub.db, data.ID, c.userID)
+	if err != nil {
+		log.Printf("[Client] Error recording delivery of message %d to user %d: %v", data.ID, c.userID, err)
+		return
+	}
+	if !delivered {
+		return
+	}
+
+	c.hub.sendToUser(data.SenderID, WSMessage{
+		Type: MessageTypeReceipt,
+		Data: ReceiptData{
+			ConversationID: data.ConversationID,
+			MessageIDs:     []int64{data.ID},
+			UserID:         c.userID,
+			Status:         models.ReceiptDelivered,
+			At:             time.Now(),
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// handleMessage dispatches a client frame to the handler for its type. Every frame
+// with a request_id is answered with exactly one ack or error.
+func (c *Client) handleMessage(frame ClientFrame) {
+	switch frame.Type {
 	case MessageTypePrivateMessage:
-		c.handlePrivateMessage(message)
+		c.handlePrivateMessage(frame)
+	case MessageTypeConversationMessage:
+		c.handleConversationMessage(frame)
 	case MessageTypeTyping:
-		c.handleTyping(message)
+		c.handleTyping(frame)
+	case MessageTypeMarkRead:
+		c.handleMarkRead(frame)
+	case MessageTypeResume:
+		c.handleResume(frame)
+	case MessageTypeHeartbeat:
+		c.handleHeartbeat(frame)
+	case MessageTypeSetStatus:
+		c.handleSetStatus(frame)
 	default:
-		log.Printf("Unknown message type: %s", message.Type)
+		log.Printf("Unknown message type: %s", frame.Type)
+		c.sendError(frame.RequestID, ErrCodeUnknownType, "Unknown message type: "+frame.Type)
 	}
 }
 
 // handlePrivateMessage processes private message sending
-func (c *Client) handlePrivateMessage(message WSMessage) {
-	log.Printf("[Client] handlePrivateMessage: user %d", c.userID)
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("[Client] handlePrivateMessage: Invalid message data for user %d", c.userID)
-		c.sendError("Invalid message data")
+func (c *Client) handlePrivateMessage(frame ClientFrame) {
+	var req PrivateMessageRequest
+	if !c.decode(frame, &req) {
+		return
+	}
+	if req.ReceiverID == 0 {
+		c.sendError(frame.RequestID, ErrCodeInvalidArgument, "receiver_id is required")
+		return
+	}
+	if !c.checkContentSize(frame.RequestID, req.Content) {
 		return
 	}
 
-	receiverID, ok := data["receiver_id"].(float64)
-	if !ok {
-		log.Printf("[Client] handlePrivateMessage: Invalid receiver ID for user %d", c.userID)
-		c.sendError("Invalid receiver ID")
+	log.Printf("[Client] handlePrivateMessage: Creating message from user %d to user %d", c.userID, req.ReceiverID)
+	privateMessage, created, err := models.CreatePrivateMessage(c.hub.db, c.userID, req.ReceiverID, req.Content, req.ClientMsgID, req.AttachmentIDs)
+	if err != nil {
+		log.Printf("[Client] Error creating private message from user %d to user %d: %v", c.userID, req.ReceiverID, err)
+		c.fail(frame.RequestID, err)
 		return
 	}
 
-	content, ok := data["content"].(string)
-	if !ok || content == "" {
-		log.Printf("[Client] handlePrivateMessage: Invalid message content for user %d", c.userID)
-		c.sendError("Invalid message content")
+	// A retried send was already delivered the first time
+	if created {
+		c.hub.broadcastMessage(privateMessage, c.user)
+	}
+	c.ack(frame.RequestID, AckData{MessageID: privateMessage.ID, Duplicate: !created})
+}
+
+// checkContentSize answers with a too_large error when message content is longer
+// than the HTTP endpoints accept, and reports whether it is within the limit
+func (c *Client) checkContentSize(requestID, content string) bool {
+	if len(content) > maxContentRequestSize {
+		c.sendError(requestID, ErrCodeTooLarge, "Message is too long")
+		return false
+	}
+	return true
+}
+
+// handleConversationMessage processes a message sent to a conversation by ID
+func (c *Client) handleConversationMessage(frame ClientFrame) {
+	var req ConversationMessageRequest
+	if !c.decode(frame, &req) {
+		return
+	}
+	if req.ConversationID == 0 {
+		c.sendError(frame.RequestID, ErrCodeInvalidArgument, "conversation_id is required")
+		return
+	}
+	if !c.checkContentSize(frame.RequestID, req.Content) {
 		return
 	}
 
-	log.Printf("[Client] handlePrivateMessage: Creating message from user %d to user %d", c.userID, int64(receiverID))
-	privateMessage, err := models.CreatePrivateMessage(c.hub.db, c.userID, int64(receiverID), content)
+	conversationMessage, created, err := models.CreateConversationMessage(c.hub.db, req.ConversationID, c.userID, req.Content, req.ClientMsgID)
 	if err != nil {
-		log.Printf("[Client] Error creating private message from user %d to user %d: %v", c.userID, int64(receiverID), err)
-		c.sendError("Failed to send message")
+		log.Printf("[Client] Error creating message in conversation %d from user %d: %v", req.ConversationID, c.userID, err)
+		c.fail(frame.RequestID, err)
 		return
 	}
 
-	messageData := PrivateMessageData{
-		ID:         privateMessage.ID,
-		SenderID:   privateMessage.SenderID,
-		ReceiverID: privateMessage.ReceiverID,
-		Content:    privateMessage.Content,
-		IsRead:     privateMessage.IsRead,
-		CreatedAt:  privateMessage.CreatedAt,
-		Sender:     c.user,
+	if created {
+		c.hub.broadcastMessage(conversationMessage, c.user)
 	}
+	c.ack(frame.RequestID, AckData{MessageID: conversationMessage.ID, Duplicate: !created})
+}
 
-	wsMessage := WSMessage{
-		Type:      MessageTypePrivateMessage,
-		Data:      messageData,
-		Timestamp: time.Now(),
+// handleMarkRead marks a conversation read up to message_id (or entirely when it is
+// omitted) and sends read receipts to the senders
+func (c *Client) handleMarkRead(frame ClientFrame) {
+	var req MarkReadRequest
+	if !c.decode(frame, &req) {
+		return
+	}
+	if req.ConversationID == 0 {
+		c.sendError(frame.RequestID, ErrCodeInvalidArgument, "conversation_id is required")
+		return
 	}
 
-	log.Printf("[Client] handlePrivateMessage: Sending to receiver %d and sender %d", int64(receiverID), c.userID)
-	c.hub.sendToUser(int64(receiverID), wsMessage)
-	c.hub.sendToUser(c.userID, wsMessage)
+	read, err := models.MarkConversationRead(c.hub.db, req.ConversationID, c.userID, req.MessageID)
+	if err != nil {
+		log.Printf("[Client] Error marking conversation %d read for user %d: %v", req.ConversationID, c.userID, err)
+		c.fail(frame.RequestID, err)
+		return
+	}
+
+	c.hub.sendReadReceipts(c.userID, read)
+	c.ack(frame.RequestID, AckData{})
 }
 
-// handleTyping processes typing indicators
-func (c *Client) handleTyping(message WSMessage) {
-	// Parse message data
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
+// handleResume replays the events missed since last_seq after a reconnect
+func (c *Client) handleResume(frame ClientFrame) {
+	var req ResumeRequest
+	if !c.decode(frame, &req) {
 		return
 	}
 
-	receiverID, ok := data["receiver_id"].(float64)
-	if !ok {
+	c.hub.resume(c, req.Epoch, req.LastSeq)
+	c.ack(frame.RequestID, AckData{})
+}
+
+// handleHeartbeat records whether the user was active since the client's last heartbeat
+func (c *Client) handleHeartbeat(frame ClientFrame) {
+	var req HeartbeatRequest
+	if !c.decode(frame, &req) {
 		return
 	}
 
-	// Forward typing indicator to receiver
-	typingMessage := WSMessage{
-		Type: MessageTypeTyping,
-		Data: map[string]interface{}{
-			"sender_id": c.userID,
-			"username":  c.user.Username,
-		},
-		Timestamp: time.Now(),
+	c.hub.heartbeats <- heartbeat{client: c, active: req.Active}
+	c.ack(frame.RequestID, AckData{})
+}
+
+// handleSetStatus stores and applies the presence status the user chose
+func (c *Client) handleSetStatus(frame ClientFrame) {
+	var req SetStatusRequest
+	if !c.decode(frame, &req) {
+		return
 	}
 
-	c.hub.sendToUser(int64(receiverID), typingMessage)
+	if err := models.SetUserStatus(c.hub.db, c.userID, req.Status); err != nil {
+		c.fail(frame.RequestID, err)
+		return
+	}
+	c.hub.SetStatus(c.userID, req.Status)
+	c.ack(frame.RequestID, AckData{})
 }
 
-// sendError sends an error message to the client
-func (c *Client) sendError(errorMsg string) {
-	message := WSMessage{
-		Type: MessageTypeError,
-		Data: map[string]string{
-			"error": errorMsg,
+// handleTyping processes typing indicators
+func (c *Client) handleTyping(frame ClientFrame) {
+	var req TypingRequest
+	if !c.decode(frame, &req) {
+		return
+	}
+
+	typingMessage := WSMessage{
+		Type: MessageTypeTyping,
+		Data: TypingData{
+			ConversationID: req.ConversationID,
+			SenderID:       c.userID,
+			Username:       c.user.Username,
 		},
 		Timestamp: time.Now(),
 	}
 
-	select {
-	case c.send <- message:
+	switch {
+	case req.ConversationID != 0:
+		// Typing in a group goes to the other members
+		if _, err := models.GetMemberRole(c.hub.db, req.ConversationID, c.userID); err != nil {
+			c.fail(frame.RequestID, err)
+			return
+		}
+		memberIDs, err := models.GetConversationMemberIDs(c.hub.db, req.ConversationID)
+		if err != nil {
+			c.fail(frame.RequestID, err)
+			return
+		}
+		for _, memberID := range memberIDs {
+			if memberID != c.userID {
+				c.hub.sendToUser(memberID, typingMessage)
+			}
+		}
+
+	case req.ReceiverID != 0:
+		// Forward typing indicator to receiver
+		c.hub.sendToUser(req.ReceiverID, typingMessage)
+
 	default:
-		close(c.send)
+		c.sendError(frame.RequestID, ErrCodeInvalidArgument, "receiver_id or conversation_id is required")
+		return
 	}
+
+	c.ack(frame.RequestID, AckData{})
 }
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"real-time-forum/backend/internal/models"
+)
+
+// startTestHub runs a hub on a fresh database with its logging silenced
+func startTestHub(t *testing.T) *Hub {
+	t.Helper()
+	quietLogs(t)
+
+	hub := NewHub(newTestDB(t), NewLocalBroker().Join("test"))
+	go hub.Run()
+	return hub
+}
+
+// drain reads a client's messages until the hub closes its send channel, standing
+// in for writePump
+func drain(client *Client) <-chan []WSMessage {
+	done := make(chan []WSMessage, 1)
+	go func() {
+		var received []WSMessage
+		for message := range client.send {
+			received = append(received, message)
+		}
+		done <- received
+	}()
+	return done
+}
+
+func TestHubConcurrentClients(t *testing.T) {
+	const (
+		clients = 2000
+		users   = 100
+	)
+	hub := startTestHub(t)
+
+	// A client that stays connected throughout must see its own stream in order
+	watcher := newTestClient(hub, users+1, clients*10)
+	watched := drain(watcher)
+	hub.register <- watcher
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			userID := int64(i%users + 1)
+			client := newTestClient(hub, userID, 256)
+			done := drain(client)
+
+			hub.register <- client
+			hub.sendToUser(userID, WSMessage{Type: MessageTypeNotification, Timestamp: time.Now()})
+			hub.sendToUsers([]int64{userID, users + 1}, WSMessage{Type: MessageTypeNotification, Timestamp: time.Now()})
+			hub.Connected([]int64{userID})
+			if i%2 == 0 {
+				hub.unregister <- client
+			} else {
+				hub.DisconnectUser(userID, "test")
+				hub.unregister <- client // a second unregister must be harmless
+			}
+			<-done
+		}(i)
+	}
+	wg.Wait()
+
+	userIDs := make([]int64, users)
+	for i := range userIDs {
+		userIDs[i] = int64(i + 1)
+	}
+	for userID, connected := range hub.Connected(userIDs) {
+		if connected {
+			t.Errorf("user %d is still connected after every client left", userID)
+		}
+	}
+
+	hub.unregister <- watcher
+	received := <-watched
+	var last uint64
+	notifications := 0
+	for _, message := range received {
+		if message.Seq == 0 {
+			continue
+		}
+		if message.Seq != last+1 {
+			t.Fatalf("watcher got seq %d after %d", message.Seq, last)
+		}
+		last = message.Seq
+		if message.Type == MessageTypeNotification {
+			notifications++
+		}
+	}
+	if notifications != clients {
+		t.Errorf("watcher got %d notifications, want %d", notifications, clients)
+	}
+}
+
+func TestHubDropsSlowClients(t *testing.T) {
+	hub := startTestHub(t)
+
+	fast := newTestClient(hub, 1, 1024)
+	fastDone := drain(fast)
+	hub.register <- fast
+
+	first := newTestClient(hub, 2, 16)
+	hub.register <- first
+	second := newTestClient(hub, 3, 16)
+	hub.register <- second
+	hub.Connected(nil) // wait for the hub to finish handling the registrations
+
+	// Fill the second client's buffer exactly, so it only overflows if something else
+	// is sent to it
+	for free := cap(second.send) - len(second.send); free > 0; free-- {
+		hub.sendToUser(3, WSMessage{Type: MessageTypeNotification, Timestamp: time.Now()})
+	}
+	connected := hub.Connected([]int64{1, 2, 3})
+	if !connected[3] {
+		t.Fatal("client with a full but not overflowing buffer was dropped")
+	}
+
+	// Overflowing the first client drops it, and the offline status that broadcasts
+	// overflows the second, which must be dropped in the same pass
+	for free := cap(first.send) - len(first.send); free >= 0; free-- {
+		hub.sendToUser(2, WSMessage{Type: MessageTypeNotification, Timestamp: time.Now()})
+	}
+	connected = hub.Connected([]int64{1, 2, 3})
+	if connected[2] || connected[3] {
+		t.Fatalf("slow clients are still connected: %v", connected)
+	}
+	if !connected[1] {
+		t.Fatal("client that kept up was dropped")
+	}
+
+	// Dropped clients have their send channels closed
+	for _, client := range []*Client{first, second} {
+		select {
+		case received := <-drain(client):
+			if len(received) != cap(client.send) {
+				t.Errorf("user %d received %d messages, want a full buffer of %d", client.userID, len(received), cap(client.send))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("send channel of user %d was never closed", client.userID)
+		}
+	}
+
+	hub.unregister <- fast
+	offline := map[int64]bool{}
+	for _, message := range <-fastDone {
+		if status, ok := message.Data.(UserStatusData); ok && status.Status == models.StatusOffline {
+			offline[status.UserID] = true
+		}
+	}
+	if !offline[2] || !offline[3] {
+		t.Errorf("remaining client was not told both slow users went offline: %v", offline)
+	}
+}
+
+// dialTestHub connects to a running hub over a real WebSocket as a new user
+func dialTestHub(t *testing.T, hub *Hub, username string) *websocket.Conn {
+	t.Helper()
+	user := newTestUser(t, hub.db, username)
+	if err := models.CreateSession(hub.db, user.ID, username+"-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(hub.WebSocketHandler))
+	t.Cleanup(server.Close)
+	header := http.Header{"Cookie": {"session_token=" + username + "-token"}}
+	dialer := websocket.Dialer{Subprotocols: []string{ProtocolV2}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readReply reads frames until the answer to requestID arrives
+func readReply(t *testing.T, conn *websocket.Conn, requestID string) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var frame map[string]interface{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("waiting for the answer to %s: %v", requestID, err)
+		}
+		if frame["request_id"] == requestID {
+			return frame
+		}
+	}
+}
+
+func TestOversizedMessageKeepsConnection(t *testing.T) {
+	hub := startTestHub(t)
+	conn := dialTestHub(t, hub, "alice")
+	bob := newTestUser(t, hub.db, "bob")
+
+	send := func(requestID, content string) map[string]interface{} {
+		err := conn.WriteJSON(map[string]interface{}{
+			"type":       MessageTypePrivateMessage,
+			"request_id": requestID,
+			"data":       map[string]interface{}{"receiver_id": bob.ID, "content": content, "client_msg_id": requestID},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readReply(t, conn, requestID)
+	}
+
+	// Well over the old 512 byte frame limit, in multi-byte text
+	if reply := send("long", strings.Repeat("é", 2000)); reply["type"] != MessageTypeAck {
+		t.Fatalf("a 4 KB message was answered with %v", reply)
+	}
+
+	reply := send("huge", strings.Repeat("a", maxContentRequestSize+1))
+	data, _ := reply["data"].(map[string]interface{})
+	if reply["type"] != MessageTypeError || data["code"] != ErrCodeTooLarge {
+		t.Fatalf("an oversized message was answered with %v", reply)
+	}
+
+	// The connection survives the refusal
+	if reply := send("after", "still here"); reply["type"] != MessageTypeAck {
+		t.Fatalf("message after the refusal was answered with %v", reply)
+	}
+}
+
+func TestTruncateCloseReason(t *testing.T) {
+	tests := []string{
+		"",
+		"Banned: spam",
+		strings.Repeat("a", maxCloseReason),
+		strings.Repeat("a", maxCloseReason+1),
+		strings.Repeat("é", 100),
+		"Banned: " + strings.Repeat("日本語", 20),
+		strings.Repeat("a", 119) + "😀😀",
+	}
+	for _, reason := range tests {
+		got := truncateCloseReason(reason)
+		if len(got) > maxCloseReason {
+			t.Errorf("truncateCloseReason(%q) is %d bytes, over %d", reason, len(got), maxCloseReason)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncateCloseReason(%q) = %q is not valid UTF-8", reason, got)
+		}
+		if len(reason) <= maxCloseReason && got != reason {
+			t.Errorf("truncateCloseReason(%q) = %q, want it unchanged", reason, got)
+		}
+		if len(reason) > maxCloseReason && (!strings.HasSuffix(got, "...") || !strings.HasPrefix(reason, strings.TrimSuffix(got, "..."))) {
+			t.Errorf("truncateCloseReason(%q) = %q, want a prefix followed by ...", reason, got)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"real-time-forum/backend/internal/models"
+)
+
+const (
+	// idleAfter is how long a connection may go without reporting user activity
+	// before it counts as idle
+	idleAfter = 5 * time.Minute
+
+	// idleCheckInterval is how often connections are checked for going idle
+	idleCheckInterval = 30 * time.Second
+)
+
+// remoteUser is a user connected to other instances, with their presence on each
+type remoteUser struct {
+	username  string
+	instances map[string]PresenceEvent
+}
+
+// heartbeat reports whether the user was active on a connection since its last heartbeat
+type heartbeat struct {
+	client *Client
+	active bool
+}
+
+// statusChange asks Run to apply a status a user chose
+type statusChange struct {
+	userID int64
+	status string
+}
+
+// statusQuery asks Run for the status other users see for each of userIDs
+type statusQuery struct {
+	userIDs []int64
+	reply   chan map[int64]string
+}
+
+// connectionQuery asks Run which of userIDs are connected to any instance
+type connectionQuery struct {
+	userIDs []int64
+	reply   chan map[int64]bool
+}
+
+// SetStatus applies a status a user chose on every instance. The caller stores it.
+func (h *Hub) SetStatus(userID int64, status string) {
+	h.statusChanges <- statusChange{userID: userID, status: status}
+	h.broker.Publish(BrokerEvent{Status: &StatusEvent{UserID: userID, Status: status}})
+}
+
+// Statuses returns the status other users see for each of userIDs: online, away, dnd
+// or offline.
+func (h *Hub) Statuses(userIDs []int64) map[int64]string {
+	reply := make(chan map[int64]string, 1)
+	h.statusQueries <- statusQuery{userIDs: userIDs, reply: reply}
+	return <-reply
+}
+
+// answerStatusQuery answers a Statuses call. Runs on the hub goroutine.
+func (h *Hub) answerStatusQuery(query statusQuery) {
+	statuses := make(map[int64]string, len(query.userIDs))
+	for _, userID := range query.userIDs {
+		statuses[userID] = models.StatusOffline
+		if shown, ok := h.shown[userID]; ok {
+			statuses[userID] = shown.Status
+		}
+	}
+	query.reply <- statuses
+}
+
+// Connected reports which of userIDs have a connection to any instance, whatever
+// status they show. Invisible users are connected but show as offline.
+func (h *Hub) Connected(userIDs []int64) map[int64]bool {
+	reply := make(chan map[int64]bool, 1)
+	h.connectionQueries <- connectionQuery{userIDs: userIDs, reply: reply}
+	return <-reply
+}
+
+// answerConnectionQuery answers a Connected call. Runs on the hub goroutine.
+func (h *Hub) answerConnectionQuery(query connectionQuery) {
+	connected := make(map[int64]bool, len(query.userIDs))
+	for _, userID := range query.userIDs {
+		connected[userID] = len(h.userClients[userID]) > 0 || h.remote[userID] != nil
+	}
+	query.reply <- connected
+}
+
+// applyStatus records the status a user chose, if they are connected here. Runs on
+// the hub goroutine.
+func (h *Hub) applyStatus(userID int64, status string) {
+	for client := range h.userClients[userID] {
+		h.chosen[userID] = status
+		h.updateLocalPresence(userID, client.user.Username)
+		return
+	}
+}
+
+// recordHeartbeat notes a connection's activity. Runs on the hub goroutine.
+func (h *Hub) recordHeartbeat(client *Client, active bool) {
+	if !h.clients[client] {
+		return
+	}
+	if active {
+		client.lastActive = time.Now()
+	}
+	h.updateLocalPresence(client.userID, client.user.Username)
+}
+
+// checkIdle notices users whose connections here all went idle. Runs on the hub goroutine.
+func (h *Hub) checkIdle() {
+	for userID, clients := range h.userClients {
+		for client := range clients {
+			h.updateLocalPresence(userID, client.user.Username)
+			break
+		}
+	}
+}
+
+// updateLocalPresence works out a user's presence on this instance, tells the other
+// instances if it changed, and updates the status everyone sees. Runs on the hub goroutine.
+func (h *Hub) updateLocalPresence(userID int64, username string) {
+	current := PresenceEvent{UserID: userID, Username: username}
+	if clients := h.userClients[userID]; len(clients) > 0 {
+		current.Online = true
+		current.Status = h.chosen[userID]
+		current.Idle = true
+		cutoff := time.Now().Add(-idleAfter)
+		for client := range clients {
+			if client.lastActive.After(cutoff) {
+				current.Idle = false
+				break
+			}
+		}
+	}
+
+	previous, known := h.announced[userID]
+	if current != previous && (known || current.Online) {
+		if current.Online {
+			h.announced[userID] = current
+		} else {
+			delete(h.announced, userID)
+			delete(h.chosen, userID)
+		}
+		h.broker.SetPresence(current)
+		h.broker.Publish(BrokerEvent{Presence: &current})
+	}
+
+	h.statusChanged(userID, username)
+}
+
+// setRemotePresence records a user's presence on another instance. Runs on the hub goroutine.
+func (h *Hub) setRemotePresence(instanceID string, presence PresenceEvent) {
+	user := h.remote[presence.UserID]
+	if presence.Online {
+		if user == nil {
+			user = &remoteUser{instances: make(map[string]PresenceEvent)}
+			h.remote[presence.UserID] = user
+		}
+		user.username = presence.Username
+		user.instances[instanceID] = presence
+	} else if user != nil {
+		delete(user.instances, instanceID)
+		if len(user.instances) == 0 {
+			delete(h.remote, presence.UserID)
+		}
+	}
+
+	h.statusChanged(presence.UserID, presence.Username)
+}
+
+// dropInstance forgets every user connected to an instance. Runs on the hub goroutine.
+func (h *Hub) dropInstance(instanceID string) {
+	for userID, user := range h.remote {
+		if _, ok := user.instances[instanceID]; ok {
+			h.setRemotePresence(instanceID, PresenceEvent{UserID: userID, Username: user.username})
+		}
+	}
+}
+
+// statusOf works out the status other users see for a user, combining their
+// presence on every instance. Runs on the hub goroutine.
+func (h *Hub) statusOf(userID int64) string {
+	online, idle, status := false, true, ""
+	if presence, ok := h.announced[userID]; ok {
+		online, idle, status = true, presence.Idle, presence.Status
+	}
+	if user := h.remote[userID]; user != nil {
+		for _, presence := range user.instances {
+			online = true
+			idle = idle && presence.Idle
+			if status == "" {
+				status = presence.Status
+			}
+		}
+	}
+
+	switch {
+	case !online || status == models.StatusInvisible:
+		return models.StatusOffline
+	case status == models.StatusAway || status == models.StatusDND:
+		return status
+	case idle:
+		return models.StatusAway
+	default:
+		return models.StatusOnline
+	}
+}
+
+// statusChanged broadcasts a user's status to this instance's clients if it differs
+// from the one they last saw. Runs on the hub goroutine.
+func (h *Hub) statusChanged(userID int64, username string) {
+	status := h.statusOf(userID)
+	shown, ok := h.shown[userID]
+	if (ok && shown.Status == status) || (!ok && status == models.StatusOffline) {
+		return
+	}
+
+	if status == models.StatusOffline {
+		delete(h.shown, userID)
+	} else {
+		h.shown[userID] = UserStatusData{UserID: userID, Username: username, Status: status}
+	}
+	h.broadcastUserStatus(userID, username, status)
+}
+
+// recordLastSeen stores when a user's last connection here closed, unless they are
+// invisible. The write happens off the hub goroutine. Runs on the hub goroutine.
+func (h *Hub) recordLastSeen(userID int64) {
+	if h.chosen[userID] == models.StatusInvisible {
+		return
+	}
+	seenAt := time.Now()
+	go func() {
+		if err := models.UpdateLastSeen(h.db, userID, seenAt); err != nil {
+			log.Printf("[Hub] Error recording last seen for user %d: %v", userID, err)
+		}
+	}()
+}
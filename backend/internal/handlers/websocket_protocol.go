@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"real-time-forum/backend/internal/models"
+)
+
+// Protocol versions, negotiated through the Sec-WebSocket-Protocol header at upgrade.
+// Clients that offer no subprotocol get v1, which never receives acks.
+const (
+	ProtocolV1 = "forum.v1"
+	ProtocolV2 = "forum.v2" // adds request_id acks
+)
+
+// supportedProtocols lists the versions the server speaks, most preferred first
+var supportedProtocols = []string{ProtocolV2, ProtocolV1}
+
+// Machine-readable error codes carried by error frames
+const (
+	ErrCodeInvalidFrame    = "invalid_frame"
+	ErrCodeUnknownType     = "unknown_type"
+	ErrCodeInvalidArgument = "invalid_argument"
+	ErrCodeTooLarge        = "too_large"
+	ErrCodeNotFound        = "not_found"
+	ErrCodeForbidden       = "forbidden"
+	ErrCodeMuted           = "muted"
+	ErrCodeBanned          = "banned"
+	ErrCodeInternal        = "internal"
+)
+
+// supportsAnyProtocol reports whether the server speaks one of the offered versions
+func supportsAnyProtocol(offered []string) bool {
+	for _, protocol := range offered {
+		for _, supported := range supportedProtocols {
+			if protocol == supported {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClientFrame is a frame received from a client. Data is decoded into the request
+// struct for its type; a RequestID is echoed back in the matching ack or error.
+type ClientFrame struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// PrivateMessageRequest sends a direct message. ClientMsgID is an optional idempotency
+// key: retrying with the same key never creates a second message.
+type PrivateMessageRequest struct {
+	ReceiverID    int64   `json:"receiver_id"`
+	Content       string  `json:"content"`
+	ClientMsgID   string  `json:"client_msg_id,omitempty"`
+	AttachmentIDs []int64 `json:"attachment_ids,omitempty"`
+}
+
+// ConversationMessageRequest sends a message to a conversation by ID
+type ConversationMessageRequest struct {
+	ConversationID int64  `json:"conversation_id"`
+	Content        string `json:"content"`
+	ClientMsgID    string `json:"client_msg_id,omitempty"`
+}
+
+// TypingRequest signals typing to one user or to a conversation's members
+type TypingRequest struct {
+	ReceiverID     int64 `json:"receiver_id,omitempty"`
+	ConversationID int64 `json:"conversation_id,omitempty"`
+}
+
+// MarkReadRequest marks a conversation read up to MessageID, or entirely when it is 0
+type MarkReadRequest struct {
+	ConversationID int64 `json:"conversation_id"`
+	MessageID      int64 `json:"message_id,omitempty"`
+}
+
+// ResumeRequest asks for the events missed since LastSeq
+type ResumeRequest struct {
+	Epoch   int64  `json:"epoch"`
+	LastSeq uint64 `json:"last_seq"`
+}
+
+// HeartbeatRequest is sent periodically; Active reports whether the user interacted
+// with the page since the previous one
+type HeartbeatRequest struct {
+	Active bool `json:"active"`
+}
+
+// SetStatusRequest chooses a presence status: online, away, dnd or invisible
+type SetStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// TypingData is forwarded to the users a TypingRequest targets
+type TypingData struct {
+	ConversationID int64  `json:"conversation_id,omitempty"`
+	SenderID       int64  `json:"sender_id"`
+	Username       string `json:"username"`
+}
+
+// AckData confirms a request. Message sends report the stored message, and whether it
+// was a retry of one already stored.
+type AckData struct {
+	MessageID int64 `json:"message_id,omitempty"`
+	Duplicate bool  `json:"duplicate,omitempty"`
+}
+
+// ErrorData reports a failed request
+type ErrorData struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// decode unmarshals a frame's data into req, answering with an error frame on failure
+func (c *Client) decode(frame ClientFrame, req interface{}) bool {
+	if err := json.Unmarshal(frame.Data, req); err != nil {
+		c.sendError(frame.RequestID, ErrCodeInvalidFrame, "Invalid message data")
+		return false
+	}
+	return true
+}
+
+// ack confirms a request. Frames without a request_id, and v1 clients, get no ack.
+func (c *Client) ack(requestID string, data AckData) {
+	if requestID == "" || c.protocol == ProtocolV1 {
+		return
+	}
+	c.sendDirect(WSMessage{
+		Type:      MessageTypeAck,
+		RequestID: requestID,
+		Data:      data,
+		Timestamp: time.Now(),
+	})
+}
+
+// sendError reports a failed request to this client
+func (c *Client) sendError(requestID, code, message string) {
+	c.sendDirect(WSMessage{
+		Type:      MessageTypeError,
+		RequestID: requestID,
+		Data:      ErrorData{Code: code, Error: message},
+		Timestamp: time.Now(),
+	})
+}
+
+// fail reports a model error with its matching code. Unexpected errors are logged
+// and reported without detail.
+func (c *Client) fail(requestID string, err error) {
+	switch err {
+	case models.ErrEmptyMessage, models.ErrMessageToSelf, models.ErrInvalidClientID, models.ErrInvalidStatus,
+		models.ErrTooManyAttachments, models.ErrAttachmentUnusable, models.ErrInvalidAttachmentID:
+		c.sendError(requestID, ErrCodeInvalidArgument, err.Error())
+	case models.ErrReceiverNotFound, models.ErrMessageNotFound, models.ErrConversationNotFound,
+		models.ErrNotConversationMember:
+		c.sendError(requestID, ErrCodeNotFound, err.Error())
+	case models.ErrForbidden:
+		c.sendError(requestID, ErrCodeForbidden, err.Error())
+	case models.ErrUserMuted:
+		c.sendError(requestID, ErrCodeMuted, err.Error())
+	case models.ErrUserBanned:
+		c.sendError(requestID, ErrCodeBanned, err.Error())
+	default:
+		log.Printf("[Client] Request %q from user %d failed: %v", requestID, c.userID, err)
+		c.sendError(requestID, ErrCodeInternal, "Internal server error")
+	}
+}
+
+// sendDirect queues an unsequenced frame for this connection only. It goes through
+// the hub so it can never race with the hub closing the connection.
+func (c *Client) sendDirect(message WSMessage) {
+	c.hub.deliveries <- delivery{client: c, message: message}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// replayBufferSize bounds how many recent events are kept per user. It stays
+	// below the client send buffer so a full replay always fits.
+	replayBufferSize = 200
+
+	// replayWindow is how long an event stays replayable after it was sent
+	replayWindow = 5 * time.Minute
+)
+
+// SessionData tells a newly connected client where its event stream stands
+type SessionData struct {
+	Epoch    int64  `json:"epoch"` // changes whenever the server restarts
+	Seq      uint64 `json:"seq"`
+	Protocol string `json:"protocol"`
+}
+
+// ResumedData confirms that the events a client missed were replayed
+type ResumedData struct {
+	Replayed int    `json:"replayed"`
+	Seq      uint64 `json:"seq"`
+}
+
+// ResyncData tells a client its missed events can't be replayed and it should reload
+type ResyncData struct {
+	Epoch  int64  `json:"epoch"`
+	Seq    uint64 `json:"seq"`
+	Reason string `json:"reason"`
+}
+
+// bufferedEvent is a sequenced event kept for replay
+type bufferedEvent struct {
+	message  WSMessage
+	queuedAt time.Time
+}
+
+// userStream numbers the events sent to one user while they are connected or were
+// recently, and keeps the most recent ones so a reconnecting client can catch up
+type userStream struct {
+	seq     uint64
+	buffer  []bufferedEvent
+	touched time.Time // when the user last connected, disconnected or was sent an event
+}
+
+// sequence stamps a message with the stream's next sequence number and buffers it.
+// Runs on the hub goroutine.
+func (h *Hub) sequence(stream *userStream, message WSMessage) WSMessage {
+	stream.seq++
+	message.Seq = stream.seq
+
+	stream.touched = time.Now()
+	stream.buffer = append(stream.buffer, bufferedEvent{message: message, queuedAt: stream.touched})
+	if len(stream.buffer) > replayBufferSize {
+		stream.buffer = stream.buffer[len(stream.buffer)-replayBufferSize:]
+	}
+	return message
+}
+
+// stream returns a user's stream, creating it when they connect. Users who never
+// connected, or left longer than replayWindow ago, have none. Runs on the hub goroutine.
+func (h *Hub) stream(userID int64) *userStream {
+	stream, ok := h.streams[userID]
+	if !ok {
+		stream = &userStream{touched: time.Now()}
+		h.streams[userID] = stream
+	}
+	return stream
+}
+
+// sendSession tells a new client the current epoch and sequence number so it can
+// resume from there if the connection drops. Runs on the hub goroutine.
+func (h *Hub) sendSession(client *Client) {
+	h.push(client, WSMessage{
+		Type:      MessageTypeSession,
+		Data:      SessionData{Epoch: h.epoch, Seq: h.stream(client.userID).seq, Protocol: client.protocol},
+		Timestamp: time.Now(),
+	})
+}
+
+// resume replays the events a reconnecting client missed after lastSeq, or tells it
+// to resync when they are no longer all buffered
+func (h *Hub) resume(client *Client, epoch int64, lastSeq uint64) {
+	h.resumes <- resumeRequest{client: client, epoch: epoch, lastSeq: lastSeq}
+}
+
+// replay handles a resume request. Runs on the hub goroutine.
+func (h *Hub) replay(client *Client, epoch int64, lastSeq uint64) {
+	if !h.clients[client] {
+		return
+	}
+
+	stream := h.stream(client.userID)
+	cutoff := time.Now().Add(-replayWindow)
+
+	var reason string
+	var missed []WSMessage
+	switch {
+	case epoch != h.epoch:
+		reason = "server restarted"
+	case lastSeq > stream.seq:
+		reason = "unknown sequence number"
+	case lastSeq < stream.seq:
+		for _, event := range stream.buffer {
+			if event.message.Seq > lastSeq {
+				missed = append(missed, event.message)
+			}
+		}
+		if len(missed) == 0 || missed[0].Seq != lastSeq+1 {
+			reason = "too many missed events"
+		} else if stream.buffer[len(stream.buffer)-len(missed)].queuedAt.Before(cutoff) {
+			reason = "missed events expired"
+		}
+	}
+
+	response := WSMessage{
+		Type:      MessageTypeResumed,
+		Data:      ResumedData{Replayed: len(missed), Seq: stream.seq},
+		Timestamp: time.Now(),
+	}
+	if reason != "" {
+		missed = nil
+		response = WSMessage{
+			Type:      MessageTypeResync,
+			Data:      ResyncData{Epoch: h.epoch, Seq: stream.seq, Reason: reason},
+			Timestamp: time.Now(),
+		}
+	}
+
+	log.Printf("[Hub] resume: user %d from seq %d, replaying %d events %s", client.userID, lastSeq, len(missed), reason)
+	for _, message := range append(missed, response) {
+		h.push(client, message)
+	}
+}
+
+// pruneStreams drops buffered events older than replayWindow, and the streams of users
+// who have been gone for longer than that. A client resuming after its stream was
+// dropped is told to resync. Runs on the hub goroutine.
+func (h *Hub) pruneStreams() {
+	cutoff := time.Now().Add(-replayWindow)
+	for userID, stream := range h.streams {
+		if len(h.userClients[userID]) == 0 && stream.touched.Before(cutoff) {
+			delete(h.streams, userID)
+			continue
+		}
+
+		expired := 0
+		for expired < len(stream.buffer) && stream.buffer[expired].queuedAt.Before(cutoff) {
+			expired++
+		}
+		if expired == len(stream.buffer) {
+			stream.buffer = nil
+		} else if expired > 0 {
+			stream.buffer = append([]bufferedEvent(nil), stream.buffer[expired:]...)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// Hub methods are called directly here, standing in for the Run goroutine
+func TestStreamsOnlyForRecentUsers(t *testing.T) {
+	hub := NewHub(newTestDB(t), NewLocalBroker().Join("test"))
+	message := WSMessage{Type: MessageTypeNotification, Timestamp: time.Now()}
+
+	hub.deliver(delivery{userIDs: []int64{1, 2, 3}, message: message})
+	if len(hub.streams) != 0 {
+		t.Fatalf("users who never connected got %d streams", len(hub.streams))
+	}
+
+	online := newTestClient(hub, 1, replayBufferSize+10)
+	hub.addClient(online)
+	gone := newTestClient(hub, 2, replayBufferSize+10)
+	hub.addClient(gone)
+	hub.removeClient(gone)
+
+	hub.deliver(delivery{all: true, message: message})
+	hub.deliver(delivery{userIDs: []int64{2, 3}, message: message})
+	if _, ok := hub.streams[3]; ok {
+		t.Error("broadcast created a stream for a user who never connected")
+	}
+	if stream := hub.streams[2]; stream == nil || stream.buffer[len(stream.buffer)-1].message.Type != message.Type {
+		t.Error("recently disconnected user's events were not buffered")
+	}
+
+	// Once the window has passed, only the connected user keeps a stream
+	old := time.Now().Add(-replayWindow - time.Second)
+	for _, stream := range hub.streams {
+		stream.touched = old
+		for i := range stream.buffer {
+			stream.buffer[i].queuedAt = old
+		}
+	}
+	hub.pruneStreams()
+
+	if _, ok := hub.streams[2]; ok {
+		t.Error("stream of a user gone longer than replayWindow was kept")
+	}
+	stream, ok := hub.streams[1]
+	if !ok {
+		t.Fatal("stream of a connected user was dropped")
+	}
+	if len(stream.buffer) != 0 {
+		t.Errorf("%d expired events were kept", len(stream.buffer))
+	}
+}
+
+// A client whose stream was evicted is told to resync rather than handed a gap
+func TestResumeAfterEviction(t *testing.T) {
+	hub := NewHub(newTestDB(t), NewLocalBroker().Join("test"))
+
+	client := newTestClient(hub, 1, replayBufferSize+10)
+	hub.addClient(client)
+	hub.deliver(delivery{userIDs: []int64{1}, message: WSMessage{Type: MessageTypeNotification}})
+	lastSeq := hub.streams[1].seq
+	hub.removeClient(client)
+
+	hub.streams[1].touched = time.Now().Add(-replayWindow - time.Second)
+	hub.pruneStreams()
+
+	client = newTestClient(hub, 1, replayBufferSize+10)
+	hub.addClient(client)
+	hub.replay(client, hub.epoch, lastSeq)
+
+	var last WSMessage
+	for len(client.send) > 0 {
+		last = <-client.send
+	}
+	if last.Type != MessageTypeResync {
+		t.Errorf("resume after eviction answered %q, want %q", last.Type, MessageTypeResync)
+	}
+}
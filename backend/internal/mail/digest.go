@@ -0,0 +1,94 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templates embed.FS
+
+var (
+	digestText = texttemplate.Must(texttemplate.ParseFS(templates, "templates/digest.txt"))
+	digestHTML = htmltemplate.Must(htmltemplate.ParseFS(templates, "templates/digest.html"))
+)
+
+// Digest is a summary of the unread activity a user missed
+type Digest struct {
+	To        string
+	Name      string
+	Frequency string // "daily" or "weekly"
+	SiteURL   string
+
+	Messages          []DigestMessage
+	MessageCount      int // all unread messages, including those not listed
+	Notifications     []DigestNotification
+	NotificationCount int
+}
+
+// DigestMessage is an unread message listed in a digest
+type DigestMessage struct {
+	From         string
+	Conversation string // room name, empty for direct messages
+	Excerpt      string
+	SentAt       time.Time
+}
+
+// DigestNotification is an unread notification listed in a digest
+type DigestNotification struct {
+	Summary string
+	Text    string
+}
+
+// MoreMessages is how many unread messages the digest doesn't list
+func (d Digest) MoreMessages() int {
+	return d.MessageCount - len(d.Messages)
+}
+
+// MoreNotifications is how many unread notifications the digest doesn't list
+func (d Digest) MoreNotifications() int {
+	return d.NotificationCount - len(d.Notifications)
+}
+
+// Message renders a digest as an email
+func (d Digest) Message() (Message, error) {
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, d); err != nil {
+		return Message{}, err
+	}
+	if err := digestHTML.Execute(&html, d); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      d.To,
+		Subject: d.subject(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func (d Digest) subject() string {
+	subject := "Your " + d.Frequency + " digest:"
+	if d.MessageCount > 0 {
+		subject += " " + plural(d.MessageCount, "unread message")
+	}
+	if d.MessageCount > 0 && d.NotificationCount > 0 {
+		subject += " and"
+	}
+	if d.NotificationCount > 0 {
+		subject += " " + plural(d.NotificationCount, "notification")
+	}
+	return subject
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
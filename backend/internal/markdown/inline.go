@@ -0,0 +1,477 @@
+package markdown
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// escaper escapes text for use in HTML content and quoted attributes
+var escaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+	"'", "&#39;",
+)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// maxLinkLength bounds how far a link's text and URL are searched for, keeping
+// rendering linear for text full of unmatched brackets
+const maxLinkLength = 2048
+
+// inline renders the inline elements of one run of text
+type inline struct {
+	b      *strings.Builder
+	text   string
+	inLink bool // inside link text, where links and bare URLs aren't recognized
+	depth  int  // emphasis nesting
+
+	// noURLBefore ends the last bare URL candidate that couldn't be linked. URLs
+	// aren't looked for again inside it, so each byte is scanned for URLs only once.
+	noURLBefore int
+
+	// Searches for closing delimiters that already failed. A closer that isn't found
+	// after one position isn't found after any later one either.
+	noCodeCloser map[int]bool
+	noCloser     map[[2]int]bool
+}
+
+// renderInline renders the inline elements of a block
+func renderInline(b *strings.Builder, text string, inLink bool) {
+	renderNested(b, text, inLink, 0)
+}
+
+func renderNested(b *strings.Builder, text string, inLink bool, depth int) {
+	r := &inline{
+		b:            b,
+		text:         text,
+		inLink:       inLink,
+		depth:        depth,
+		noCodeCloser: make(map[int]bool),
+		noCloser:     make(map[[2]int]bool),
+	}
+	r.render()
+}
+
+func (r *inline) render() {
+	b, text := r.b, r.text
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch c {
+		case '\\':
+			if i+1 < len(text) && isPunct(text[i+1]) {
+				b.WriteString(escape(text[i+1 : i+2]))
+				i += 2
+				continue
+			}
+
+		case '\n':
+			b.WriteString("<br>\n")
+			i++
+			continue
+
+		case '`':
+			n := runLength(text[i:], '`')
+			if end := r.codeCloser(i+n, n); end >= 0 {
+				writeCodeSpan(b, text[i+n:end])
+				i = end + n
+				continue
+			}
+			// An unmatched run of backticks is literal
+			b.WriteString(text[i : i+n])
+			i += n
+			continue
+
+		case '*', '_', '~':
+			if n := r.emphasis(i); n > 0 {
+				i += n
+				continue
+			}
+			n := runLength(text[i:], c)
+			b.WriteString(text[i : i+n])
+			i += n
+			continue
+
+		case '!', '[':
+			if !r.inLink {
+				if n := renderLink(b, text[i:]); n > 0 {
+					i += n
+					continue
+				}
+			}
+
+		case '<':
+			if !r.inLink {
+				if n := renderAutolink(b, text[i:]); n > 0 {
+					i += n
+					continue
+				}
+			}
+
+		case 'h':
+			if !r.inLink && i >= r.noURLBefore && (i == 0 || !isWordByte(text[i-1])) {
+				n, candidate := renderBareURL(b, text[i:])
+				if n > 0 {
+					i += n
+					continue
+				}
+				r.noURLBefore = i + candidate
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(text[i:])
+		b.WriteString(escape(text[i : i+size]))
+		i += size
+	}
+}
+
+// codeCloser finds the run of exactly n backticks that closes a code span whose
+// content starts at start, or returns -1
+func (r *inline) codeCloser(start, n int) int {
+	if r.noCodeCloser[n] {
+		return -1
+	}
+	for j := start; j < len(r.text); {
+		k := strings.IndexByte(r.text[j:], '`')
+		if k < 0 {
+			break
+		}
+		j += k
+		m := runLength(r.text[j:], '`')
+		if m == n {
+			return j
+		}
+		j += m
+	}
+	r.noCodeCloser[n] = true
+	return -1
+}
+
+func writeCodeSpan(b *strings.Builder, code string) {
+	code = strings.ReplaceAll(code, "\n", " ")
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+		code = code[1 : len(code)-1]
+	}
+	b.WriteString("<code>" + escape(code) + "</code>")
+}
+
+// emphasis renders *em*, **strong**, _em_, __strong__ or ~~strikethrough~~ starting
+// at text[i] and returns the length consumed, or 0 if it isn't closed
+func (r *inline) emphasis(i int) int {
+	text := r.text
+	c := text[i]
+	run := runLength(text[i:], c)
+
+	// The opening delimiter must be followed by text, and underscores inside
+	// words (snake_case) don't count
+	after := i + run
+	if r.depth >= maxDepth || after >= len(text) || isSpace(text[after]) {
+		return 0
+	}
+	if c == '_' && i > 0 && isWordByte(text[i-1]) {
+		return 0
+	}
+
+	sizes := []int{2, 1}
+	if c == '~' {
+		if run != 2 {
+			return 0
+		}
+		sizes = []int{2}
+	}
+
+	for _, size := range sizes {
+		if run < size {
+			continue
+		}
+		start := i + size
+		end := r.closer(start, c, size)
+		if end < 0 {
+			continue
+		}
+		tag := "em"
+		if c == '~' {
+			tag = "del"
+		} else if size == 2 {
+			tag = "strong"
+		}
+		r.b.WriteString("<" + tag + ">")
+		renderNested(r.b, text[start:end], r.inLink, r.depth+1)
+		r.b.WriteString("</" + tag + ">")
+		return end + size - i
+	}
+	return 0
+}
+
+// closer finds a closing delimiter of size c characters after start, or returns -1
+func (r *inline) closer(start int, c byte, size int) int {
+	key := [2]int{int(c), size}
+	if r.noCloser[key] {
+		return -1
+	}
+
+	text := r.text
+	for j := start + 1; j+size <= len(text); j++ {
+		switch text[j] {
+		case '`':
+			// Delimiters inside code spans don't close anything
+			n := runLength(text[j:], '`')
+			if end := r.codeCloser(j+n, n); end >= 0 {
+				j = end + n - 1
+			} else {
+				j += n - 1
+			}
+			continue
+		case '\\':
+			j++
+			continue
+		case c:
+		default:
+			continue
+		}
+
+		run := runLength(text[j:], c)
+		if run < size || isSpace(text[j-1]) || (run != size && size == 1) {
+			j += run - 1
+			continue
+		}
+		if c == '_' && j+run < len(text) && isWordByte(text[j+run]) {
+			j += run - 1
+			continue
+		}
+		// In a longer run the last delimiters close, so ***a*** nests as <strong><em>
+		return j + run - size
+	}
+
+	r.noCloser[key] = true
+	return -1
+}
+
+// renderLink renders [text](url "title") or ![alt](url) at the start of text and
+// returns its length, or 0 if it isn't a well-formed link to a safe URL
+func renderLink(b *strings.Builder, text string) int {
+	image := text[0] == '!'
+	open := 0
+	if image {
+		if len(text) < 2 || text[1] != '[' {
+			return 0
+		}
+		open = 1
+	}
+
+	if len(text) > maxLinkLength {
+		text = text[:maxLinkLength]
+	}
+
+	// Find the matching ]
+	depth := 0
+	closeBracket := -1
+	for j := open; j < len(text) && closeBracket < 0; j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeBracket = j
+			}
+		}
+	}
+	if closeBracket < 0 || closeBracket+1 >= len(text) || text[closeBracket+1] != '(' {
+		return 0
+	}
+
+	// Find the matching ), allowing balanced parentheses in the URL
+	closeParen := -1
+	depth = 0
+	for j := closeBracket + 2; j < len(text) && closeParen < 0; j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				closeParen = j
+			}
+			depth--
+		}
+	}
+	if closeParen < 0 {
+		return 0
+	}
+	dest := strings.TrimSpace(text[closeBracket+2 : closeParen])
+
+	var title string
+	if k := strings.IndexAny(dest, " \t\n"); k >= 0 {
+		rest := strings.TrimSpace(dest[k:])
+		dest = dest[:k]
+		if len(rest) < 2 || rest[0] != '"' || rest[len(rest)-1] != '"' {
+			return 0
+		}
+		title = rest[1 : len(rest)-1]
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+
+	href, ok := safeURL(dest)
+	if !ok {
+		return 0
+	}
+
+	label := text[open+1 : closeBracket]
+	writeLinkStart(b, href, title)
+	if image {
+		// Images are linked rather than embedded, so pages never load remote content
+		if label == "" {
+			label = dest
+		}
+		b.WriteString(escape(label))
+	} else {
+		renderInline(b, label, true)
+	}
+	b.WriteString("</a>")
+	return closeParen + 1
+}
+
+// renderAutolink renders <https://example.com> or <user@example.com> at the start of text
+func renderAutolink(b *strings.Builder, text string) int {
+	end := strings.IndexByte(text[:min(len(text), maxLinkLength)], '>')
+	if end < 0 {
+		return 0
+	}
+	target := text[1:end]
+	if target == "" || strings.ContainsAny(target, " \t\n<") {
+		return 0
+	}
+
+	href := target
+	if !strings.Contains(target, ":") && strings.Contains(target, "@") {
+		href = "mailto:" + target
+	} else if !hasScheme(target) {
+		return 0
+	}
+	href, ok := safeURL(href)
+	if !ok {
+		return 0
+	}
+
+	writeLinkStart(b, href, "")
+	b.WriteString(escape(target) + "</a>")
+	return end + 1
+}
+
+// renderBareURL links an http(s) URL written without any markup and returns its
+// length. It also returns the length of the URL-like run of text it looked at, which
+// is all there is to link at this position and within it.
+func renderBareURL(b *strings.Builder, text string) (n, candidate int) {
+	if !strings.HasPrefix(text, "http://") && !strings.HasPrefix(text, "https://") {
+		return 0, 0
+	}
+	candidate = strings.IndexAny(text, " \t\n<>\"")
+	if candidate < 0 {
+		candidate = len(text)
+	}
+	if candidate > maxLinkLength {
+		return 0, candidate
+	}
+
+	// Leave trailing punctuation, and a closing parenthesis that has no opening
+	// one in the URL, to the surrounding sentence
+	end := candidate
+	opens := strings.Count(text[:end], "(")
+	closes := strings.Count(text[:end], ")")
+	for end > 0 {
+		last := text[end-1]
+		if strings.IndexByte(".,:;!?'*_~", last) >= 0 {
+			end--
+			continue
+		}
+		if last == ')' && opens < closes {
+			end--
+			closes--
+			continue
+		}
+		break
+	}
+
+	target := text[:end]
+	href, ok := safeURL(target)
+	if !ok || strings.HasSuffix(target, "://") {
+		return 0, candidate
+	}
+	writeLinkStart(b, href, "")
+	b.WriteString(escape(target) + "</a>")
+	return end, candidate
+}
+
+func writeLinkStart(b *strings.Builder, href, title string) {
+	b.WriteString(`<a href="` + escape(href) + `"`)
+	if title != "" {
+		b.WriteString(` title="` + escape(title) + `"`)
+	}
+	b.WriteString(` rel="nofollow">`)
+}
+
+// safeURL accepts http, https and mailto URLs and paths on this site. Anything else,
+// such as javascript: or data: URLs, is rejected.
+func safeURL(raw string) (string, bool) {
+	if raw == "" || strings.IndexFunc(raw, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", false
+	}
+	if (strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//") && !strings.HasPrefix(raw, "/\\")) ||
+		strings.HasPrefix(raw, "#") {
+		return raw, true
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
+
+func hasScheme(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != ""
+}
+
+// runLength counts how many times c repeats at the start of s
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+func isPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// isWordByte reports whether c is part of a word; bytes of multi-byte characters count
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
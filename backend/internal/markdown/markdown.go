@@ -0,0 +1,289 @@
+// Package markdown renders the Markdown users write in posts, comments and messages
+// to HTML. Raw HTML in the source is escaped rather than passed through, links are
+// limited to safe schemes and marked nofollow, and only a fixed set of tags is ever
+// produced, so the output can be inserted into a page as is.
+//
+// Supported syntax: paragraphs with line breaks, ATX headings, emphasis, strong,
+// strikethrough, inline code, fenced and indented code blocks, block quotes, ordered
+// and unordered lists, horizontal rules, links, autolinks and bare http(s) URLs.
+// Images are rendered as links so pages never load remote content.
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	fenceRe    = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`\\s]*)")
+	headingRe  = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	ruleRe     = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	quoteRe    = regexp.MustCompile(`^ {0,3}> ?`)
+	listItemRe = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])(?:([ \t]+)(.*))?$`)
+	languageRe = regexp.MustCompile(`^[A-Za-z0-9_+#.-]{1,32}$`)
+)
+
+// maxDepth bounds how deeply quotes and lists nest, so crafted input can't recurse without limit
+const maxDepth = 16
+
+// Render converts Markdown source to sanitized HTML
+func Render(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	source = strings.ReplaceAll(source, "\x00", "�")
+
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(source, "\n"), false, 0)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// renderBlocks renders a sequence of block-level elements. In tight list items
+// paragraphs are written without <p> tags.
+func renderBlocks(b *strings.Builder, lines []string, tight bool, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			i++
+
+		case fenceRe.MatchString(line):
+			i = renderFencedCode(b, lines, i)
+
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">")
+			renderInline(b, strings.TrimSpace(m[2]), false)
+			b.WriteString("</h" + level + ">\n")
+			i++
+
+		case ruleRe.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case quoteRe.MatchString(line) && depth < maxDepth:
+			var quoted []string
+			for ; i < len(lines) && quoteRe.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quoteRe.ReplaceAllString(lines[i], ""))
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, false, depth+1)
+			b.WriteString("</blockquote>\n")
+
+		case listItemRe.MatchString(line) && depth < maxDepth:
+			i = renderList(b, lines, i, depth)
+
+		case indentWidth(line) >= 4:
+			i = renderIndentedCode(b, lines, i)
+
+		default:
+			i = renderParagraph(b, lines, i, tight)
+		}
+	}
+}
+
+// renderFencedCode renders a ``` or ~~~ code block starting at lines[start] and
+// returns the index after it. An unclosed fence runs to the end of the input.
+func renderFencedCode(b *strings.Builder, lines []string, start int) int {
+	m := fenceRe.FindStringSubmatch(lines[start])
+	fence := m[1]
+	indent := indentWidth(lines[start])
+
+	b.WriteString("<pre><code")
+	if languageRe.MatchString(m[2]) {
+		b.WriteString(` class="language-` + escape(m[2]) + `"`)
+	}
+	b.WriteString(">")
+
+	i := start + 1
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if indentWidth(lines[i]) < 4 && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		b.WriteString(escape(trimIndent(lines[i], indent)))
+		b.WriteString("\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+// renderIndentedCode renders a block of lines indented by four or more spaces
+func renderIndentedCode(b *strings.Builder, lines []string, start int) int {
+	end := start
+	for i := start; i < len(lines) && (isBlank(lines[i]) || indentWidth(lines[i]) >= 4); i++ {
+		if !isBlank(lines[i]) {
+			end = i + 1
+		}
+	}
+
+	b.WriteString("<pre><code>")
+	for _, line := range lines[start:end] {
+		b.WriteString(escape(trimIndent(line, 4)))
+		b.WriteString("\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return end
+}
+
+// renderParagraph renders lines up to the next blank line or block start
+func renderParagraph(b *strings.Builder, lines []string, start int, tight bool) int {
+	i := start + 1
+	for ; i < len(lines); i++ {
+		if isBlank(lines[i]) || startsBlock(lines[i]) {
+			break
+		}
+	}
+
+	text := make([]string, 0, i-start)
+	for _, line := range lines[start:i] {
+		text = append(text, strings.TrimSpace(line))
+	}
+
+	if !tight {
+		b.WriteString("<p>")
+	}
+	renderInline(b, strings.Join(text, "\n"), false)
+	if !tight {
+		b.WriteString("</p>")
+	}
+	b.WriteString("\n")
+	return i
+}
+
+// startsBlock reports whether a line interrupts a paragraph
+func startsBlock(line string) bool {
+	if fenceRe.MatchString(line) || headingRe.MatchString(line) || ruleRe.MatchString(line) || quoteRe.MatchString(line) {
+		return true
+	}
+	// Only bullets and lists starting at 1 interrupt a paragraph, so a line that
+	// happens to begin with a year or a number isn't turned into a list
+	m := listItemRe.FindStringSubmatch(line)
+	return m != nil && m[4] != "" && (!isOrdered(m[2]) || (m[2][0] == '1' && len(m[2]) == 2))
+}
+
+// listItem is one item of a list being collected
+type listItem struct {
+	lines []string
+}
+
+// renderList renders the list starting at lines[start] and returns the index after it
+func renderList(b *strings.Builder, lines []string, start, depth int) int {
+	first := listItemRe.FindStringSubmatch(lines[start])
+	ordered := isOrdered(first[2])
+	delimiter := first[2][len(first[2])-1:]
+
+	var items []listItem
+	tight := true
+	contentIndent := 0
+	sawBlank := false
+
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := listItemRe.FindStringSubmatch(line); m != nil && !ruleRe.MatchString(line) && (i == start || len(m[1]) < contentIndent) {
+			// A new item of this list, or the start of a different list
+			if isOrdered(m[2]) != ordered || m[2][len(m[2])-1:] != delimiter {
+				break
+			}
+			if sawBlank && len(items) > 0 {
+				tight = false
+			}
+			sawBlank = false
+			contentIndent = len(m[1]) + len(m[2]) + len(m[3])
+			if m[3] == "" || len(m[3]) > 4 {
+				contentIndent = len(m[1]) + len(m[2]) + 1
+			}
+			items = append(items, listItem{lines: []string{m[4]}})
+			continue
+		}
+
+		item := &items[len(items)-1]
+		switch {
+		case isBlank(line):
+			sawBlank = true
+			item.lines = append(item.lines, "")
+		case indentWidth(line) >= contentIndent:
+			if sawBlank {
+				tight = false
+			}
+			sawBlank = false
+			item.lines = append(item.lines, trimIndent(line, contentIndent))
+		case !sawBlank && !startsBlock(line):
+			// Lazy continuation of the item's paragraph
+			item.lines = append(item.lines, strings.TrimSpace(line))
+		default:
+			return finishList(b, items, ordered, first[2], tight, depth, i)
+		}
+	}
+	return finishList(b, items, ordered, first[2], tight, depth, i)
+}
+
+// finishList writes a collected list and returns next
+func finishList(b *strings.Builder, items []listItem, ordered bool, marker string, tight bool, depth, next int) int {
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if ordered {
+		if n, err := strconv.Atoi(marker[:len(marker)-1]); err == nil && n != 1 {
+			b.WriteString(` start="` + strconv.Itoa(n) + `"`)
+		}
+	}
+	b.WriteString(">\n")
+
+	for _, item := range items {
+		b.WriteString("<li>")
+		var inner strings.Builder
+		renderBlocks(&inner, item.lines, tight, depth+1)
+		b.WriteString(strings.TrimSuffix(inner.String(), "\n"))
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return next
+}
+
+func isOrdered(marker string) bool {
+	return marker[0] >= '0' && marker[0] <= '9'
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indentWidth counts leading whitespace, with tabs advancing to the next multiple of four
+func indentWidth(line string) int {
+	width := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4 - width%4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// trimIndent removes up to n columns of leading whitespace
+func trimIndent(line string, n int) string {
+	width := 0
+	for i, r := range line {
+		if width >= n || (r != ' ' && r != '\t') {
+			return line[i:]
+		}
+		if r == '\t' {
+			width += 4 - width%4
+		} else {
+			width++
+		}
+	}
+	return ""
+}
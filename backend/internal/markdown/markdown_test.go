@@ -0,0 +1,138 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// Raw HTML is escaped, never passed through
+		{"script tag", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"event handler", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&quot;alert(1)&quot;&gt;</p>"},
+		{"unterminated tag", "<b onclick='x'", "<p>&lt;b onclick=&#39;x&#39;</p>"},
+		{"script in code span", "`<script>`", "<p><code>&lt;script&gt;</code></p>"},
+		{"script in fenced code", "```html\n<script>x</script>\n```", "<pre><code class=\"language-html\">&lt;script&gt;x&lt;/script&gt;\n</code></pre>"},
+		{"code block language", "```\" onmouseover=\"x\n```", "<pre><code></code></pre>"},
+
+		// Links keep to safe schemes and are always nofollow
+		{"link", "[site](https://example.com)", `<p><a href="https://example.com" rel="nofollow">site</a></p>`},
+		{"link title", `[site](https://example.com "Home")`, `<p><a href="https://example.com" title="Home" rel="nofollow">site</a></p>`},
+		{"relative link", "[post](/post?id=1)", `<p><a href="/post?id=1" rel="nofollow">post</a></p>`},
+		{"javascript link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+		{"javascript link mixed case", "[x](JaVaScRiPt:alert(1))", "<p>[x](JaVaScRiPt:alert(1))</p>"},
+		{"javascript autolink", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>[x](data:text/html;base64,PHNjcmlwdD4=)</p>"},
+		{"protocol-relative link", "[x](//evil.example)", "<p>[x](//evil.example)</p>"},
+		{"quote in URL", `[x](https://example.com/"onmouseover="alert(1))`, `<p><a href="https://example.com/%22onmouseover=%22alert%281%29" rel="nofollow">x</a></p>`},
+		{"quote in title", `[x](https://example.com "a"b")`, `<p><a href="https://example.com" title="a&quot;b" rel="nofollow">x</a></p>`},
+		{"image", "![alt](https://example.com/a.png)", `<p><a href="https://example.com/a.png" rel="nofollow">alt</a></p>`},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com" rel="nofollow">https://example.com</a></p>`},
+		{"email autolink", "<me@example.com>", `<p><a href="mailto:me@example.com" rel="nofollow">me@example.com</a></p>`},
+		{"bare URL", "see https://example.com/a_b.", `<p>see <a href="https://example.com/a_b" rel="nofollow">https://example.com/a_b</a>.</p>`},
+		{"bare URL in parentheses", "(https://example.com/x)", `<p>(<a href="https://example.com/x" rel="nofollow">https://example.com/x</a>)</p>`},
+		{"bare scheme only", "http://", "<p>http://</p>"},
+		{"link inside link text", "[a https://example.com](https://example.org)", `<p><a href="https://example.org" rel="nofollow">a https://example.com</a></p>`},
+
+		// Nested and unterminated markup
+		{"nested emphasis", "***both***", "<p><strong><em>both</em></strong></p>"},
+		{"emphasis in link", "[**bold**](https://example.com)", `<p><a href="https://example.com" rel="nofollow"><strong>bold</strong></a></p>`},
+		{"unterminated emphasis", "**open", "<p>**open</p>"},
+		{"unterminated code span", "`open", "<p>`open</p>"},
+		{"unterminated link", "[text](https://example.com", `<p>[text](<a href="https://example.com" rel="nofollow">https://example.com</a></p>`},
+		{"unterminated fence", "```\n<b>", "<pre><code>&lt;b&gt;\n</code></pre>"},
+		{"snake_case", "a_b_c", "<p>a_b_c</p>"},
+		{"nested quote", "> > <i>", "<blockquote>\n<blockquote>\n<p>&lt;i&gt;</p>\n</blockquote>\n</blockquote>"},
+		{"list", "- a\n- *b*", "<ul>\n<li>a</li>\n<li><em>b</em></li>\n</ul>"},
+		{"ordered list start", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>"},
+		{"heading", "## Title <x>", "<h2>Title &lt;x&gt;</h2>"},
+		{"escaped delimiter", `\*not em\*`, "<p>*not em*</p>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.in); got != tt.want {
+				t.Errorf("Render(%q)\n got  %q\n want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// Inputs that used to take quadratic time must render quickly
+func TestRenderLinearTime(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("http://", 20000),
+		strings.Repeat("http://a.b/)", 12000),
+		strings.Repeat("`a``", 35000),
+		strings.Repeat("*a", 70000),
+	}
+	for _, in := range inputs {
+		start := time.Now()
+		Render(in)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("rendering %d bytes of %q took %v", len(in), in[:8], elapsed)
+		}
+	}
+}
+
+var (
+	// tagRe matches every tag the renderer may produce, with the only attributes it may
+	// give them, so no on* event handler or other attribute can appear
+	tagRe = regexp.MustCompile(`^<(?:/?(?:p|br|em|strong|del|code|pre|h[1-6]|blockquote|ul|li|hr|a|ol)` +
+		`|a href="[^"<>]*"(?: title="[^"<>]*")? rel="nofollow"` +
+		`|code class="language-[A-Za-z0-9_+#.-]+"` +
+		`|ol start="\d+")>`)
+	hrefRe = regexp.MustCompile(`href="([^"]*)"`)
+)
+
+func FuzzRender(f *testing.F) {
+	for _, seed := range []string{
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"[x](javascript:alert(1))",
+		"[x](https://example.com \"t\")",
+		"![x](java\tscript:alert(1))",
+		"<jav&#x09;ascript:alert(1)>",
+		"**a _b `c` d_ e**",
+		"> - 1. ```js\n<x>",
+		"https://example.com/(a)?b=\"c\"",
+		"~~del~~ [*a*](/b)",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		out := Render(in)
+
+		// Every < in the output opens an allowed tag
+		for i := strings.IndexByte(out, '<'); i >= 0; {
+			tag := tagRe.FindString(out[i:])
+			if tag == "" {
+				t.Fatalf("Render(%q) produced a disallowed tag at %d:\n%s", in, i, out)
+			}
+			next := strings.IndexByte(out[i+len(tag):], '<')
+			if next < 0 {
+				break
+			}
+			i += len(tag) + next
+		}
+
+		lower := strings.ToLower(out)
+		if strings.Contains(lower, "<script") {
+			t.Fatalf("Render(%q) produced a script tag:\n%s", in, out)
+		}
+		for _, m := range hrefRe.FindAllStringSubmatch(out, -1) {
+			href := strings.ToLower(strings.TrimSpace(html.UnescapeString(m[1])))
+			if strings.HasPrefix(href, "javascript:") || strings.HasPrefix(href, "data:") || strings.HasPrefix(href, "vbscript:") {
+				t.Fatalf("Render(%q) produced an unsafe link %q", in, m[1])
+			}
+		}
+	})
+}
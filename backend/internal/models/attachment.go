@@ -0,0 +1,381 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// MaxAttachmentSize is the largest file a user may upload
+	MaxAttachmentSize = 10 << 20
+
+	// AttachmentQuota is how many bytes of attachments each user may store in total
+	AttachmentQuota = 100 << 20
+
+	// MaxAttachments is how many files one message, post or comment may carry
+	MaxAttachments = 10
+
+	// maxFilenameLength bounds stored file names
+	maxFilenameLength = 255
+)
+
+var (
+	ErrAttachmentNotFound  = errors.New("attachment not found")
+	ErrAttachmentTooLarge  = errors.New("attachments can be at most 10 MB")
+	ErrQuotaExceeded       = errors.New("attachment storage quota exceeded")
+	ErrTooManyAttachments  = errors.New("at most 10 files can be attached at once")
+	ErrAttachmentUnusable  = errors.New("attachments must be your own uploads that are not already attached")
+	ErrInvalidAttachmentID = errors.New("invalid attachment ID")
+)
+
+// Attachment is an uploaded file. It belongs to its uploader until it is attached to a
+// message, post or comment, after which everyone who can see that can download it.
+type Attachment struct {
+	ID            int64     `json:"id"`
+	MessageID     *int64    `json:"message_id,omitempty"`
+	PostID        *int64    `json:"post_id,omitempty"`
+	CommentID     *int64    `json:"comment_id,omitempty"`
+	UploaderID    int64     `json:"uploader_id"`
+	Filename      string    `json:"filename"`
+	ContentType   string    `json:"content_type"`
+	Size          int64     `json:"size"`
+	Width         int       `json:"width,omitempty"`
+	Height        int       `json:"height,omitempty"`
+	URL           string    `json:"url"`
+	ThumbnailURL  string    `json:"thumbnail_url,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	BlobHash      string    `json:"-"`
+	ThumbnailHash string    `json:"-"`
+}
+
+// unattachedUpload matches uploads that aren't attached to anything yet
+const unattachedUpload = `message_id IS NULL AND post_id IS NULL AND comment_id IS NULL`
+
+// attachmentColumns selects an attachment, for scanAttachment
+const attachmentColumns = `a.id, a.message_id, a.post_id, a.comment_id, a.uploader_id, a.filename, a.content_type, a.size,
+		       COALESCE(a.width, 0), COALESCE(a.height, 0), a.blob_hash, COALESCE(a.thumbnail_hash, ''), a.created_at`
+
+func scanAttachment(scanner interface{ Scan(...interface{}) error }) (*Attachment, error) {
+	var attachment Attachment
+	err := scanner.Scan(
+		&attachment.ID,
+		&attachment.MessageID,
+		&attachment.PostID,
+		&attachment.CommentID,
+		&attachment.UploaderID,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.Width,
+		&attachment.Height,
+		&attachment.BlobHash,
+		&attachment.ThumbnailHash,
+		&attachment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.URL = fmt.Sprintf("/api/attachments/%d", attachment.ID)
+	if attachment.ThumbnailHash != "" {
+		attachment.ThumbnailURL = attachment.URL + "/thumbnail"
+	}
+	return &attachment, nil
+}
+
+// SanitizeFilename keeps the base name of an uploaded file without control
+// characters or quotes, so it is safe to store and to echo in headers
+func SanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = string(runes[len(runes)-maxFilenameLength:])
+	}
+	return name
+}
+
+// GetAttachmentUsage returns how many bytes of attachments a user has uploaded
+func GetAttachmentUsage(db *sql.DB, userID int64) (int64, error) {
+	var used int64
+	err := db.QueryRow("SELECT COALESCE(SUM(size), 0) FROM attachments WHERE uploader_id = ?", userID).Scan(&used)
+	return used, err
+}
+
+// CreateAttachment records an uploaded file whose content is already stored. It fails
+// with ErrQuotaExceeded if the file would take its uploader over AttachmentQuota,
+// checking in the same statement as the insert so parallel uploads can't all fit.
+func CreateAttachment(db *sql.DB, attachment *Attachment) (*Attachment, error) {
+	var width, height, thumbnailHash interface{}
+	if attachment.Width > 0 && attachment.Height > 0 {
+		width, height = attachment.Width, attachment.Height
+	}
+	if attachment.ThumbnailHash != "" {
+		thumbnailHash = attachment.ThumbnailHash
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO attachments (uploader_id, blob_hash, filename, content_type, size, width, height, thumbnail_hash, created_at)
+		SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9
+		WHERE (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE uploader_id = ?1) + ?5 <= ?10`,
+		attachment.UploaderID, attachment.BlobHash, SanitizeFilename(attachment.Filename), attachment.ContentType,
+		attachment.Size, width, height, thumbnailHash, time.Now(), AttachmentQuota)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrQuotaExceeded
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetAttachment(db, id)
+}
+
+// GetAttachment retrieves an attachment by ID
+func GetAttachment(db *sql.DB, attachmentID int64) (*Attachment, error) {
+	attachment, err := scanAttachment(db.QueryRow(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		WHERE a.id = ?`, attachmentID))
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	return attachment, err
+}
+
+// CanViewAttachment reports whether a user may download an attachment. Its uploader
+// always can. Members of the conversation it was sent to can while its message is still
+// visible to them. Files on posts and comments follow the content they are attached to:
+// everyone can see them unless it is hidden, in which case only its author and
+// moderators can.
+func CanViewAttachment(db *sql.DB, attachment *Attachment, viewerID int64) (bool, error) {
+	if attachment.UploaderID == viewerID {
+		return true, nil
+	}
+
+	var allowed bool
+	var err error
+	switch {
+	case attachment.MessageID != nil:
+		err = db.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM messages m
+				JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = ?
+				WHERE m.id = ? AND `+visibleMessage+`
+			)`, viewerID, *attachment.MessageID, viewerID).Scan(&allowed)
+		return allowed, err
+	case attachment.PostID != nil:
+		err = db.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM posts
+				WHERE id = ? AND (is_hidden = FALSE OR user_id = ?)
+			)`, *attachment.PostID, viewerID).Scan(&allowed)
+	case attachment.CommentID != nil:
+		err = db.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM comments c
+				JOIN posts p ON p.id = c.post_id
+				WHERE c.id = ? AND ((c.is_hidden = FALSE AND (p.is_hidden = FALSE OR p.user_id = ?)) OR c.user_id = ?)
+			)`, *attachment.CommentID, viewerID, viewerID).Scan(&allowed)
+	default:
+		return false, nil
+	}
+	if err != nil || allowed {
+		return allowed, err
+	}
+
+	// Moderators can see hidden content, and so its files
+	return UserCan(db, viewerID, PermModerate)
+}
+
+// DeleteStaleUploads removes uploads created before cutoff that were never attached to
+// anything, and returns how many it removed. Their files are left for the blob sweep.
+func DeleteStaleUploads(db *sql.DB, cutoff time.Time) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM attachments
+		WHERE `+unattachedUpload+` AND created_at < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// BlobInUse reports whether any attachment stores its file or thumbnail in a blob
+func BlobInUse(db *sql.DB, hash string) (bool, error) {
+	var used bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM attachments WHERE blob_hash = ?)
+		    OR EXISTS(SELECT 1 FROM attachments WHERE thumbnail_hash = ?)`, hash, hash).Scan(&used)
+	return used, err
+}
+
+// checkAttachments verifies that an uploader may attach the given uploads to a new
+// message, post or comment
+func checkAttachments(tx *sql.Tx, uploaderID int64, attachmentIDs []int64) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+	if len(attachmentIDs) > MaxAttachments {
+		return ErrTooManyAttachments
+	}
+
+	seen := make(map[int64]bool, len(attachmentIDs))
+	args := []interface{}{uploaderID}
+	for _, id := range attachmentIDs {
+		if id <= 0 {
+			return ErrInvalidAttachmentID
+		}
+		if seen[id] {
+			return ErrAttachmentUnusable
+		}
+		seen[id] = true
+		args = append(args, id)
+	}
+
+	var usable int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM attachments
+		WHERE uploader_id = ? AND `+unattachedUpload+`
+		  AND id IN (?`+strings.Repeat(", ?", len(attachmentIDs)-1)+`)`, args...).Scan(&usable)
+	if err != nil {
+		return err
+	}
+	if usable != len(attachmentIDs) {
+		return ErrAttachmentUnusable
+	}
+	return nil
+}
+
+// linkAttachments attaches an uploader's unused uploads to the row with the given ID,
+// where column is message_id, post_id or comment_id. Callers run checkAttachments in
+// the same transaction first.
+func linkAttachments(tx *sql.Tx, column string, targetID, uploaderID int64, attachmentIDs []int64) error {
+	for _, id := range attachmentIDs {
+		_, err := tx.Exec(`
+			UPDATE attachments SET `+column+` = ?
+			WHERE id = ? AND uploader_id = ? AND `+unattachedUpload,
+			targetID, id, uploaderID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAttachments returns the attachments of the rows with the given IDs, keyed by
+// row ID, where column is message_id, post_id or comment_id
+func loadAttachments(db *sql.DB, column string, ids []int64) (map[int64][]Attachment, error) {
+	attachments := make(map[int64][]Attachment)
+	if len(ids) == 0 {
+		return attachments, nil
+	}
+
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a
+		WHERE a.`+column+` IN (?`+strings.Repeat(", ?", len(args)-1)+`)
+		ORDER BY a.`+column+`, a.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		ownerID := attachment.MessageID
+		switch column {
+		case "post_id":
+			ownerID = attachment.PostID
+		case "comment_id":
+			ownerID = attachment.CommentID
+		}
+		attachments[*ownerID] = append(attachments[*ownerID], *attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// attachMessageAttachments fills in the attachments of messages
+func attachMessageAttachments(db *sql.DB, messages []PrivateMessage) error {
+	ids := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	attachments, err := loadAttachments(db, "message_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].ID]
+	}
+	return nil
+}
+
+// attachPostAttachments fills in the attachments of posts
+func attachPostAttachments(db *sql.DB, posts []Post) error {
+	ids := make([]int64, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	attachments, err := loadAttachments(db, "post_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Attachments = attachments[posts[i].ID]
+	}
+	return nil
+}
+
+// attachCommentAttachments fills in the attachments of comments and their replies
+func attachCommentAttachments(db *sql.DB, comments []Comment) error {
+	byID := make(map[int64]*Comment)
+	var collect func([]Comment)
+	collect = func(list []Comment) {
+		for i := range list {
+			byID[list[i].ID] = &list[i]
+			collect(list[i].Replies)
+		}
+	}
+	collect(comments)
+
+	ids := make([]int64, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	attachments, err := loadAttachments(db, "comment_id", ids)
+	if err != nil {
+		return err
+	}
+	for id, list := range attachments {
+		byID[id].Attachments = list
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateAttachmentQuota(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "alice")
+	other := newTestUser(t, db, "bob")
+	hash := strings.Repeat("a", 64)
+
+	create := func(uploaderID, size int64) error {
+		_, err := CreateAttachment(db, &Attachment{
+			UploaderID: uploaderID, Filename: "file.bin", ContentType: "application/octet-stream", Size: size, BlobHash: hash,
+		})
+		return err
+	}
+
+	if err := create(user.ID, AttachmentQuota-10); err != nil {
+		t.Fatal(err)
+	}
+	if err := create(user.ID, 11); err != ErrQuotaExceeded {
+		t.Errorf("going over the quota: %v, want ErrQuotaExceeded", err)
+	}
+	if err := create(user.ID, 10); err != nil {
+		t.Errorf("filling the quota exactly: %v", err)
+	}
+	if used, err := GetAttachmentUsage(db, user.ID); err != nil || used != AttachmentQuota {
+		t.Errorf("usage %d, %v, want the full quota", used, err)
+	}
+	if err := create(other.ID, 10); err != nil {
+		t.Errorf("another user's upload: %v", err)
+	}
+}
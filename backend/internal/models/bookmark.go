@@ -0,0 +1,211 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBookmarkNotFound       = errors.New("post is not bookmarked")
+	ErrBookmarkFolderNotFound = errors.New("bookmark folder not found")
+	ErrBookmarkFolderExists   = errors.New("you already have a folder with this name")
+	ErrInvalidFolderName      = errors.New("folder name must be 1 to 100 characters")
+)
+
+// Bookmark is a post a user saved. FolderID is nil for bookmarks outside any folder.
+type Bookmark struct {
+	PostID    int64     `json:"post_id"`
+	FolderID  *int64    `json:"folder_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BookmarkFolder is a named group of a user's bookmarks
+type BookmarkFolder struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	BookmarkCount int       `json:"bookmark_count"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// BookmarkPost saves a post for a user, in folderID when it isn't nil. Bookmarking a
+// post again moves it to the given folder.
+func BookmarkPost(db *sql.DB, userID, postID int64, folderID *int64) (*Bookmark, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ? AND is_hidden = FALSE)", postID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrPostNotFound
+	}
+	if folderID != nil {
+		if _, err := GetBookmarkFolder(db, userID, *folderID); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO bookmarks (user_id, post_id, folder_id) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, post_id) DO UPDATE SET folder_id = excluded.folder_id`,
+		userID, postID, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	bookmark := &Bookmark{PostID: postID}
+	err = db.QueryRow("SELECT folder_id, created_at FROM bookmarks WHERE user_id = ? AND post_id = ?",
+		userID, postID).Scan(&bookmark.FolderID, &bookmark.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return bookmark, nil
+}
+
+// UnbookmarkPost removes a post from a user's bookmarks
+func UnbookmarkPost(db *sql.DB, userID, postID int64) error {
+	result, err := db.Exec("DELETE FROM bookmarks WHERE user_id = ? AND post_id = ?", userID, postID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrBookmarkNotFound
+	}
+	return nil
+}
+
+// HasUserBookmarkedPost checks if a user has bookmarked a post
+func HasUserBookmarkedPost(db *sql.DB, postID, userID int64) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM bookmarks WHERE post_id = ? AND user_id = ?)",
+		postID, userID).Scan(&exists)
+	return exists, err
+}
+
+// ListBookmarkFolders returns a user's bookmark folders by name
+func ListBookmarkFolders(db *sql.DB, userID int64) ([]BookmarkFolder, error) {
+	return queryBookmarkFolders(db, "f.user_id = ?", userID)
+}
+
+// GetBookmarkFolder returns one of a user's bookmark folders
+func GetBookmarkFolder(db *sql.DB, userID, folderID int64) (*BookmarkFolder, error) {
+	folders, err := queryBookmarkFolders(db, "f.user_id = ? AND f.id = ?", userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, ErrBookmarkFolderNotFound
+	}
+	return &folders[0], nil
+}
+
+func queryBookmarkFolders(db *sql.DB, where string, args ...interface{}) ([]BookmarkFolder, error) {
+	rows, err := db.Query(`
+		SELECT f.id, f.name, f.created_at,
+		       (SELECT COUNT(*) FROM bookmarks b WHERE b.folder_id = f.id)
+		FROM bookmark_folders f
+		WHERE `+where+`
+		ORDER BY f.name COLLATE NOCASE, f.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := []BookmarkFolder{}
+	for rows.Next() {
+		var folder BookmarkFolder
+		if err := rows.Scan(&folder.ID, &folder.Name, &folder.CreatedAt, &folder.BookmarkCount); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// CreateBookmarkFolder adds a bookmark folder for a user
+func CreateBookmarkFolder(db *sql.DB, userID int64, name string) (*BookmarkFolder, error) {
+	name, err := checkBookmarkFolderName(db, userID, 0, name)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec("INSERT INTO bookmark_folders (user_id, name) VALUES (?, ?)", userID, name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetBookmarkFolder(db, userID, id)
+}
+
+// RenameBookmarkFolder changes the name of one of a user's bookmark folders
+func RenameBookmarkFolder(db *sql.DB, userID, folderID int64, name string) (*BookmarkFolder, error) {
+	if _, err := GetBookmarkFolder(db, userID, folderID); err != nil {
+		return nil, err
+	}
+	name, err := checkBookmarkFolderName(db, userID, folderID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec("UPDATE bookmark_folders SET name = ? WHERE id = ?", name, folderID); err != nil {
+		return nil, err
+	}
+	return GetBookmarkFolder(db, userID, folderID)
+}
+
+// checkBookmarkFolderName trims a folder name and checks that it is valid and not used
+// by another of the user's folders
+func checkBookmarkFolderName(db *sql.DB, userID, folderID int64, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return "", ErrInvalidFolderName
+	}
+
+	var taken bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM bookmark_folders WHERE user_id = ? AND name = ? AND id != ?)",
+		userID, name, folderID).Scan(&taken)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrBookmarkFolderExists
+	}
+	return name, nil
+}
+
+// DeleteBookmarkFolder removes one of a user's bookmark folders. The bookmarks in it
+// are kept outside any folder.
+func DeleteBookmarkFolder(db *sql.DB, userID, folderID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM bookmark_folders WHERE id = ? AND user_id = ?", folderID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrBookmarkFolderNotFound
+	}
+
+	// Foreign keys aren't enforced on the connection, so detach the bookmarks by hand
+	if _, err := tx.Exec("UPDATE bookmarks SET folder_id = NULL WHERE folder_id = ?", folderID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// BrokerRecord is a WebSocket event stored for other server instances to pick up
+type BrokerRecord struct {
+	ID      int64
+	Origin  string
+	Payload []byte
+}
+
+// InstancePresence records that a user has connections on a server instance, the
+// status they chose and whether all those connections are idle
+type InstancePresence struct {
+	InstanceID string
+	UserID     int64
+	Username   string
+	Status     string
+	Idle       bool
+}
+
+// PublishBrokerEvent stores an encoded event published by the origin instance
+func PublishBrokerEvent(db *sql.DB, origin string, payload []byte) error {
+	_, err := db.Exec("INSERT INTO broker_events (origin, payload, created_at) VALUES (?, ?, ?)",
+		origin, string(payload), time.Now())
+	return err
+}
+
+// GetBrokerEvents returns the events after afterID that other instances published, oldest first
+func GetBrokerEvents(db *sql.DB, instanceID string, afterID int64) ([]BrokerRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, origin, payload FROM broker_events
+		WHERE id > ? AND origin != ?
+		ORDER BY id`, afterID, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []BrokerRecord
+	for rows.Next() {
+		var record BrokerRecord
+		var payload string
+		if err := rows.Scan(&record.ID, &record.Origin, &payload); err != nil {
+			return nil, err
+		}
+		record.Payload = []byte(payload)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// GetLatestBrokerEventID returns the ID of the newest stored event, or 0 when there is none
+func GetLatestBrokerEventID(db *sql.DB) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM broker_events").Scan(&id)
+	return id, err
+}
+
+// PruneBrokerEvents deletes events stored before the cutoff
+func PruneBrokerEvents(db *sql.DB, before time.Time) error {
+	_, err := db.Exec("DELETE FROM broker_events WHERE created_at < ?", before)
+	return err
+}
+
+// TouchBrokerInstance records a heartbeat from a server instance
+func TouchBrokerInstance(db *sql.DB, instanceID string) error {
+	_, err := db.Exec(`
+		INSERT INTO broker_instances (instance_id, seen_at) VALUES (?, ?)
+		ON CONFLICT (instance_id) DO UPDATE SET seen_at = excluded.seen_at`,
+		instanceID, time.Now())
+	return err
+}
+
+// GetLiveBrokerInstances lists the instances that sent a heartbeat since the cutoff
+func GetLiveBrokerInstances(db *sql.DB, since time.Time) ([]string, error) {
+	rows, err := db.Query("SELECT instance_id FROM broker_instances WHERE seen_at >= ? ORDER BY instance_id", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instances []string
+	for rows.Next() {
+		var instanceID string
+		if err := rows.Scan(&instanceID); err != nil {
+			return nil, err
+		}
+		instances = append(instances, instanceID)
+	}
+	return instances, rows.Err()
+}
+
+// RemoveBrokerInstance deletes an instance and the presence it recorded
+func RemoveBrokerInstance(db *sql.DB, instanceID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM broker_presence WHERE instance_id = ?", instanceID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM broker_instances WHERE instance_id = ?", instanceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveStaleBrokerInstances deletes the instances that sent no heartbeat since the
+// cutoff, along with the presence they recorded
+func RemoveStaleBrokerInstances(db *sql.DB, since time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM broker_presence WHERE instance_id NOT IN (
+			SELECT instance_id FROM broker_instances WHERE seen_at >= ?
+		)`, since)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM broker_instances WHERE seen_at < ?", since); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetInstancePresence records a user's presence on an instance
+func SetInstancePresence(db *sql.DB, presence InstancePresence) error {
+	_, err := db.Exec(`
+		INSERT INTO broker_presence (instance_id, user_id, username, status, idle) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (instance_id, user_id) DO UPDATE SET
+			username = excluded.username, status = excluded.status, idle = excluded.idle`,
+		presence.InstanceID, presence.UserID, presence.Username, presence.Status, presence.Idle)
+	return err
+}
+
+// ClearInstancePresence records that a user has no connections left on an instance
+func ClearInstancePresence(db *sql.DB, instanceID string, userID int64) error {
+	_, err := db.Exec("DELETE FROM broker_presence WHERE instance_id = ? AND user_id = ?", instanceID, userID)
+	return err
+}
+
+// GetInstancePresence lists the users connected to instances that sent a heartbeat
+// since the cutoff
+func GetInstancePresence(db *sql.DB, since time.Time) ([]InstancePresence, error) {
+	rows, err := db.Query(`
+		SELECT p.instance_id, p.user_id, p.username, p.status, p.idle
+		FROM broker_presence p
+		JOIN broker_instances i ON i.instance_id = p.instance_id
+		WHERE i.seen_at >= ?
+		ORDER BY p.instance_id, p.user_id`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var presence []InstancePresence
+	for rows.Next() {
+		var entry InstancePresence
+		if err := rows.Scan(&entry.InstanceID, &entry.UserID, &entry.Username, &entry.Status, &entry.Idle); err != nil {
+			return nil, err
+		}
+		presence = append(presence, entry)
+	}
+	return presence, rows.Err()
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Category struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Slug        string     `json:"slug"`
+	Description string     `json:"description"`
+	SortOrder   int        `json:"sort_order"`
+	Color       string     `json:"color"`
+	Icon        string     `json:"icon"`
+	Archived    bool       `json:"archived"`
+	PostRole    string     `json:"post_role"` // minimum role allowed to post in the category
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+type CategoryRequest struct {
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	SortOrder   int    `json:"sort_order"`
+	Color       string `json:"color"`
+	Icon        string `json:"icon"`
+	Archived    bool   `json:"archived"`
+	PostRole    string `json:"post_role"`
+}
+
+// categoryColumns is the column list every category query selects, in scanCategory order
+const categoryColumns = `c.id, c.name, c.slug, COALESCE(c.description, ''), c.sort_order, c.color, c.icon,
+		       c.archived, c.post_role, c.created_at, c.updated_at`
+
+var (
+	ErrCategoryNotFound   = errors.New("category not found")
+	ErrCategoryExists     = errors.New("a category with this name or slug already exists")
+	ErrEmptyCategoryName  = errors.New("category name cannot be empty")
+	ErrInvalidSlug        = errors.New("slug may only contain lowercase letters, digits and dashes")
+	ErrInvalidColor       = errors.New("color must be a hex value like #1a2b3c")
+	ErrCategoryArchived   = errors.New("one or more categories are archived")
+	ErrCategoryRestricted = errors.New("you are not allowed to post in one or more of these categories")
+	ErrCategoryInUse      = errors.New("category still has posts; archive it instead")
+)
+
+var (
+	slugPattern  = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	slugStrip    = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// scanCategory reads one row selected with categoryColumns
+func scanCategory(scanner interface{ Scan(...interface{}) error }) (Category, error) {
+	var cat Category
+	err := scanner.Scan(
+		&cat.ID,
+		&cat.Name,
+		&cat.Slug,
+		&cat.Description,
+		&cat.SortOrder,
+		&cat.Color,
+		&cat.Icon,
+		&cat.Archived,
+		&cat.PostRole,
+		&cat.CreatedAt,
+		&cat.UpdatedAt,
+	)
+	return cat, err
+}
+
+// ListCategories returns all categories that are open for browsing, in display order
+func ListCategories(db *sql.DB) ([]Category, error) {
+	return queryCategories(db, `
+		SELECT `+categoryColumns+`
+		FROM categories c
+		WHERE c.archived = FALSE
+		ORDER BY c.sort_order, c.name`)
+}
+
+// ListAllCategories returns every category, including archived ones, for administration
+func ListAllCategories(db *sql.DB) ([]Category, error) {
+	return queryCategories(db, `
+		SELECT `+categoryColumns+`
+		FROM categories c
+		ORDER BY c.archived, c.sort_order, c.name`)
+}
+
+func queryCategories(db *sql.DB, query string, args ...interface{}) ([]Category, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make([]Category, 0)
+	for rows.Next() {
+		cat, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, cat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// GetCategoryByID retrieves a single category
+func GetCategoryByID(db *sql.DB, id int64) (*Category, error) {
+	cat, err := scanCategory(db.QueryRow(`
+		SELECT `+categoryColumns+`
+		FROM categories c
+		WHERE c.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cat, nil
+}
+
+// CreateCategory adds a new category
+func CreateCategory(db *sql.DB, req CategoryRequest) (*Category, error) {
+	if err := normalizeCategoryRequest(&req); err != nil {
+		return nil, err
+	}
+
+	if err := checkCategoryUnique(db, 0, req); err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO categories (name, slug, description, sort_order, color, icon, archived, post_role)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, req.Slug, req.Description, req.SortOrder, req.Color, req.Icon, req.Archived, req.PostRole)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return GetCategoryByID(db, id)
+}
+
+// UpdateCategory replaces all editable fields of a category
+func UpdateCategory(db *sql.DB, id int64, req CategoryRequest) (*Category, error) {
+	if err := normalizeCategoryRequest(&req); err != nil {
+		return nil, err
+	}
+
+	if err := checkCategoryUnique(db, id, req); err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(`
+		UPDATE categories
+		SET name = ?, slug = ?, description = ?, sort_order = ?, color = ?, icon = ?,
+		    archived = ?, post_role = ?, updated_at = ?
+		WHERE id = ?`,
+		req.Name, req.Slug, req.Description, req.SortOrder, req.Color, req.Icon,
+		req.Archived, req.PostRole, time.Now(), id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrCategoryNotFound
+	}
+
+	return GetCategoryByID(db, id)
+}
+
+// DeleteCategory removes a category that no post uses
+func DeleteCategory(db *sql.DB, id int64) error {
+	var inUse bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM post_categories WHERE category_id = ?)", id).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrCategoryInUse
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM categories WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrCategoryNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM follows WHERE target_type = 'category' AND target_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// validatePostCategories checks that every category exists, is open and accepts posts from role.
+// Categories listed in allowed (e.g. the ones a post already has) skip the archive and role checks.
+func validatePostCategories(db *sql.DB, categoryIDs []int64, role string, allowed map[int64]bool) error {
+	for _, categoryID := range categoryIDs {
+		var archived bool
+		var postRole string
+		err := db.QueryRow("SELECT archived, post_role FROM categories WHERE id = ?", categoryID).
+			Scan(&archived, &postRole)
+		if err == sql.ErrNoRows {
+			return ErrInvalidCategory
+		}
+		if err != nil {
+			return err
+		}
+
+		if allowed[categoryID] {
+			continue
+		}
+		if archived {
+			return ErrCategoryArchived
+		}
+		if !RoleAtLeast(role, postRole) {
+			return ErrCategoryRestricted
+		}
+	}
+	return nil
+}
+
+// normalizeCategoryRequest trims input, fills defaults and validates the result
+func normalizeCategoryRequest(req *CategoryRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Slug = strings.TrimSpace(req.Slug)
+	req.Description = strings.TrimSpace(req.Description)
+	req.Color = strings.TrimSpace(req.Color)
+	req.Icon = strings.TrimSpace(req.Icon)
+
+	if req.Name == "" {
+		return ErrEmptyCategoryName
+	}
+	if req.Slug == "" {
+		req.Slug = Slugify(req.Name)
+	}
+	if !slugPattern.MatchString(req.Slug) {
+		return ErrInvalidSlug
+	}
+	if req.Color != "" && !colorPattern.MatchString(req.Color) {
+		return ErrInvalidColor
+	}
+	if req.PostRole == "" {
+		req.PostRole = RoleUser
+	}
+	if !IsValidRole(req.PostRole) {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+// checkCategoryUnique makes sure no other category uses the same name or slug
+func checkCategoryUnique(db *sql.DB, id int64, req CategoryRequest) error {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM categories
+			WHERE (name = ? OR slug = ?) AND id != ?
+		)`, req.Name, req.Slug, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrCategoryExists
+	}
+	return nil
+}
+
+// Slugify turns a display name into a URL-friendly slug
+func Slugify(name string) string {
+	return strings.Trim(slugStrip.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
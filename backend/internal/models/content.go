@@ -0,0 +1,77 @@
+package models
+
+import (
+	"database/sql"
+
+	"real-time-forum/backend/internal/markdown"
+)
+
+// renderedTables are the tables whose content is Markdown with a cached content_html column
+var renderedTables = []string{"posts", "comments", "messages"}
+
+// renderedContent returns the cached HTML of a post, comment or message, rendering the
+// Markdown source if nothing has been cached yet
+func renderedContent(source string, cached sql.NullString) string {
+	if cached.Valid {
+		return cached.String
+	}
+	return markdown.Render(source)
+}
+
+// RenderStoredContent fills in the cached HTML of posts, comments and messages written
+// before it was stored. With all set it re-renders every row, which is needed after the
+// renderer changes. It returns how many rows were rendered.
+func RenderStoredContent(db *sql.DB, all bool) (int, error) {
+	rendered := 0
+	for _, table := range renderedTables {
+		query := "SELECT id, content FROM " + table
+		if !all {
+			query += " WHERE content_html IS NULL"
+		}
+
+		rows, err := db.Query(query)
+		if err != nil {
+			return rendered, err
+		}
+		html := make(map[int64]string)
+		for rows.Next() {
+			var id int64
+			var content string
+			if err := rows.Scan(&id, &content); err != nil {
+				rows.Close()
+				return rendered, err
+			}
+			html[id] = markdown.Render(content)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return rendered, err
+		}
+		if len(html) == 0 {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return rendered, err
+		}
+		stmt, err := tx.Prepare("UPDATE " + table + " SET content_html = ? WHERE id = ?")
+		if err != nil {
+			tx.Rollback()
+			return rendered, err
+		}
+		for id, content := range html {
+			if _, err := stmt.Exec(content, id); err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return rendered, err
+			}
+		}
+		stmt.Close()
+		if err := tx.Commit(); err != nil {
+			return rendered, err
+		}
+		rendered += len(html)
+	}
+	return rendered, nil
+}
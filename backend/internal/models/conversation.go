@@ -0,0 +1,564 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Conversation types
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+)
+
+// Conversation member roles
+const (
+	MemberRoleOwner  = "owner"
+	MemberRoleAdmin  = "admin"
+	MemberRoleMember = "member"
+)
+
+// MaxGroupMembers caps how many people can be in one group conversation
+const MaxGroupMembers = 100
+
+var (
+	ErrConversationNotFound    = errors.New("conversation not found")
+	ErrNotConversationMember   = errors.New("you are not a member of this conversation")
+	ErrNotGroupConversation    = errors.New("this action only applies to group conversations")
+	ErrInvalidConversationName = errors.New("room name must be between 1 and 100 characters")
+	ErrInvalidMemberRole       = errors.New("role must be 'admin' or 'member'")
+	ErrGroupTooLarge           = errors.New("group conversations are limited to 100 members")
+	ErrCannotRemoveOwner       = errors.New("the owner cannot be removed; they must leave instead")
+)
+
+// ConversationDetails describes a conversation and its members
+type ConversationDetails struct {
+	ID        int64                `json:"id"`
+	Type      string               `json:"type"`
+	Name      string               `json:"name"`
+	CreatedBy *int64               `json:"created_by,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	Members   []ConversationMember `json:"members"`
+}
+
+// ConversationMember is a user's membership in a conversation
+type ConversationMember struct {
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// directKey identifies the single direct conversation between two users
+func directKey(userA, userB int64) string {
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	return fmt.Sprintf("%d:%d", userA, userB)
+}
+
+// getOrCreateDirectConversation returns the one-to-one conversation between two users,
+// creating it on first contact
+func getOrCreateDirectConversation(db *sql.DB, senderID, receiverID int64) (int64, error) {
+	key := directKey(senderID, receiverID)
+
+	var id int64
+	err := db.QueryRow("SELECT id FROM conversations WHERE direct_key = ?", key).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	// INSERT OR IGNORE keeps concurrent first messages from creating two conversations
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO conversations (type, direct_key, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		ConversationDirect, key, senderID, now, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRow("SELECT id FROM conversations WHERE direct_key = ?", key).Scan(&id); err != nil {
+		return 0, err
+	}
+
+	for _, userID := range []int64{senderID, receiverID} {
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO conversation_members (conversation_id, user_id, role, joined_at)
+			VALUES (?, ?, ?, ?)`,
+			id, userID, MemberRoleMember, now)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// CreateGroupConversation starts a named room owned by ownerID
+func CreateGroupConversation(db *sql.DB, ownerID int64, name string, memberIDs []int64) (*ConversationDetails, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, ErrInvalidConversationName
+	}
+
+	memberIDs = uniqueIDs(memberIDs, ownerID)
+	if len(memberIDs)+1 > MaxGroupMembers {
+		return nil, ErrGroupTooLarge
+	}
+	if err := checkUsersExist(db, memberIDs); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO conversations (type, name, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		ConversationGroup, name, ownerID, now, now)
+	if err != nil {
+		return nil, err
+	}
+	conversationID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id, role, joined_at)
+		VALUES (?, ?, ?, ?)`,
+		conversationID, ownerID, MemberRoleOwner, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range memberIDs {
+		_, err = tx.Exec(`
+			INSERT INTO conversation_members (conversation_id, user_id, role, joined_at)
+			VALUES (?, ?, ?, ?)`,
+			conversationID, userID, MemberRoleMember, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetConversation(db, conversationID, ownerID)
+}
+
+// GetConversation returns a conversation and its members, if viewerID belongs to it
+func GetConversation(db *sql.DB, conversationID, viewerID int64) (*ConversationDetails, error) {
+	if _, err := GetMemberRole(db, conversationID, viewerID); err != nil {
+		return nil, err
+	}
+
+	var c ConversationDetails
+	err := db.QueryRow(`
+		SELECT id, type, name, created_by, created_at, updated_at
+		FROM conversations WHERE id = ?`, conversationID).Scan(
+		&c.ID, &c.Type, &c.Name, &c.CreatedBy, &c.CreatedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT u.id, u.username, u.first_name, u.last_name, cm.role, cm.joined_at
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id = ?
+		ORDER BY cm.joined_at ASC, u.username ASC`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	c.Members = make([]ConversationMember, 0)
+	for rows.Next() {
+		var m ConversationMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.FirstName, &m.LastName, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		c.Members = append(c.Members, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// GetMemberRole returns userID's role in a conversation
+func GetMemberRole(db *sql.DB, conversationID, userID int64) (string, error) {
+	var role string
+	err := db.QueryRow(`
+		SELECT role FROM conversation_members
+		WHERE conversation_id = ? AND user_id = ?`,
+		conversationID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		// Don't reveal whether a conversation exists to non-members
+		return "", ErrNotConversationMember
+	}
+	return role, err
+}
+
+// GetConversationMemberIDs returns the user IDs of everyone in a conversation
+func GetConversationMemberIDs(db *sql.DB, conversationID int64) ([]int64, error) {
+	rows, err := db.Query("SELECT user_id FROM conversation_members WHERE conversation_id = ?", conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// RenameConversation changes a group's name. Owners and admins only.
+func RenameConversation(db *sql.DB, conversationID, actorID int64, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return ErrInvalidConversationName
+	}
+	if err := requireGroupManager(db, conversationID, actorID); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("UPDATE conversations SET name = ?, updated_at = ? WHERE id = ?", name, time.Now(), conversationID)
+	return err
+}
+
+// AddConversationMembers invites users into a group. Owners and admins only.
+// It returns the IDs that were actually added.
+func AddConversationMembers(db *sql.DB, conversationID, actorID int64, userIDs []int64) ([]int64, error) {
+	if err := requireGroupManager(db, conversationID, actorID); err != nil {
+		return nil, err
+	}
+
+	userIDs = uniqueIDs(userIDs, 0)
+	if err := checkUsersExist(db, userIDs); err != nil {
+		return nil, err
+	}
+
+	existing, err := GetConversationMemberIDs(db, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	isMember := make(map[int64]bool, len(existing))
+	for _, id := range existing {
+		isMember[id] = true
+	}
+
+	var added []int64
+	for _, id := range userIDs {
+		if !isMember[id] {
+			added = append(added, id)
+		}
+	}
+	if len(existing)+len(added) > MaxGroupMembers {
+		return nil, ErrGroupTooLarge
+	}
+
+	now := time.Now()
+	for _, id := range added {
+		_, err := db.Exec(`
+			INSERT OR IGNORE INTO conversation_members (conversation_id, user_id, role, joined_at)
+			VALUES (?, ?, ?, ?)`,
+			conversationID, id, MemberRoleMember, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return added, nil
+}
+
+// RemoveConversationMember kicks userID out of a group. The owner can remove anyone;
+// admins can only remove plain members.
+func RemoveConversationMember(db *sql.DB, conversationID, actorID, userID int64) error {
+	if actorID == userID {
+		return LeaveConversation(db, conversationID, userID)
+	}
+	if err := requireGroupManager(db, conversationID, actorID); err != nil {
+		return err
+	}
+
+	actorRole, err := GetMemberRole(db, conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	targetRole, err := GetMemberRole(db, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if targetRole == MemberRoleOwner {
+		return ErrCannotRemoveOwner
+	}
+	if targetRole == MemberRoleAdmin && actorRole != MemberRoleOwner {
+		return ErrForbidden
+	}
+
+	_, err = db.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationID, userID)
+	return err
+}
+
+// LeaveConversation removes userID from a group. A departing owner hands the room to
+// the longest-standing admin, or failing that the longest-standing member; the last
+// member out deletes the room.
+func LeaveConversation(db *sql.DB, conversationID, userID int64) error {
+	role, err := GetMemberRole(db, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if err := requireGroup(db, conversationID); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	if role == MemberRoleOwner {
+		var heirID int64
+		err := tx.QueryRow(`
+			SELECT user_id FROM conversation_members
+			WHERE conversation_id = ?
+			ORDER BY CASE role WHEN 'admin' THEN 0 ELSE 1 END, joined_at ASC
+			LIMIT 1`, conversationID).Scan(&heirID)
+		switch {
+		case err == sql.ErrNoRows:
+			// Nobody left: remove the room and its history
+			for _, statement := range []string{
+				"DELETE FROM message_deletions WHERE message_id IN (SELECT id FROM messages WHERE conversation_id = ?)",
+				"DELETE FROM message_receipts WHERE message_id IN (SELECT id FROM messages WHERE conversation_id = ?)",
+				"DELETE FROM attachments WHERE message_id IN (SELECT id FROM messages WHERE conversation_id = ?)",
+				"DELETE FROM messages WHERE conversation_id = ?",
+				"DELETE FROM conversations WHERE id = ?",
+			} {
+				if _, err := tx.Exec(statement, conversationID); err != nil {
+					return err
+				}
+			}
+		case err != nil:
+			return err
+		default:
+			_, err = tx.Exec(`
+				UPDATE conversation_members SET role = ?
+				WHERE conversation_id = ? AND user_id = ?`,
+				MemberRoleOwner, conversationID, heirID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetConversationMemberRole promotes a member to admin or demotes an admin. Owner only.
+func SetConversationMemberRole(db *sql.DB, conversationID, actorID, userID int64, role string) error {
+	if role != MemberRoleAdmin && role != MemberRoleMember {
+		return ErrInvalidMemberRole
+	}
+	if err := requireGroup(db, conversationID); err != nil {
+		return err
+	}
+
+	actorRole, err := GetMemberRole(db, conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if actorRole != MemberRoleOwner {
+		return ErrForbidden
+	}
+
+	targetRole, err := GetMemberRole(db, conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if targetRole == MemberRoleOwner {
+		return ErrCannotRemoveOwner
+	}
+
+	_, err = db.Exec(`
+		UPDATE conversation_members SET role = ?
+		WHERE conversation_id = ? AND user_id = ?`,
+		role, conversationID, userID)
+	return err
+}
+
+// CreateConversationMessage posts a message into any conversation the sender belongs to.
+// clientMsgID works as in CreatePrivateMessage.
+func CreateConversationMessage(db *sql.DB, conversationID, senderID int64, content, clientMsgID string) (*PrivateMessage, bool, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, false, ErrEmptyMessage
+	}
+	if _, err := GetMemberRole(db, conversationID, senderID); err != nil {
+		return nil, false, err
+	}
+	if err := CheckCanPost(db, senderID); err != nil {
+		return nil, false, err
+	}
+
+	var conversationType string
+	if err := db.QueryRow("SELECT type FROM conversations WHERE id = ?", conversationID).Scan(&conversationType); err != nil {
+		return nil, false, err
+	}
+
+	// Direct messages keep their receiver so read flags and the DM endpoints still work
+	var receiverID interface{}
+	if conversationType == ConversationDirect {
+		var otherID int64
+		err := db.QueryRow(`
+			SELECT user_id FROM conversation_members
+			WHERE conversation_id = ? AND user_id != ?`,
+			conversationID, senderID).Scan(&otherID)
+		if err != nil {
+			return nil, false, err
+		}
+		receiverID = otherID
+	}
+
+	return insertMessage(db, conversationID, senderID, receiverID, content, clientMsgID, nil)
+}
+
+// GetConversationMessages returns a page of a conversation's messages, oldest first
+func GetConversationMessages(db *sql.DB, conversationID, viewerID int64, limit, offset int) ([]PrivateMessage, error) {
+	if _, err := GetMemberRole(db, conversationID, viewerID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		WHERE m.conversation_id = ? AND `+visibleMessage+`
+		ORDER BY m.created_at DESC
+		LIMIT ? OFFSET ?`,
+		conversationID, viewerID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]PrivateMessage, 0)
+	for rows.Next() {
+		message, err := scanPrivateMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reverse to chronological order (oldest first)
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	if err := attachMessageAttachments(db, messages); err != nil {
+		return nil, err
+	}
+	return messages, attachReceipts(db, viewerID, messages)
+}
+
+// requireGroup fails unless the conversation is a group
+func requireGroup(db *sql.DB, conversationID int64) error {
+	var conversationType string
+	err := db.QueryRow("SELECT type FROM conversations WHERE id = ?", conversationID).Scan(&conversationType)
+	if err == sql.ErrNoRows {
+		return ErrConversationNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if conversationType != ConversationGroup {
+		return ErrNotGroupConversation
+	}
+	return nil
+}
+
+// requireGroupManager fails unless actorID is an owner or admin of a group
+func requireGroupManager(db *sql.DB, conversationID, actorID int64) error {
+	role, err := GetMemberRole(db, conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if err := requireGroup(db, conversationID); err != nil {
+		return err
+	}
+	if role != MemberRoleOwner && role != MemberRoleAdmin {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// uniqueIDs drops duplicates, non-positive IDs and exclude
+func uniqueIDs(ids []int64, exclude int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id <= 0 || id == exclude || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
+
+// checkUsersExist returns ErrUserNotFound if any of the IDs has no account
+func checkUsersExist(db *sql.DB, ids []int64) error {
+	for _, id := range ids {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", id).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUserNotFound
+		}
+	}
+	return nil
+}
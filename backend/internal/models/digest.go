@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// How often a user receives an email digest of unread activity
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// digestPeriods is how long each frequency waits between digests
+var digestPeriods = map[string]time.Duration{
+	DigestDaily:  24 * time.Hour,
+	DigestWeekly: 7 * 24 * time.Hour,
+}
+
+var ErrInvalidDigestFrequency = errors.New("digest frequency must be off, daily or weekly")
+
+// DigestRecipient is a user whose digest is due. Since is when the activity the digest
+// covers starts: their last visit or last digest, whichever is later.
+type DigestRecipient struct {
+	UserID       int64
+	Username     string
+	FirstName    string
+	Email        string
+	Frequency    string
+	Since        time.Time
+	LastDigestAt *time.Time
+}
+
+// DigestActivity is the unread activity of a user since a point in time. The slices
+// hold the most recent items, the counts cover all of them.
+type DigestActivity struct {
+	Messages          []UnreadMessage
+	MessageCount      int
+	Notifications     []Notification
+	NotificationCount int
+}
+
+// UnreadMessage is a message listed in a digest. ConversationName is empty for direct
+// messages.
+type UnreadMessage struct {
+	ID               int64
+	ConversationName string
+	Content          string
+	CreatedAt        time.Time
+	Sender           User
+}
+
+// Empty reports whether there is nothing to tell the user about
+func (a *DigestActivity) Empty() bool {
+	return a.MessageCount == 0 && a.NotificationCount == 0
+}
+
+// GetDigestFrequency returns how often a user receives digests
+func GetDigestFrequency(db *sql.DB, userID int64) (string, error) {
+	var frequency string
+	err := db.QueryRow("SELECT digest_frequency FROM users WHERE id = ?", userID).Scan(&frequency)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	return frequency, err
+}
+
+// SetDigestFrequency changes how often a user receives digests
+func SetDigestFrequency(db *sql.DB, userID int64, frequency string) error {
+	if frequency != DigestOff && digestPeriods[frequency] == 0 {
+		return ErrInvalidDigestFrequency
+	}
+
+	result, err := db.Exec("UPDATE users SET digest_frequency = ? WHERE id = ?", frequency, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DueDigests returns the users whose digest period has passed since their last one.
+// Banned users get no digests.
+func DueDigests(db *sql.DB, now time.Time) ([]DigestRecipient, error) {
+	rows, err := db.Query(`
+		SELECT id, username, first_name, email, digest_frequency, last_seen_at, last_digest_at, created_at
+		FROM users u
+		WHERE email != ''
+		  AND ((digest_frequency = ? AND (last_digest_at IS NULL OR last_digest_at <= ?))
+		    OR (digest_frequency = ? AND (last_digest_at IS NULL OR last_digest_at <= ?)))
+		  AND NOT EXISTS (
+			SELECT 1 FROM user_sanctions s
+			WHERE s.user_id = u.id AND s.type = ? AND s.revoked_at IS NULL
+			  AND (s.expires_at IS NULL OR s.expires_at > ?)
+		  )`,
+		DigestDaily, now.Add(-digestPeriods[DigestDaily]),
+		DigestWeekly, now.Add(-digestPeriods[DigestWeekly]),
+		SanctionBan, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []DigestRecipient
+	for rows.Next() {
+		var r DigestRecipient
+		var lastSeenAt *time.Time
+		var createdAt time.Time
+		err := rows.Scan(&r.UserID, &r.Username, &r.FirstName, &r.Email, &r.Frequency,
+			&lastSeenAt, &r.LastDigestAt, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Since = createdAt
+		for _, t := range []*time.Time{lastSeenAt, r.LastDigestAt} {
+			if t != nil && t.After(r.Since) {
+				r.Since = *t
+			}
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// ClaimDigest records that a user's digest is being sent at the given time. It fails
+// with false when another server instance claimed it first.
+func ClaimDigest(db *sql.DB, recipient DigestRecipient, at time.Time) (bool, error) {
+	result, err := db.Exec("UPDATE users SET last_digest_at = ? WHERE id = ? AND last_digest_at IS ?",
+		at, recipient.UserID, recipient.LastDigestAt)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// GetDigestActivity collects the unread messages and notifications a user received
+// after since, listing up to limit of each
+func GetDigestActivity(db *sql.DB, userID int64, since time.Time, limit int) (*DigestActivity, error) {
+	var activity DigestActivity
+
+	// Unread messages, in every conversation the user belongs to
+	const unreadSince = `
+		FROM conversation_members cm
+		JOIN conversations c ON c.id = cm.conversation_id
+		JOIN messages m ON m.conversation_id = c.id
+		JOIN users u ON u.id = m.sender_id
+		WHERE cm.user_id = ? AND m.created_at > ? AND ` + unreadMessage + ` AND ` + visibleMessage
+	err := db.QueryRow("SELECT COUNT(*) "+unreadSince, userID, since, userID, userID).Scan(&activity.MessageCount)
+	if err != nil {
+		return nil, err
+	}
+	if activity.MessageCount > 0 {
+		rows, err := db.Query(`
+			SELECT m.id, CASE WHEN c.type = 'group' THEN c.name ELSE '' END, m.content, m.created_at,
+			       u.id, u.username, u.first_name, u.last_name
+			`+unreadSince+`
+			ORDER BY m.id DESC LIMIT ?`, userID, since, userID, userID, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var message UnreadMessage
+			err := rows.Scan(&message.ID, &message.ConversationName, &message.Content, &message.CreatedAt,
+				&message.Sender.ID, &message.Sender.Username, &message.Sender.FirstName, &message.Sender.LastName)
+			if err != nil {
+				return nil, err
+			}
+			activity.Messages = append(activity.Messages, message)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Unread notifications
+	const unreadNotificationsSince = `
+		FROM notifications n
+		WHERE n.user_id = ? AND n.is_read = FALSE AND n.created_at > ? AND ` + visibleNotification
+	err = db.QueryRow("SELECT COUNT(*) "+unreadNotificationsSince, userID, since).Scan(&activity.NotificationCount)
+	if err != nil {
+		return nil, err
+	}
+	if activity.NotificationCount > 0 {
+		page, err := ListNotifications(db, userID, limit, 0, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range page.Notifications {
+			if n.CreatedAt.After(since) {
+				activity.Notifications = append(activity.Notifications, n)
+			}
+		}
+	}
+
+	return &activity, nil
+}
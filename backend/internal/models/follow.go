@@ -0,0 +1,302 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What a user can follow
+const (
+	FollowPost     = "post"
+	FollowCategory = "category"
+	FollowUser     = "user"
+)
+
+// feedTimeLayout is how feed queries normalize created_at, so timestamps written by
+// SQLite and by Go compare and parse alike
+const feedTimeLayout = "2006-01-02 15:04:05"
+
+var (
+	ErrInvalidFollowType = errors.New("follow type must be post, category or user")
+	ErrFollowNotFound    = errors.New("not following")
+	ErrFollowSelf        = errors.New("you can't follow yourself")
+	ErrInvalidFeedCursor = errors.New("invalid feed cursor")
+)
+
+// Follow is a post, category or user someone follows. Name is the post title, the
+// category name or the username.
+type Follow struct {
+	ID         int64     `json:"id"`
+	TargetType string    `json:"target_type"`
+	TargetID   int64     `json:"target_id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FeedItem is a post or comment in a user's following feed. Reason is the kind of
+// follow that brought it in: "user" for the author, "category" for one of the post's
+// categories, or "post" for comments on a followed post.
+type FeedItem struct {
+	Type      string    `json:"type"` // "post" or "comment"
+	Reason    string    `json:"reason"`
+	PostID    int64     `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	CommentID int64     `json:"comment_id,omitempty"`
+	Excerpt   string    `json:"excerpt"`
+	CreatedAt time.Time `json:"created_at"`
+	Author    *User     `json:"author"`
+}
+
+// FeedPage is one page of a following feed, newest first. Pass NextCursor back to get
+// the next page; it is empty on the last one.
+type FeedPage struct {
+	Items      []FeedItem `json:"items"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// FollowTarget makes a user follow a post, category or user. Following twice is not
+// an error.
+func FollowTarget(db *sql.DB, userID int64, targetType string, targetID int64) (*Follow, error) {
+	if err := checkFollowTarget(db, userID, targetType, targetID); err != nil {
+		return nil, err
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO follows (user_id, target_type, target_id) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, target_type, target_id) DO NOTHING`,
+		userID, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	follows, err := queryFollows(db, "f.user_id = ? AND f.target_type = ? AND f.target_id = ?", userID, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if len(follows) == 0 {
+		return nil, ErrFollowNotFound
+	}
+	return &follows[0], nil
+}
+
+// checkFollowTarget checks that a follow target exists and can be followed
+func checkFollowTarget(db *sql.DB, userID int64, targetType string, targetID int64) error {
+	var query string
+	var notFound error
+	switch targetType {
+	case FollowPost:
+		query, notFound = "SELECT EXISTS(SELECT 1 FROM posts WHERE id = ? AND is_hidden = FALSE)", ErrPostNotFound
+	case FollowCategory:
+		query, notFound = "SELECT EXISTS(SELECT 1 FROM categories WHERE id = ?)", ErrCategoryNotFound
+	case FollowUser:
+		if targetID == userID {
+			return ErrFollowSelf
+		}
+		query, notFound = "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", ErrUserNotFound
+	default:
+		return ErrInvalidFollowType
+	}
+
+	var exists bool
+	if err := db.QueryRow(query, targetID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return notFound
+	}
+	return nil
+}
+
+// UnfollowTarget stops a user following a post, category or user
+func UnfollowTarget(db *sql.DB, userID int64, targetType string, targetID int64) error {
+	result, err := db.Exec("DELETE FROM follows WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, targetType, targetID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrFollowNotFound
+	}
+	return nil
+}
+
+// ListFollows returns everything a user follows, most recently followed first
+func ListFollows(db *sql.DB, userID int64) ([]Follow, error) {
+	return queryFollows(db, "f.user_id = ?", userID)
+}
+
+func queryFollows(db *sql.DB, where string, args ...interface{}) ([]Follow, error) {
+	rows, err := db.Query(`
+		SELECT f.id, f.target_type, f.target_id, f.created_at,
+		       COALESCE(CASE f.target_type
+		           WHEN 'post' THEN (SELECT title FROM posts WHERE id = f.target_id)
+		           WHEN 'category' THEN (SELECT name FROM categories WHERE id = f.target_id)
+		           WHEN 'user' THEN (SELECT username FROM users WHERE id = f.target_id)
+		       END, '')
+		FROM follows f
+		WHERE `+where+`
+		ORDER BY f.id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	follows := []Follow{}
+	for rows.Next() {
+		var follow Follow
+		if err := rows.Scan(&follow.ID, &follow.TargetType, &follow.TargetID, &follow.CreatedAt, &follow.Name); err != nil {
+			return nil, err
+		}
+		follows = append(follows, follow)
+	}
+	return follows, rows.Err()
+}
+
+// GetFollowerIDs returns the users following a post, category or user
+func GetFollowerIDs(db *sql.DB, targetType string, targetID int64) ([]int64, error) {
+	rows, err := db.Query("SELECT user_id FROM follows WHERE target_type = ? AND target_id = ? ORDER BY user_id",
+		targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// feedCursor marks the last item of a feed page. Items sort by time, then type, then
+// ID, so items posted in the same second still page in a stable order.
+type feedCursor struct {
+	createdAt string
+	itemType  string
+	id        int64
+}
+
+func (c feedCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%s|%d", c.createdAt, c.itemType, c.id)))
+}
+
+func decodeFeedCursor(s string) (*feedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidFeedCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return nil, ErrInvalidFeedCursor
+	}
+	if _, err := time.Parse(feedTimeLayout, parts[0]); err != nil {
+		return nil, ErrInvalidFeedCursor
+	}
+	if parts[1] != "post" && parts[1] != "comment" {
+		return nil, ErrInvalidFeedCursor
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidFeedCursor
+	}
+	return &feedCursor{createdAt: parts[0], itemType: parts[1], id: id}, nil
+}
+
+// ListFollowingFeed returns a page of a user's following feed: new posts by followed
+// users or in followed categories, and new comments on followed posts or by followed
+// users. The user's own content and hidden content are left out. Pass the previous
+// page's NextCursor as cursor to continue.
+func ListFollowingFeed(db *sql.DB, userID int64, limit int, cursor string) (*FeedPage, error) {
+	query := `
+		WITH followed AS (SELECT target_type, target_id FROM follows WHERE user_id = ?)
+		SELECT feed.type, feed.id, feed.post_id, feed.title, feed.content, feed.created_at, feed.reason,
+		       u.id, u.username, u.first_name, u.last_name
+		FROM (
+			SELECT 'post' AS type, p.id AS id, p.id AS post_id, p.title AS title, p.content AS content,
+			       p.user_id AS author_id, strftime('%Y-%m-%d %H:%M:%S', p.created_at) AS created_at,
+			       CASE WHEN EXISTS (SELECT 1 FROM followed WHERE target_type = 'user' AND target_id = p.user_id)
+			            THEN 'user' ELSE 'category' END AS reason
+			FROM posts p
+			WHERE p.is_hidden = FALSE AND p.user_id != ?
+			  AND (EXISTS (SELECT 1 FROM followed WHERE target_type = 'user' AND target_id = p.user_id)
+			    OR EXISTS (SELECT 1 FROM followed f JOIN post_categories pc ON pc.category_id = f.target_id
+			               WHERE f.target_type = 'category' AND pc.post_id = p.id))
+			UNION ALL
+			SELECT 'comment', c.id, c.post_id, p.title, c.content,
+			       c.user_id, strftime('%Y-%m-%d %H:%M:%S', c.created_at),
+			       CASE WHEN EXISTS (SELECT 1 FROM followed WHERE target_type = 'post' AND target_id = c.post_id)
+			            THEN 'post' ELSE 'user' END
+			FROM comments c
+			JOIN posts p ON p.id = c.post_id
+			WHERE c.is_hidden = FALSE AND p.is_hidden = FALSE AND c.user_id != ?
+			  AND (EXISTS (SELECT 1 FROM followed WHERE target_type = 'post' AND target_id = c.post_id)
+			    OR EXISTS (SELECT 1 FROM followed WHERE target_type = 'user' AND target_id = c.user_id))
+		) feed
+		JOIN users u ON u.id = feed.author_id`
+	args := []interface{}{userID, userID, userID}
+	if cursor != "" {
+		after, err := decodeFeedCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		query += " WHERE (feed.created_at, feed.type, feed.id) < (?, ?, ?)"
+		args = append(args, after.createdAt, after.itemType, after.id)
+	}
+	query += " ORDER BY feed.created_at DESC, feed.type DESC, feed.id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []FeedItem{}
+	var cursors []feedCursor
+	for rows.Next() {
+		var item FeedItem
+		var author User
+		var position feedCursor
+		err := rows.Scan(&item.Type, &position.id, &item.PostID, &item.PostTitle, &item.Excerpt, &position.createdAt,
+			&item.Reason, &author.ID, &author.Username, &author.FirstName, &author.LastName)
+		if err != nil {
+			return nil, err
+		}
+		position.itemType = item.Type
+
+		item.CreatedAt, err = time.Parse(feedTimeLayout, position.createdAt)
+		if err != nil {
+			return nil, err
+		}
+		if item.Type == "comment" {
+			item.CommentID = position.id
+		}
+		item.Excerpt = MentionExcerpt(item.Excerpt)
+		item.Author = &author
+		items = append(items, item)
+		cursors = append(cursors, position)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &FeedPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = cursors[limit-1].encode()
+	}
+	return page, nil
+}
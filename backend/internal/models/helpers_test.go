@@ -0,0 +1,43 @@
+package models
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"real-time-forum/backend/internal/database"
+)
+
+// newTestDB opens a fresh database file with the full schema
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.InitializeSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestUser registers a user with the given username
+func newTestUser(t *testing.T, db *sql.DB, username string) *User {
+	t.Helper()
+	user, err := CreateUser(db, RegisterRequest{
+		Username:  username,
+		Email:     username + "@example.com",
+		Password:  "password",
+		FirstName: username,
+		LastName:  "Test",
+		Age:       30,
+		Gender:    "other",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// maxMentions bounds how many users one post or comment can notify
+	maxMentions = 20
+
+	// mentionExcerptLength is how much of the mentioning content a mention shows
+	mentionExcerptLength = 140
+)
+
+var (
+	// mentionRe matches @username where the @ doesn't follow a word character, so
+	// email addresses aren't mistaken for mentions
+	mentionRe = regexp.MustCompile(`(?:^|[^\w@.])@([\w.-]+)`)
+
+	// codeRe matches fenced code blocks and code spans, where @ isn't a mention
+	codeRe = regexp.MustCompile("(?s)```.*?(?:```|$)|~~~.*?(?:~~~|$)|`[^`\n]*`")
+)
+
+// Mention records that a post or comment mentioned a user. CommentID is nil for
+// mentions in the post itself.
+type Mention struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	AuthorID  int64     `json:"author_id"`
+	PostID    int64     `json:"post_id"`
+	CommentID *int64    `json:"comment_id,omitempty"`
+	PostTitle string    `json:"post_title"`
+	Excerpt   string    `json:"excerpt"`
+	CreatedAt time.Time `json:"created_at"`
+	Author    *User     `json:"author,omitempty"`
+}
+
+// MentionPage is one page of a user's mentions, newest first
+type MentionPage struct {
+	Mentions []Mention `json:"mentions"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// ParseMentions returns the distinct usernames mentioned with @username in Markdown
+// content, in order of appearance. Mentions inside code are ignored.
+func ParseMentions(content string) []string {
+	content = codeRe.ReplaceAllString(content, " ")
+
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, m := range mentionRe.FindAllStringSubmatch(content, -1) {
+		// Trailing punctuation ends the sentence rather than the username
+		username := strings.TrimRight(m[1], ".-")
+		if username == "" || seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+		if len(usernames) == maxMentions {
+			break
+		}
+	}
+	return usernames
+}
+
+// syncMentions makes the mentions stored for a post (commentID nil) or comment match
+// its content, and returns the users who weren't mentioned there before. Authors never
+// mention themselves.
+func syncMentions(tx *sql.Tx, authorID, postID int64, commentID *int64, content string) ([]int64, error) {
+	var mentioned []int64
+	if usernames := ParseMentions(content); len(usernames) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(usernames)), ", ")
+		args := make([]interface{}, 0, len(usernames)+1)
+		for _, username := range usernames {
+			args = append(args, username)
+		}
+		args = append(args, authorID)
+
+		rows, err := tx.Query(`
+			SELECT id FROM users
+			WHERE username IN (`+placeholders+`) AND id != ?`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var userID int64
+			if err := rows.Scan(&userID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			mentioned = append(mentioned, userID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	target, targetArgs := "post_id = ? AND comment_id IS NULL", []interface{}{postID}
+	if commentID != nil {
+		target, targetArgs = "comment_id = ?", []interface{}{*commentID}
+	}
+
+	// Drop mentions an edit removed
+	keep := make(map[int64]bool, len(mentioned))
+	for _, userID := range mentioned {
+		keep[userID] = true
+	}
+	rows, err := tx.Query("SELECT user_id FROM mentions WHERE "+target, targetArgs...)
+	if err != nil {
+		return nil, err
+	}
+	var existing []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		existing = append(existing, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	already := make(map[int64]bool, len(existing))
+	for _, userID := range existing {
+		already[userID] = true
+		if !keep[userID] {
+			args := append([]interface{}{userID}, targetArgs...)
+			if _, err := tx.Exec("DELETE FROM mentions WHERE user_id = ? AND "+target, args...); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var added []int64
+	for _, userID := range mentioned {
+		if already[userID] {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO mentions (user_id, author_id, post_id, comment_id)
+			VALUES (?, ?, ?, ?)`,
+			userID, authorID, postID, commentID)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, userID)
+	}
+	return added, nil
+}
+
+// ListMentions returns a page of the mentions of a user, newest first, skipping hidden
+// posts and comments. Pass beforeID to page back from a mention.
+func ListMentions(db *sql.DB, userID int64, limit int, beforeID int64) (*MentionPage, error) {
+	query := `
+		SELECT m.id, m.user_id, m.author_id, m.post_id, m.comment_id, p.title,
+		       COALESCE(c.content, p.content), m.created_at,
+		       u.username, u.first_name, u.last_name
+		FROM mentions m
+		JOIN posts p ON p.id = m.post_id
+		LEFT JOIN comments c ON c.id = m.comment_id
+		JOIN users u ON u.id = m.author_id
+		WHERE m.user_id = ? AND p.is_hidden = FALSE AND COALESCE(c.is_hidden, FALSE) = FALSE`
+	args := []interface{}{userID}
+	if beforeID > 0 {
+		query += " AND m.id < ?"
+		args = append(args, beforeID)
+	}
+	query += " ORDER BY m.id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []Mention{}
+	for rows.Next() {
+		var mention Mention
+		var author User
+		err := rows.Scan(
+			&mention.ID,
+			&mention.UserID,
+			&mention.AuthorID,
+			&mention.PostID,
+			&mention.CommentID,
+			&mention.PostTitle,
+			&mention.Excerpt,
+			&mention.CreatedAt,
+			&author.Username,
+			&author.FirstName,
+			&author.LastName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		author.ID = mention.AuthorID
+		mention.Author = &author
+		mention.Excerpt = MentionExcerpt(mention.Excerpt)
+		mentions = append(mentions, mention)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &MentionPage{Mentions: mentions, HasMore: len(mentions) > limit}
+	if page.HasMore {
+		page.Mentions = page.Mentions[:limit]
+	}
+	return page, nil
+}
+
+// MentionExcerpt shortens mentioning content for listings and notifications
+func MentionExcerpt(content string) string {
+	runes := []rune(strings.TrimSpace(content))
+	if len(runes) <= mentionExcerptLength {
+		return string(runes)
+	}
+	return strings.TrimSpace(string(runes[:mentionExcerptLength])) + "…"
+}
@@ -3,271 +3,533 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"real-time-forum/backend/internal/markdown"
 )
 
-// PrivateMessage represents a private message between users
+// PrivateMessage represents a message in a direct or group conversation.
+// ReceiverID is 0 for group messages.
 type PrivateMessage struct {
-	ID         int64     `json:"id"`
-	SenderID   int64     `json:"sender_id"`
-	ReceiverID int64     `json:"receiver_id"`
-	Content    string    `json:"content"`
-	IsRead     bool      `json:"is_read"`
-	CreatedAt  time.Time `json:"created_at"`
-	Sender     *User     `json:"sender,omitempty"`
-	Receiver   *User     `json:"receiver,omitempty"`
+	ID             int64        `json:"id"`
+	ConversationID int64        `json:"conversation_id"`
+	SenderID       int64        `json:"sender_id"`
+	ReceiverID     int64        `json:"receiver_id"`
+	Content        string       `json:"content"`
+	ContentHTML    string       `json:"content_html"`
+	IsRead         bool         `json:"is_read"`
+	CreatedAt      time.Time    `json:"created_at"`
+	EditedAt       *time.Time   `json:"edited_at,omitempty"`
+	ClientMsgID    string       `json:"client_msg_id,omitempty"`
+	Receipts       []Receipt    `json:"receipts,omitempty"` // only on the viewer's own messages
+	Attachments    []Attachment `json:"attachments,omitempty"`
+	Sender         *User        `json:"sender,omitempty"`
+	Receiver       *User        `json:"receiver,omitempty"`
 }
 
-// Conversation represents a conversation between two users
+// Conversation is an entry in a user's conversation list. Direct conversations
+// carry the other user's details; group conversations carry a name and member count.
 type Conversation struct {
-	UserID      int64           `json:"user_id"`
-	Username    string          `json:"username"`
-	FirstName   string          `json:"first_name"`
-	LastName    string          `json:"last_name"`
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	Name        string          `json:"name,omitempty"`
+	MemberCount int             `json:"member_count,omitempty"`
+	UserID      int64           `json:"user_id,omitempty"`
+	Username    string          `json:"username,omitempty"`
+	FirstName   string          `json:"first_name,omitempty"`
+	LastName    string          `json:"last_name,omitempty"`
 	LastMessage *PrivateMessage `json:"last_message"`
 	UnreadCount int             `json:"unread_count"`
 	IsOnline    bool            `json:"is_online"`
+	Status      string          `json:"status,omitempty"` // the other user's presence status, for direct conversations
+	LastSeenAt  *time.Time      `json:"last_seen_at,omitempty"`
 }
 
-// CreatePrivateMessage creates a new private message
-func CreatePrivateMessage(db *sql.DB, senderID, receiverID int64, content string) (*PrivateMessage, error) {
-	if content == "" {
-		return nil, errors.New("message content cannot be empty")
+// MessageEditWindow is how long after sending a message its sender may still edit it
+const MessageEditWindow = 15 * time.Minute
+
+var (
+	ErrMessageNotFound   = errors.New("message not found")
+	ErrNotMessageSender  = errors.New("only the sender can change this message")
+	ErrEditWindowExpired = errors.New("messages can only be edited within 15 minutes of sending")
+	ErrEmptyMessage      = errors.New("message content cannot be empty")
+	ErrMessageToSelf     = errors.New("cannot send message to yourself")
+	ErrReceiverNotFound  = errors.New("receiver not found")
+	ErrInvalidClientID   = errors.New("client_msg_id must be at most 64 characters")
+)
+
+// messageColumns selects a message with its sender, for scanPrivateMessage
+const messageColumns = `m.id, COALESCE(m.conversation_id, 0), m.sender_id, COALESCE(m.receiver_id, 0), m.content, m.content_html, m.is_read, m.created_at, m.edited_at,
+		       COALESCE(m.client_msg_id, ''),
+		       u.username, u.first_name, u.last_name`
+
+// visibleMessage excludes messages that were unsent, hidden by a moderator,
+// or deleted by the viewing user (the single ? parameter)
+const visibleMessage = `m.deleted_at IS NULL AND m.is_hidden = FALSE
+		  AND NOT EXISTS (SELECT 1 FROM message_deletions md WHERE md.message_id = m.id AND md.user_id = ?)`
+
+func scanPrivateMessage(scanner interface{ Scan(...interface{}) error }) (*PrivateMessage, error) {
+	var message PrivateMessage
+	var sender User
+	var contentHTML sql.NullString
+	err := scanner.Scan(
+		&message.ID,
+		&message.ConversationID,
+		&message.SenderID,
+		&message.ReceiverID,
+		&message.Content,
+		&contentHTML,
+		&message.IsRead,
+		&message.CreatedAt,
+		&message.EditedAt,
+		&message.ClientMsgID,
+		&sender.Username,
+		&sender.FirstName,
+		&sender.LastName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	message.ContentHTML = renderedContent(message.Content, contentHTML)
+
+	sender.ID = message.SenderID
+	message.Sender = &sender
+	return &message, nil
+}
+
+// MaxClientMessageIDLength bounds the idempotency keys clients attach to new messages
+const MaxClientMessageIDLength = 64
+
+// CreatePrivateMessage creates a new private message carrying the sender's unsent
+// uploads in attachmentIDs; content may be empty when there are attachments. A
+// non-empty clientMsgID makes the call idempotent: retrying with the same key returns
+// the original message and false.
+func CreatePrivateMessage(db *sql.DB, senderID, receiverID int64, content, clientMsgID string, attachmentIDs []int64) (*PrivateMessage, bool, error) {
+	if content == "" && len(attachmentIDs) == 0 {
+		return nil, false, ErrEmptyMessage
 	}
 
 	if senderID == receiverID {
-		return nil, errors.New("cannot send message to yourself")
+		return nil, false, ErrMessageToSelf
+	}
+
+	if err := CheckCanPost(db, senderID); err != nil {
+		return nil, false, err
 	}
 
 	// Check if receiver exists
 	var exists bool
 	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", receiverID).Scan(&exists)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	if !exists {
-		return nil, errors.New("receiver not found")
+		return nil, false, ErrReceiverNotFound
 	}
 
-	// Insert the message
-	result, err := db.Exec(`
-		INSERT INTO messages (sender_id, receiver_id, content, created_at)
-		VALUES (?, ?, ?, ?)`,
-		senderID, receiverID, content, time.Now())
+	conversationID, err := getOrCreateDirectConversation(db, senderID, receiverID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	return insertMessage(db, conversationID, senderID, receiverID, content, clientMsgID, attachmentIDs)
+}
+
+// insertMessage stores a message with its attachments and bumps its conversation's
+// activity time. receiverID is nil for group messages. When the sender already used
+// clientMsgID, the earlier message is returned with false instead of storing a duplicate.
+func insertMessage(db *sql.DB, conversationID, senderID int64, receiverID interface{}, content, clientMsgID string, attachmentIDs []int64) (*PrivateMessage, bool, error) {
+	if len(clientMsgID) > MaxClientMessageIDLength {
+		return nil, false, ErrInvalidClientID
+	}
+	var clientID interface{}
+	if clientMsgID != "" {
+		clientID = clientMsgID
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO messages (conversation_id, sender_id, receiver_id, content, content_html, created_at, client_msg_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		conversationID, senderID, receiverID, content, markdown.Render(content), now, clientID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if inserted == 0 {
+		tx.Rollback()
+		message, err := getMessageByClientID(db, senderID, clientMsgID)
+		if err != nil {
+			return nil, false, err
+		}
+		messages := []PrivateMessage{*message}
+		return &messages[0], false, attachMessageAttachments(db, messages)
+	}
+
+	if err := checkAttachments(tx, senderID, attachmentIDs); err != nil {
+		return nil, false, err
 	}
 
 	messageID, err := result.LastInsertId()
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	if err := linkAttachments(tx, "message_id", messageID, senderID, attachmentIDs); err != nil {
+		return nil, false, err
+	}
+
+	_, err = tx.Exec("UPDATE conversations SET updated_at = ? WHERE id = ?", now, conversationID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
 	}
 
 	// Get the created message with sender info
 	message, err := GetPrivateMessage(db, messageID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
+	messages := []PrivateMessage{*message}
+	if err := attachMessageAttachments(db, messages); err != nil {
+		return nil, false, err
+	}
+	return &messages[0], true, nil
+}
 
-	return message, nil
+// getMessageByClientID finds the message a sender created with an idempotency key
+func getMessageByClientID(db *sql.DB, senderID int64, clientMsgID string) (*PrivateMessage, error) {
+	message, err := scanPrivateMessage(db.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		WHERE m.sender_id = ? AND m.client_msg_id = ?`, senderID, clientMsgID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	return message, err
 }
 
 // GetPrivateMessage retrieves a private message by ID
 func GetPrivateMessage(db *sql.DB, messageID int64) (*PrivateMessage, error) {
-	query := `
-		SELECT m.id, m.sender_id, m.receiver_id, m.content, m.is_read, m.created_at,
-		       u.username, u.first_name, u.last_name
+	message, err := scanPrivateMessage(db.QueryRow(`
+		SELECT `+messageColumns+`
 		FROM messages m
 		JOIN users u ON m.sender_id = u.id
-		WHERE m.id = ?`
+		WHERE m.id = ? AND m.deleted_at IS NULL`, messageID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	return message, err
+}
 
-	var message PrivateMessage
-	var sender User
-	err := db.QueryRow(query, messageID).Scan(
-		&message.ID,
-		&message.SenderID,
-		&message.ReceiverID,
-		&message.Content,
-		&message.IsRead,
-		&message.CreatedAt,
-		&sender.Username,
-		&sender.FirstName,
-		&sender.LastName,
-	)
+// MessagePage is a page of conversation history, oldest first
+type MessagePage struct {
+	Messages []PrivateMessage `json:"messages"`
+	HasMore  bool             `json:"has_more"` // more messages lie beyond the page in the direction it was read
+}
+
+// MessageContext is a message together with the messages around it, oldest first
+type MessageContext struct {
+	Messages []PrivateMessage `json:"messages"`
+	HasOlder bool             `json:"has_older"`
+	HasNewer bool             `json:"has_newer"`
+}
+
+// beforeMessage and afterMessage position messages relative to a cursor message by
+// creation time, then ID, so cursors stay stable when several messages share a
+// timestamp. Their single ? parameter is the cursor's ID.
+const (
+	beforeMessage = `(m.created_at, m.id) < (SELECT created_at, id FROM messages WHERE id = ?)`
+	afterMessage  = `(m.created_at, m.id) > (SELECT created_at, id FROM messages WHERE id = ?)`
+)
+
+// GetConversationHistory retrieves a page of the direct messages between two users.
+// Without a cursor it returns the newest messages; beforeID pages back through older
+// ones and afterID forward through newer ones. Cursors must be messages between the two.
+func GetConversationHistory(db *sql.DB, userID1, userID2 int64, limit int, beforeID, afterID int64) (*MessagePage, error) {
+	const pair = `((m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?))`
+	pairArgs := []interface{}{userID1, userID2, userID2, userID1}
+
+	cursor, cursorID, order := "", int64(0), "DESC"
+	switch {
+	case afterID != 0:
+		cursor, cursorID, order = "AND "+afterMessage, afterID, "ASC"
+	case beforeID != 0:
+		cursor, cursorID = "AND "+beforeMessage, beforeID
+	}
+
+	args := append([]interface{}{}, pairArgs...)
+	args = append(args, userID1)
+	if cursorID != 0 {
+		var exists bool
+		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM messages m WHERE m.id = ? AND "+pair+")",
+			append([]interface{}{cursorID}, pairArgs...)...).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrMessageNotFound
+		}
+		args = append(args, cursorID)
+	}
+	args = append(args, limit+1)
+
+	messages, err := queryMessages(db, `
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		WHERE `+pair+` AND `+visibleMessage+` `+cursor+`
+		ORDER BY m.created_at `+order+`, m.id `+order+`
+		LIMIT ?`, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	sender.ID = message.SenderID
-	message.Sender = &sender
+	// One extra row was fetched to tell whether there are more
+	page := &MessagePage{Messages: messages, HasMore: len(messages) > limit}
+	if page.HasMore {
+		page.Messages = page.Messages[:limit]
+	}
+	if order == "DESC" {
+		reverseMessages(page.Messages)
+	}
 
-	return &message, nil
+	if err := attachMessageAttachments(db, page.Messages); err != nil {
+		return nil, err
+	}
+	return page, attachReceipts(db, userID1, page.Messages)
 }
 
-// GetConversationHistory retrieves message history between two users with pagination
-func GetConversationHistory(db *sql.DB, userID1, userID2 int64, limit, offset int) ([]PrivateMessage, error) {
-	query := `
-		SELECT m.id, m.sender_id, m.receiver_id, m.content, m.is_read, m.created_at,
-		       u.username, u.first_name, u.last_name
+// GetMessageContext retrieves a message the viewer can see along with up to limit
+// messages on either side of it in its conversation, for jumping to it in history
+func GetMessageContext(db *sql.DB, messageID, viewerID int64, limit int) (*MessageContext, error) {
+	target, err := scanPrivateMessage(db.QueryRow(`
+		SELECT `+messageColumns+`
 		FROM messages m
 		JOIN users u ON m.sender_id = u.id
-		WHERE (m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?)
-		ORDER BY m.created_at DESC
-		LIMIT ? OFFSET ?`
+		WHERE m.id = ? AND `+visibleMessage, messageID, viewerID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := db.Query(query, userID1, userID2, userID2, userID1, limit, offset)
+	// Don't reveal messages in conversations the viewer isn't part of
+	if _, err := GetMemberRole(db, target.ConversationID, viewerID); err == ErrNotConversationMember {
+		return nil, ErrMessageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	const around = `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		WHERE m.conversation_id = ? AND ` + visibleMessage + ` AND %s
+		ORDER BY m.created_at %s, m.id %s
+		LIMIT ?`
+
+	older, err := queryMessages(db, fmt.Sprintf(around, beforeMessage, "DESC", "DESC"),
+		target.ConversationID, viewerID, messageID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	newer, err := queryMessages(db, fmt.Sprintf(around, afterMessage, "ASC", "ASC"),
+		target.ConversationID, viewerID, messageID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	surrounding := &MessageContext{HasOlder: len(older) > limit, HasNewer: len(newer) > limit}
+	if surrounding.HasOlder {
+		older = older[:limit]
+	}
+	if surrounding.HasNewer {
+		newer = newer[:limit]
+	}
+	reverseMessages(older)
+
+	surrounding.Messages = append(older, *target)
+	surrounding.Messages = append(surrounding.Messages, newer...)
+	if err := attachMessageAttachments(db, surrounding.Messages); err != nil {
+		return nil, err
+	}
+	return surrounding, attachReceipts(db, viewerID, surrounding.Messages)
+}
+
+// queryMessages runs a query selecting messageColumns and collects the messages
+func queryMessages(db *sql.DB, query string, args ...interface{}) ([]PrivateMessage, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []PrivateMessage
+	messages := []PrivateMessage{}
 	for rows.Next() {
-		var message PrivateMessage
-		var sender User
-		err := rows.Scan(
-			&message.ID,
-			&message.SenderID,
-			&message.ReceiverID,
-			&message.Content,
-			&message.IsRead,
-			&message.CreatedAt,
-			&sender.Username,
-			&sender.FirstName,
-			&sender.LastName,
-		)
+		message, err := scanPrivateMessage(rows)
 		if err != nil {
-			// log.Printf("GetConversationHistory scan error: %v", err)
 			return nil, err
 		}
-
-		sender.ID = message.SenderID
-		message.Sender = &sender
-
-		messages = append(messages, message)
+		messages = append(messages, *message)
 	}
+	return messages, rows.Err()
+}
 
-	// Reverse the slice to get chronological order (oldest first)
+// reverseMessages reverses messages in place, turning newest-first into oldest-first
+func reverseMessages(messages []PrivateMessage) {
 	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
 		messages[i], messages[j] = messages[j], messages[i]
 	}
-
-	return messages, nil
 }
 
-// GetUserConversations retrieves all conversations for a user
-func GetUserConversations(db *sql.DB, userID int64) ([]Conversation, error) {
-	query := `
-		SELECT DISTINCT
-			CASE 
-				WHEN m.sender_id = ? THEN m.receiver_id 
-				ELSE m.sender_id 
-			END as other_user_id,
-			u.username, u.first_name, u.last_name
-		FROM messages m
-		JOIN users u ON (
-			CASE 
-				WHEN m.sender_id = ? THEN m.receiver_id = u.id
-				ELSE m.sender_id = u.id
-			END
-		)
-		WHERE m.sender_id = ? OR m.receiver_id = ?`
+// unreadMessage matches messages in conversation c that member cm has not read. Direct
+// conversations track reads per message; groups by the member's read position. Its
+// single ? parameter is the member's user ID.
+const unreadMessage = `m.sender_id != ?
+		  AND CASE WHEN c.type = 'direct' THEN m.is_read = FALSE
+		           ELSE m.created_at > COALESCE(cm.last_read_at, cm.joined_at) END`
+
+// UnreadTotals sums a user's unread messages across their conversations
+type UnreadTotals struct {
+	Messages      int `json:"messages"`
+	Conversations int `json:"conversations"`
+}
 
-	rows, err := db.Query(query, userID, userID, userID, userID)
+// GetUserConversations retrieves a page of a user's direct and group conversations,
+// most recently active first, with their last message and unread count
+func GetUserConversations(db *sql.DB, userID int64, limit, offset int) ([]Conversation, error) {
+	rows, err := db.Query(`
+		SELECT c.id, c.type, COALESCE(c.name, ''),
+		       CASE WHEN c.type = 'group'
+		            THEN (SELECT COUNT(*) FROM conversation_members gm WHERE gm.conversation_id = c.id)
+		            ELSE 0 END,
+		       COALESCE(ou.id, 0), COALESCE(ou.username, ''), COALESCE(ou.first_name, ''),
+		       COALESCE(ou.last_name, ''), ou.last_seen_at,
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.conversation_id = c.id AND `+unreadMessage+` AND `+visibleMessage+`),
+		       lm.id, COALESCE(lm.sender_id, 0), COALESCE(lm.receiver_id, 0), lm.content, lm.content_html, lm.is_read,
+		       lm.created_at, lm.edited_at, COALESCE(lm.client_msg_id, ''),
+		       lu.username, lu.first_name, lu.last_name
+		FROM conversation_members cm
+		JOIN conversations c ON c.id = cm.conversation_id
+		LEFT JOIN conversation_members ocm
+		       ON ocm.conversation_id = c.id AND c.type = 'direct' AND ocm.user_id != cm.user_id
+		LEFT JOIN users ou ON ou.id = ocm.user_id
+		LEFT JOIN messages lm ON lm.id = (
+			SELECT m.id FROM messages m
+			WHERE m.conversation_id = c.id AND `+visibleMessage+`
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT 1)
+		LEFT JOIN users lu ON lu.id = lm.sender_id
+		WHERE cm.user_id = ?
+		  AND (c.type = 'group' OR ou.id IS NOT NULL) -- skip chats with deleted accounts
+		ORDER BY c.updated_at DESC, c.id DESC
+		LIMIT ? OFFSET ?`,
+		userID, userID, userID, userID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var conversations []Conversation
+	conversations := []Conversation{}
 	for rows.Next() {
 		var conv Conversation
+		var lastID sql.NullInt64
+		var last PrivateMessage
+		var content, contentHTML, username, firstName, lastName sql.NullString
+		var isRead sql.NullBool
+		var createdAt *time.Time
 		err := rows.Scan(
-			&conv.UserID,
-			&conv.Username,
-			&conv.FirstName,
-			&conv.LastName,
+			&conv.ID, &conv.Type, &conv.Name, &conv.MemberCount,
+			&conv.UserID, &conv.Username, &conv.FirstName, &conv.LastName, &conv.LastSeenAt,
+			&conv.UnreadCount,
+			&lastID, &last.SenderID, &last.ReceiverID, &content, &contentHTML, &isRead,
+			&createdAt, &last.EditedAt, &last.ClientMsgID,
+			&username, &firstName, &lastName,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		// Get last message for this conversation
-		lastMessage, err := getLastMessage(db, userID, conv.UserID)
-		if err == nil {
-			conv.LastMessage = lastMessage
+		if lastID.Valid {
+			last.ID = lastID.Int64
+			last.ConversationID = conv.ID
+			last.Content = content.String
+			last.ContentHTML = renderedContent(last.Content, contentHTML)
+			last.IsRead = isRead.Bool
+			if createdAt != nil {
+				last.CreatedAt = *createdAt
+			}
+			last.Sender = &User{
+				ID:        last.SenderID,
+				Username:  username.String,
+				FirstName: firstName.String,
+				LastName:  lastName.String,
+			}
+			conv.LastMessage = &last
 		}
 
-		// Get unread count
-		conv.UnreadCount, _ = getUnreadCount(db, userID, conv.UserID)
-
 		conversations = append(conversations, conv)
 	}
 
-	return conversations, nil
+	return conversations, rows.Err()
 }
 
-// getLastMessage retrieves the last message between two users
-func getLastMessage(db *sql.DB, userID1, userID2 int64) (*PrivateMessage, error) {
-	query := `
-        SELECT m.id, m.sender_id, m.receiver_id, m.content, m.is_read, m.created_at,
-               u.username, u.first_name, u.last_name
-        FROM messages m
-        JOIN users u ON m.sender_id = u.id
-        WHERE (m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?)
-        ORDER BY m.created_at DESC
-        LIMIT 1`
-
-	var message PrivateMessage
-	var sender User
-	err := db.QueryRow(query, userID1, userID2, userID2, userID1).Scan(
-		&message.ID,
-		&message.SenderID,
-		&message.ReceiverID,
-		&message.Content,
-		&message.IsRead,
-		&message.CreatedAt,
-		&sender.Username,
-		&sender.FirstName,
-		&sender.LastName,
-	)
+// GetUnreadTotals counts a user's unread messages and the conversations they are in
+func GetUnreadTotals(db *sql.DB, userID int64) (*UnreadTotals, error) {
+	var totals UnreadTotals
+	err := db.QueryRow(`
+		SELECT COUNT(*), COUNT(DISTINCT c.id)
+		FROM conversation_members cm
+		JOIN conversations c ON c.id = cm.conversation_id
+		JOIN messages m ON m.conversation_id = c.id
+		WHERE cm.user_id = ? AND `+unreadMessage+` AND `+visibleMessage,
+		userID, userID, userID).Scan(&totals.Messages, &totals.Conversations)
 	if err != nil {
-		// Add debug log here
-		// log.Printf("getLastMessage error: %v", err)
 		return nil, err
 	}
-
-	sender.ID = message.SenderID
-	message.Sender = &sender
-
-	return &message, nil
+	return &totals, nil
 }
 
-// getUnreadCount gets the number of unread messages from a specific user
-func getUnreadCount(db *sql.DB, receiverID, senderID int64) (int, error) {
-	var count int
-	err := db.QueryRow(`
-		SELECT COUNT(*) 
-		FROM messages 
-		WHERE receiver_id = ? AND sender_id = ? AND is_read = FALSE`,
-		receiverID, senderID).Scan(&count)
-	return count, err
-}
+// MarkMessagesAsRead marks all messages from a sender to receiver as read and
+// returns the messages that were newly read
+func MarkMessagesAsRead(db *sql.DB, receiverID, senderID int64) ([]ReadMessage, error) {
+	var conversationID int64
+	err := db.QueryRow("SELECT id FROM conversations WHERE direct_key = ?",
+		directKey(receiverID, senderID)).Scan(&conversationID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-// MarkMessagesAsRead marks all messages from a sender to receiver as read
-func MarkMessagesAsRead(db *sql.DB, receiverID, senderID int64) error {
-	_, err := db.Exec(`
-		UPDATE messages 
-		SET is_read = TRUE 
-		WHERE receiver_id = ? AND sender_id = ? AND is_read = FALSE`,
-		receiverID, senderID)
-	return err
+	return MarkConversationRead(db, conversationID, receiverID, 0)
 }
 
 // GetAllUsers retrieves all users except the current user (for chat user list)
 func GetAllUsers(db *sql.DB, currentUserID int64) ([]User, error) {
 	query := `
-		SELECT id, username, first_name, last_name, email, age, gender, created_at
+		SELECT id, username, first_name, last_name, email, age, gender, last_seen_at, created_at
 		FROM users 
 		WHERE id != ?
 		ORDER BY username`
@@ -289,6 +551,7 @@ func GetAllUsers(db *sql.DB, currentUserID int64) ([]User, error) {
 			&user.Email,
 			&user.Age,
 			&user.Gender,
+			&user.LastSeenAt,
 			&user.CreatedAt,
 		)
 		if err != nil {
@@ -299,3 +562,94 @@ func GetAllUsers(db *sql.DB, currentUserID int64) ([]User, error) {
 
 	return users, nil
 }
+
+// SetMessageHidden hides or restores a private message for both participants
+func SetMessageHidden(db *sql.DB, messageID int64, hidden bool) error {
+	_, err := db.Exec("UPDATE messages SET is_hidden = ? WHERE id = ?", hidden, messageID)
+	return err
+}
+
+// DeletePrivateMessage permanently removes a private message
+func DeletePrivateMessage(db *sql.DB, messageID int64) error {
+	if _, err := db.Exec("DELETE FROM message_deletions WHERE message_id = ?", messageID); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM message_receipts WHERE message_id = ?", messageID); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM messages WHERE id = ?", messageID)
+	return err
+}
+
+// EditPrivateMessage replaces the content of a message. Only the sender may edit,
+// and only within MessageEditWindow of sending it.
+func EditPrivateMessage(db *sql.DB, messageID, userID int64, content string) (*PrivateMessage, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	message, err := GetPrivateMessage(db, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrNotMessageSender
+	}
+	if time.Since(message.CreatedAt) > MessageEditWindow {
+		return nil, ErrEditWindowExpired
+	}
+	if err := CheckCanPost(db, userID); err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("UPDATE messages SET content = ?, content_html = ?, edited_at = ? WHERE id = ?",
+		content, markdown.Render(content), time.Now(), messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetPrivateMessage(db, messageID)
+}
+
+// UnsendPrivateMessage removes a message for both participants. The row is kept
+// as an empty tombstone so reports against it still resolve.
+func UnsendPrivateMessage(db *sql.DB, messageID, userID int64) (*PrivateMessage, error) {
+	message, err := GetPrivateMessage(db, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrNotMessageSender
+	}
+
+	_, err = db.Exec("UPDATE messages SET content = '', content_html = '', deleted_at = ? WHERE id = ?", time.Now(), messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// DeleteMessageForUser hides a message from one participant's view only
+func DeleteMessageForUser(db *sql.DB, messageID, userID int64) (*PrivateMessage, error) {
+	message, err := GetPrivateMessage(db, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID && message.ReceiverID != userID {
+		if _, err := GetMemberRole(db, message.ConversationID, userID); err != nil {
+			return nil, ErrMessageNotFound
+		}
+	}
+
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO message_deletions (message_id, user_id, created_at)
+		VALUES (?, ?, ?)`,
+		messageID, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
@@ -0,0 +1,332 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Notification types. Moderation notices are always delivered; users can turn the
+// others off.
+const (
+	NotificationPostReply    = "post_reply"
+	NotificationCommentReply = "comment_reply"
+	NotificationReaction     = "reaction"
+	NotificationMention      = "mention"
+	NotificationModeration   = "moderation"
+	NotificationFollowedPost = "followed_post"
+)
+
+// OptionalNotificationTypes are the notification types users can turn off
+var OptionalNotificationTypes = []string{
+	NotificationPostReply,
+	NotificationCommentReply,
+	NotificationReaction,
+	NotificationMention,
+	NotificationFollowedPost,
+}
+
+var (
+	ErrNotificationNotFound    = errors.New("notification not found")
+	ErrInvalidNotificationType = errors.New("invalid notification type")
+)
+
+// Notification tells a user about activity concerning them. ActorID is the user who
+// caused it, if any; PostID and CommentID point at the content it is about.
+type Notification struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Type      string    `json:"type"`
+	ActorID   *int64    `json:"actor_id,omitempty"`
+	PostID    *int64    `json:"post_id,omitempty"`
+	CommentID *int64    `json:"comment_id,omitempty"`
+	Text      string    `json:"text"`
+	IsRead    bool      `json:"is_read"`
+	CreatedAt time.Time `json:"created_at"`
+	Actor     *User     `json:"actor,omitempty"`
+}
+
+// NotificationPage is one page of a user's notifications, newest first
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	HasMore       bool           `json:"has_more"`
+	UnreadCount   int            `json:"unread_count"`
+}
+
+// Summary describes a notification in one line, as the notification center shows it
+func (n *Notification) Summary() string {
+	actor := "Someone"
+	if n.Actor != nil {
+		actor = n.Actor.Username
+	}
+	switch n.Type {
+	case NotificationPostReply:
+		return actor + " commented on your post"
+	case NotificationCommentReply:
+		return actor + " replied to your comment"
+	case NotificationReaction:
+		if n.CommentID != nil {
+			return actor + " liked your comment"
+		}
+		return actor + " liked your post"
+	case NotificationMention:
+		return actor + " mentioned you"
+	case NotificationModeration:
+		return "Moderation notice"
+	case NotificationFollowedPost:
+		return actor + " commented on a post you follow"
+	}
+	return n.Type
+}
+
+// isOptionalNotificationType reports whether users can turn a notification type off
+func isOptionalNotificationType(notificationType string) bool {
+	for _, t := range OptionalNotificationTypes {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateNotification stores a notification unless its recipient turned the type off,
+// caused it themselves, or was already told about the same reaction. It reports
+// whether the notification was stored, and fills in its ID, time and actor if so.
+func CreateNotification(db *sql.DB, n *Notification) (bool, error) {
+	if n.ActorID != nil && *n.ActorID == n.UserID {
+		return false, nil
+	}
+
+	if isOptionalNotificationType(n.Type) {
+		enabled, err := notificationEnabled(db, n.UserID, n.Type)
+		if err != nil || !enabled {
+			return false, err
+		}
+	}
+
+	// Liking, unliking and liking again shouldn't notify twice
+	if n.Type == NotificationReaction {
+		var exists bool
+		err := db.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM notifications
+				WHERE user_id = ? AND type = ? AND actor_id IS ?
+				  AND post_id IS ? AND comment_id IS ?
+			)`, n.UserID, n.Type, n.ActorID, n.PostID, n.CommentID).Scan(&exists)
+		if err != nil || exists {
+			return false, err
+		}
+	}
+
+	n.CreatedAt = time.Now()
+	result, err := db.Exec(`
+		INSERT INTO notifications (user_id, type, actor_id, post_id, comment_id, text, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		n.UserID, n.Type, n.ActorID, n.PostID, n.CommentID, n.Text, n.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	n.ID, err = result.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+
+	if n.ActorID != nil {
+		actor, err := GetUserByID(db, *n.ActorID)
+		if err != nil {
+			return false, err
+		}
+		n.Actor = publicUser(actor)
+	}
+	return true, nil
+}
+
+// ListNotifications returns a page of a user's notifications, newest first, optionally
+// only unread ones. Notifications about content that has since been hidden are left
+// out, except moderation notices. Pass beforeID to page back from a notification.
+func ListNotifications(db *sql.DB, userID int64, limit int, beforeID int64, unreadOnly bool) (*NotificationPage, error) {
+	query := `
+		SELECT n.id, n.user_id, n.type, n.actor_id, n.post_id, n.comment_id, n.text,
+		       n.is_read, n.created_at, u.username, u.first_name, u.last_name
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		WHERE n.user_id = ? AND ` + visibleNotification
+	args := []interface{}{userID}
+	if unreadOnly {
+		query += " AND n.is_read = FALSE"
+	}
+	if beforeID > 0 {
+		query += " AND n.id < ?"
+		args = append(args, beforeID)
+	}
+	query += " ORDER BY n.id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var username, firstName, lastName sql.NullString
+		err := rows.Scan(
+			&n.ID,
+			&n.UserID,
+			&n.Type,
+			&n.ActorID,
+			&n.PostID,
+			&n.CommentID,
+			&n.Text,
+			&n.IsRead,
+			&n.CreatedAt,
+			&username,
+			&firstName,
+			&lastName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n.ActorID != nil && username.Valid {
+			n.Actor = &User{
+				ID:        *n.ActorID,
+				Username:  username.String,
+				FirstName: firstName.String,
+				LastName:  lastName.String,
+			}
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &NotificationPage{Notifications: notifications, HasMore: len(notifications) > limit}
+	if page.HasMore {
+		page.Notifications = page.Notifications[:limit]
+	}
+	page.UnreadCount, err = CountUnreadNotifications(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// visibleNotification filters out notifications about hidden posts and comments
+const visibleNotification = `(n.type = '` + NotificationModeration + `' OR (
+			NOT EXISTS(SELECT 1 FROM posts WHERE id = n.post_id AND is_hidden = TRUE)
+			AND NOT EXISTS(SELECT 1 FROM comments WHERE id = n.comment_id AND is_hidden = TRUE)))`
+
+// CountUnreadNotifications counts the visible notifications a user hasn't read
+func CountUnreadNotifications(db *sql.DB, userID int64) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM notifications n
+		WHERE n.user_id = ? AND n.is_read = FALSE AND `+visibleNotification, userID).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationRead marks one of a user's notifications read
+func MarkNotificationRead(db *sql.DB, userID, notificationID int64) error {
+	result, err := db.Exec("UPDATE notifications SET is_read = TRUE WHERE id = ? AND user_id = ?",
+		notificationID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead marks every notification of a user read and returns how many
+// were unread
+func MarkAllNotificationsRead(db *sql.DB, userID int64) (int64, error) {
+	result, err := db.Exec("UPDATE notifications SET is_read = TRUE WHERE user_id = ? AND is_read = FALSE", userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetNotificationPreferences returns which optional notification types a user receives.
+// Types the user never changed are on.
+func GetNotificationPreferences(db *sql.DB, userID int64) (map[string]bool, error) {
+	preferences := make(map[string]bool, len(OptionalNotificationTypes))
+	for _, t := range OptionalNotificationTypes {
+		preferences[t] = true
+	}
+
+	rows, err := db.Query("SELECT type, enabled FROM notification_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var notificationType string
+		var enabled bool
+		if err := rows.Scan(&notificationType, &enabled); err != nil {
+			return nil, err
+		}
+		if isOptionalNotificationType(notificationType) {
+			preferences[notificationType] = enabled
+		}
+	}
+	return preferences, rows.Err()
+}
+
+// SetNotificationPreferences turns optional notification types on or off. Types not
+// in changes keep their setting.
+func SetNotificationPreferences(db *sql.DB, userID int64, changes map[string]bool) error {
+	for notificationType := range changes {
+		if !isOptionalNotificationType(notificationType) {
+			return ErrInvalidNotificationType
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for notificationType, enabled := range changes {
+		_, err := tx.Exec(`
+			INSERT INTO notification_preferences (user_id, type, enabled)
+			VALUES (?, ?, ?)
+			ON CONFLICT(user_id, type) DO UPDATE SET enabled = excluded.enabled`,
+			userID, notificationType, enabled)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// notificationEnabled reports whether a user receives an optional notification type
+func notificationEnabled(db *sql.DB, userID int64, notificationType string) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT enabled FROM notification_preferences WHERE user_id = ? AND type = ?",
+		userID, notificationType).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return enabled, err
+}
+
+// publicUser copies the parts of a user anyone may see
+func publicUser(user *User) *User {
+	return &User{
+		ID:        user.ID,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+}
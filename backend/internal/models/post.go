@@ -3,51 +3,71 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
+
+	"real-time-forum/backend/internal/markdown"
 )
 
 type Post struct {
-	ID         int64      `json:"id"`
-	UserID     int64      `json:"user_id"`
-	Title      string     `json:"title"`
-	Content    string     `json:"content"`
-	Categories []Category `json:"categories"`
-	Comments   []Comment  `json:"comments,omitempty"`
-	CreatedAt  time.Time  `json:"created_at"`
-	UpdatedAt  time.Time  `json:"updated_at"`
-	Author     *User      `json:"author,omitempty"`
-	LikeCount  int        `json:"like_count"`
+	ID          int64        `json:"id"`
+	UserID      int64        `json:"user_id"`
+	Title       string       `json:"title"`
+	Content     string       `json:"content"`
+	ContentHTML string       `json:"content_html"`
+	Categories  []Category   `json:"categories"`
+	Comments    []Comment    `json:"comments,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	Author      *User        `json:"author,omitempty"`
+	LikeCount   int          `json:"like_count"`
+	IsHidden    bool         `json:"is_hidden,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// HasLiked and HasBookmarked tell whether the user asking liked and bookmarked the post
+	HasLiked      bool `json:"has_liked"`
+	HasBookmarked bool `json:"has_bookmarked"`
+
+	// Mentioned lists the users a create or edit newly mentioned, for notifying them
+	Mentioned []int64 `json:"-"`
 }
 
 type Comment struct {
-	ID        int64     `json:"id"`
-	PostID    int64     `json:"post_id"`
-	UserID    int64     `json:"user_id"`
-	Content   string    `json:"content"`
-	ParentID  *int64    `json:"parent_id,omitempty"`
-	Replies   []Comment `json:"replies,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Author    *User     `json:"author,omitempty"`
-	LikeCount int       `json:"like_count"`
+	ID          int64        `json:"id"`
+	PostID      int64        `json:"post_id"`
+	UserID      int64        `json:"user_id"`
+	Content     string       `json:"content"`
+	ContentHTML string       `json:"content_html"`
+	ParentID    *int64       `json:"parent_id,omitempty"`
+	Replies     []Comment    `json:"replies,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	Author      *User        `json:"author,omitempty"`
+	LikeCount   int          `json:"like_count"`
+	IsHidden    bool         `json:"is_hidden,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Mentioned lists the users a create or edit newly mentioned, for notifying them
+	Mentioned []int64 `json:"-"`
 }
 
-type Category struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
+type CreatePostRequest struct {
+	Title         string  `json:"title"`
+	Content       string  `json:"content"`
+	CategoryIDs   []int64 `json:"category_ids"`
+	AttachmentIDs []int64 `json:"attachment_ids,omitempty"`
 }
 
-type CreatePostRequest struct {
+type UpdatePostRequest struct {
 	Title       string  `json:"title"`
 	Content     string  `json:"content"`
 	CategoryIDs []int64 `json:"category_ids"`
 }
 
 type CreateCommentRequest struct {
-	Content  string `json:"content"`
-	ParentID *int64 `json:"parent_id,omitempty"`
+	Content       string  `json:"content"`
+	ParentID      *int64  `json:"parent_id,omitempty"`
+	AttachmentIDs []int64 `json:"attachment_ids,omitempty"`
 }
 
 var (
@@ -57,11 +77,22 @@ var (
 	ErrInvalidCategory = errors.New("one or more categories are invalid")
 	ErrEmptyComment    = errors.New("comment content cannot be empty")
 	ErrPostNotFound    = errors.New("post not found")
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrInvalidPostSort = errors.New("sort must be newest, oldest, most_liked or most_commented")
 )
 
-// CreatePost creates a new post and links it with the specified categories
+// CreatePost creates a new post and links it with the specified categories and attachments
 func CreatePost(db *sql.DB, userID int64, req CreatePostRequest) (*Post, error) {
-	if err := validateCreatePostRequest(req); err != nil {
+	if err := CheckCanPost(db, userID); err != nil {
+		return nil, err
+	}
+
+	role, err := GetUserRole(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateCreatePostRequest(db, req, role, nil); err != nil {
 		return nil, err
 	}
 
@@ -74,9 +105,9 @@ func CreatePost(db *sql.DB, userID int64, req CreatePostRequest) (*Post, error)
 
 	// Insert post
 	result, err := tx.Exec(`
-		INSERT INTO posts (user_id, title, content)
-		VALUES (?, ?, ?)`,
-		userID, req.Title, req.Content)
+		INSERT INTO posts (user_id, title, content, content_html)
+		VALUES (?, ?, ?, ?)`,
+		userID, req.Title, req.Content, markdown.Render(req.Content))
 	if err != nil {
 		return nil, err
 	}
@@ -97,21 +128,183 @@ func CreatePost(db *sql.DB, userID int64, req CreatePostRequest) (*Post, error)
 		}
 	}
 
+	// Link attachments
+	if err := checkAttachments(tx, userID, req.AttachmentIDs); err != nil {
+		return nil, err
+	}
+	if err := linkAttachments(tx, "post_id", postID, userID, req.AttachmentIDs); err != nil {
+		return nil, err
+	}
+
+	mentioned, err := syncMentions(tx, userID, postID, nil, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 
 	// Return the created post with categories
-	return GetPostByID(db, postID)
+	post, err := GetPostByID(db, postID)
+	if err != nil {
+		return nil, err
+	}
+	post.Mentioned = mentioned
+	return post, nil
+}
+
+// UpdatePost changes the title, content and categories of a post.
+// Categories the post already has stay allowed even if they were archived since.
+func UpdatePost(db *sql.DB, postID, editorID int64, req UpdatePostRequest) (*Post, error) {
+	if err := CheckCanPost(db, editorID); err != nil {
+		return nil, err
+	}
+
+	role, err := GetUserRole(db, editorID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[int64]bool)
+	catRows, err := db.Query("SELECT category_id FROM post_categories WHERE post_id = ?", postID)
+	if err != nil {
+		return nil, err
+	}
+	for catRows.Next() {
+		var categoryID int64
+		if err := catRows.Scan(&categoryID); err != nil {
+			catRows.Close()
+			return nil, err
+		}
+		current[categoryID] = true
+	}
+	catRows.Close()
+
+	validate := CreatePostRequest{Title: req.Title, Content: req.Content, CategoryIDs: req.CategoryIDs}
+	if err := validateCreatePostRequest(db, validate, role, current); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE posts SET title = ?, content = ?, content_html = ?, updated_at = ?
+		WHERE id = ?`,
+		req.Title, req.Content, markdown.Render(req.Content), time.Now(), postID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrPostNotFound
+	}
+
+	// Replace category links
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE post_id = ?", postID); err != nil {
+		return nil, err
+	}
+	for _, categoryID := range req.CategoryIDs {
+		_, err := tx.Exec(`
+			INSERT INTO post_categories (post_id, category_id)
+			VALUES (?, ?)`,
+			postID, categoryID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Mentions stay the author's when someone else edits the post
+	var authorID int64
+	if err := tx.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&authorID); err != nil {
+		return nil, err
+	}
+	mentioned, err := syncMentions(tx, authorID, postID, nil, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	post, err := GetPostByID(db, postID)
+	if err != nil {
+		return nil, err
+	}
+	post.Mentioned = mentioned
+	return post, nil
+}
+
+// DeletePost removes a post together with its comments, likes, bookmarks, attachments and category links
+func DeletePost(db *sql.DB, postID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Foreign keys aren't enforced on the connection, so cascade by hand
+	statements := []string{
+		"DELETE FROM attachments WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM attachments WHERE post_id = ?",
+		"DELETE FROM mentions WHERE post_id = ?",
+		"DELETE FROM follows WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM bookmarks WHERE post_id = ?",
+		// Moderation notices outlive the content they are about
+		"DELETE FROM notifications WHERE post_id = ? AND type != 'moderation'",
+		"UPDATE notifications SET post_id = NULL, comment_id = NULL WHERE post_id = ?",
+		"DELETE FROM likes WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM likes WHERE post_id = ?",
+		"DELETE FROM comments WHERE post_id = ?",
+		"DELETE FROM post_categories WHERE post_id = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, postID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM posts WHERE id = ?", postID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrPostNotFound
+	}
+
+	return tx.Commit()
+}
+
+// GetPostOwnerID returns the ID of the user who wrote a post
+func GetPostOwnerID(db *sql.DB, postID int64) (int64, error) {
+	var ownerID int64
+	err := db.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPostNotFound
+	}
+	return ownerID, err
 }
 
 // GetPostByID retrieves a post by its ID, including categories and author
 func GetPostByID(db *sql.DB, id int64) (*Post, error) {
 	// Get post with like count
 	post := &Post{}
+	var contentHTML sql.NullString
 	err := db.QueryRow(`
-		SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at,
+		SELECT p.id, p.user_id, p.title, p.content, p.content_html, p.created_at, p.updated_at, p.is_hidden,
 		       (SELECT COUNT(*) FROM likes WHERE post_id = p.id) as like_count
 		FROM posts p
 		WHERE p.id = ?`, id).Scan(
@@ -119,17 +312,20 @@ func GetPostByID(db *sql.DB, id int64) (*Post, error) {
 		&post.UserID,
 		&post.Title,
 		&post.Content,
+		&contentHTML,
 		&post.CreatedAt,
 		&post.UpdatedAt,
+		&post.IsHidden,
 		&post.LikeCount,
 	)
 	if err != nil {
 		return nil, err
 	}
+	post.ContentHTML = renderedContent(post.Content, contentHTML)
 
 	// Get categories
 	rows, err := db.Query(`
-		SELECT c.id, c.name, c.description, c.created_at
+		SELECT `+categoryColumns+`
 		FROM categories c
 		JOIN post_categories pc ON c.id = pc.category_id
 		WHERE pc.post_id = ?`, id)
@@ -139,8 +335,7 @@ func GetPostByID(db *sql.DB, id int64) (*Post, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var cat Category
-		err := rows.Scan(&cat.ID, &cat.Name, &cat.Description, &cat.CreatedAt)
+		cat, err := scanCategory(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -156,10 +351,10 @@ func GetPostByID(db *sql.DB, id int64) (*Post, error) {
 
 	// Get comments (only parent comments)
 	rows, err = db.Query(`
-		SELECT c.id, c.post_id, c.user_id, c.content, c.parent_id, c.created_at, c.updated_at,
+		SELECT c.id, c.post_id, c.user_id, c.content, c.content_html, c.parent_id, c.created_at, c.updated_at,
 		       (SELECT COUNT(*) FROM likes WHERE comment_id = c.id) as like_count
 		FROM comments c
-		WHERE c.post_id = ? AND c.parent_id IS NULL
+		WHERE c.post_id = ? AND c.parent_id IS NULL AND c.is_hidden = FALSE
 		ORDER BY c.created_at ASC`, id)
 	if err != nil {
 		return nil, err
@@ -168,11 +363,13 @@ func GetPostByID(db *sql.DB, id int64) (*Post, error) {
 
 	for rows.Next() {
 		var comment Comment
+		var contentHTML sql.NullString
 		err := rows.Scan(
 			&comment.ID,
 			&comment.PostID,
 			&comment.UserID,
 			&comment.Content,
+			&contentHTML,
 			&comment.ParentID,
 			&comment.CreatedAt,
 			&comment.UpdatedAt,
@@ -181,6 +378,7 @@ func GetPostByID(db *sql.DB, id int64) (*Post, error) {
 		if err != nil {
 			return nil, err
 		}
+		comment.ContentHTML = renderedContent(comment.Content, contentHTML)
 
 		// Get comment author
 		commentAuthor, err := GetUserByID(db, comment.UserID)
@@ -198,121 +396,100 @@ func GetPostByID(db *sql.DB, id int64) (*Post, error) {
 		post.Comments = append(post.Comments, comment)
 	}
 
-	// Get like count
-	post.LikeCount, err = GetPostLikes(db, post.ID)
+	// Get attachments of the post and its comments
+	attachments, err := loadAttachments(db, "post_id", []int64{post.ID})
 	if err != nil {
 		return nil, err
 	}
-
-	return post, nil
-}
-
-// ListCategories returns all available categories
-func ListCategories(db *sql.DB) ([]Category, error) {
-	rows, err := db.Query(`
-		SELECT id, name, description, created_at 
-		FROM categories 
-		ORDER BY name`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var categories []Category
-	for rows.Next() {
-		var cat Category
-		if err := rows.Scan(&cat.ID, &cat.Name, &cat.Description, &cat.CreatedAt); err != nil {
-			return nil, err
-		}
-		categories = append(categories, cat)
-	}
-
-	if err = rows.Err(); err != nil {
+	post.Attachments = attachments[post.ID]
+	if err := attachCommentAttachments(db, post.Comments); err != nil {
 		return nil, err
 	}
 
-	return categories, nil
-}
-
-// ListPosts returns all posts with their categories and authors
-func ListPosts(db *sql.DB) ([]Post, error) {
-	// Get all posts
-	rows, err := db.Query(`
-		SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at
-		FROM posts p
-		ORDER BY p.created_at DESC`)
+	// Get like count
+	post.LikeCount, err = GetPostLikes(db, post.ID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	posts := make([]Post, 0) // Initialize as empty slice instead of nil slice
-	for rows.Next() {
-		var post Post
-		err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Title,
-			&post.Content,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
+	return post, nil
+}
 
-		// Get categories for this post
-		catRows, err := db.Query(`
-			SELECT c.id, c.name, c.description, c.created_at
-			FROM categories c
-			JOIN post_categories pc ON c.id = pc.category_id
-			WHERE pc.post_id = ?`, post.ID)
-		if err != nil {
-			return nil, err
-		}
-		defer catRows.Close()
+// PostFilter narrows down and orders ListPosts. Zero fields don't filter, and the
+// filters combine.
+type PostFilter struct {
+	AuthorID   int64
+	CategoryID int64
+	Liked      bool   // only posts the viewer liked
+	Bookmarked bool   // only posts the viewer bookmarked
+	FolderID   int64  // only posts the viewer bookmarked into this folder
+	Sort       string // one of the PostSort values, PostSortNewest when empty
+}
 
-		for catRows.Next() {
-			var cat Category
-			err := catRows.Scan(&cat.ID, &cat.Name, &cat.Description, &cat.CreatedAt)
-			if err != nil {
-				return nil, err
-			}
-			post.Categories = append(post.Categories, cat)
-		}
+// How ListPosts orders posts
+const (
+	PostSortNewest        = "newest"
+	PostSortOldest        = "oldest"
+	PostSortMostLiked     = "most_liked"
+	PostSortMostCommented = "most_commented"
+)
 
-		// Get author
-		author, err := GetUserByID(db, post.UserID)
-		if err != nil {
-			return nil, err
-		}
-		post.Author = author
+var postSortOrders = map[string]string{
+	PostSortNewest:        "p.created_at DESC, p.id DESC",
+	PostSortOldest:        "p.created_at ASC, p.id ASC",
+	PostSortMostLiked:     "like_count DESC, p.created_at DESC, p.id DESC",
+	PostSortMostCommented: "(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND c.is_hidden = FALSE) DESC, p.created_at DESC, p.id DESC",
+}
 
-		// Get like count
-		post.LikeCount, err = GetPostLikes(db, post.ID)
-		if err != nil {
-			return nil, err
+// ListPosts returns the posts matching a filter with their categories and authors, and
+// whether the viewer liked and bookmarked each. Hidden posts are left out, except when
+// viewers list their own posts.
+func ListPosts(db *sql.DB, viewerID int64, filter PostFilter) ([]Post, error) {
+	order := postSortOrders[PostSortNewest]
+	if filter.Sort != "" {
+		var ok bool
+		if order, ok = postSortOrders[filter.Sort]; !ok {
+			return nil, ErrInvalidPostSort
 		}
-
-		posts = append(posts, post)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+	var conditions []string
+	args := []interface{}{viewerID, viewerID}
+	if filter.AuthorID == 0 || filter.AuthorID != viewerID {
+		conditions = append(conditions, "p.is_hidden = FALSE")
+	}
+	if filter.AuthorID != 0 {
+		conditions = append(conditions, "p.user_id = ?")
+		args = append(args, filter.AuthorID)
+	}
+	if filter.CategoryID != 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = p.id AND pc.category_id = ?)")
+		args = append(args, filter.CategoryID)
+	}
+	if filter.Liked {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM likes l WHERE l.post_id = p.id AND l.user_id = ?)")
+		args = append(args, viewerID)
+	}
+	if filter.FolderID != 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM bookmarks b WHERE b.post_id = p.id AND b.user_id = ? AND b.folder_id = ?)")
+		args = append(args, viewerID, filter.FolderID)
+	} else if filter.Bookmarked {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM bookmarks b WHERE b.post_id = p.id AND b.user_id = ?)")
+		args = append(args, viewerID)
 	}
 
-	return posts, nil
-}
+	query := `
+		SELECT p.id, p.user_id, p.title, p.content, p.content_html, p.created_at, p.updated_at, p.is_hidden,
+		       (SELECT COUNT(*) FROM likes WHERE post_id = p.id) AS like_count,
+		       EXISTS (SELECT 1 FROM likes WHERE post_id = p.id AND user_id = ?),
+		       EXISTS (SELECT 1 FROM bookmarks WHERE post_id = p.id AND user_id = ?)
+		FROM posts p`
+	if len(conditions) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conditions, " AND ")
+	}
+	query += "\n\t\tORDER BY " + order
 
-// ListPostsByCategory returns all posts in a specific category
-func ListPostsByCategory(db *sql.DB, categoryID int64) ([]Post, error) {
-	// Get posts with a specific category
-	rows, err := db.Query(`
-		SELECT DISTINCT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at
-		FROM posts p
-		JOIN post_categories pc ON p.id = pc.post_id
-		WHERE pc.category_id = ?
-		ORDER BY p.created_at DESC`, categoryID)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -321,126 +498,64 @@ func ListPostsByCategory(db *sql.DB, categoryID int64) ([]Post, error) {
 	posts := make([]Post, 0) // Initialize as empty slice instead of nil slice
 	for rows.Next() {
 		var post Post
+		var contentHTML sql.NullString
 		err := rows.Scan(
 			&post.ID,
 			&post.UserID,
 			&post.Title,
 			&post.Content,
+			&contentHTML,
 			&post.CreatedAt,
 			&post.UpdatedAt,
+			&post.IsHidden,
+			&post.LikeCount,
+			&post.HasLiked,
+			&post.HasBookmarked,
 		)
 		if err != nil {
 			return nil, err
 		}
-
-		// Get categories for this post
-		catRows, err := db.Query(`
-			SELECT c.id, c.name, c.description, c.created_at
-			FROM categories c
-			JOIN post_categories pc ON c.id = pc.category_id
-			WHERE pc.post_id = ?`, post.ID)
-		if err != nil {
-			return nil, err
-		}
-		defer catRows.Close()
-
-		for catRows.Next() {
-			var cat Category
-			err := catRows.Scan(&cat.ID, &cat.Name, &cat.Description, &cat.CreatedAt)
-			if err != nil {
-				return nil, err
-			}
-			post.Categories = append(post.Categories, cat)
-		}
-
-		// Get author
-		author, err := GetUserByID(db, post.UserID)
-		if err != nil {
-			return nil, err
-		}
-		post.Author = author
-
-		// Get like count
-		post.LikeCount, err = GetPostLikes(db, post.ID)
-		if err != nil {
-			return nil, err
-		}
-
+		post.ContentHTML = renderedContent(post.Content, contentHTML)
 		posts = append(posts, post)
 	}
-
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	rows.Close()
 
-	return posts, nil
-}
-
-// ListPostsByUserID returns all posts created by a specific user
-func ListPostsByUserID(db *sql.DB, userID int64) ([]Post, error) {
-	// Get posts by user
-	rows, err := db.Query(`
-		SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at
-		FROM posts p
-		WHERE p.user_id = ?
-		ORDER BY p.created_at DESC`, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	posts := make([]Post, 0) // Initialize as empty slice instead of nil slice
-	for rows.Next() {
-		var post Post
-		err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Title,
-			&post.Content,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
+	for i := range posts {
+		post := &posts[i]
 
 		// Get categories for this post
 		catRows, err := db.Query(`
-			SELECT c.id, c.name, c.description, c.created_at
+			SELECT `+categoryColumns+`
 			FROM categories c
 			JOIN post_categories pc ON c.id = pc.category_id
 			WHERE pc.post_id = ?`, post.ID)
 		if err != nil {
 			return nil, err
 		}
-		defer catRows.Close()
-
 		for catRows.Next() {
-			var cat Category
-			err := catRows.Scan(&cat.ID, &cat.Name, &cat.Description, &cat.CreatedAt)
+			cat, err := scanCategory(catRows)
 			if err != nil {
+				catRows.Close()
 				return nil, err
 			}
 			post.Categories = append(post.Categories, cat)
 		}
-
-		// Get author
-		author, err := GetUserByID(db, post.UserID)
-		if err != nil {
+		catRows.Close()
+		if err := catRows.Err(); err != nil {
 			return nil, err
 		}
-		post.Author = author
 
-		// Get like count
-		post.LikeCount, err = GetPostLikes(db, post.ID)
+		// Get author
+		post.Author, err = GetUserByID(db, post.UserID)
 		if err != nil {
 			return nil, err
 		}
-
-		posts = append(posts, post)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := attachPostAttachments(db, posts); err != nil {
 		return nil, err
 	}
 
@@ -453,6 +568,10 @@ func CreateComment(db *sql.DB, postID, userID int64, req CreateCommentRequest) (
 		return nil, ErrEmptyComment
 	}
 
+	if err := CheckCanPost(db, userID); err != nil {
+		return nil, err
+	}
+
 	// Check if post exists
 	var exists bool
 	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID).Scan(&exists)
@@ -478,10 +597,16 @@ func CreateComment(db *sql.DB, postID, userID int64, req CreateCommentRequest) (
 		}
 	}
 
-	result, err := db.Exec(`
-		INSERT INTO comments (post_id, user_id, content, parent_id)
-		VALUES (?, ?, ?, ?)`,
-		postID, userID, req.Content, req.ParentID)
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO comments (post_id, user_id, content, content_html, parent_id)
+		VALUES (?, ?, ?, ?, ?)`,
+		postID, userID, req.Content, markdown.Render(req.Content), req.ParentID)
 	if err != nil {
 		return nil, err
 	}
@@ -491,14 +616,155 @@ func CreateComment(db *sql.DB, postID, userID int64, req CreateCommentRequest) (
 		return nil, err
 	}
 
-	return GetCommentByID(db, commentID)
+	if err := checkAttachments(tx, userID, req.AttachmentIDs); err != nil {
+		return nil, err
+	}
+	if err := linkAttachments(tx, "comment_id", commentID, userID, req.AttachmentIDs); err != nil {
+		return nil, err
+	}
+
+	mentioned, err := syncMentions(tx, userID, postID, &commentID, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	comment, err := GetCommentByID(db, commentID)
+	if err != nil {
+		return nil, err
+	}
+	comment.Mentioned = mentioned
+	return comment, nil
+}
+
+// UpdateComment changes the content of a comment
+func UpdateComment(db *sql.DB, commentID, editorID int64, content string) (*Comment, error) {
+	if err := CheckCanPost(db, editorID); err != nil {
+		return nil, err
+	}
+	if content == "" {
+		return nil, ErrEmptyComment
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE comments SET content = ?, content_html = ?, updated_at = ?
+		WHERE id = ?`,
+		content, markdown.Render(content), time.Now(), commentID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrCommentNotFound
+	}
+
+	// Mentions stay the author's when someone else edits the comment
+	var authorID, postID int64
+	err = tx.QueryRow("SELECT user_id, post_id FROM comments WHERE id = ?", commentID).Scan(&authorID, &postID)
+	if err != nil {
+		return nil, err
+	}
+	mentioned, err := syncMentions(tx, authorID, postID, &commentID, content)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	comment, err := GetCommentByID(db, commentID)
+	if err != nil {
+		return nil, err
+	}
+	comment.Mentioned = mentioned
+	return comment, nil
+}
+
+// commentSubtree selects a comment and every reply beneath it, at any depth, as
+// subtree(id). Statements using it take the comment ID as ?1.
+const commentSubtree = `WITH RECURSIVE subtree(id) AS (
+	SELECT ?1
+	UNION
+	SELECT c.id FROM comments c JOIN subtree s ON c.parent_id = s.id
+) `
+
+// DeleteComment removes a comment together with its replies at every depth, and their
+// likes, mentions, notifications and attachments
+func DeleteComment(db *sql.DB, commentID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DELETE FROM attachments WHERE comment_id IN (SELECT id FROM subtree)",
+		"DELETE FROM mentions WHERE comment_id IN (SELECT id FROM subtree)",
+		"DELETE FROM notifications WHERE comment_id IN (SELECT id FROM subtree) AND type != 'moderation'",
+		"UPDATE notifications SET comment_id = NULL WHERE comment_id IN (SELECT id FROM subtree)",
+		"DELETE FROM likes WHERE comment_id IN (SELECT id FROM subtree)",
+		"DELETE FROM comments WHERE id IN (SELECT id FROM subtree) AND id != ?1",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(commentSubtree+stmt, commentID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM comments WHERE id = ?", commentID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrCommentNotFound
+	}
+
+	return tx.Commit()
+}
+
+// GetCommentOwnerID returns the ID of the user who wrote a comment
+func GetCommentOwnerID(db *sql.DB, commentID int64) (int64, error) {
+	var ownerID int64
+	err := db.QueryRow("SELECT user_id FROM comments WHERE id = ?", commentID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return 0, ErrCommentNotFound
+	}
+	return ownerID, err
+}
+
+// GetCommentPostID returns the ID of the post a comment belongs to
+func GetCommentPostID(db *sql.DB, commentID int64) (int64, error) {
+	var postID int64
+	err := db.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID)
+	if err == sql.ErrNoRows {
+		return 0, ErrCommentNotFound
+	}
+	return postID, err
 }
 
 // GetCommentByID retrieves a comment by its ID
 func GetCommentByID(db *sql.DB, id int64) (*Comment, error) {
 	comment := &Comment{}
+	var contentHTML sql.NullString
 	err := db.QueryRow(`
-		SELECT c.id, c.post_id, c.user_id, c.content, c.parent_id, c.created_at, c.updated_at,
+		SELECT c.id, c.post_id, c.user_id, c.content, c.content_html, c.parent_id, c.created_at, c.updated_at, c.is_hidden,
 		       (SELECT COUNT(*) FROM likes WHERE comment_id = c.id) as like_count
 		FROM comments c
 		WHERE c.id = ?`, id).Scan(
@@ -506,14 +772,17 @@ func GetCommentByID(db *sql.DB, id int64) (*Comment, error) {
 		&comment.PostID,
 		&comment.UserID,
 		&comment.Content,
+		&contentHTML,
 		&comment.ParentID,
 		&comment.CreatedAt,
 		&comment.UpdatedAt,
+		&comment.IsHidden,
 		&comment.LikeCount,
 	)
 	if err != nil {
 		return nil, err
 	}
+	comment.ContentHTML = renderedContent(comment.Content, contentHTML)
 
 	// Get author
 	author, err := GetUserByID(db, comment.UserID)
@@ -524,9 +793,9 @@ func GetCommentByID(db *sql.DB, id int64) (*Comment, error) {
 
 	// Get replies if this is a parent comment
 	rows, err := db.Query(`
-		SELECT id, post_id, user_id, content, parent_id, created_at, updated_at
+		SELECT id, post_id, user_id, content, content_html, parent_id, created_at, updated_at
 		FROM comments
-		WHERE parent_id = ?
+		WHERE parent_id = ? AND is_hidden = FALSE
 		ORDER BY created_at ASC`, comment.ID)
 	if err != nil {
 		return nil, err
@@ -535,11 +804,13 @@ func GetCommentByID(db *sql.DB, id int64) (*Comment, error) {
 
 	for rows.Next() {
 		var reply Comment
+		var contentHTML sql.NullString
 		err := rows.Scan(
 			&reply.ID,
 			&reply.PostID,
 			&reply.UserID,
 			&reply.Content,
+			&contentHTML,
 			&reply.ParentID,
 			&reply.CreatedAt,
 			&reply.UpdatedAt,
@@ -547,6 +818,7 @@ func GetCommentByID(db *sql.DB, id int64) (*Comment, error) {
 		if err != nil {
 			return nil, err
 		}
+		reply.ContentHTML = renderedContent(reply.Content, contentHTML)
 
 		// Get reply author
 		replyAuthor, err := GetUserByID(db, reply.UserID)
@@ -564,16 +836,22 @@ func GetCommentByID(db *sql.DB, id int64) (*Comment, error) {
 		return nil, err
 	}
 
-	return comment, nil
+	// Get attachments of the comment and its replies
+	comments := []Comment{*comment}
+	if err := attachCommentAttachments(db, comments); err != nil {
+		return nil, err
+	}
+
+	return &comments[0], nil
 }
 
 // getCommentReplies returns all replies for a given comment
 func getCommentReplies(db *sql.DB, parentID int64) ([]Comment, error) {
 	rows, err := db.Query(`
-		SELECT c.id, c.post_id, c.user_id, c.content, c.parent_id, c.created_at, c.updated_at,
+		SELECT c.id, c.post_id, c.user_id, c.content, c.content_html, c.parent_id, c.created_at, c.updated_at,
 		       (SELECT COUNT(*) FROM likes WHERE comment_id = c.id) as like_count
 		FROM comments c
-		WHERE c.parent_id = ?
+		WHERE c.parent_id = ? AND c.is_hidden = FALSE
 		ORDER BY c.created_at ASC`, parentID)
 	if err != nil {
 		return nil, err
@@ -583,11 +861,13 @@ func getCommentReplies(db *sql.DB, parentID int64) ([]Comment, error) {
 	var replies []Comment
 	for rows.Next() {
 		var reply Comment
+		var contentHTML sql.NullString
 		err := rows.Scan(
 			&reply.ID,
 			&reply.PostID,
 			&reply.UserID,
 			&reply.Content,
+			&contentHTML,
 			&reply.ParentID,
 			&reply.CreatedAt,
 			&reply.UpdatedAt,
@@ -596,6 +876,7 @@ func getCommentReplies(db *sql.DB, parentID int64) ([]Comment, error) {
 		if err != nil {
 			return nil, err
 		}
+		reply.ContentHTML = renderedContent(reply.Content, contentHTML)
 
 		// Get reply author
 		replyAuthor, err := GetUserByID(db, reply.UserID)
@@ -698,7 +979,7 @@ func HasUserLikedComment(db *sql.DB, commentID, userID int64) (bool, error) {
 	return exists, err
 }
 
-func validateCreatePostRequest(req CreatePostRequest) error {
+func validateCreatePostRequest(db *sql.DB, req CreatePostRequest, role string, allowed map[int64]bool) error {
 	if req.Title == "" {
 		return ErrEmptyTitle
 	}
@@ -708,5 +989,37 @@ func validateCreatePostRequest(req CreatePostRequest) error {
 	if len(req.CategoryIDs) == 0 {
 		return ErrNoCategories
 	}
+	return validatePostCategories(db, req.CategoryIDs, role, allowed)
+}
+
+// SetPostHidden hides or restores a post for regular users
+func SetPostHidden(db *sql.DB, postID int64, hidden bool) error {
+	result, err := db.Exec("UPDATE posts SET is_hidden = ? WHERE id = ?", hidden, postID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrPostNotFound
+	}
+	return nil
+}
+
+// SetCommentHidden hides or restores a comment for regular users
+func SetCommentHidden(db *sql.DB, commentID int64, hidden bool) error {
+	result, err := db.Exec("UPDATE comments SET is_hidden = ? WHERE id = ?", hidden, commentID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrCommentNotFound
+	}
 	return nil
 }
@@ -0,0 +1,58 @@
+package models
+
+import "testing"
+
+func TestDeleteCommentRemovesNestedReplies(t *testing.T) {
+	db := newTestDB(t)
+	author := newTestUser(t, db, "alice")
+	reader := newTestUser(t, db, "bob")
+
+	post, err := CreatePost(db, author.ID, CreatePostRequest{Title: "Thread", Content: "Body", CategoryIDs: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := func(parentID *int64) int64 {
+		t.Helper()
+		comment, err := CreateComment(db, post.ID, reader.ID, CreateCommentRequest{Content: "reply", ParentID: parentID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return comment.ID
+	}
+
+	// top <- doomed <- child <- grandchild <- great-grandchild, and a sibling of doomed
+	top := reply(nil)
+	doomed := reply(&top)
+	sibling := reply(&top)
+	chain := []int64{doomed}
+	for i := 0; i < 3; i++ {
+		parent := chain[len(chain)-1]
+		chain = append(chain, reply(&parent))
+	}
+	deepest := chain[len(chain)-1]
+	if err := LikeComment(db, deepest, author.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteComment(db, doomed); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range chain {
+		if _, err := GetCommentOwnerID(db, id); err != ErrCommentNotFound {
+			t.Errorf("comment %d under the deleted one: %v, want ErrCommentNotFound", id, err)
+		}
+	}
+	for _, id := range []int64{top, sibling} {
+		if _, err := GetCommentOwnerID(db, id); err != nil {
+			t.Errorf("comment %d outside the deleted subtree: %v", id, err)
+		}
+	}
+	if likes, err := GetCommentLikes(db, deepest); err != nil || likes != 0 {
+		t.Errorf("likes of a deleted grandchild: %d, %v", likes, err)
+	}
+
+	if err := DeleteComment(db, doomed); err != ErrCommentNotFound {
+		t.Errorf("deleting again: %v, want ErrCommentNotFound", err)
+	}
+}
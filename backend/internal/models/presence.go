@@ -0,0 +1,53 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Presence statuses. Users choose online, away, dnd or invisible; other users see an
+// invisible or disconnected user as offline, and an idle online user as away.
+const (
+	StatusOnline    = "online"
+	StatusAway      = "away"
+	StatusDND       = "dnd"
+	StatusInvisible = "invisible"
+	StatusOffline   = "offline"
+)
+
+var ErrInvalidStatus = errors.New("status must be online, away, dnd or invisible")
+
+// ValidStatus reports whether a user may choose status
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusOnline, StatusAway, StatusDND, StatusInvisible:
+		return true
+	}
+	return false
+}
+
+// SetUserStatus stores the presence status a user chose
+func SetUserStatus(db *sql.DB, userID int64, status string) error {
+	if !ValidStatus(status) {
+		return ErrInvalidStatus
+	}
+	result, err := db.Exec("UPDATE users SET status = ? WHERE id = ?", status, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdateLastSeen records when a user's last connection closed
+func UpdateLastSeen(db *sql.DB, userID int64, at time.Time) error {
+	_, err := db.Exec("UPDATE users SET last_seen_at = ? WHERE id = ?", at, userID)
+	return err
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrPushSubscriptionNotFound = errors.New("push subscription not found")
+
+// PushSubscription is a browser a user turned on push notifications in
+type PushSubscription struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Endpoint   string     `json:"endpoint"`
+	P256dh     string     `json:"-"`
+	Auth       string     `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// SavePushSubscription stores a subscription for a user. A browser has one endpoint, so
+// subscribing again, or as another user after switching accounts, replaces the old row.
+func SavePushSubscription(db *sql.DB, sub *PushSubscription) error {
+	_, err := db.Exec(`
+		INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, user_agent)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET
+			user_id = excluded.user_id, p256dh = excluded.p256dh, auth = excluded.auth,
+			user_agent = excluded.user_agent, created_at = CURRENT_TIMESTAMP, last_used_at = NULL`,
+		sub.UserID, sub.Endpoint, sub.P256dh, sub.Auth, sub.UserAgent)
+	if err != nil {
+		return err
+	}
+	return db.QueryRow("SELECT id, created_at FROM push_subscriptions WHERE endpoint = ?",
+		sub.Endpoint).Scan(&sub.ID, &sub.CreatedAt)
+}
+
+// DeletePushSubscription removes one of a user's subscriptions by endpoint
+func DeletePushSubscription(db *sql.DB, userID int64, endpoint string) error {
+	result, err := db.Exec("DELETE FROM push_subscriptions WHERE user_id = ? AND endpoint = ?", userID, endpoint)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrPushSubscriptionNotFound
+	}
+	return nil
+}
+
+// PrunePushSubscription removes a subscription the push service reported gone
+func PrunePushSubscription(db *sql.DB, id int64) error {
+	_, err := db.Exec("DELETE FROM push_subscriptions WHERE id = ?", id)
+	return err
+}
+
+// TouchPushSubscription records that a push was delivered to a subscription
+func TouchPushSubscription(db *sql.DB, id int64, at time.Time) error {
+	_, err := db.Exec("UPDATE push_subscriptions SET last_used_at = ? WHERE id = ?", at, id)
+	return err
+}
+
+// GetPushSubscriptions returns every subscription of a user
+func GetPushSubscriptions(db *sql.DB, userID int64) ([]PushSubscription, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, endpoint, p256dh, auth, user_agent, created_at, last_used_at
+		FROM push_subscriptions
+		WHERE user_id = ?
+		ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []PushSubscription{}
+	for rows.Next() {
+		var sub PushSubscription
+		err := rows.Scan(&sub.ID, &sub.UserID, &sub.Endpoint, &sub.P256dh, &sub.Auth,
+			&sub.UserAgent, &sub.CreatedAt, &sub.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	return subscriptions, rows.Err()
+}
+
+// GetOrCreateVAPIDKey returns the stored VAPID private key, storing generate's key
+// first if there is none. Instances racing to create it all end up with the same one.
+func GetOrCreateVAPIDKey(db *sql.DB, generate func() (string, error)) (string, error) {
+	var key string
+	err := db.QueryRow("SELECT private_key FROM vapid_keys WHERE id = 1").Scan(&key)
+	if err != sql.ErrNoRows {
+		return key, err
+	}
+
+	key, err = generate()
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.Exec("INSERT OR IGNORE INTO vapid_keys (id, private_key) VALUES (1, ?)", key); err != nil {
+		return "", err
+	}
+	err = db.QueryRow("SELECT private_key FROM vapid_keys WHERE id = 1").Scan(&key)
+	return key, err
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Receipt statuses
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// Receipt records when one recipient's client received and read a message
+type Receipt struct {
+	MessageID   int64      `json:"message_id"`
+	UserID      int64      `json:"user_id"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// ReadMessage identifies a message that was just marked read, so its sender can be told
+type ReadMessage struct {
+	MessageID      int64
+	ConversationID int64
+	SenderID       int64
+}
+
+// MarkMessageDelivered records that a message reached one of userID's clients.
+// It reports false when the message had already been delivered to that user.
+func MarkMessageDelivered(db *sql.DB, messageID, userID int64) (bool, error) {
+	result, err := db.Exec(`
+		INSERT INTO message_receipts (message_id, user_id, delivered_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (message_id, user_id) DO UPDATE SET delivered_at = excluded.delivered_at
+		WHERE message_receipts.delivered_at IS NULL`,
+		messageID, userID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// MarkConversationRead records that userID has read a conversation up to and including
+// message upToID, or all of it when upToID is 0. It returns the messages that became
+// read so their senders can be notified.
+func MarkConversationRead(db *sql.DB, conversationID, userID, upToID int64) ([]ReadMessage, error) {
+	if _, err := GetMemberRole(db, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	readUntil := time.Now()
+	if upToID != 0 {
+		err := db.QueryRow("SELECT created_at FROM messages WHERE id = ? AND conversation_id = ?",
+			upToID, conversationID).Scan(&readUntil)
+		if err == sql.ErrNoRows {
+			return nil, ErrMessageNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT m.id, m.sender_id
+		FROM messages m
+		WHERE m.conversation_id = ? AND m.sender_id != ? AND m.deleted_at IS NULL
+		  AND (? = 0 OR m.id <= ?)
+		  AND NOT EXISTS (
+			SELECT 1 FROM message_receipts r
+			WHERE r.message_id = m.id AND r.user_id = ? AND r.read_at IS NOT NULL
+		  )`,
+		conversationID, userID, upToID, upToID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var read []ReadMessage
+	for rows.Next() {
+		message := ReadMessage{ConversationID: conversationID}
+		if err := rows.Scan(&message.MessageID, &message.SenderID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		read = append(read, message)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, message := range read {
+		// Reading a message implies it was delivered, even if no socket saw it
+		_, err := tx.Exec(`
+			INSERT INTO message_receipts (message_id, user_id, delivered_at, read_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (message_id, user_id) DO UPDATE SET
+				delivered_at = COALESCE(message_receipts.delivered_at, excluded.delivered_at),
+				read_at = excluded.read_at`,
+			message.MessageID, userID, now, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE conversation_members SET last_read_at = ?
+		WHERE conversation_id = ? AND user_id = ? AND (last_read_at IS NULL OR last_read_at < ?)`,
+		readUntil, conversationID, userID, readUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the per-message flag used by direct conversations in step
+	_, err = tx.Exec(`
+		UPDATE messages SET is_read = TRUE
+		WHERE conversation_id = ? AND receiver_id = ? AND is_read = FALSE AND (? = 0 OR id <= ?)`,
+		conversationID, userID, upToID, upToID)
+	if err != nil {
+		return nil, err
+	}
+
+	return read, tx.Commit()
+}
+
+// attachReceipts fills in the receipts of the messages viewerID sent
+func attachReceipts(db *sql.DB, viewerID int64, messages []PrivateMessage) error {
+	index := make(map[int64]int)
+	var args []interface{}
+	for i, message := range messages {
+		if message.SenderID == viewerID {
+			index[message.ID] = i
+			args = append(args, message.ID)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT message_id, user_id, delivered_at, read_at
+		FROM message_receipts
+		WHERE message_id IN (?`+strings.Repeat(", ?", len(args)-1)+`)
+		ORDER BY message_id, user_id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var receipt Receipt
+		if err := rows.Scan(&receipt.MessageID, &receipt.UserID, &receipt.DeliveredAt, &receipt.ReadAt); err != nil {
+			return err
+		}
+		i := index[receipt.MessageID]
+		messages[i].Receipts = append(messages[i].Receipts, receipt)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,387 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Report is a user complaint about a post, comment or private message
+type Report struct {
+	ID             int64      `json:"id"`
+	ReporterID     int64      `json:"reporter_id"`
+	TargetType     string     `json:"target_type"`
+	TargetID       int64      `json:"target_id"`
+	TargetUserID   int64      `json:"target_user_id"`
+	TargetContent  string     `json:"target_content"`
+	ReasonCode     string     `json:"reason_code"`
+	Details        string     `json:"details"`
+	Status         string     `json:"status"`
+	ClaimedBy      *int64     `json:"claimed_by,omitempty"`
+	ClaimedAt      *time.Time `json:"claimed_at,omitempty"`
+	ResolvedBy     *int64     `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	Action         *string    `json:"action,omitempty"`
+	ResolutionNote string     `json:"resolution_note"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Reporter       *User      `json:"reporter,omitempty"`
+	TargetUser     *User      `json:"target_user,omitempty"`
+}
+
+type CreateReportRequest struct {
+	TargetType string `json:"target_type"`
+	TargetID   int64  `json:"target_id"`
+	ReasonCode string `json:"reason_code"`
+	Details    string `json:"details"`
+}
+
+type ResolveReportRequest struct {
+	Action        string `json:"action"`
+	Note          string `json:"note"`
+	DurationHours int    `json:"duration_hours,omitempty"` // mute or ban length; 0 means permanent
+}
+
+// ReportFilter narrows the moderation queue
+type ReportFilter struct {
+	Status     string
+	TargetType string
+	ReasonCode string
+	ClaimedBy  int64
+	Limit      int
+	Offset     int
+}
+
+// Report target types
+const (
+	ReportTargetPost    = "post"
+	ReportTargetComment = "comment"
+	ReportTargetMessage = "message"
+)
+
+// Report statuses
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusClaimed   = "claimed"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+)
+
+// Moderation actions that resolve a report
+const (
+	ModerationActionNone   = "none"
+	ModerationActionHide   = "hide"
+	ModerationActionDelete = "delete"
+	ModerationActionWarn   = "warn"
+	ModerationActionMute   = "mute"
+	ModerationActionBan    = "ban"
+)
+
+// ReportReasons lists the accepted reason codes
+var ReportReasons = []string{"spam", "harassment", "hate", "sexual", "violence", "self_harm", "misinformation", "other"}
+
+var (
+	ErrInvalidReportTarget = errors.New("target_type must be 'post', 'comment', or 'message'")
+	ErrInvalidReportReason = errors.New("invalid reason code")
+	ErrReportTargetMissing = errors.New("reported content not found")
+	ErrCannotReportSelf    = errors.New("you cannot report your own content")
+	ErrDuplicateReport     = errors.New("you have already reported this content")
+	ErrReportNotFound      = errors.New("report not found")
+	ErrReportClosed        = errors.New("report has already been resolved")
+	ErrReportClaimed       = errors.New("report is claimed by another moderator")
+	ErrInvalidAction       = errors.New("action must be 'none', 'hide', 'delete', 'warn', 'mute', or 'ban'")
+)
+
+const reportColumns = `r.id, r.reporter_id, r.target_type, r.target_id, r.target_user_id, r.target_content,
+		       r.reason_code, r.details, r.status, r.claimed_by, r.claimed_at, r.resolved_by, r.resolved_at,
+		       r.action, r.resolution_note, r.created_at,
+		       ru.username, tu.username`
+
+func scanReport(scanner interface{ Scan(...interface{}) error }) (*Report, error) {
+	var report Report
+	var reporter, target User
+	err := scanner.Scan(
+		&report.ID,
+		&report.ReporterID,
+		&report.TargetType,
+		&report.TargetID,
+		&report.TargetUserID,
+		&report.TargetContent,
+		&report.ReasonCode,
+		&report.Details,
+		&report.Status,
+		&report.ClaimedBy,
+		&report.ClaimedAt,
+		&report.ResolvedBy,
+		&report.ResolvedAt,
+		&report.Action,
+		&report.ResolutionNote,
+		&report.CreatedAt,
+		&reporter.Username,
+		&target.Username,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	reporter.ID = report.ReporterID
+	target.ID = report.TargetUserID
+	report.Reporter = &reporter
+	report.TargetUser = &target
+	return &report, nil
+}
+
+// CreateReport files a report against a piece of content
+func CreateReport(db *sql.DB, reporterID int64, req CreateReportRequest) (*Report, error) {
+	if !isValidReason(req.ReasonCode) {
+		return nil, ErrInvalidReportReason
+	}
+
+	ownerID, content, err := reportTarget(db, reporterID, req.TargetType, req.TargetID)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID == reporterID {
+		return nil, ErrCannotReportSelf
+	}
+
+	var exists bool
+	err = db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM reports
+			WHERE reporter_id = ? AND target_type = ? AND target_id = ?
+		)`, reporterID, req.TargetType, req.TargetID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrDuplicateReport
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO reports (reporter_id, target_type, target_id, target_user_id, target_content, reason_code, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		reporterID, req.TargetType, req.TargetID, ownerID, content, req.ReasonCode,
+		strings.TrimSpace(req.Details), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return GetReport(db, id)
+}
+
+// GetReport retrieves a single report
+func GetReport(db *sql.DB, id int64) (*Report, error) {
+	report, err := scanReport(db.QueryRow(`
+		SELECT `+reportColumns+`
+		FROM reports r
+		JOIN users ru ON ru.id = r.reporter_id
+		JOIN users tu ON tu.id = r.target_user_id
+		WHERE r.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	return report, err
+}
+
+// ListReports returns reports for the moderation queue, oldest first
+func ListReports(db *sql.DB, filter ReportFilter) ([]Report, error) {
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports r
+		JOIN users ru ON ru.id = r.reporter_id
+		JOIN users tu ON tu.id = r.target_user_id
+		WHERE 1 = 1`
+	var args []interface{}
+
+	if filter.Status != "" {
+		query += " AND r.status = ?"
+		args = append(args, filter.Status)
+	}
+	if filter.TargetType != "" {
+		query += " AND r.target_type = ?"
+		args = append(args, filter.TargetType)
+	}
+	if filter.ReasonCode != "" {
+		query += " AND r.reason_code = ?"
+		args = append(args, filter.ReasonCode)
+	}
+	if filter.ClaimedBy != 0 {
+		query += " AND r.claimed_by = ?"
+		args = append(args, filter.ClaimedBy)
+	}
+
+	query += " ORDER BY r.created_at ASC, r.id ASC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := make([]Report, 0)
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// ClaimReport assigns an open report to a moderator
+func ClaimReport(db *sql.DB, id, moderatorID int64) (*Report, error) {
+	result, err := db.Exec(`
+		UPDATE reports SET status = ?, claimed_by = ?, claimed_at = ?
+		WHERE id = ? AND (status = ? OR (status = ? AND claimed_by = ?))`,
+		ReportStatusClaimed, moderatorID, time.Now(),
+		id, ReportStatusOpen, ReportStatusClaimed, moderatorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReportTransition(db, result, id); err != nil {
+		return nil, err
+	}
+	return GetReport(db, id)
+}
+
+// ReleaseReport returns a claimed report to the open queue
+func ReleaseReport(db *sql.DB, id, moderatorID int64) (*Report, error) {
+	result, err := db.Exec(`
+		UPDATE reports SET status = ?, claimed_by = NULL, claimed_at = NULL
+		WHERE id = ? AND status = ? AND claimed_by = ?`,
+		ReportStatusOpen, id, ReportStatusClaimed, moderatorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReportTransition(db, result, id); err != nil {
+		return nil, err
+	}
+	return GetReport(db, id)
+}
+
+// CloseReport marks a report, and any other unresolved reports against the same
+// content, as resolved with the given action. It returns the IDs of every report closed.
+// Applying the action itself is up to the caller.
+func CloseReport(db *sql.DB, report *Report, moderatorID int64, action, note string) ([]int64, error) {
+	status := ReportStatusResolved
+	if action == ModerationActionNone {
+		status = ReportStatusDismissed
+	}
+
+	rows, err := db.Query(`
+		SELECT id FROM reports
+		WHERE target_type = ? AND target_id = ? AND status IN (?, ?)`,
+		report.TargetType, report.TargetID, ReportStatusOpen, ReportStatusClaimed)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	_, err = db.Exec(`
+		UPDATE reports
+		SET status = ?, resolved_by = ?, resolved_at = ?, action = ?, resolution_note = ?
+		WHERE target_type = ? AND target_id = ? AND status IN (?, ?)`,
+		status, moderatorID, time.Now(), action, strings.TrimSpace(note),
+		report.TargetType, report.TargetID, ReportStatusOpen, ReportStatusClaimed)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// IsValidModerationAction reports whether action can resolve a report
+func IsValidModerationAction(action string) bool {
+	switch action {
+	case ModerationActionNone, ModerationActionHide, ModerationActionDelete, ModerationActionWarn,
+		ModerationActionMute, ModerationActionBan:
+		return true
+	}
+	return false
+}
+
+// IsOpen reports whether a report still needs a decision
+func (r *Report) IsOpen() bool {
+	return r.Status == ReportStatusOpen || r.Status == ReportStatusClaimed
+}
+
+// checkReportTransition explains why a conditional report update touched no rows
+func checkReportTransition(db *sql.DB, result sql.Result, id int64) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	report, err := GetReport(db, id)
+	if err != nil {
+		return err
+	}
+	if !report.IsOpen() {
+		return ErrReportClosed
+	}
+	return ErrReportClaimed
+}
+
+// reportTarget resolves the owner and a content snapshot for the reported item.
+// Private messages can only be reported by a member of their conversation.
+func reportTarget(db *sql.DB, reporterID int64, targetType string, targetID int64) (int64, string, error) {
+	var ownerID int64
+	var content string
+	var err error
+
+	switch targetType {
+	case ReportTargetPost:
+		err = db.QueryRow("SELECT user_id, title || '\n\n' || content FROM posts WHERE id = ?", targetID).
+			Scan(&ownerID, &content)
+	case ReportTargetComment:
+		err = db.QueryRow("SELECT user_id, content FROM comments WHERE id = ?", targetID).
+			Scan(&ownerID, &content)
+	case ReportTargetMessage:
+		err = db.QueryRow(`
+			SELECT sender_id, content FROM messages
+			WHERE id = ? AND deleted_at IS NULL
+			  AND (sender_id = ? OR receiver_id = ? OR conversation_id IN (
+			      SELECT conversation_id FROM conversation_members WHERE user_id = ?))`,
+			targetID, reporterID, reporterID, reporterID).Scan(&ownerID, &content)
+	default:
+		return 0, "", ErrInvalidReportTarget
+	}
+
+	if err == sql.ErrNoRows {
+		return 0, "", ErrReportTargetMissing
+	}
+	return ownerID, content, err
+}
+
+func isValidReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Roles a user can hold, from least to most privileged
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permission names a single action that is granted by a role
+type Permission string
+
+const (
+	PermCreatePost       Permission = "post:create"
+	PermCreateComment    Permission = "comment:create"
+	PermEditOwnContent   Permission = "content:edit_own"
+	PermDeleteOwnContent Permission = "content:delete_own"
+	PermEditAnyContent   Permission = "content:edit_any"
+	PermDeleteAnyContent Permission = "content:delete_any"
+	PermModerate         Permission = "moderation:act"
+	PermManageCategories Permission = "category:manage"
+	PermManageRoles      Permission = "role:manage"
+)
+
+// rolePermissions lists what each role may do. Higher roles include everything below them.
+var rolePermissions = map[string][]Permission{
+	RoleUser: {
+		PermCreatePost,
+		PermCreateComment,
+		PermEditOwnContent,
+		PermDeleteOwnContent,
+	},
+	RoleModerator: {
+		PermDeleteAnyContent,
+		PermModerate,
+	},
+	RoleAdmin: {
+		PermEditAnyContent,
+		PermManageCategories,
+		PermManageRoles,
+	},
+}
+
+// roleRank orders roles so higher roles inherit lower role permissions
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+var (
+	ErrInvalidRole  = errors.New("role must be 'user', 'moderator', or 'admin'")
+	ErrUserNotFound = errors.New("user not found")
+	ErrForbidden    = errors.New("you do not have permission to perform this action")
+	ErrLastAdmin    = errors.New("cannot demote the last admin")
+)
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role is the same as or more privileged than minimum
+func RoleAtLeast(role, minimum string) bool {
+	return roleRank[role] >= roleRank[minimum] && roleRank[role] > 0
+}
+
+// RoleHasPermission reports whether a role grants a permission
+func RoleHasPermission(role string, perm Permission) bool {
+	for r, perms := range rolePermissions {
+		if !RoleAtLeast(role, r) {
+			continue
+		}
+		for _, p := range perms {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetUserRole retrieves the role of a user
+func GetUserRole(db *sql.DB, userID int64) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	return role, err
+}
+
+// UserCan checks whether a user's role grants a permission
+func UserCan(db *sql.DB, userID int64, perm Permission) (bool, error) {
+	role, err := GetUserRole(db, userID)
+	if err != nil {
+		return false, err
+	}
+	return RoleHasPermission(role, perm), nil
+}
+
+// SetUserRole changes the role of a user. It refuses to demote the last admin, checking
+// in the same statement as the update so two admins demoting each other can't both succeed.
+func SetUserRole(db *sql.DB, userID int64, role string) error {
+	if !IsValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	result, err := db.Exec(`
+		UPDATE users SET role = ?1
+		WHERE id = ?2 AND (role != ?3 OR ?1 = ?3 OR (SELECT COUNT(*) FROM users WHERE role = ?3) > 1)`,
+		role, userID, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		if _, err := GetUserRole(db, userID); err != nil {
+			return err
+		}
+		return ErrLastAdmin
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+)
+
+func countAdmins(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var admins int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", RoleAdmin).Scan(&admins); err != nil {
+		t.Fatal(err)
+	}
+	return admins
+}
+
+func TestSetUserRoleKeepsLastAdmin(t *testing.T) {
+	db := newTestDB(t)
+	admin := newTestUser(t, db, "alice")
+	if err := SetUserRole(db, admin.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetUserRole(db, admin.ID, RoleUser); err != ErrLastAdmin {
+		t.Fatalf("demoting the only admin: %v, want ErrLastAdmin", err)
+	}
+	if err := SetUserRole(db, admin.ID, RoleAdmin); err != nil {
+		t.Errorf("keeping the only admin an admin: %v", err)
+	}
+	if err := SetUserRole(db, 9999, RoleUser); err != ErrUserNotFound {
+		t.Errorf("unknown user: %v, want ErrUserNotFound", err)
+	}
+}
+
+// Two admins demoting each other at once must leave one of them an admin
+func TestSetUserRoleConcurrentDemotions(t *testing.T) {
+	db := newTestDB(t)
+	var admins []int64
+	for _, name := range []string{"alice", "bob"} {
+		admins = append(admins, newTestUser(t, db, name).ID)
+	}
+
+	for round := 0; round < 20; round++ {
+		for _, userID := range admins {
+			if err := SetUserRole(db, userID, RoleAdmin); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(admins))
+		for i, userID := range admins {
+			wg.Add(1)
+			go func(i int, userID int64) {
+				defer wg.Done()
+				errs[i] = SetUserRole(db, userID, RoleModerator)
+			}(i, userID)
+		}
+		wg.Wait()
+
+		if n := countAdmins(t, db); n != 1 {
+			t.Fatalf("round %d: %d admins left (errors %v), want 1", round, n, errs)
+		}
+		if (errs[0] == nil) == (errs[1] == nil) {
+			t.Fatalf("round %d: want exactly one demotion to succeed, got %v", round, errs)
+		}
+	}
+}
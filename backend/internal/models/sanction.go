@@ -0,0 +1,185 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Sanction is a moderator action against a user account
+type Sanction struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	ModeratorID *int64     `json:"moderator_id,omitempty"`
+	Type        string     `json:"type"`
+	Reason      string     `json:"reason"`
+	ReportID    *int64     `json:"report_id,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Sanction types
+const (
+	SanctionWarning = "warning"
+	SanctionBan     = "ban"
+	SanctionMute    = "mute"
+)
+
+var (
+	ErrInvalidSanctionType = errors.New("type must be 'warning', 'ban', or 'mute'")
+	ErrSanctionNotFound    = errors.New("sanction not found")
+	ErrUserBanned          = errors.New("your account is banned")
+	ErrUserMuted           = errors.New("you are muted and cannot post or send messages")
+)
+
+const sanctionColumns = `id, user_id, moderator_id, type, reason, report_id, expires_at, revoked_at, created_at`
+
+func scanSanction(scanner interface{ Scan(...interface{}) error }) (*Sanction, error) {
+	var s Sanction
+	err := scanner.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.ModeratorID,
+		&s.Type,
+		&s.Reason,
+		&s.ReportID,
+		&s.ExpiresAt,
+		&s.RevokedAt,
+		&s.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateSanction records a warning, ban or mute. A nil expiresAt means it never expires.
+func CreateSanction(db *sql.DB, userID int64, moderatorID, reportID *int64, sanctionType, reason string, expiresAt *time.Time) (*Sanction, error) {
+	if sanctionType != SanctionWarning && sanctionType != SanctionBan && sanctionType != SanctionMute {
+		return nil, ErrInvalidSanctionType
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO user_sanctions (user_id, moderator_id, type, reason, report_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, moderatorID, sanctionType, strings.TrimSpace(reason), reportID, expiresAt, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSanction(db.QueryRow("SELECT "+sanctionColumns+" FROM user_sanctions WHERE id = ?", id))
+}
+
+// GetActiveSanction returns the longest-running unrevoked, unexpired sanction of a type,
+// or nil if the user has none
+func GetActiveSanction(db *sql.DB, userID int64, sanctionType string) (*Sanction, error) {
+	sanction, err := scanSanction(db.QueryRow(`
+		SELECT `+sanctionColumns+`
+		FROM user_sanctions
+		WHERE user_id = ? AND type = ? AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY expires_at IS NULL DESC, expires_at DESC
+		LIMIT 1`, userID, sanctionType, time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return sanction, err
+}
+
+// CheckCanPost returns ErrUserBanned or ErrUserMuted if the user may not create content
+func CheckCanPost(db *sql.DB, userID int64) error {
+	for _, sanctionType := range []string{SanctionBan, SanctionMute} {
+		sanction, err := GetActiveSanction(db, userID, sanctionType)
+		if err != nil {
+			return err
+		}
+		if sanction != nil {
+			if sanctionType == SanctionBan {
+				return ErrUserBanned
+			}
+			return ErrUserMuted
+		}
+	}
+	return nil
+}
+
+// ListSanctions returns every sanction issued against a user, newest first
+func ListSanctions(db *sql.DB, userID int64) ([]Sanction, error) {
+	rows, err := db.Query(`
+		SELECT `+sanctionColumns+`
+		FROM user_sanctions
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sanctions := make([]Sanction, 0)
+	for rows.Next() {
+		sanction, err := scanSanction(rows)
+		if err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, *sanction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sanctions, nil
+}
+
+// GetSanction retrieves a single sanction
+func GetSanction(db *sql.DB, id int64) (*Sanction, error) {
+	sanction, err := scanSanction(db.QueryRow("SELECT "+sanctionColumns+" FROM user_sanctions WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrSanctionNotFound
+	}
+	return sanction, err
+}
+
+// RevokeSanction lifts a ban or mute before it expires
+func RevokeSanction(db *sql.DB, id int64) (*Sanction, error) {
+	result, err := db.Exec(`
+		UPDATE user_sanctions SET revoked_at = ?
+		WHERE id = ? AND revoked_at IS NULL`, time.Now(), id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		if _, err := GetSanction(db, id); err != nil {
+			return nil, err
+		}
+	}
+	return GetSanction(db, id)
+}
+
+// Describe explains a ban or mute to the affected user, e.g. "banned until ...: reason"
+func (s *Sanction) Describe() string {
+	verb := "banned"
+	if s.Type == SanctionMute {
+		verb = "muted"
+	}
+
+	text := "You are " + verb + " permanently"
+	if s.ExpiresAt != nil {
+		text = "You are " + verb + " until " + s.ExpiresAt.UTC().Format(time.RFC1123)
+	}
+	if s.Reason != "" {
+		text += ": " + s.Reason
+	}
+	return text
+}
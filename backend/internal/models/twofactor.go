@@ -0,0 +1,315 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TwoFactor holds a user's TOTP configuration
+type TwoFactor struct {
+	UserID       int64      `json:"user_id"`
+	Secret       string     `json:"-"`
+	Enabled      bool       `json:"enabled"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+}
+
+const (
+	RecoveryCodeCount      = 10
+	LoginChallengeDuration = 5 * time.Minute
+	MaxChallengeAttempts   = 5
+
+	// MaxSecondFactorFailures wrong codes in a row lock a user's second factor for
+	// SecondFactorLockout, however many login challenges they were spread over
+	MaxSecondFactorFailures = 5
+	SecondFactorLockout     = 15 * time.Minute
+)
+
+var (
+	ErrTwoFactorNotConfigured  = errors.New("two-factor authentication is not configured")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrInvalidChallenge        = errors.New("login challenge is invalid or expired")
+	ErrSecondFactorLocked      = errors.New("too many failed two-factor attempts, try again later")
+)
+
+// GetTwoFactor retrieves the TOTP configuration for a user
+func GetTwoFactor(db *sql.DB, userID int64) (*TwoFactor, error) {
+	var tf TwoFactor
+	err := db.QueryRow(`
+		SELECT user_id, secret, enabled, last_used_step, created_at, enabled_at
+		FROM two_factor WHERE user_id = ?`, userID).Scan(
+		&tf.UserID,
+		&tf.Secret,
+		&tf.Enabled,
+		&tf.LastUsedStep,
+		&tf.CreatedAt,
+		&tf.EnabledAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrTwoFactorNotConfigured
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// IsTwoFactorEnabled reports whether a user must pass a second factor on login
+func IsTwoFactorEnabled(db *sql.DB, userID int64) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM two_factor WHERE user_id = ? AND enabled = TRUE)",
+		userID).Scan(&enabled)
+	return enabled, err
+}
+
+// SaveTwoFactorSecret stores a pending (not yet enabled) TOTP secret for a user
+func SaveTwoFactorSecret(db *sql.DB, userID int64, secret string) error {
+	enabled, err := IsTwoFactorEnabled(db, userID)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		return ErrTwoFactorAlreadyEnabled
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO two_factor (user_id, secret, enabled, last_used_step, created_at)
+		VALUES (?, ?, FALSE, 0, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_used_step = 0, created_at = excluded.created_at`,
+		userID, secret, time.Now())
+	return err
+}
+
+// EnableTwoFactor turns on a pending TOTP configuration after the first code was verified
+func EnableTwoFactor(db *sql.DB, userID, step int64) error {
+	result, err := db.Exec(`
+		UPDATE two_factor
+		SET enabled = TRUE, enabled_at = ?, last_used_step = ?
+		WHERE user_id = ?`,
+		time.Now(), step, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTwoFactorNotConfigured
+	}
+	return nil
+}
+
+// DisableTwoFactor removes the TOTP configuration and recovery codes for a user
+func DisableTwoFactor(db *sql.DB, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM two_factor WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateTwoFactorStep records the last accepted time step so codes can't be replayed.
+// The step only moves forward, so when two requests race with the same code only one
+// of them records it; the other gets ErrInvalidTwoFactorCode.
+func UpdateTwoFactorStep(db *sql.DB, userID, step int64) error {
+	result, err := db.Exec("UPDATE two_factor SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?",
+		step, userID, step)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// CheckSecondFactorLock returns ErrSecondFactorLocked while a user is locked out after
+// too many wrong codes
+func CheckSecondFactorLock(db *sql.DB, userID int64) error {
+	var lockedUntil *time.Time
+	err := db.QueryRow("SELECT locked_until FROM two_factor WHERE user_id = ?", userID).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if lockedUntil != nil && time.Now().Before(*lockedUntil) {
+		return ErrSecondFactorLocked
+	}
+	return nil
+}
+
+// RecordSecondFactorFailure counts a wrong code against the user rather than the login
+// challenge, so signing in again doesn't buy more guesses. The failure that reaches
+// MaxSecondFactorFailures locks the user out for SecondFactorLockout.
+func RecordSecondFactorFailure(db *sql.DB, userID int64) error {
+	_, err := db.Exec(`
+		UPDATE two_factor
+		SET failed_attempts = CASE WHEN failed_attempts + 1 >= ? THEN 0 ELSE failed_attempts + 1 END,
+		    locked_until = CASE WHEN failed_attempts + 1 >= ? THEN ? ELSE locked_until END
+		WHERE user_id = ?`,
+		MaxSecondFactorFailures, MaxSecondFactorFailures, time.Now().Add(SecondFactorLockout), userID)
+	return err
+}
+
+// ResetSecondFactorFailures clears a user's wrong-code count after a correct code
+func ResetSecondFactorFailures(db *sql.DB, userID int64) error {
+	_, err := db.Exec("UPDATE two_factor SET failed_attempts = 0, locked_until = NULL WHERE user_id = ?", userID)
+	return err
+}
+
+// GenerateRecoveryCodes replaces a user's recovery codes and returns the new plaintext codes.
+// Only hashes are stored, so the codes can never be shown again.
+func GenerateRecoveryCodes(db *sql.DB, userID int64) ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err := tx.Exec(`
+			INSERT INTO recovery_codes (user_id, code_hash)
+			VALUES (?, ?)`,
+			userID, hashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode consumes a recovery code, returning false if it is unknown or already used
+func UseRecoveryCode(db *sql.DB, userID int64, code string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE recovery_codes
+		SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now(), userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func CountRecoveryCodes(db *sql.DB, userID int64) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL",
+		userID).Scan(&count)
+	return count, err
+}
+
+// CreateLoginChallenge issues a short-lived token for the second login step
+func CreateLoginChallenge(db *sql.DB, userID int64) (string, error) {
+	// Clean up stale challenges for this user
+	_, err := db.Exec("DELETE FROM login_challenges WHERE user_id = ? OR expires_at < ?", userID, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := uuid.New().String()
+	_, err = db.Exec(`
+		INSERT INTO login_challenges (user_id, token, expires_at)
+		VALUES (?, ?, ?)`,
+		userID, token, time.Now().Add(LoginChallengeDuration))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UseLoginChallenge looks up a challenge and counts the attempt against it.
+// Challenges that expired or ran out of attempts are deleted.
+func UseLoginChallenge(db *sql.DB, token string) (int64, error) {
+	var userID int64
+	var attempts int
+	var expiresAt time.Time
+	err := db.QueryRow(`
+		SELECT user_id, attempts, expires_at
+		FROM login_challenges WHERE token = ?`, token).Scan(&userID, &attempts, &expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidChallenge
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if time.Now().After(expiresAt) || attempts >= MaxChallengeAttempts {
+		_, _ = db.Exec("DELETE FROM login_challenges WHERE token = ?", token)
+		return 0, ErrInvalidChallenge
+	}
+
+	_, err = db.Exec("UPDATE login_challenges SET attempts = attempts + 1 WHERE token = ?", token)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// DeleteLoginChallenge removes a challenge once it has been completed
+func DeleteLoginChallenge(db *sql.DB, token string) error {
+	_, err := db.Exec("DELETE FROM login_challenges WHERE token = ?", token)
+	return err
+}
+
+// newRecoveryCode returns a random code formatted as xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code for storage
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
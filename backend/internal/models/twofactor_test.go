@@ -0,0 +1,87 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpdateTwoFactorStepRejectsReplay(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "alice")
+	if err := SaveTwoFactorSecret(db, user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableTwoFactor(db, user.ID, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateTwoFactorStep(db, user.ID, 101); err != nil {
+		t.Fatalf("first use of step 101: %v", err)
+	}
+	for _, step := range []int64{101, 100} {
+		if err := UpdateTwoFactorStep(db, user.ID, step); err != ErrInvalidTwoFactorCode {
+			t.Errorf("step %d after 101: got %v, want ErrInvalidTwoFactorCode", step, err)
+		}
+	}
+
+	// Of many requests racing with the same code, exactly one may succeed
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := UpdateTwoFactorStep(db, user.ID, 102)
+			switch err {
+			case nil:
+				atomic.AddInt32(&accepted, 1)
+			case ErrInvalidTwoFactorCode:
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("step 102 accepted %d times, want 1", accepted)
+	}
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "bob")
+	if err := SaveTwoFactorSecret(db, user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableTwoFactor(db, user.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < MaxSecondFactorFailures; i++ {
+		if err := RecordSecondFactorFailure(db, user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckSecondFactorLock(db, user.ID); err != nil {
+			t.Fatalf("after %d failures: %v", i, err)
+		}
+	}
+
+	// New login challenges don't reset the count, so the next failure locks the user
+	if _, err := CreateLoginChallenge(db, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordSecondFactorFailure(db, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSecondFactorLock(db, user.ID); err != ErrSecondFactorLocked {
+		t.Fatalf("after %d failures: got %v, want ErrSecondFactorLocked", MaxSecondFactorFailures, err)
+	}
+
+	if err := ResetSecondFactorFailures(db, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSecondFactorLock(db, user.ID); err != nil {
+		t.Fatalf("after reset: %v", err)
+	}
+}
@@ -10,15 +10,18 @@ import (
 
 // User represents a forum user
 type User struct {
-	ID           int64     `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"` // Never send in JSON responses
-	FirstName    string    `json:"first_name"`
-	LastName     string    `json:"last_name"`
-	Age          int       `json:"age"`
-	Gender       string    `json:"gender"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int64      `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"-"` // Never send in JSON responses
+	FirstName    string     `json:"first_name"`
+	LastName     string     `json:"last_name"`
+	Age          int        `json:"age"`
+	Gender       string     `json:"gender"`
+	Role         string     `json:"role"`
+	Status       string     `json:"status,omitempty"` // chosen presence status; for other users, the status they appear with
+	LastSeenAt   *time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
 }
 
 type RegisterRequest struct {
@@ -91,6 +94,7 @@ func CreateUser(db *sql.DB, req RegisterRequest) (*User, error) {
 		LastName:  req.LastName,
 		Age:       req.Age,
 		Gender:    req.Gender,
+		Role:      RoleUser,
 	}
 
 	return user, nil
@@ -115,7 +119,7 @@ func validateRegisterRequest(req RegisterRequest) error {
 
 // GetUserByLogin retrieves a user by email or username
 func GetUserByLogin(db *sql.DB, login string) (*User, error) {
-	query := `SELECT id, username, email, password_hash, first_name, last_name, age, gender, created_at 
+	query := `SELECT id, username, email, password_hash, first_name, last_name, age, gender, role, status, last_seen_at, created_at 
 			  FROM users WHERE email = ? OR username = ?`
 
 	var user User
@@ -128,6 +132,9 @@ func GetUserByLogin(db *sql.DB, login string) (*User, error) {
 		&user.LastName,
 		&user.Age,
 		&user.Gender,
+		&user.Role,
+		&user.Status,
+		&user.LastSeenAt,
 		&user.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -158,7 +165,7 @@ func CreateSession(db *sql.DB, userID int64, token string) error {
 // GetUserBySessionToken retrieves a user by their session token
 func GetUserBySessionToken(db *sql.DB, token string) (*User, error) {
 	query := `
-		SELECT u.id, u.username, u.email, u.password_hash, u.first_name, u.last_name, u.age, u.gender, u.created_at
+		SELECT u.id, u.username, u.email, u.password_hash, u.first_name, u.last_name, u.age, u.gender, u.role, u.status, u.last_seen_at, u.created_at
 		FROM users u
 		JOIN sessions s ON u.id = s.user_id
 		WHERE s.token = ? AND s.expires_at > ?`
@@ -173,6 +180,9 @@ func GetUserBySessionToken(db *sql.DB, token string) (*User, error) {
 		&user.LastName,
 		&user.Age,
 		&user.Gender,
+		&user.Role,
+		&user.Status,
+		&user.LastSeenAt,
 		&user.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -186,7 +196,7 @@ func GetUserBySessionToken(db *sql.DB, token string) (*User, error) {
 
 // GetUserByID retrieves a user by their ID
 func GetUserByID(db *sql.DB, id int64) (*User, error) {
-	query := `SELECT id, username, email, password_hash, first_name, last_name, age, gender, created_at 
+	query := `SELECT id, username, email, password_hash, first_name, last_name, age, gender, role, status, last_seen_at, created_at 
 			  FROM users WHERE id = ?`
 
 	var user User
@@ -199,10 +209,13 @@ func GetUserByID(db *sql.DB, id int64) (*User, error) {
 		&user.LastName,
 		&user.Age,
 		&user.Gender,
+		&user.Role,
+		&user.Status,
+		&user.LastSeenAt,
 		&user.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, err
@@ -0,0 +1,310 @@
+// Package push delivers Web Push messages (RFC 8030) to browser push services. Payloads
+// are encrypted for the subscribed browser with aes128gcm (RFC 8291) and requests are
+// signed with a VAPID key (RFC 8292) identifying this server.
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// recordSize is the aes128gcm record size. Payloads go out as a single record.
+	recordSize = 4096
+
+	// MaxPayloadSize is the largest payload whose encrypted body stays within the 4096
+	// bytes push services accept: that minus the header, the padding delimiter and the
+	// authentication tag
+	MaxPayloadSize = recordSize - 86 - 1 - 16
+
+	// vapidLifetime is how long a signed VAPID token stays valid; push services reject
+	// tokens valid for more than 24 hours
+	vapidLifetime = 12 * time.Hour
+)
+
+var (
+	// ErrSubscriptionGone means the push service no longer knows the subscription, and
+	// it should be deleted
+	ErrSubscriptionGone = errors.New("push subscription expired or unsubscribed")
+
+	ErrPayloadTooLarge     = errors.New("push payload too large")
+	ErrInvalidSubscription = errors.New("invalid push subscription")
+)
+
+// Subscription is where and how to reach one browser, as returned by
+// PushManager.subscribe(). The keys are base64url encoded.
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	P256dh   string `json:"p256dh"`
+	Auth     string `json:"auth"`
+}
+
+// Validate checks that a subscription has an HTTPS endpoint and well-formed keys
+func (s Subscription) Validate() error {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return ErrInvalidSubscription
+	}
+	if _, err := s.keys(); err != nil {
+		return ErrInvalidSubscription
+	}
+	return nil
+}
+
+// subscriptionKeys are a subscription's decoded keys
+type subscriptionKeys struct {
+	public *ecdh.PublicKey
+	auth   []byte
+}
+
+func (s Subscription) keys() (*subscriptionKeys, error) {
+	publicBytes, err := decodeKey(s.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	public, err := ecdh.P256().NewPublicKey(publicBytes)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := decodeKey(s.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if len(auth) != 16 {
+		return nil, errors.New("auth secret must be 16 bytes")
+	}
+	return &subscriptionKeys{public: public, auth: auth}, nil
+}
+
+// decodeKey decodes base64url with or without padding, as browsers vary
+func decodeKey(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+// Encrypt encrypts a payload for a subscription in the aes128gcm content encoding
+func Encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	keys, err := sub.keys()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSubscription, err)
+	}
+
+	// A fresh key pair and salt for every message
+	local, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	sharedSecret, err := local.ECDH(keys.public)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mix the browser's auth secret into the shared secret (RFC 8291 section 3.3)
+	localPublic := local.PublicKey().Bytes()
+	keyInfo := append([]byte("WebPush: info\x00"), keys.public.Bytes()...)
+	keyInfo = append(keyInfo, localPublic...)
+	ikm, err := hkdf.Key(sha256.New, sharedSecret, keys.auth, string(keyInfo), 32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the content key and nonce (RFC 8188 section 2.2)
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, key ID length and the key ID, which is our public key
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(recordSize))
+	body.WriteByte(byte(len(localPublic)))
+	body.Write(localPublic)
+
+	// The one and last record ends with the 0x02 padding delimiter
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	body.Write(gcm.Seal(nil, nonce, plaintext, nil))
+	return body.Bytes(), nil
+}
+
+// VAPIDKey is the P-256 key pair this server signs push requests with
+type VAPIDKey struct {
+	private *ecdsa.PrivateKey
+	public  []byte // uncompressed point
+}
+
+// GenerateVAPIDKey creates a new VAPID key pair
+func GenerateVAPIDKey() (*VAPIDKey, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVAPIDKey(base64.RawURLEncoding.EncodeToString(key.Bytes()))
+}
+
+// ParseVAPIDKey reads a private key in the usual base64url raw scalar encoding
+func ParseVAPIDKey(s string) (*VAPIDKey, error) {
+	d, err := decodeKey(s)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, err
+	}
+
+	public := key.PublicKey().Bytes()
+	return &VAPIDKey{
+		private: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+			D: new(big.Int).SetBytes(d),
+		},
+		public: public,
+	}, nil
+}
+
+// PrivateKey returns the private key in the encoding ParseVAPIDKey reads
+func (k *VAPIDKey) PrivateKey() string {
+	return base64.RawURLEncoding.EncodeToString(k.private.D.FillBytes(make([]byte, 32)))
+}
+
+// PublicKey returns the public key browsers pass to PushManager.subscribe() as
+// applicationServerKey
+func (k *VAPIDKey) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(k.public)
+}
+
+// token signs a VAPID JWT for a push service
+func (k *VAPIDKey) token(audience, subject string, expires time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"exp": expires.Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants the raw r || s signature, not ASN.1
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Client sends push messages
+type Client struct {
+	key     *VAPIDKey
+	subject string // contact for push service operators, a mailto: or https: URL
+	http    *http.Client
+}
+
+// NewClient creates a client signing requests with key
+func NewClient(key *VAPIDKey, subject string) *Client {
+	return &Client{key: key, subject: subject, http: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// SetHTTPClient replaces the HTTP client requests go out through, for instance to
+// reach a push service behind a proxy or a fake one in tests
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.http = client
+}
+
+// PublicKey returns the VAPID public key browsers subscribe with
+func (c *Client) PublicKey() string {
+	return c.key.PublicKey()
+}
+
+// Send encrypts a payload and hands it to the subscription's push service, which keeps
+// it for up to ttl while the browser is unreachable. It returns ErrSubscriptionGone
+// when the subscription no longer exists.
+func (c *Client) Send(sub Subscription, payload []byte, ttl time.Duration) error {
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSubscription, err)
+	}
+	body, err := Encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	token, err := c.key.token(endpoint.Scheme+"://"+endpoint.Host, c.subject, time.Now().Add(vapidLifetime))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "vapid t="+token+", k="+c.key.PublicKey())
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	req.Header.Set("Urgency", "high")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("push service answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscriber is a fake browser: the keys it subscribed with and the private half
+// it decrypts with
+type subscriber struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newSubscriber(t *testing.T) *subscriber {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &subscriber{key: key, auth: auth}
+}
+
+func (s *subscriber) subscription(endpoint string) Subscription {
+	return Subscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(s.key.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(s.auth),
+	}
+}
+
+// decrypt reverses Encrypt the way a browser does (RFC 8291 and RFC 8188)
+func (s *subscriber) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+	if len(body) < 16+4+1 {
+		t.Fatalf("body of %d bytes is shorter than the header", len(body))
+	}
+	salt := body[:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	idlen := int(body[20])
+	if rs != recordSize {
+		t.Errorf("record size %d, want %d", rs, recordSize)
+	}
+	if idlen != 65 || len(body) < 21+idlen {
+		t.Fatalf("key ID length %d, want an uncompressed P-256 point of 65", idlen)
+	}
+	senderBytes := body[21 : 21+idlen]
+	ciphertext := body[21+idlen:]
+
+	sender, err := ecdh.P256().NewPublicKey(senderBytes)
+	if err != nil {
+		t.Fatalf("key ID is not a P-256 public key: %v", err)
+	}
+	sharedSecret, err := s.key.ECDH(sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyInfo := append([]byte("WebPush: info\x00"), s.key.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, senderBytes...)
+	ikm, _ := hkdf.Key(sha256.New, sharedSecret, s.auth, string(keyInfo), 32)
+	cek, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("decrypting: %v", err)
+	}
+
+	// The last record ends with the 0x02 delimiter followed by zero padding
+	end := bytes.LastIndexByte(plaintext, 0x02)
+	if end < 0 || len(bytes.Trim(plaintext[end+1:], "\x00")) != 0 {
+		t.Fatalf("plaintext has no final record delimiter: %x", plaintext)
+	}
+	return plaintext[:end]
+}
+
+// checkVAPID verifies the Authorization header is a VAPID token signed by key for
+// audience
+func checkVAPID(t *testing.T, header string, key *VAPIDKey, audience string) {
+	t.Helper()
+	rest, ok := strings.CutPrefix(header, "vapid t=")
+	token, publicKey, ok2 := strings.Cut(rest, ", k=")
+	if !ok || !ok2 {
+		t.Fatalf("Authorization %q is not vapid t=..., k=...", header)
+	}
+	if publicKey != key.PublicKey() {
+		t.Errorf("k=%s, want the VAPID public key %s", publicKey, key.PublicKey())
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %q is not a JWT", token)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		t.Fatalf("signature is not a raw 64 byte ES256 signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&key.private.PublicKey, digest[:], r, s) {
+		t.Error("token signature does not verify with the VAPID key")
+	}
+
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("token claims: %v", err)
+	}
+	if claims.Aud != audience {
+		t.Errorf("aud %q, want %q", claims.Aud, audience)
+	}
+	if until := time.Until(time.Unix(claims.Exp, 0)); until <= 0 || until > 24*time.Hour {
+		t.Errorf("token expires in %v, want within 24 hours", until)
+	}
+	if claims.Sub != "mailto:admin@example.com" {
+		t.Errorf("sub %q", claims.Sub)
+	}
+}
+
+func newTestClient(t *testing.T, server *httptest.Server) *Client {
+	t.Helper()
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(key, "mailto:admin@example.com")
+	client.SetHTTPClient(server.Client())
+	return client
+}
+
+func TestSend(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{header: r.Header, body: body}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	browser := newSubscriber(t)
+	sub := browser.subscription(server.URL + "/push/abc")
+	if err := sub.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	payload := []byte(`{"title":"New message from alice","body":"hi","url":"/chat"}`)
+	if err := client.Send(sub, payload, time.Hour); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	req := <-requests
+
+	for name, want := range map[string]string{
+		"Content-Encoding": "aes128gcm",
+		"Content-Type":     "application/octet-stream",
+		"TTL":              "3600",
+		"Urgency":          "high",
+	} {
+		if got := req.header.Get(name); got != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+	checkVAPID(t, req.header.Get("Authorization"), client.key, server.URL)
+
+	if got := browser.decrypt(t, req.body); !bytes.Equal(got, payload) {
+		t.Errorf("decrypted payload %q, want %q", got, payload)
+	}
+}
+
+func TestSendStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		ok     bool
+		gone   bool
+	}{
+		{http.StatusCreated, true, false},
+		{http.StatusNotFound, false, true},
+		{http.StatusGone, false, true},
+		{http.StatusTooManyRequests, false, false},
+	}
+	for _, tt := range tests {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		client := newTestClient(t, server)
+		err := client.Send(newSubscriber(t).subscription(server.URL), []byte("{}"), time.Minute)
+		server.Close()
+
+		if (err == nil) != tt.ok || errors.Is(err, ErrSubscriptionGone) != tt.gone {
+			t.Errorf("status %d: got error %v", tt.status, err)
+		}
+	}
+}
+
+func TestEncryptPayloadLimit(t *testing.T) {
+	browser := newSubscriber(t)
+	sub := browser.subscription("https://push.example.com/x")
+
+	body, err := Encrypt(sub, make([]byte, MaxPayloadSize))
+	if err != nil {
+		t.Fatalf("Encrypt at the limit: %v", err)
+	}
+	if len(body) > recordSize {
+		t.Errorf("encrypted body is %d bytes, over the %d push services accept", len(body), recordSize)
+	}
+	if len(browser.decrypt(t, body)) != MaxPayloadSize {
+		t.Error("payload at the limit did not round trip")
+	}
+
+	if _, err := Encrypt(sub, make([]byte, MaxPayloadSize+1)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("Encrypt over the limit: %v, want ErrPayloadTooLarge", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Package storage keeps uploaded files on local disk, addressed by the SHA-256 of
+// their content so identical uploads are stored once.
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sniffLength is how many leading bytes are used to detect a blob's content type
+const sniffLength = 512
+
+var (
+	ErrTooLarge     = errors.New("blob exceeds the size limit")
+	ErrBlobNotFound = errors.New("blob not found")
+	ErrInvalidHash  = errors.New("invalid blob hash")
+)
+
+// BlobStore stores blobs under root as <root>/<first two hex digits>/<hash>
+type BlobStore struct {
+	root string
+	mu   sync.Mutex // keeps Sweep from removing a blob that Put is handing out again
+}
+
+// Blob describes a stored blob
+type Blob struct {
+	Hash        string
+	Size        int64
+	ContentType string // sniffed from the content, never taken from the client
+}
+
+// NewBlobStore opens the store at root, creating the directory if needed
+func NewBlobStore(root string) (*BlobStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0755); err != nil {
+		return nil, err
+	}
+	return &BlobStore{root: root}, nil
+}
+
+// Put stores everything read from r. It fails with ErrTooLarge, storing nothing,
+// if r holds more than limit bytes.
+func (s *BlobStore) Put(r io.Reader, limit int64) (*Blob, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name()) // a no-op once the file has been moved into place
+	defer tmp.Close()
+
+	hash := sha256.New()
+	head := &headBuffer{limit: sniffLength}
+	size, err := io.Copy(io.MultiWriter(tmp, hash, head), io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > limit {
+		return nil, ErrTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	blob := &Blob{
+		Hash:        hex.EncodeToString(hash.Sum(nil)),
+		Size:        size,
+		ContentType: http.DetectContentType(head.data),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(blob.Hash)
+	if _, err := os.Stat(path); err == nil {
+		// Already stored; mark it as fresh so a sweep leaves it for the new upload
+		now := time.Now()
+		return blob, os.Chtimes(path, now, now)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// Open opens a stored blob for reading
+func (s *BlobStore) Open(hash string) (*os.File, error) {
+	if !validHash(hash) {
+		return nil, ErrInvalidHash
+	}
+	file, err := os.Open(s.path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return file, err
+}
+
+// Remove deletes a stored blob. Removing a blob that isn't stored is not an error.
+func (s *BlobStore) Remove(hash string) error {
+	if !validHash(hash) {
+		return ErrInvalidHash
+	}
+	err := os.Remove(s.path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Sweep removes the blobs last stored before cutoff for which inUse reports false, and
+// returns how many it removed. Blobs stored since cutoff are kept so uploads that are
+// not recorded yet survive.
+func (s *BlobStore) Sweep(cutoff time.Time, inUse func(hash string) (bool, error)) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.root, "[0-9a-f][0-9a-f]", "*"))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range paths {
+		hash := filepath.Base(path)
+		if !validHash(hash) {
+			continue
+		}
+		ok, err := s.removeUnused(hash, cutoff, inUse)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeUnused removes one blob if it is older than cutoff and not in use
+func (s *BlobStore) removeUnused(hash string, cutoff time.Time, inUse func(hash string) (bool, error)) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.ModTime().Before(cutoff) {
+		return false, nil
+	}
+
+	used, err := inUse(hash)
+	if err != nil || used {
+		return false, err
+	}
+	if err := os.Remove(s.path(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
+
+// path is where a blob with the given hash lives
+func (s *BlobStore) path(hash string) string {
+	return filepath.Join(s.root, hash[:2], hash)
+}
+
+// validHash reports whether hash is a hex SHA-256 digest, so it is safe to use in a path
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// headBuffer keeps the first limit bytes written to it
+type headBuffer struct {
+	data  []byte
+	limit int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.data); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.data = append(b.data, p[:room]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobStorePut(t *testing.T) {
+	store, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("%PDF-1.7 hello")
+	blob, err := store.Put(bytes.NewReader(content), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Size != int64(len(content)) || blob.ContentType != "application/pdf" {
+		t.Errorf("Put = %+v", blob)
+	}
+
+	again, err := store.Put(bytes.NewReader(content), 1024)
+	if err != nil || again.Hash != blob.Hash {
+		t.Errorf("identical content stored as %v, %v, want the same hash %s", again, err, blob.Hash)
+	}
+
+	file, err := store.Open(blob.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := io.ReadAll(file)
+	file.Close()
+	if !bytes.Equal(stored, content) {
+		t.Errorf("Open returned %q, want %q", stored, content)
+	}
+
+	if _, err := store.Put(strings.NewReader(strings.Repeat("a", 1025)), 1024); err != ErrTooLarge {
+		t.Errorf("Put over the limit: %v, want ErrTooLarge", err)
+	}
+	if _, err := store.Open("../../etc/passwd"); err != ErrInvalidHash {
+		t.Errorf("Open with a path: %v, want ErrInvalidHash", err)
+	}
+	if _, err := store.Open(strings.Repeat("0", 64)); err != ErrBlobNotFound {
+		t.Errorf("Open of a missing blob: %v, want ErrBlobNotFound", err)
+	}
+}
+
+func TestBlobStoreSweep(t *testing.T) {
+	store, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(content string, age time.Duration) string {
+		t.Helper()
+		blob, err := store.Put(strings.NewReader(content), 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := time.Now().Add(-age)
+		if err := os.Chtimes(store.path(blob.Hash), stored, stored); err != nil {
+			t.Fatal(err)
+		}
+		return blob.Hash
+	}
+
+	used := put("used", 2*time.Hour)
+	unused := put("unused", 2*time.Hour)
+	recent := put("recent", time.Minute)
+
+	removed, err := store.Sweep(time.Now().Add(-time.Hour), func(hash string) (bool, error) {
+		return hash == used, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Sweep removed %d blobs, want 1", removed)
+	}
+	for hash, kept := range map[string]bool{used: true, unused: false, recent: true} {
+		_, err := os.Stat(store.path(hash))
+		if exists := err == nil; exists != kept {
+			t.Errorf("blob %s exists = %v after the sweep, want %v", hash[:8], exists, kept)
+		}
+	}
+
+	// Storing the content again makes it fresh, so the next sweep leaves it
+	put("used", 2*time.Hour)
+	if _, err := store.Put(strings.NewReader("used"), 1024); err != nil {
+		t.Fatal(err)
+	}
+	removed, err = store.Sweep(time.Now().Add(-time.Hour), func(string) (bool, error) { return false, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.path(used)); err != nil {
+		t.Errorf("re-uploaded blob was swept: %v", err)
+	}
+	if removed != 0 {
+		t.Errorf("second sweep removed %d blobs, want none", removed)
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrInvalidImage = errors.New("image file is malformed")
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// jpegMetadataMarkers are the JPEG segments that carry metadata rather than pixels:
+// APP1 (EXIF and XMP), APP13 (IPTC) and comments. ICC colour profiles in APP2 are kept.
+var jpegMetadataMarkers = map[byte]bool{
+	0xE1: true,
+	0xED: true,
+	0xFE: true,
+}
+
+// pngMetadataChunks are the PNG chunks that carry metadata rather than pixels
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// StripMetadata returns the content of r without the EXIF, XMP and text metadata of
+// JPEG and PNG images, such as camera details and GPS coordinates. Other content types
+// pass through unchanged. Reading fails with ErrInvalidImage if the image is malformed.
+// The caller must close the returned reader.
+func StripMetadata(r io.Reader, contentType string) io.ReadCloser {
+	var strip func(io.Writer, *bufio.Reader) error
+	switch contentType {
+	case "image/jpeg":
+		strip = stripJPEG
+	case "image/png":
+		strip = stripPNG
+	default:
+		return io.NopCloser(r)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(strip(pw, bufio.NewReader(r)))
+	}()
+	return pr
+}
+
+// stripJPEG copies a JPEG file, leaving out metadata segments before the image data
+func stripJPEG(w io.Writer, r *bufio.Reader) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return malformed(err)
+	}
+	if soi != [2]byte{0xFF, 0xD8} {
+		return ErrInvalidImage
+	}
+	if _, err := w.Write(soi[:]); err != nil {
+		return err
+	}
+
+	for {
+		prefix, err := r.ReadByte()
+		if err != nil {
+			return malformed(err)
+		}
+		if prefix != 0xFF {
+			return ErrInvalidImage
+		}
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xFF { // fill bytes
+			marker, err = r.ReadByte()
+		}
+		if err != nil {
+			return malformed(err)
+		}
+
+		switch {
+		case marker == 0xDA || marker == 0xD9:
+			// Start of scan or end of image: the rest is image data
+			if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, r)
+			return err
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Markers without a length or payload
+			if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return malformed(err)
+		}
+		size := int64(binary.BigEndian.Uint16(length[:]))
+		if size < 2 {
+			return ErrInvalidImage
+		}
+
+		if jpegMetadataMarkers[marker] {
+			if _, err := r.Discard(int(size - 2)); err != nil {
+				return malformed(err)
+			}
+			continue
+		}
+		if _, err := w.Write([]byte{0xFF, marker, length[0], length[1]}); err != nil {
+			return err
+		}
+		if err := copySegment(w, r, size-2); err != nil {
+			return err
+		}
+	}
+}
+
+// stripPNG copies a PNG file up to its IEND chunk, leaving out metadata chunks
+func stripPNG(w io.Writer, r *bufio.Reader) error {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return malformed(err)
+	}
+	if !bytes.Equal(signature, pngSignature) {
+		return ErrInvalidImage
+	}
+	if _, err := w.Write(signature); err != nil {
+		return err
+	}
+
+	for {
+		var header [8]byte // length and type
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return malformed(err)
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		if length > 1<<31-1 {
+			return ErrInvalidImage
+		}
+		chunkType := string(header[4:])
+		size := int64(length) + 4 // data and CRC
+
+		if pngMetadataChunks[chunkType] {
+			if _, err := r.Discard(int(size)); err != nil {
+				return malformed(err)
+			}
+			continue
+		}
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		if err := copySegment(w, r, size); err != nil {
+			return err
+		}
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
+
+// copySegment copies exactly n bytes, treating a short input as a malformed image
+func copySegment(w io.Writer, r io.Reader, n int64) error {
+	_, err := io.CopyN(w, r, n)
+	return malformed(err)
+}
+
+// malformed reports an input that ends too early as ErrInvalidImage, and passes other
+// read errors, such as a body exceeding its size limit, through
+func malformed(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidImage
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// testImage is a small gradient, so encoders produce real image data
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 20), 128, 255})
+		}
+	}
+	return img
+}
+
+// jpegSegment builds a JPEG marker segment with its length
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// jpegMarkers lists the markers of the segments before the image data
+func jpegMarkers(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var markers []byte
+	for i := 2; i+4 <= len(data); {
+		marker := data[i+1]
+		markers = append(markers, marker)
+		if marker == 0xDA {
+			break
+		}
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+	}
+	return markers
+}
+
+// pngChunk builds a PNG chunk with its length and CRC
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngChunks lists the chunk types of a PNG file
+func pngChunks(t *testing.T, data []byte) []string {
+	t.Helper()
+	var chunks []string
+	for i := len(pngSignature); i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunks = append(chunks, string(data[i+4:i+8]))
+		i += 12 + length
+	}
+	return chunks
+}
+
+func strip(t *testing.T, data []byte, contentType string) ([]byte, error) {
+	t.Helper()
+	r := StripMetadata(bytes.NewReader(data), contentType)
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2aGPS 51.5007N 0.1246W"))
+	xmp := jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))
+	icc := jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01profile"))
+	iptc := jpegSegment(0xED, []byte("Photoshop 3.0\x00IPTC"))
+	comment := jpegSegment(0xFE, []byte("shot by alice"))
+
+	// SOI, then the metadata and an ICC profile ahead of the encoder's segments
+	var input []byte
+	input = append(input, encoded.Bytes()[:2]...)
+	for _, segment := range [][]byte{exif, xmp, icc, iptc, comment} {
+		input = append(input, segment...)
+	}
+	input = append(input, encoded.Bytes()[2:]...)
+
+	out, err := strip(t, input, "image/jpeg")
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+
+	for _, marker := range jpegMarkers(t, out) {
+		if jpegMetadataMarkers[marker] {
+			t.Errorf("segment %#x survived stripping", marker)
+		}
+	}
+	if bytes.Contains(out, []byte("GPS")) || bytes.Contains(out, []byte("alice")) {
+		t.Error("metadata text survived stripping")
+	}
+	if !bytes.Contains(out, []byte("ICC_PROFILE")) {
+		t.Error("ICC colour profile was removed")
+	}
+	if want := len(input) - len(exif) - len(xmp) - len(iptc) - len(comment); len(out) != want {
+		t.Errorf("stripped JPEG is %d bytes, want %d without just the metadata", len(out), want)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped JPEG no longer decodes: %v", err)
+	}
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	// Insert metadata chunks after IHDR, which is always first and 25 bytes long
+	ihdrEnd := len(pngSignature) + 25
+	var input []byte
+	input = append(input, data[:ihdrEnd]...)
+	input = append(input, pngChunk("tEXt", []byte("Author\x00alice"))...)
+	input = append(input, pngChunk("eXIf", []byte("MM\x00\x2aGPS 51.5007N"))...)
+	input = append(input, pngChunk("iTXt", []byte("Comment\x00\x00\x00\x00\x00hello"))...)
+	input = append(input, pngChunk("tIME", []byte{0x07, 0xE9, 1, 2, 3, 4, 5})...)
+	input = append(input, data[ihdrEnd:]...)
+	// Anything after IEND is dropped too
+	input = append(input, "trailing data"...)
+
+	out, err := strip(t, input, "image/png")
+	if err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("stripped PNG has chunks %v, want the encoder's %v", pngChunks(t, out), pngChunks(t, data))
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped PNG no longer decodes: %v", err)
+	}
+}
+
+func TestStripMetadataMalformed(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+	}{
+		{"not a JPEG", []byte("GIF89a"), "image/jpeg"},
+		{"truncated JPEG segment", append([]byte{0xFF, 0xD8}, jpegSegment(0xE1, make([]byte, 100))[:50]...), "image/jpeg"},
+		{"JPEG without image data", []byte{0xFF, 0xD8}, "image/jpeg"},
+		{"not a PNG", []byte("\x89PNX\r\n\x1a\n"), "image/png"},
+		{"PNG without IEND", append(append([]byte{}, pngSignature...), pngChunk("tEXt", []byte("a\x00b"))...), "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := strip(t, tt.data, tt.contentType); err != ErrInvalidImage {
+				t.Errorf("got %v, want ErrInvalidImage", err)
+			}
+		})
+	}
+}
+
+func TestStripMetadataPassesOtherTypes(t *testing.T) {
+	data := []byte("%PDF-1.7 Author alice")
+	out, err := strip(t, data, "application/pdf")
+	if err != nil || !bytes.Equal(out, data) {
+		t.Errorf("got %q, %v, want the content unchanged", out, err)
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// maxImagePixels bounds the images that are decoded for thumbnails, so a small file
+// claiming huge dimensions can't exhaust memory
+const maxImagePixels = 40_000_000
+
+var ErrUnsupportedImage = errors.New("image format not supported for thumbnails")
+
+// Thumbnail is a scaled-down copy of an image
+type Thumbnail struct {
+	Data        []byte
+	ContentType string
+	Width       int // of the original image
+	Height      int // of the original image
+}
+
+// MakeThumbnail decodes a JPEG, PNG or GIF image of the given content type and
+// scales it to fit within maxSide pixels. JPEGs stay JPEGs; the others become PNGs
+// to keep their transparency.
+func MakeThumbnail(r io.ReadSeeker, contentType string, maxSide int) (*Thumbnail, error) {
+	var decode func(io.Reader) (image.Image, error)
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch contentType {
+	case "image/jpeg":
+		decode, decodeConfig = jpeg.Decode, jpeg.DecodeConfig
+	case "image/png":
+		decode, decodeConfig = png.Decode, png.DecodeConfig
+	case "image/gif":
+		decode, decodeConfig = gif.Decode, gif.DecodeConfig
+	default:
+		return nil, ErrUnsupportedImage
+	}
+
+	config, err := decodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, ErrUnsupportedImage
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, err := decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := scaleDown(src, maxSide)
+	thumbnailType := ThumbnailContentType(contentType)
+	var buf bytes.Buffer
+	if thumbnailType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Thumbnail{
+		Data:        buf.Bytes(),
+		ContentType: thumbnailType,
+		Width:       config.Width,
+		Height:      config.Height,
+	}, nil
+}
+
+// ThumbnailContentType is the content type of thumbnails made from images of the given type
+func ThumbnailContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// scaleDown shrinks src to fit within maxSide pixels by averaging the source pixels
+// that fall into each destination pixel. Images that already fit are copied as is.
+func scaleDown(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	scaledWidth, scaledHeight := width, height
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			scaledWidth, scaledHeight = maxSide, max(1, height*maxSide/width)
+		} else {
+			scaledWidth, scaledHeight = max(1, width*maxSide/height), maxSide
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, scaledWidth, scaledHeight))
+	for y := 0; y < scaledHeight; y++ {
+		y0 := bounds.Min.Y + y*height/scaledHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/scaledHeight)
+		for x := 0; x < scaledWidth; x++ {
+			x0 := bounds.Min.X + x*width/scaledWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/scaledWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBAModel.Convert(src.At(sx, sy)).(color.NRGBA)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					b += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestMakeThumbnail(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 640, 320))
+	encoders := map[string]func(*bytes.Buffer) error{
+		"image/jpeg": func(b *bytes.Buffer) error { return jpeg.Encode(b, src, nil) },
+		"image/png":  func(b *bytes.Buffer) error { return png.Encode(b, src) },
+		"image/gif":  func(b *bytes.Buffer) error { return gif.Encode(b, src, nil) },
+	}
+	for contentType, encode := range encoders {
+		t.Run(contentType, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encode(&buf); err != nil {
+				t.Fatal(err)
+			}
+			thumb, err := MakeThumbnail(bytes.NewReader(buf.Bytes()), contentType, 160)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if thumb.Width != 640 || thumb.Height != 320 {
+				t.Errorf("original size %dx%d, want 640x320", thumb.Width, thumb.Height)
+			}
+			if thumb.ContentType != ThumbnailContentType(contentType) {
+				t.Errorf("content type %s, want %s", thumb.ContentType, ThumbnailContentType(contentType))
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(thumb.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != 160 || config.Height != 80 {
+				t.Errorf("thumbnail is %dx%d, want 160x80", config.Width, config.Height)
+			}
+		})
+	}
+
+	if _, err := MakeThumbnail(bytes.NewReader([]byte("RIFF")), "image/webp", 160); err != ErrUnsupportedImage {
+		t.Errorf("webp: %v, want ErrUnsupportedImage", err)
+	}
+}
+
+// A tiny file claiming huge dimensions is refused before it is decoded
+func TestMakeThumbnailRefusesHugeImages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// IHDR width and height follow the signature, chunk length and type
+	copy(data[16:24], []byte{0, 0, 0x80, 0, 0, 0, 0x80, 0})
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	if _, err := MakeThumbnail(bytes.NewReader(data), "image/png", 160); err != ErrUnsupportedImage {
+		t.Errorf("got %v, want ErrUnsupportedImage", err)
+	}
+}
@@ -44,6 +44,16 @@ const API = {
         });
     },
 
+    async verifyLogin(challengeToken, code) {
+        return await this.request('/login/verify', {
+            method: 'POST',
+            body: JSON.stringify({
+                challenge_token: challengeToken,
+                code: code
+            })
+        });
+    },
+
     async logout() {
         try {
             const response = await fetch(`${this.baseUrl}/logout`, {
@@ -74,9 +84,40 @@ const API = {
     },
 
     // Post endpoints
-    async getPosts(categoryId = '') {
-        const endpoint = categoryId ? `/posts?category_id=${categoryId}` : '/posts';
-        return await this.request(endpoint);
+    // filters may hold category_id, my_posts, liked, bookmarked, folder_id and sort
+    async getPosts(filters = {}) {
+        const params = new URLSearchParams();
+        Object.entries(filters).forEach(([key, value]) => {
+            if (value) params.set(key, value);
+        });
+        const query = params.toString();
+        return await this.request(query ? `/posts?${query}` : '/posts');
+    },
+
+    async bookmarkPost(postId, folderId = null) {
+        return await this.request(`/posts/${postId}/bookmark`, {
+            method: 'POST',
+            body: JSON.stringify({ folder_id: folderId })
+        });
+    },
+
+    async unbookmarkPost(postId) {
+        return await this.request(`/posts/${postId}/bookmark`, { method: 'DELETE' });
+    },
+
+    async getBookmarkFolders() {
+        return await this.request('/bookmarks/folders');
+    },
+
+    async createBookmarkFolder(name) {
+        return await this.request('/bookmarks/folders', {
+            method: 'POST',
+            body: JSON.stringify({ name })
+        });
+    },
+
+    async deleteBookmarkFolder(folderId) {
+        return await this.request(`/bookmarks/folders/${folderId}`, { method: 'DELETE' });
     },
 
     async getPost(postId) {
@@ -115,12 +156,112 @@ const API = {
     },
 
     // Message endpoints
-    async getConversations() {
-        return await this.request('/messages/conversations');
+    async getConversations(limit = 50, offset = 0) {
+        return await this.request(`/messages/conversations?limit=${limit}&offset=${offset}`);
+    },
+
+    async getUnreadTotals() {
+        return await this.request('/messages/unread');
+    },
+
+    // Notification endpoints
+    async getNotifications(beforeID = null) {
+        const cursor = beforeID ? `?before_id=${beforeID}` : '';
+        return await this.request(`/notifications${cursor}`);
+    },
+
+    async markNotificationRead(notificationID) {
+        return await this.request(`/notifications/${notificationID}/read`, { method: 'POST' });
+    },
+
+    async markAllNotificationsRead() {
+        return await this.request('/notifications/read-all', { method: 'POST' });
+    },
+
+    async getNotificationPreferences() {
+        return await this.request('/notifications/preferences');
+    },
+
+    async updateNotificationPreferences(preferences) {
+        return await this.request('/notifications/preferences', {
+            method: 'PUT',
+            body: JSON.stringify(preferences)
+        });
+    },
+
+    async getFollows() {
+        return await this.request('/follows');
+    },
+
+    async follow(targetType, targetID) {
+        return await this.request(`/follows/${targetType}/${targetID}`, { method: 'POST' });
     },
 
-    async getConversationHistory(userID, limit = 10, offset = 0) {
-        return await this.request(`/messages/history?user_id=${userID}&limit=${limit}&offset=${offset}`);
+    async unfollow(targetType, targetID) {
+        return await this.request(`/follows/${targetType}/${targetID}`, { method: 'DELETE' });
+    },
+
+    async getFollowingFeed(cursor = '') {
+        const query = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+        return await this.request(`/feed/following${query}`);
+    },
+
+    async getDigestFrequency() {
+        return await this.request('/notifications/digest');
+    },
+
+    async updateDigestFrequency(frequency) {
+        return await this.request('/notifications/digest', {
+            method: 'PUT',
+            body: JSON.stringify({ frequency })
+        });
+    },
+
+    async getPushKey() {
+        return await this.request('/push/key');
+    },
+
+    async savePushSubscription(subscription) {
+        return await this.request('/push/subscriptions', {
+            method: 'POST',
+            body: JSON.stringify(subscription)
+        });
+    },
+
+    async deletePushSubscription(endpoint) {
+        return await this.request('/push/subscriptions', {
+            method: 'DELETE',
+            body: JSON.stringify({ endpoint })
+        });
+    },
+
+    async getConversationHistory(userID, limit = 10, beforeID = null) {
+        const cursor = beforeID ? `&before_id=${beforeID}` : '';
+        return await this.request(`/messages/history?user_id=${userID}&limit=${limit}${cursor}`);
+    },
+
+    // Upload a file for a later message, post or comment. Sent as multipart, so it bypasses request().
+    async uploadAttachment(file) {
+        const form = new FormData();
+        form.append('file', file);
+        try {
+            const response = await fetch(`${this.baseUrl}/attachments`, {
+                method: 'POST',
+                body: form,
+                credentials: 'include'
+            });
+            if (!response.ok) {
+                return { success: false, error: (await response.text()).trim() };
+            }
+            return { success: true, data: await response.json() };
+        } catch (error) {
+            console.error('Upload failed:', error);
+            return { success: false, error: error.message };
+        }
+    },
+
+    async getMessageContext(messageID, limit = 10) {
+        return await this.request(`/messages/${messageID}/context?limit=${limit}`);
     },
 
     async sendMessage(receiverID, content) {
@@ -133,6 +274,19 @@ const API = {
         });
     },
 
+    async editMessage(messageID, content) {
+        return await this.request(`/messages/${messageID}`, {
+            method: 'PUT',
+            body: JSON.stringify({ content })
+        });
+    },
+
+    async deleteMessage(messageID, scope = 'everyone') {
+        return await this.request(`/messages/${messageID}?scope=${scope}`, {
+            method: 'DELETE'
+        });
+    },
+
     async markMessagesAsRead(senderID) {
         return await this.request('/messages/mark-read', {
             method: 'POST',
@@ -3,8 +3,10 @@ class ChatUI {
     constructor() {
         this.currentConversation = null;
         this.conversations = new Map();
-        this.onlineUsers = new Map();
+        this.onlineUsers = new Map(); // user ID -> online, away or dnd
         this.messageHistory = new Map();
+        this.hasOlderMessages = new Map(); // user ID -> whether older history remains
+        this.pendingAttachments = []; // uploaded files waiting to be sent
         this.typingTimeout = null;
         this.loadingMore = false;
         this.scrollTimeout = null;
@@ -34,6 +36,28 @@ class ChatUI {
             console.warn('Message input not found during binding');
         }
 
+        // File attachments
+        const attachBtn = document.getElementById('attach-btn');
+        const attachmentInput = document.getElementById('attachment-input');
+        if (attachBtn && attachmentInput) {
+            attachBtn.addEventListener('click', () => attachmentInput.click());
+            attachmentInput.addEventListener('change', () => {
+                this.uploadAttachments(Array.from(attachmentInput.files));
+                attachmentInput.value = '';
+            });
+        }
+        const pendingAttachments = document.getElementById('pending-attachments');
+        if (pendingAttachments) {
+            pendingAttachments.addEventListener('click', (e) => {
+                const remove = e.target.closest('[data-remove-attachment]');
+                if (remove) {
+                    const id = Number(remove.dataset.removeAttachment);
+                    this.pendingAttachments = this.pendingAttachments.filter(a => a.id !== id);
+                    this.renderPendingAttachments();
+                }
+            });
+        }
+
         // User search (with debouncing)
         const userSearch = document.getElementById('user-search');
         if (userSearch) {
@@ -50,6 +74,13 @@ class ChatUI {
         const messagesContainer = document.getElementById('messages-container');
         if (messagesContainer) {
             messagesContainer.addEventListener('scroll', () => this.throttledHandleScroll());
+            messagesContainer.addEventListener('click', (e) => this.handleMessageAction(e));
+        }
+
+        // Presence status picker
+        const presenceSelect = document.getElementById('presence-status');
+        if (presenceSelect) {
+            presenceSelect.onchange = (e) => this.setPresenceStatus(e.target.value);
         }
 
         // WebSocket status listener
@@ -89,6 +120,8 @@ class ChatUI {
             console.log('Loading all users...');
             await this.loadAllUsers();
 
+            await this.loadPresenceStatus();
+
             // Request current online users from WebSocket if connected
             if (window.wsClient && window.wsClient.isConnected) {
                 // The WebSocket will automatically send online users when we connect
@@ -109,12 +142,23 @@ class ChatUI {
 
    async loadConversations() {
     try {
-        const result = await API.request('/messages/conversations');
-        if (result.success && result.data) {
-            // Optionally clear conversations every time for a fresh state
-            // this.conversations.clear();
+        // The list is paginated, most recently active first
+        const pageSize = 50;
+        const loaded = [];
+        for (let offset = 0; ; offset += pageSize) {
+            const result = await API.getConversations(pageSize, offset);
+            if (!result.success || !result.data) {
+                break;
+            }
+            loaded.push(...result.data);
+            if (result.data.length < pageSize) {
+                break;
+            }
+        }
 
-            result.data.forEach(conv => {
+        if (loaded.length > 0) {
+            // This view lists direct chats; group rooms are served by /api/conversations
+            loaded.filter(conv => conv.type !== 'group').forEach(conv => {
                 const existingConv = this.conversations.get(conv.user_id);
                 if (existingConv) {
                     // Merge with existing conversation, preserving real-time updates
@@ -123,7 +167,9 @@ class ChatUI {
                     // Always update online status from server if present
                     if ('is_online' in conv) {
                         existingConv.is_online = conv.is_online;
+                        existingConv.status = conv.status;
                     }
+                    existingConv.last_seen_at = conv.last_seen_at;
                 } else {
                     // Add new conversation
                     this.conversations.set(conv.user_id, conv);
@@ -131,10 +177,28 @@ class ChatUI {
             });
             this.renderConversations();
         }
+        this.refreshUnreadBadge();
     } catch (error) {
         console.error('Failed to load conversations:', error);
     }
 }
+
+    // Show the total number of unread messages on the chat buttons
+    async refreshUnreadBadge() {
+        try {
+            const result = await API.getUnreadTotals();
+            if (!result.success || !result.data) {
+                return;
+            }
+            const total = result.data.messages;
+            document.querySelectorAll('[data-unread-badge]').forEach(badge => {
+                badge.textContent = total > 99 ? '99+' : total;
+                badge.classList.toggle('hidden', total === 0);
+            });
+        } catch (error) {
+            console.error('Failed to load unread totals:', error);
+        }
+    }
     async loadAllUsers() {
         console.log('loadAllUsers called');
         try {
@@ -155,7 +219,9 @@ class ChatUI {
                             last_name: user.last_name,
                             last_message: null,
                             unread_count: 0,
-                            is_online: false
+                            is_online: !!user.status && user.status !== 'offline',
+                            status: user.status,
+                            last_seen_at: user.last_seen_at
                         });
                     } else {
                         console.log('User already exists in conversations:', user.username);
@@ -199,12 +265,12 @@ class ChatUI {
 
     conversationsList.innerHTML = sortedConversations.map(conv => {
         const username = conv.username || 'User';
-        const isOnline = ('is_online' in conv) ? conv.is_online : this.onlineUsers.has(conv.user_id);
+        const status = this.presenceStatus(conv);
         const lastMessageTime = conv.last_message ?
             this.formatRelativeTime(conv.last_message.created_at) : '';
-        const lastMessagePreview = conv.last_message ?
-            conv.last_message.content.substring(0, 50) + (conv.last_message.content.length > 50 ? '...' : '') :
-            'No messages yet';
+        const lastMessagePreview = !conv.last_message ? 'No messages yet' :
+            !conv.last_message.content ? '📎 Attachment' :
+            conv.last_message.content.substring(0, 50) + (conv.last_message.content.length > 50 ? '...' : '');
 
         // Add visual emphasis for unread messages
         const hasUnread = conv.unread_count > 0;
@@ -216,7 +282,7 @@ class ChatUI {
                 <div class="conversation-avatar">
                     <img src="https://ui-avatars.com/api/?name=${username}&background=random"
                          alt="${username}'s avatar" />
-                    <div class="status-indicator ${isOnline ? 'online' : 'offline'}"></div>
+                    <div class="status-indicator ${status}" title="${this.presenceLabel(conv)}"></div>
                 </div>
                 <div class="conversation-info">
                     <div class="conversation-header">
@@ -285,18 +351,18 @@ class ChatUI {
     if (!chatHeader) return;
 
     const username = conversation.username || 'User';
-    const isOnline = ('is_online' in conversation) ? conversation.is_online : this.onlineUsers.has(userID);
+    const status = this.presenceStatus(conversation);
 
     chatHeader.innerHTML = `
         <div class="chat-user-info">
             <div class="avatar">
                 <img src="https://ui-avatars.com/api/?name=${username}&background=random" 
                      alt="${username}'s avatar" />
-                <div class="status-indicator ${isOnline ? 'online' : 'offline'}"></div>
+                <div class="status-indicator ${status}"></div>
             </div>
             <div class="user-details">
                 <span class="username">${username}</span>
-                <span class="status">${isOnline ? 'Online' : 'Offline'}</span>
+                <span class="status">${this.presenceLabel(conversation)}</span>
             </div>
         </div>
     `;
@@ -313,15 +379,21 @@ class ChatUI {
         if (sendBtn) {
             sendBtn.disabled = false;
         }
+        const attachBtn = document.getElementById('attach-btn');
+        if (attachBtn) {
+            attachBtn.disabled = false;
+        }
     }
 
-    async loadMessageHistory(userID, offset = 0) {
+    // Load the newest messages, or with beforeID the page of messages older than it
+    async loadMessageHistory(userID, beforeID = null) {
         try {
-            const result = await API.request(`/messages/history?user_id=${userID}&limit=10&offset=${offset}`);
+            const result = await API.getConversationHistory(userID, 10, beforeID);
             if (result.success && result.data) {
-                const messages = Array.isArray(result.data) ? result.data : [];
+                const messages = Array.isArray(result.data.messages) ? result.data.messages : [];
+                this.hasOlderMessages.set(userID, !!result.data.has_more);
 
-                if (offset === 0) {
+                if (!beforeID) {
                     // Clear existing messages for new conversation
                     console.log('Loading message history for user', userID, 'found', messages.length, 'messages');
                     this.messageHistory.set(userID, messages);
@@ -335,7 +407,7 @@ class ChatUI {
                 }
             } else {
                 // Handle case where no messages exist
-                if (offset === 0) {
+                if (!beforeID) {
                     this.messageHistory.set(userID, []);
                     this.renderMessages([]);
                 }
@@ -387,12 +459,22 @@ class ChatUI {
         const timestamp = this.formatMessageTime(message.created_at);
 
         return `
-            <div class="message ${isOwn ? 'own' : 'other'}">
+            <div class="message ${isOwn ? 'own' : 'other'}" data-message-id="${message.id}">
                 <div class="message-content">
-                    <div class="message-text">${this.escapeHtml(message.content)}</div>
+                    ${message.content ? `<div class="message-text markdown">${message.content_html || this.escapeHtml(message.content)}</div>` : ''}
+                    ${this.renderAttachments(message.attachments)}
                     <div class="message-meta">
                         <span class="sender">${message.sender ? message.sender.username : 'Unknown'}</span>
                         <span class="timestamp">${timestamp}</span>
+                        ${message.edited_at ? '<span class="edited">(edited)</span>' : ''}
+                        ${isOwn ? `<span class="receipt">${this.receiptStatus(message)}</span>` : ''}
+                    </div>
+                    <div class="message-actions">
+                        ${isOwn ? `
+                            <button type="button" class="message-action" data-action="edit">Edit</button>
+                            <button type="button" class="message-action" data-action="unsend">Unsend</button>
+                        ` : ''}
+                        <button type="button" class="message-action" data-action="delete-for-me">Delete for me</button>
                     </div>
                 </div>
             </div>
@@ -434,6 +516,7 @@ class ChatUI {
             // Only increment unread count for received messages
             if (messageData.sender_id !== currentUserId) {
                 conversation.unread_count = (conversation.unread_count || 0) + 1;
+                this.refreshUnreadBadge();
             }
         } else {
             // Create new conversation if it doesn't exist
@@ -447,6 +530,9 @@ class ChatUI {
                 is_online: this.onlineUsers.has(conversationPartnerId)
             };
             this.conversations.set(conversationPartnerId, conversation);
+            if (messageData.sender_id !== currentUserId) {
+                this.refreshUnreadBadge();
+            }
         }
 
         // Update UI if this is the current conversation AND user is on chat page
@@ -466,7 +552,13 @@ class ChatUI {
 
                 // Auto-mark as read only if user is actively viewing the chat and message is from other user
                 if (messageData.sender_id !== currentUserId) {
-                    this.markMessagesAsRead(messageData.sender_id);
+                    if (window.wsClient && window.wsClient.isConnected && messageData.conversation_id) {
+                        window.wsClient.sendMarkRead(messageData.conversation_id, messageData.id)
+                            .then(() => this.refreshUnreadBadge())
+                            .catch(() => {});
+                    } else {
+                        this.markMessagesAsRead(messageData.sender_id);
+                    }
                     conversation.unread_count = 0;
                 }
             }
@@ -482,6 +574,129 @@ class ChatUI {
         console.log('Message added:', messageData);
     }
 
+    // Edit, unsend or delete-for-me from the buttons under a message.
+    // The resulting WebSocket event updates the view.
+    async handleMessageAction(e) {
+        const button = e.target.closest('.message-action');
+        if (!button) return;
+
+        const messageElement = button.closest('.message');
+        const messageID = Number(messageElement.dataset.messageId);
+
+        let result;
+        switch (button.dataset.action) {
+            case 'edit': {
+                const original = this.findMessage(messageID);
+                const current = original ? original.content : messageElement.querySelector('.message-text').textContent;
+                const content = prompt('Edit message', current);
+                if (content === null || content.trim() === '' || content === current) return;
+                result = await API.editMessage(messageID, content);
+                break;
+            }
+            case 'unsend':
+                if (!confirm('Unsend this message for everyone?')) return;
+                result = await API.deleteMessage(messageID, 'everyone');
+                break;
+            case 'delete-for-me':
+                result = await API.deleteMessage(messageID, 'me');
+                break;
+            default:
+                return;
+        }
+
+        if (!result.success) {
+            console.error('Message action failed:', result.error);
+            alert('Could not update the message.');
+        }
+    }
+
+    // Look up a loaded message by ID, for its Markdown source
+    findMessage(messageID) {
+        for (const messages of this.messageHistory.values()) {
+            const message = messages.find(msg => msg.id === messageID);
+            if (message) return message;
+        }
+        return null;
+    }
+
+    // Apply an edit made in either participant's client
+    updateMessage(messageData) {
+        const currentUserId = window.views && window.views.currentUser ? window.views.currentUser.id : 0;
+        const partnerId = messageData.sender_id === currentUserId ? messageData.receiver_id : messageData.sender_id;
+
+        const messages = this.messageHistory.get(partnerId) || [];
+        const index = messages.findIndex(msg => msg.id === messageData.id);
+        if (index !== -1) {
+            messages[index] = { ...messages[index], ...messageData };
+        }
+
+        const conversation = this.conversations.get(partnerId);
+        if (conversation && conversation.last_message && conversation.last_message.id === messageData.id) {
+            conversation.last_message = { ...conversation.last_message, ...messageData };
+            this.renderConversations();
+        }
+
+        const element = document.querySelector(`.message[data-message-id="${messageData.id}"]`);
+        if (element) {
+            element.outerHTML = this.renderMessage(index !== -1 ? messages[index] : messageData);
+        }
+    }
+
+    // Sent, Delivered or Read, from the receipts on one of our own messages
+    receiptStatus(message) {
+        const receipts = message.receipts || [];
+        if (receipts.some(receipt => receipt.read_at)) return 'Read';
+        if (receipts.some(receipt => receipt.delivered_at)) return 'Delivered';
+        return 'Sent';
+    }
+
+    // Record a delivery or read receipt for our own messages and refresh them
+    applyReceipt(data) {
+        const timestamp = data.status === 'read' ? { read_at: data.at } : { delivered_at: data.at };
+
+        data.message_ids.forEach(messageID => {
+            for (const messages of this.messageHistory.values()) {
+                const message = messages.find(msg => msg.id === messageID);
+                if (!message) continue;
+
+                const receipts = message.receipts || [];
+                const existing = receipts.find(receipt => receipt.user_id === data.user_id);
+                if (existing) {
+                    Object.assign(existing, timestamp);
+                } else {
+                    receipts.push({ message_id: messageID, user_id: data.user_id, ...timestamp });
+                }
+                message.receipts = receipts;
+
+                const element = document.querySelector(`.message[data-message-id="${messageID}"] .receipt`);
+                if (element) {
+                    element.textContent = this.receiptStatus(message);
+                }
+                break;
+            }
+        });
+    }
+
+    // Drop an unsent or self-deleted message from the open chat
+    removeMessage(data) {
+        const currentUserId = window.views && window.views.currentUser ? window.views.currentUser.id : 0;
+        const partnerId = data.sender_id === currentUserId ? data.receiver_id : data.sender_id;
+
+        const messages = (this.messageHistory.get(partnerId) || []).filter(msg => msg.id !== data.message_id);
+        this.messageHistory.set(partnerId, messages);
+
+        const conversation = this.conversations.get(partnerId);
+        if (conversation && conversation.last_message && conversation.last_message.id === data.message_id) {
+            conversation.last_message = messages.length > 0 ? messages[messages.length - 1] : null;
+            this.renderConversations();
+        }
+
+        const element = document.querySelector(`.message[data-message-id="${data.message_id}"]`);
+        if (element) {
+            element.remove();
+        }
+    }
+
     async handleSendMessage(e) {
         e.preventDefault();
         console.log('handleSendMessage called');
@@ -500,7 +715,9 @@ class ChatUI {
         const content = messageInput.value.trim();
         console.log('Message content:', content);
 
-        if (!content) {
+        const attachments = this.pendingAttachments;
+        const attachmentIDs = attachments.map(a => a.id);
+        if (!content && attachmentIDs.length === 0) {
             console.log('Empty message, not sending');
             return;
         }
@@ -521,6 +738,12 @@ class ChatUI {
 
         // Clear input immediately for better UX
         messageInput.value = '';
+        this.pendingAttachments = [];
+        this.renderPendingAttachments();
+        const restoreAttachments = () => {
+            this.pendingAttachments = attachments.concat(this.pendingAttachments);
+            this.renderPendingAttachments();
+        };
 
         // Send via WebSocket if connected, otherwise use HTTP API
         let success = false;
@@ -528,12 +751,18 @@ class ChatUI {
 
         if (window.wsClient && window.wsClient.isConnected) {
             console.log('Sending via WebSocket');
-            success = window.wsClient.sendPrivateMessage(this.currentConversation, content);
-            console.log('WebSocket send result:', success);
-            if (success) {
-                // Don't add optimistic message - let WebSocket response handle it
-                console.log('Message sent via WebSocket, waiting for server response');
-            }
+            // Don't add optimistic message - the private_message event delivers it
+            success = true;
+            window.wsClient.sendPrivateMessage(this.currentConversation, content, attachmentIDs)
+                .then(ack => console.log('Message acknowledged:', ack))
+                .catch(error => {
+                    console.error('Message not sent:', error);
+                    if (!messageInput.value) {
+                        messageInput.value = content;
+                    }
+                    restoreAttachments();
+                    alert(`Message not sent: ${error.error}`);
+                });
         } else {
             // Fallback to HTTP API
             console.log('Sending via HTTP API');
@@ -542,7 +771,8 @@ class ChatUI {
                     method: 'POST',
                     body: JSON.stringify({
                         receiver_id: this.currentConversation,
-                        content: content
+                        content: content,
+                        attachment_ids: attachmentIDs
                     })
                 });
                 console.log('API response:', result);
@@ -553,6 +783,7 @@ class ChatUI {
                 } else {
                     console.error('API request failed:', result.error);
                     messageInput.value = content; // Restore input on failure
+                    restoreAttachments();
                 }
             } catch (error) {
                 console.error('Failed to send message:', error);
@@ -600,16 +831,20 @@ class ChatUI {
     }
 
     updateUserStatus(userID, status) {
-        if (status === 'online') {
-            this.onlineUsers.set(userID, true);
-        } else {
+        if (status === 'offline') {
             this.onlineUsers.delete(userID);
+        } else {
+            this.onlineUsers.set(userID, status);
         }
 
         // Update conversation if it exists
         const conversation = this.conversations.get(userID);
         if (conversation) {
-            conversation.is_online = status === 'online';
+            if (status === 'offline' && conversation.status && conversation.status !== 'offline') {
+                conversation.last_seen_at = new Date().toISOString();
+            }
+            conversation.is_online = status !== 'offline';
+            conversation.status = status;
         }
 
         // Update UI
@@ -621,13 +856,18 @@ class ChatUI {
 
     updateOnlineUsers(users) {
         this.onlineUsers.clear();
+        this.conversations.forEach(conversation => {
+            conversation.is_online = false;
+            conversation.status = 'offline';
+        });
         users.forEach(user => {
-            this.onlineUsers.set(user.user_id, true);
+            this.onlineUsers.set(user.user_id, user.status);
             
             // Update conversation status
             const conversation = this.conversations.get(user.user_id);
             if (conversation) {
                 conversation.is_online = true;
+                conversation.status = user.status;
             }
         });
 
@@ -649,11 +889,63 @@ class ChatUI {
             if (conversation) {
                 conversation.unread_count = 0;
             }
+            this.refreshUnreadBadge();
         } catch (error) {
             console.error('Failed to mark messages as read:', error);
         }
     }
 
+    // Upload files picked for the next message
+    async uploadAttachments(files) {
+        for (const file of files) {
+            const result = await API.uploadAttachment(file);
+            if (result.success) {
+                this.pendingAttachments.push(result.data);
+            } else {
+                alert(`Could not attach ${file.name}: ${result.error}`);
+            }
+        }
+        this.renderPendingAttachments();
+    }
+
+    renderPendingAttachments() {
+        const container = document.getElementById('pending-attachments');
+        if (!container) return;
+
+        container.innerHTML = this.pendingAttachments.map(attachment => `
+            <span class="pending-attachment">
+                <i class="fas fa-paperclip"></i>
+                ${this.escapeHtml(attachment.filename)}
+                <button type="button" data-remove-attachment="${attachment.id}" title="Remove">&times;</button>
+            </span>
+        `).join('');
+    }
+
+    renderAttachments(attachments) {
+        if (!attachments || attachments.length === 0) return '';
+
+        return `<div class="message-attachments">${attachments.map(attachment => {
+            if (attachment.thumbnail_url) {
+                return `
+                    <a class="attachment-image" href="${attachment.url}" target="_blank" rel="noopener">
+                        <img src="${attachment.thumbnail_url}" alt="${this.escapeHtml(attachment.filename).replace(/"/g, '&quot;')}" loading="lazy">
+                    </a>`;
+            }
+            return `
+                <a class="attachment-file" href="${attachment.url}" target="_blank" rel="noopener">
+                    <i class="fas fa-file"></i>
+                    <span>${this.escapeHtml(attachment.filename)}</span>
+                    <span class="attachment-size">${this.formatFileSize(attachment.size)}</span>
+                </a>`;
+        }).join('')}</div>`;
+    }
+
+    formatFileSize(bytes) {
+        if (bytes < 1024) return `${bytes} B`;
+        if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+        return `${(bytes / (1024 * 1024)).toFixed(1)} MB`;
+    }
+
     handleConnectionStatus(connected) {
         const messageInput = document.getElementById('message-input');
         const sendBtn = document.getElementById('send-btn');
@@ -689,9 +981,11 @@ class ChatUI {
     async loadMoreMessages() {
         if (this.loadingMore || !this.currentConversation) return;
 
-        this.loadingMore = true;
         const currentMessages = this.messageHistory.get(this.currentConversation) || [];
-        await this.loadMessageHistory(this.currentConversation, currentMessages.length);
+        if (currentMessages.length === 0 || !this.hasOlderMessages.get(this.currentConversation)) return;
+
+        this.loadingMore = true;
+        await this.loadMessageHistory(this.currentConversation, currentMessages[0].id);
         this.loadingMore = false;
     }
 
@@ -755,6 +1049,54 @@ class ChatUI {
         }
     }
 
+    // presenceStatus returns online, away, dnd or offline for a conversation's other user
+    presenceStatus(conversation) {
+        return conversation.status || this.onlineUsers.get(conversation.user_id) || 'offline';
+    }
+
+    presenceLabel(conversation) {
+        const labels = { online: 'Online', away: 'Away', dnd: 'Do not disturb' };
+        const status = this.presenceStatus(conversation);
+        if (labels[status]) return labels[status];
+        if (conversation.last_seen_at) {
+            const ago = this.formatRelativeTime(conversation.last_seen_at);
+            return ago === 'now' ? 'Last seen just now' : `Last seen ${ago} ago`;
+        }
+        return 'Offline';
+    }
+
+    async loadPresenceStatus() {
+        const result = await API.request('/presence');
+        const presenceSelect = document.getElementById('presence-status');
+        if (!result.success) return;
+        if (presenceSelect) {
+            presenceSelect.value = result.data.status;
+        }
+        if (window.wsClient) {
+            window.wsClient.ownStatus = result.data.status;
+        }
+    }
+
+    async setPresenceStatus(status) {
+        if (window.wsClient && window.wsClient.isConnected) {
+            try {
+                await window.wsClient.setStatus(status);
+                return;
+            } catch (error) {
+                console.error('Failed to set status over WebSocket:', error);
+            }
+        }
+        const result = await API.request('/presence', {
+            method: 'PUT',
+            body: JSON.stringify({ status: status })
+        });
+        if (!result.success) {
+            console.error('Failed to set status:', result.error);
+        } else if (window.wsClient) {
+            window.wsClient.ownStatus = status;
+        }
+    }
+
     formatRelativeTime(dateString) {
         const date = new Date(dateString);
         const now = new Date();
@@ -5,7 +5,8 @@ class Router {
             '/login': 'login-section',
             '/register': 'register-section',
             '/post': 'post-section',
-            '/chat': 'chat-section'
+            '/chat': 'chat-section',
+            '/notifications': 'notifications-section'
         };
 
         window.addEventListener('popstate', () => this.handleRoute());
@@ -60,6 +61,10 @@ class Router {
         }
             
 
+            if (path === '/notifications' && window.views) {
+                window.views.loadNotifications();
+            }
+
             // Reset comment form if navigating away from post view
             if (path !== '/post') {
                 const commentForm = document.getElementById('comment-form');
@@ -3,6 +3,12 @@ class Views {
         this.bindEvents();
         this.currentUser = null;
         this.categoriesLoaded = false;
+        this.notifications = [];
+        this.unreadNotifications = 0;
+        this.follows = new Set(); // "type:id" of everything the user follows
+        this.feedCursor = '';
+        this.postFilter = ''; // '', 'my-posts', 'liked-posts' or 'bookmarks'
+        this.bookmarkFolders = [];
     }
 
     formatRelativeTime(dateString) {
@@ -49,12 +55,14 @@ class Views {
         document.getElementById('registerBtn')?.addEventListener('click', () => router.navigate('/register'));
         document.getElementById('homeBtn')?.addEventListener('click', () => router.navigate('/'));
         document.getElementById('chatBtn')?.addEventListener('click', () => router.navigate('/chat'));
+        document.getElementById('notificationsBtn')?.addEventListener('click', () => router.navigate('/notifications'));
         document.getElementById('profileBtn')?.addEventListener('click', () => this.showProfile());
         document.querySelector('.nav-left h1')?.addEventListener('click', () => router.navigate('/'));
 
         // Mobile navigation
         document.getElementById('homeBtn-mobile')?.addEventListener('click', () => router.navigate('/'));
         document.getElementById('chatBtn-mobile')?.addEventListener('click', () => router.navigate('/chat'));
+        document.getElementById('notificationsBtn-mobile')?.addEventListener('click', () => router.navigate('/notifications'));
         document.getElementById('profileBtn-mobile')?.addEventListener('click', () => this.showProfile());
 
         // Mobile menu toggle
@@ -78,10 +86,26 @@ class Views {
         // Chat form is now handled by chat.js
 
         // Feed events
-        document.getElementById('categoryFilter')?.addEventListener('change', (e) => this.loadPosts(e.target.value));
+        document.getElementById('categoryFilter')?.addEventListener('change', (e) => {
+            this.loadPosts(e.target.value, this.postFilter);
+            this.updateFollowCategoryButton();
+        });
+        document.getElementById('sortFilter')?.addEventListener('change', () => this.filterPosts(this.postFilter));
+        document.getElementById('bookmarkFolderFilter')?.addEventListener('change', () => this.filterPosts('bookmarks'));
+        document.getElementById('new-folder-btn')?.addEventListener('click', () => this.createBookmarkFolder());
+        document.getElementById('delete-folder-btn')?.addEventListener('click', () => this.deleteBookmarkFolder());
+        document.getElementById('follow-category-btn')?.addEventListener('click', () => {
+            this.toggleFollow('category', Number(document.getElementById('categoryFilter').value));
+        });
         document.getElementById('newPostBtn')?.addEventListener('click', () => this.toggleQuickPostForm());
         document.getElementById('quick-post-form')?.addEventListener('submit', (e) => this.handleQuickPost(e));
 
+        // Notification center events
+        document.getElementById('mark-all-read-btn')?.addEventListener('click', () => this.markAllNotificationsRead());
+        document.getElementById('load-more-notifications')?.addEventListener('click', () => this.loadNotifications(true));
+        document.getElementById('notifications-list')?.addEventListener('click', (e) => this.openNotification(e));
+        document.getElementById('notification-preferences')?.addEventListener('change', (e) => this.updateNotificationPreference(e));
+
         // Mobile menu events
         document.getElementById('hamburger-menu')?.addEventListener('click', () => this.toggleMobileMenu());
         document.getElementById('homeBtn-mobile')?.addEventListener('click', () => router.navigate('/'));
@@ -99,7 +123,19 @@ class Views {
         password: formData.get('password')
     };
 
-    const result = await API.login(credentials);
+    let result = await API.login(credentials);
+    if (result.success && result.data.two_factor_required) {
+        const code = prompt('Enter the code from your authenticator app (or a recovery code):');
+        if (!code) {
+            return;
+        }
+        result = code.includes('-')
+            ? await API.request('/login/verify', {
+                method: 'POST',
+                body: JSON.stringify({ challenge_token: result.data.challenge_token, recovery_code: code })
+            })
+            : await API.verifyLogin(result.data.challenge_token, code);
+    }
     if (result.success) {
         this.currentUser = result.data;
         router.setAuthenticated(true);
@@ -108,6 +144,8 @@ class Views {
         await this.loadCategories(); // Load categories first
         this.updateProfileCard(); // Update profile card with user info
         this.loadPosts(); // Then load posts
+        this.loadFollows();
+        this.loadBookmarkFolders();
 
         // --- Ensure chat is initialized for the new session ---
         if (window.Chat) {
@@ -141,9 +179,11 @@ class Views {
     }
 
     async handleLogout() {
+    await this.disablePush(); // Stop pushing this account's notifications to this browser
     const result = await API.logout();
     if (result.success) {
         this.currentUser = null;
+        this.follows = new Set();
         router.setAuthenticated(false);
 
         // Disconnect WebSocket
@@ -187,25 +227,34 @@ class Views {
             return;
         }
 
+        this.postFilter = filter;
+        const categorySelect = document.getElementById('categoryFilter');
+        if (categorySelect) categorySelect.value = category;
+        this.updateBookmarkControls();
+
         try {
-            const result = await API.getPosts(category);
+            const folderId = document.getElementById('bookmarkFolderFilter')?.value;
+            const result = await API.getPosts({
+                category_id: category,
+                my_posts: filter === 'my-posts',
+                liked: filter === 'liked-posts',
+                bookmarked: filter === 'bookmarks',
+                folder_id: filter === 'bookmarks' ? folderId : '',
+                sort: document.getElementById('sortFilter')?.value
+            });
             console.log('loadPosts API response:', result); // Debug logging
 
             if (result.success && result.data && Array.isArray(result.data)) {
                 let filteredPosts = result.data;
                 let filterMessage = '';
 
-                // Apply additional filters if specified
+                // Pick the message for an empty result
                 if (filter === 'my-posts') {
-                    filteredPosts = result.data.filter(post =>
-                        post.author && post.author.username === this.currentUser.username
-                    );
                     filterMessage = 'You haven\'t created any posts yet.';
                 } else if (filter === 'liked-posts') {
-                    filteredPosts = result.data.filter(post =>
-                        post.like_count > 0
-                    );
                     filterMessage = 'You haven\'t liked any posts yet.';
+                } else if (filter === 'bookmarks') {
+                    filterMessage = 'You haven\'t bookmarked any posts yet.';
                 } else if (category && category !== '') {
                     // If a specific category is selected, set appropriate message
                     filterMessage = 'No posts found in this category.';
@@ -263,6 +312,75 @@ class Views {
         }
     }
 
+    // Apply a profile filter on top of the selected category and sort
+    filterPosts(filter) {
+        this.loadPosts(document.getElementById('categoryFilter')?.value || '', filter);
+    }
+
+    async loadBookmarkFolders() {
+        const result = await API.getBookmarkFolders();
+        if (!result.success) return;
+
+        this.bookmarkFolders = result.data;
+        const select = document.getElementById('bookmarkFolderFilter');
+        const selected = select.value;
+        select.innerHTML = '<option value="">All bookmarks</option>' + this.bookmarkFolders.map(folder => `
+            <option value="${folder.id}">${window.chatUI.escapeHtml(folder.name)} (${folder.bookmark_count})</option>
+        `).join('');
+        select.value = this.bookmarkFolders.some(folder => String(folder.id) === selected) ? selected : '';
+        this.updateBookmarkControls();
+    }
+
+    // Show the folder controls only while listing bookmarks
+    updateBookmarkControls() {
+        const listingBookmarks = this.postFilter === 'bookmarks';
+        const select = document.getElementById('bookmarkFolderFilter');
+        select?.classList.toggle('hidden', !listingBookmarks);
+        document.getElementById('new-folder-btn')?.classList.toggle('hidden', !listingBookmarks);
+        document.getElementById('delete-folder-btn')?.classList.toggle('hidden', !listingBookmarks || !select?.value);
+    }
+
+    async createBookmarkFolder() {
+        const name = prompt('Folder name');
+        if (!name) return;
+
+        const result = await API.createBookmarkFolder(name);
+        if (!result.success) {
+            alert('Failed to create folder: ' + (result.error || 'Unknown error'));
+            return;
+        }
+        await this.loadBookmarkFolders();
+        document.getElementById('bookmarkFolderFilter').value = result.data.id;
+        this.filterPosts('bookmarks');
+    }
+
+    async deleteBookmarkFolder() {
+        const select = document.getElementById('bookmarkFolderFilter');
+        if (!select.value || !confirm('Delete this folder? Its bookmarks are kept.')) return;
+
+        const result = await API.deleteBookmarkFolder(select.value);
+        if (result.success) {
+            select.value = '';
+            await this.loadBookmarkFolders();
+            this.filterPosts('bookmarks');
+        }
+    }
+
+    // Bookmark or unbookmark a post. New bookmarks go into the folder being viewed.
+    async toggleBookmark(postId) {
+        const icons = document.querySelectorAll(`[data-post-id="${postId}"].bookmark-icon`);
+        const bookmarked = icons.length > 0 && icons[0].classList.contains('bookmarked');
+        const folderId = this.postFilter === 'bookmarks' ? Number(document.getElementById('bookmarkFolderFilter').value) || null : null;
+
+        const result = bookmarked ? await API.unbookmarkPost(postId) : await API.bookmarkPost(postId, folderId);
+        if (!result.success) {
+            alert('Failed to update bookmark: ' + (result.error || 'Unknown error'));
+            return;
+        }
+        icons.forEach(icon => icon.classList.toggle('bookmarked', !bookmarked));
+        this.loadBookmarkFolders(); // Refresh folder counts
+    }
+
     async loadPost(postId) {
         const result = await API.getPost(postId);
         if (result.success) {
@@ -282,9 +400,12 @@ class Views {
     async handleComment(e) {
         e.preventDefault();
         const formData = new FormData(e.target);
+        const attachmentIDs = await this.uploadFormFiles(e.target);
+        if (attachmentIDs === null) return;
         const commentData = {
             post_id: this.currentPostId,
-            content: formData.get('content')
+            content: formData.get('content'),
+            attachment_ids: attachmentIDs
         };
 
         const result = await API.createComment(commentData);
@@ -297,7 +418,8 @@ class Views {
             const commentElement = document.createElement('div');
             commentElement.className = 'comment';
             commentElement.innerHTML = `
-                <p>${newComment.content}</p>
+                <div class="markdown">${newComment.content_html}</div>
+                ${window.chatUI.renderAttachments(newComment.attachments)}
                 <div class="comment-meta">
                     <div class="user-info">
                         <div class="avatar">
@@ -368,10 +490,13 @@ class Views {
         form.onsubmit = async (e) => {
             e.preventDefault();
             const formData = new FormData(form);
+            const attachmentIDs = await this.uploadFormFiles(form);
+            if (attachmentIDs === null) return;
             const postData = {
                 title: formData.get('title'),
                 content: formData.get('content'),
-                category_ids: [parseInt(formData.get('category'))]
+                category_ids: [parseInt(formData.get('category'))],
+                attachment_ids: attachmentIDs
             };
 
             const result = await API.createPost(postData);
@@ -402,11 +527,14 @@ class Views {
         e.preventDefault();
         const form = e.target;
         const formData = new FormData(form);
-        
+        const attachmentIDs = await this.uploadFormFiles(form);
+        if (attachmentIDs === null) return;
+
         const postData = {
             title: formData.get('title'),
             content: formData.get('content'),
-            category_ids: [parseInt(formData.get('category'))]
+            category_ids: [parseInt(formData.get('category'))],
+            attachment_ids: attachmentIDs
         };
 
         const result = await API.createPost(postData);
@@ -419,6 +547,22 @@ class Views {
         }
     }
 
+    // Upload the files picked in a form's file input. Returns their attachment IDs,
+    // or null if an upload failed.
+    async uploadFormFiles(form) {
+        const input = form.querySelector('input[name="files"]');
+        const ids = [];
+        for (const file of input ? Array.from(input.files) : []) {
+            const result = await API.uploadAttachment(file);
+            if (!result.success) {
+                alert(`Could not attach ${file.name}: ${result.error}`);
+                return null;
+            }
+            ids.push(result.data.id);
+        }
+        return ids;
+    }
+
     async loadCategories() {
         // If categories are already loaded and dropdowns exist, don't reload
         const categoryFilter = document.getElementById('categoryFilter');
@@ -476,10 +620,11 @@ class Views {
                     </div>
                 </div>
                 <h3>${post.title}</h3>
-                <p>${post.content.substring(0, 150)}...</p>
+                <p>${window.chatUI.escapeHtml(post.content.substring(0, 150))}...</p>
+                ${window.chatUI.renderAttachments(post.attachments)}
                 <div class="post-meta">
                     <div class="post-actions">
-                        <span class="action-icon like-icon" onclick="event.stopPropagation(); window.views.handleLike(${post.id})" data-post-id="${post.id}">
+                        <span class="action-icon like-icon ${post.has_liked ? 'liked' : ''}" onclick="event.stopPropagation(); window.views.handleLike(${post.id})" data-post-id="${post.id}">
                             <i class="fas fa-heart"></i>
                             <span class="count">${post.like_count || 0}</span>
                         </span>
@@ -487,6 +632,9 @@ class Views {
                             <i class="fas fa-comment"></i>
                             <span class="count">${post.comments ? post.comments.length : 0}</span>
                         </span>
+                        <span class="action-icon bookmark-icon ${post.has_bookmarked ? 'bookmarked' : ''}" onclick="event.stopPropagation(); window.views.toggleBookmark(${post.id})" data-post-id="${post.id}">
+                            <i class="fas fa-bookmark"></i>
+                        </span>
                     </div>
                 </div>
             </div>
@@ -509,10 +657,13 @@ class Views {
                     </div>
                 </div>
                 <h2>${post.title}</h2>
-                <p>${post.content}</p>
+                <div class="markdown">${post.content_html}</div>
+                ${window.chatUI.renderAttachments(post.attachments)}
                 <div class="post-meta">
                     <div class="post-actions">
-                        <span class="action-icon like-icon" onclick="window.views.handleLike(${post.id})" data-post-id="${post.id}">
+                        ${this.renderFollowButton('post', post.id, 'post')}
+                        ${post.user_id !== this.currentUser.id ? this.renderFollowButton('user', post.user_id, post.author.username) : ''}
+                        <span class="action-icon like-icon ${post.has_liked ? 'liked' : ''}" onclick="window.views.handleLike(${post.id})" data-post-id="${post.id}">
                             <i class="fas fa-heart"></i>
                             <span class="count">${post.like_count || 0}</span>
                         </span>
@@ -520,12 +671,16 @@ class Views {
                             <i class="fas fa-comment"></i>
                             <span class="count">${post.comments ? post.comments.length : 0}</span>
                         </span>
+                        <span class="action-icon bookmark-icon ${post.has_bookmarked ? 'bookmarked' : ''}" onclick="window.views.toggleBookmark(${post.id})" data-post-id="${post.id}">
+                            <i class="fas fa-bookmark"></i>
+                        </span>
                     </div>
                 </div>
                 <div id="comments-section">
                     <h3>Comments (${post.comments ? post.comments.length : 0})</h3>
                     <form id="comment-form" class="comment-form hidden">
                         <textarea name="content" placeholder="Write a comment..." required></textarea>
+                        <input type="file" name="files" multiple class="attachment-picker">
                         <button type="submit">Add Comment</button>
                     </form>
                     <div id="comments-container">
@@ -536,10 +691,100 @@ class Views {
         `;
     }
 
+    renderFollowButton(targetType, targetID, label) {
+        const following = this.follows.has(`${targetType}:${targetID}`);
+        return `
+            <button class="filter-btn follow-btn ${following ? 'following' : ''}" data-follow="${targetType}:${targetID}" data-label="${window.chatUI.escapeHtml(label)}"
+                    onclick="window.views.toggleFollow('${targetType}', ${targetID})">
+                ${following ? 'Following' : 'Follow'} ${window.chatUI.escapeHtml(label)}
+            </button>
+        `;
+    }
+
+    async loadFollows() {
+        const result = await API.getFollows();
+        if (result.success) {
+            this.follows = new Set(result.data.map(f => `${f.target_type}:${f.target_id}`));
+            this.updateFollowCategoryButton();
+        }
+    }
+
+    async toggleFollow(targetType, targetID) {
+        const key = `${targetType}:${targetID}`;
+        const following = this.follows.has(key);
+        const result = following ? await API.unfollow(targetType, targetID) : await API.follow(targetType, targetID);
+        if (!result.success) return;
+
+        if (following) {
+            this.follows.delete(key);
+        } else {
+            this.follows.add(key);
+        }
+        document.querySelectorAll(`[data-follow="${key}"]`).forEach(button => {
+            button.classList.toggle('following', !following);
+            button.textContent = `${following ? 'Follow' : 'Following'} ${button.dataset.label}`;
+        });
+        this.updateFollowCategoryButton();
+    }
+
+    updateFollowCategoryButton() {
+        const button = document.getElementById('follow-category-btn');
+        const categoryID = document.getElementById('categoryFilter')?.value;
+        button.classList.toggle('hidden', !categoryID);
+        if (categoryID) {
+            button.textContent = this.follows.has(`category:${categoryID}`) ? 'Following category' : 'Follow category';
+        }
+    }
+
+    // Show activity from followed posts, categories and users in place of the post list
+    async loadFollowingFeed(more = false) {
+        if (!more) {
+            this.postFilter = '';
+            this.updateBookmarkControls();
+        }
+        const result = await API.getFollowingFeed(more ? this.feedCursor : '');
+        if (!result.success) return;
+
+        const container = document.getElementById('posts-container');
+        const items = result.data.items.map(item => `
+            <div class="post-card feed-item" data-post-id="${item.post_id}">
+                <div class="post-meta-info">
+                    <span class="username">${window.chatUI.escapeHtml(item.author.username)}</span>
+                    ${item.type === 'comment' ? 'commented on' : 'posted'}
+                    <strong>${window.chatUI.escapeHtml(item.post_title)}</strong>
+                    <span class="timestamp">${this.formatRelativeTime(item.created_at)}</span>
+                </div>
+                <p>${window.chatUI.escapeHtml(item.excerpt)}</p>
+            </div>
+        `).join('');
+
+        container.querySelector('#load-more-feed')?.remove();
+        if (more) {
+            container.insertAdjacentHTML('beforeend', items);
+        } else {
+            container.innerHTML = items || `
+                <div class="no-posts-message">
+                    <p>Nothing here yet. Follow posts, categories or people to fill this feed.</p>
+                    <button onclick="window.views.loadPosts()" class="action-btn">View All Posts</button>
+                </div>
+            `;
+        }
+
+        this.feedCursor = result.data.next_cursor || '';
+        if (this.feedCursor) {
+            container.insertAdjacentHTML('beforeend', '<button id="load-more-feed" class="filter-btn" onclick="window.views.loadFollowingFeed(true)">Load more</button>');
+        }
+        container.querySelectorAll('.feed-item:not([data-bound])').forEach(card => {
+            card.dataset.bound = 'true';
+            card.addEventListener('click', () => this.loadPost(card.dataset.postId));
+        });
+    }
+
     renderComments(comments) {
         return comments.map(comment => `
             <div class="comment">
-                <p>${comment.content}</p>
+                <div class="markdown">${comment.content_html}</div>
+                ${window.chatUI.renderAttachments(comment.attachments)}
                 <div class="comment-meta">
                     <div class="user-info">
                         <div class="avatar">
@@ -703,6 +948,195 @@ class Views {
         }
     }
 
+    // Load the notification list, or the next page of it when more is set
+    async loadNotifications(more = false) {
+        const list = document.getElementById('notifications-list');
+        const beforeID = more && this.notifications.length > 0 ? this.notifications[this.notifications.length - 1].id : null;
+        const result = await API.getNotifications(beforeID);
+        if (!result.success) {
+            return;
+        }
+
+        this.notifications = more ? this.notifications.concat(result.data.notifications) : result.data.notifications;
+        list.innerHTML = this.notifications.length > 0
+            ? this.notifications.map(n => this.renderNotification(n)).join('')
+            : '<p class="notification-text">No notifications yet.</p>';
+        document.getElementById('load-more-notifications').classList.toggle('hidden', !result.data.has_more);
+        this.setNotificationBadge(result.data.unread_count);
+
+        if (!more) {
+            const preferences = await API.getNotificationPreferences();
+            if (preferences.success) {
+                const form = document.getElementById('notification-preferences');
+                Object.entries(preferences.data).forEach(([type, enabled]) => {
+                    if (form.elements[type]) form.elements[type].checked = enabled;
+                });
+            }
+            const digest = await API.getDigestFrequency();
+            if (digest.success) {
+                document.getElementById('notification-preferences').elements.digest_frequency.value = digest.data.frequency;
+            }
+            this.refreshPushToggle();
+        }
+    }
+
+    renderNotification(notification) {
+        const actor = notification.actor ? window.chatUI.escapeHtml(notification.actor.username) : 'Someone';
+        const summaries = {
+            post_reply: `${actor} commented on your post`,
+            comment_reply: `${actor} replied to your comment`,
+            reaction: `${actor} liked your ${notification.comment_id ? 'comment' : 'post'}`,
+            mention: `${actor} mentioned you`,
+            moderation: 'Moderation notice',
+            followed_post: `${actor} commented on a post you follow`
+        };
+        return `
+            <div class="notification-item ${notification.is_read ? '' : 'unread'}" data-notification-id="${notification.id}" data-post-id="${notification.post_id || ''}">
+                <div>${summaries[notification.type] || notification.type}</div>
+                ${notification.text ? `<div class="notification-text">${window.chatUI.escapeHtml(notification.text)}</div>` : ''}
+                <span class="notification-time">${this.formatRelativeTime(notification.created_at)}</span>
+            </div>
+        `;
+    }
+
+    // Mark a notification read and open the post it is about
+    async openNotification(e) {
+        const item = e.target.closest('.notification-item');
+        if (!item) return;
+
+        const notification = this.notifications.find(n => n.id === Number(item.dataset.notificationId));
+        if (notification && !notification.is_read) {
+            const result = await API.markNotificationRead(notification.id);
+            if (result.success) {
+                notification.is_read = true;
+                item.classList.remove('unread');
+                this.setNotificationBadge(result.data.unread_count);
+            }
+        }
+        if (item.dataset.postId) {
+            this.loadPost(item.dataset.postId);
+        }
+    }
+
+    async markAllNotificationsRead() {
+        const result = await API.markAllNotificationsRead();
+        if (result.success) {
+            this.notifications.forEach(n => { n.is_read = true; });
+            document.querySelectorAll('.notification-item.unread').forEach(item => item.classList.remove('unread'));
+            this.setNotificationBadge(result.data.unread_count);
+        }
+    }
+
+    async updateNotificationPreference(e) {
+        if (e.target.name === 'push_device') {
+            const checkbox = e.target;
+            if (checkbox.checked) {
+                checkbox.checked = await this.enablePush();
+            } else {
+                await this.disablePush();
+            }
+            return;
+        }
+
+        if (e.target.name === 'digest_frequency') {
+            const select = e.target;
+            const result = await API.updateDigestFrequency(select.value);
+            if (result.success) {
+                select.value = result.data.frequency;
+            }
+            return;
+        }
+
+        const checkbox = e.target;
+        const result = await API.updateNotificationPreferences({ [checkbox.name]: checkbox.checked });
+        if (!result.success) {
+            checkbox.checked = !checkbox.checked;
+        }
+    }
+
+    pushSupported() {
+        return 'serviceWorker' in navigator && 'PushManager' in window && 'Notification' in window;
+    }
+
+    // Tick the push checkbox if this browser is subscribed
+    async refreshPushToggle() {
+        const checkbox = document.getElementById('notification-preferences').elements.push_device;
+        checkbox.disabled = !this.pushSupported();
+        if (checkbox.disabled) return;
+
+        const registration = await navigator.serviceWorker.getRegistration('/');
+        const subscription = registration ? await registration.pushManager.getSubscription() : null;
+        checkbox.checked = Boolean(subscription);
+    }
+
+    // Subscribe this browser to Web Push and register it with the server
+    async enablePush() {
+        if (!this.pushSupported() || await Notification.requestPermission() !== 'granted') {
+            return false;
+        }
+
+        const key = await API.getPushKey();
+        if (!key.success) return false;
+
+        try {
+            await navigator.serviceWorker.register('/sw.js');
+            const registration = await navigator.serviceWorker.ready;
+            const subscription = await registration.pushManager.subscribe({
+                userVisibleOnly: true,
+                applicationServerKey: this.decodeBase64URL(key.data.public_key)
+            });
+            const result = await API.savePushSubscription(subscription.toJSON());
+            if (!result.success) {
+                await subscription.unsubscribe();
+            }
+            return result.success;
+        } catch (error) {
+            console.error('Failed to subscribe to push notifications:', error);
+            return false;
+        }
+    }
+
+    // Unsubscribe this browser from Web Push, if it is subscribed
+    async disablePush() {
+        if (!this.pushSupported()) return;
+
+        const registration = await navigator.serviceWorker.getRegistration('/');
+        const subscription = registration ? await registration.pushManager.getSubscription() : null;
+        if (subscription) {
+            await API.deletePushSubscription(subscription.endpoint);
+            await subscription.unsubscribe();
+        }
+    }
+
+    decodeBase64URL(value) {
+        const base64 = (value + '='.repeat((4 - value.length % 4) % 4)).replace(/-/g, '+').replace(/_/g, '/');
+        return Uint8Array.from(atob(base64), c => c.charCodeAt(0));
+    }
+
+    // Show a notification pushed over the WebSocket
+    addNotification(notification) {
+        this.setNotificationBadge(this.unreadNotifications + 1);
+        if (window.location.pathname === '/notifications') {
+            this.notifications.unshift(notification);
+            document.getElementById('notifications-list').innerHTML = this.notifications.map(n => this.renderNotification(n)).join('');
+        }
+    }
+
+    async refreshNotificationBadge() {
+        const result = await API.getNotifications();
+        if (result.success) {
+            this.setNotificationBadge(result.data.unread_count);
+        }
+    }
+
+    setNotificationBadge(count) {
+        this.unreadNotifications = count;
+        document.querySelectorAll('[data-notification-badge]').forEach(badge => {
+            badge.textContent = count > 99 ? '99+' : count;
+            badge.classList.toggle('hidden', count === 0);
+        });
+    }
+
     toggleMobileMenu() {
         const mobileMenu = document.getElementById('mobile-menu');
         mobileMenu.classList.toggle('active');
@@ -732,6 +1166,9 @@ class Views {
                 await this.loadCategories(); // Load categories first
                 this.updateProfileCard(); // Update profile card with user info
                 this.loadPosts(); // Then load posts
+                this.refreshNotificationBadge();
+                this.loadFollows();
+                this.loadBookmarkFolders();
 
                 // Connect WebSocket for real-time features (with delay to ensure server is ready)
                 if (window.wsClient) {
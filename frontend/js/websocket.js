@@ -1,5 +1,9 @@
 // WebSocket client for real-time communication
 class WebSocketClient {
+    static PROTOCOL = 'forum.v2';
+    static REQUEST_TIMEOUT = 30000;
+    static HEARTBEAT_INTERVAL = 60000;
+
     constructor() {
         this.ws = null;
         this.isConnected = false;
@@ -7,8 +11,23 @@ class WebSocketClient {
         this.maxReconnectAttempts = 5;
         this.reconnectDelay = 1000; // Start with 1 second
         this.messageHandlers = new Map();
-        this.onlineUsers = new Set();
+        this.onlineUsers = new Map(); // user ID -> online, away or dnd
         this.currentConversation = null;
+
+        // Heartbeats tell the server whether the user is active, so idle users show as away
+        this.heartbeatTimer = null;
+        this.userActive = true;
+        this.ownStatus = 'online'; // desktop notifications are muted while do-not-disturb
+        this.trackActivity();
+
+        // Event stream position, used to resume after a dropped connection
+        this.epoch = null;
+        this.lastSeq = 0;
+        this.seenSeqs = new Set();
+
+        // Requests awaiting an ack, resent with the same request_id after a reconnect
+        this.nextRequestID = 1;
+        this.pendingRequests = new Map();
         
         // Bind methods to preserve 'this' context
         this.connect = this.connect.bind(this);
@@ -25,6 +44,14 @@ class WebSocketClient {
         this.registerHandler('online_users', this.handleOnlineUsers.bind(this));
         this.registerHandler('typing', this.handleTyping.bind(this));
         this.registerHandler('error', this.handleServerError.bind(this));
+        this.registerHandler('message_edited', this.handleMessageEdited.bind(this));
+        this.registerHandler('message_deleted', this.handleMessageDeleted.bind(this));
+        this.registerHandler('receipt', this.handleReceipt.bind(this));
+        this.registerHandler('session', this.handleSession.bind(this));
+        this.registerHandler('resumed', this.handleResumed.bind(this));
+        this.registerHandler('resync', this.handleResync.bind(this));
+        this.registerHandler('mention', this.handleMention.bind(this));
+        this.registerHandler('notification', this.handleNotification.bind(this));
     }
 
     connect() {
@@ -37,7 +64,7 @@ class WebSocketClient {
             const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
             const wsUrl = `${protocol}//${window.location.host}/ws`;
             
-            this.ws = new WebSocket(wsUrl);
+            this.ws = new WebSocket(wsUrl, [WebSocketClient.PROTOCOL]);
             this.ws.onopen = this.handleOpen;
             this.ws.onmessage = this.handleMessage;
             this.ws.onclose = this.handleClose;
@@ -55,8 +82,16 @@ class WebSocketClient {
             this.ws.close();
             this.ws = null;
         }
+        this.resetStream();
+
+        this.pendingRequests.forEach(pending => {
+            clearTimeout(pending.timer);
+            pending.reject({ code: 'not_connected', error: 'WebSocket closed' });
+        });
+        this.pendingRequests.clear();
         this.isConnected = false;
         this.onlineUsers.clear();
+        this.stopHeartbeat();
     }
 
     handleOpen(event) {
@@ -67,6 +102,20 @@ class WebSocketClient {
 
         // Notify UI about connection status
         this.notifyConnectionStatus(true);
+        this.startHeartbeat();
+
+        // Ask for anything we missed while disconnected
+        if (this.epoch !== null) {
+            this.sendMessage('resume', {
+                epoch: this.epoch,
+                last_seq: this.lastSeq
+            });
+        }
+
+        // Retry sends that were never acknowledged; their client_msg_id prevents duplicates
+        this.pendingRequests.forEach(pending => {
+            this.ws.send(JSON.stringify(pending.frame));
+        });
 
         // Initialize chat if not already initialized
         if (window.chatUI && !window.chatUI.isInitialized) {
@@ -78,6 +127,18 @@ class WebSocketClient {
         try {
             const message = JSON.parse(event.data);
             console.log('Received WebSocket message:', message);
+
+            // Replayed events can overlap ones that already arrived live
+            if (message.seq) {
+                if (this.seenSeqs.has(message.seq)) return;
+                this.trackSeq(message.seq);
+            }
+
+            // Answers to our own requests settle their promise instead of going to a handler
+            if (message.request_id && this.pendingRequests.has(message.request_id)) {
+                this.settleRequest(message);
+                return;
+            }
             
             const handler = this.messageHandlers.get(message.type);
             if (handler) {
@@ -94,6 +155,7 @@ class WebSocketClient {
     console.log('WebSocket disconnected:', event.code, event.reason);
     this.isConnected = false;
     this.onlineUsers.clear();
+    this.stopHeartbeat();
 
     // Debug: Log reconnect attempts and current state
     console.log(`WebSocket readyState: ${this.ws ? this.ws.readyState : 'N/A'}`);
@@ -149,19 +211,136 @@ class WebSocketClient {
         }
     }
 
-    sendPrivateMessage(receiverID, content) {
-        return this.sendMessage('private_message', {
+    // Send a frame that the server acknowledges. Resolves with the ack data, or rejects
+    // with the error data ({code, error}) if the server refuses it or never answers.
+    request(type, data) {
+        if (!this.isConnected || !this.ws) {
+            return Promise.reject({ code: 'not_connected', error: 'WebSocket not connected' });
+        }
+
+        const requestID = String(this.nextRequestID++);
+        const frame = {
+            type: type,
+            request_id: requestID,
+            data: data,
+            timestamp: new Date().toISOString()
+        };
+
+        return new Promise((resolve, reject) => {
+            const timer = setTimeout(() => {
+                this.pendingRequests.delete(requestID);
+                reject({ code: 'timeout', error: 'No response from server' });
+            }, WebSocketClient.REQUEST_TIMEOUT);
+            this.pendingRequests.set(requestID, { frame, resolve, reject, timer });
+
+            try {
+                this.ws.send(JSON.stringify(frame));
+            } catch (error) {
+                // Left pending: it is resent once the connection comes back
+                console.error('Error sending WebSocket request:', error);
+            }
+        });
+    }
+
+    settleRequest(message) {
+        const pending = this.pendingRequests.get(message.request_id);
+        this.pendingRequests.delete(message.request_id);
+        clearTimeout(pending.timer);
+
+        if (message.type === 'error') {
+            pending.reject(message.data);
+        } else {
+            pending.resolve(message.data);
+        }
+    }
+
+    sendPrivateMessage(receiverID, content, attachmentIDs = []) {
+        return this.request('private_message', {
             receiver_id: receiverID,
-            content: content
+            content: content,
+            client_msg_id: WebSocketClient.newClientMessageID(),
+            attachment_ids: attachmentIDs
         });
     }
 
+    static newClientMessageID() {
+        if (window.crypto && window.crypto.randomUUID) {
+            return window.crypto.randomUUID();
+        }
+        return `${Date.now()}-${Math.random().toString(36).slice(2)}`;
+    }
+
     sendTypingIndicator(receiverID) {
         return this.sendMessage('typing', {
             receiver_id: receiverID
         });
     }
 
+    // Mark a conversation read up to and including messageID
+    setStatus(status) {
+        return this.request('set_status', { status: status }).then(result => {
+            this.ownStatus = status;
+            return result;
+        });
+    }
+
+    trackActivity() {
+        const markActive = () => {
+            const wasIdle = !this.userActive;
+            this.userActive = true;
+            // Coming back from idle shouldn't wait for the next heartbeat
+            if (wasIdle) {
+                this.sendHeartbeat();
+            }
+        };
+        ['mousemove', 'keydown', 'click', 'scroll', 'touchstart'].forEach(type => {
+            document.addEventListener(type, markActive, { passive: true });
+        });
+        document.addEventListener('visibilitychange', () => {
+            if (document.visibilityState === 'visible') {
+                markActive();
+            }
+        });
+    }
+
+    sendHeartbeat() {
+        this.sendMessage('heartbeat', { active: this.userActive });
+        this.userActive = false;
+    }
+
+    startHeartbeat() {
+        this.stopHeartbeat();
+        this.heartbeatTimer = setInterval(() => this.sendHeartbeat(), WebSocketClient.HEARTBEAT_INTERVAL);
+    }
+
+    stopHeartbeat() {
+        if (this.heartbeatTimer) {
+            clearInterval(this.heartbeatTimer);
+            this.heartbeatTimer = null;
+        }
+    }
+
+    sendMarkRead(conversationID, messageID) {
+        return this.request('mark_read', {
+            conversation_id: conversationID,
+            message_id: messageID
+        });
+    }
+
+    trackSeq(seq) {
+        this.seenSeqs.add(seq);
+        if (this.seenSeqs.size > 500) {
+            this.seenSeqs.delete(this.seenSeqs.values().next().value);
+        }
+        this.lastSeq = Math.max(this.lastSeq, seq);
+    }
+
+    resetStream() {
+        this.epoch = null;
+        this.lastSeq = 0;
+        this.seenSeqs.clear();
+    }
+
     registerHandler(messageType, handler) {
         this.messageHandlers.set(messageType, handler);
     }
@@ -189,13 +368,80 @@ class WebSocketClient {
         }
     }
 
+    handleMention(data, timestamp) {
+        if (this.ownStatus === 'dnd') return;
+        if ('Notification' in window && Notification.permission === 'granted') {
+            const notification = new Notification(`${data.author.username} mentioned you`, {
+                body: data.excerpt,
+                icon: '/favicon.ico'
+            });
+            notification.onclick = () => {
+                window.focus();
+                if (window.views) window.views.loadPost(data.post_id);
+            };
+        }
+    }
+
+    handleNotification(data, timestamp) {
+        if (window.views) {
+            window.views.addNotification(data);
+        }
+    }
+
+    handleMessageEdited(data, timestamp) {
+        if (window.chatUI) {
+            window.chatUI.updateMessage(data);
+        }
+    }
+
+    handleMessageDeleted(data, timestamp) {
+        if (window.chatUI) {
+            window.chatUI.removeMessage(data);
+        }
+    }
+
+    handleReceipt(data, timestamp) {
+        if (window.chatUI) {
+            window.chatUI.applyReceipt(data);
+        }
+    }
+
+    // First frame on every connection. A fresh connection starts from the server's position;
+    // a reconnect keeps its own until the resume reply arrives.
+    handleSession(data, timestamp) {
+        if (this.epoch === null) {
+            this.epoch = data.epoch;
+            this.lastSeq = data.seq;
+        }
+    }
+
+    handleResumed(data, timestamp) {
+        console.log(`Resumed event stream, ${data.replayed} missed events replayed`);
+    }
+
+    // Too much was missed to replay, so reload state over HTTP and start over
+    handleResync(data, timestamp) {
+        console.log('Event stream resync required:', data.reason);
+        this.resetStream();
+        this.epoch = data.epoch;
+        this.lastSeq = data.seq;
+
+        if (window.chatUI && window.chatUI.isInitialized) {
+            window.chatUI.loadConversations().then(() => {
+                if (window.chatUI.currentConversation) {
+                    window.chatUI.loadMessageHistory(window.chatUI.currentConversation);
+                }
+            });
+        }
+    }
+
     handleUserStatus(data, timestamp) {
         console.log('User status update:', data);
         
-        if (data.status === 'online') {
-            this.onlineUsers.add(data.user_id);
-        } else {
+        if (data.status === 'offline') {
             this.onlineUsers.delete(data.user_id);
+        } else {
+            this.onlineUsers.set(data.user_id, data.status);
         }
         
         // Update UI
@@ -209,7 +455,7 @@ class WebSocketClient {
         
         this.onlineUsers.clear();
         data.forEach(user => {
-            this.onlineUsers.add(user.user_id);
+            this.onlineUsers.set(user.user_id, user.status);
         });
         
         // Update UI
@@ -256,6 +502,7 @@ class WebSocketClient {
 
     showMessageNotification(messageData) {
         // Create a simple notification
+        if (this.ownStatus === 'dnd') return;
         if ('Notification' in window && Notification.permission === 'granted') {
             new Notification(`New message from ${messageData.sender.username}`, {
                 body: messageData.content.substring(0, 100),
@@ -0,0 +1,35 @@
+// Service worker showing Web Push notifications while the forum isn't open
+
+self.addEventListener('push', (event) => {
+    if (!event.data) return;
+
+    let message;
+    try {
+        message = event.data.json();
+    } catch (error) {
+        message = { title: 'Real-Time Forum', body: event.data.text(), url: '/' };
+    }
+
+    event.waitUntil(self.registration.showNotification(message.title, {
+        body: message.body,
+        tag: message.tag,
+        icon: '/favicon.ico',
+        data: { url: message.url || '/' }
+    }));
+});
+
+// Focus an open forum tab, or open one, at the notification's page
+self.addEventListener('notificationclick', (event) => {
+    event.notification.close();
+    const url = event.notification.data.url;
+
+    event.waitUntil(self.clients.matchAll({ type: 'window', includeUncontrolled: true }).then((windows) => {
+        for (const client of windows) {
+            if ('focus' in client) {
+                client.navigate(url);
+                return client.focus();
+            }
+        }
+        return self.clients.openWindow(url);
+    }));
+});